@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+
+	"personal/poc-routing/server/buildinfo"
+)
+
+// Build metadata in reports. Soak and scenario reports record the client's own build and the
+// server's /version, so a result can be traced to the exact code on both sides. `client version`
+// prints the client's build.
+
+type reportBuild struct {
+	Client buildinfo.Info  `json:"client"`
+	Server json.RawMessage `json:"server,omitempty"` // /version of the server that answered, if any
+}
+
+// newReportBuild returns the client's build and the /version served next to rawURL.
+func newReportBuild(client *http.Client, rawURL string) reportBuild {
+	b := reportBuild{Client: buildinfo.Get()}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return b
+	}
+	u.Path, u.RawQuery = "/version", ""
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return b
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	if err == nil && resp.StatusCode == http.StatusOK && json.Valid(raw) {
+		b.Server = raw
+	}
+	return b
+}
+
+// serverVersion summarizes the server build for text reports.
+func (b reportBuild) serverVersion() string {
+	var v struct{ Version, Commit string }
+	if len(b.Server) == 0 || json.Unmarshal(b.Server, &v) != nil {
+		return "unknown"
+	}
+	return v.Version + " (" + v.Commit + ")"
+}
@@ -1,3 +1,8 @@
 module personal/poc-routing/client
 
-go 1.23
+go 1.24.0
+
+require personal/poc-routing/server v0.0.0
+
+// buildinfo lives in the server module, next door.
+replace personal/poc-routing/server => ../server
@@ -0,0 +1,84 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"flag"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Join retries. A /join that fails in transit (transport error, or 502, 503 or 504 from the proxy
+// or the replica) is sent again, up to JOIN_RETRIES times (--join-retries, default 2), after a
+// jittered backoff doubling from JOIN_RETRY_BACKOFF (--join-backoff, default 100ms). Every attempt
+// of one join carries the same Idempotency-Key, so a server that already ran the join answers the
+// retry with that join's response instead of registering the client a second time. Failing over
+// to the standby keeps the key too.
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+var joinRetry = newJoinRetryFromEnv()
+
+type joinRetryPolicy struct {
+	retries int
+	backoff time.Duration
+}
+
+func newJoinRetryFromEnv() *joinRetryPolicy {
+	p := &joinRetryPolicy{retries: 2, backoff: 100 * time.Millisecond}
+	if n, err := strconv.Atoi(os.Getenv("JOIN_RETRIES")); err == nil && n >= 0 {
+		p.retries = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("JOIN_RETRY_BACKOFF")); err == nil && d > 0 {
+		p.backoff = d
+	}
+	return p
+}
+
+// addJoinRetryFlags registers --join-retries and --join-backoff on fs.
+func addJoinRetryFlags(fs *flag.FlagSet) {
+	fs.IntVar(&joinRetry.retries, "join-retries", joinRetry.retries, "times a /join that failed in transit is retried with the same Idempotency-Key")
+	fs.DurationVar(&joinRetry.backoff, "join-backoff", joinRetry.backoff, "backoff before the first /join retry, doubling per retry")
+}
+
+// newIdempotencyKey returns a random key for one logical join.
+func newIdempotencyKey() string {
+	return crand.Text()
+}
+
+// doJoin sends the /join req, giving it an Idempotency-Key unless it has one, and retries it while
+// it fails in transit.
+func doJoin(client *http.Client, req *http.Request) (*http.Response, error) {
+	if req.Header.Get(idempotencyKeyHeader) == "" {
+		req.Header.Set(idempotencyKeyHeader, newIdempotencyKey())
+	}
+	resp, err := client.Do(req)
+	for attempt := 1; attempt <= joinRetry.retries && req.Context().Err() == nil && joinRetriable(resp, err); attempt++ {
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+			resp.Body.Close()
+		}
+		ceiling := joinRetry.backoff << (attempt - 1)
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(ceiling/2 + rand.N(ceiling/2+1)):
+		}
+		resp, err = client.Do(req.Clone(req.Context()))
+	}
+	return resp, err
+}
+
+func joinRetriable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
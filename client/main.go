@@ -1,34 +1,161 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
+
+	"personal/poc-routing/server/buildinfo"
 )
 
 func main() {
-	clientID := "123"
 	if len(os.Args) > 1 {
-		agentID = os.Args[1]
+		switch os.Args[1] {
+		case "soak":
+			runSoak(os.Args[2:])
+			return
+		case "verify":
+			runVerify(os.Args[2:])
+			return
+		case "wal-replay":
+			runWALReplay(os.Args[2:])
+			return
+		case "scenario":
+			runScenario(os.Args[2:])
+			return
+		case "version":
+			fmt.Println(buildinfo.Get())
+			return
+		}
 	}
+	joinOnce(os.Args[1:])
+}
 
-	target := "http://localhost:10000/join"
+// joinTarget returns the /join URL, from ENVOY_URL or the local Envoy default.
+func joinTarget() string {
 	if v := os.Getenv("ENVOY_URL"); v != "" {
-		target = v
+		return v
+	}
+	return "http://localhost:10000/join"
+}
+
+// joinOnce performs a single /join for the client_id given as the first argument (default 123),
+// retried as described in joinretry.go. With --check it first resolves the client through /where
+// and fails with exitMismatch when the join lands on another replica. Failures exit with the codes
+// in output.go: a 503 NO_HEALTHY_REPLICA is a failed resolution, a join that could not reach a
+// replica (after retries) an unreachable owner.
+func joinOnce(args []string) {
+	fs := flag.NewFlagSet("join", flag.ExitOnError)
+	check := fs.Bool("check", false, "resolve the client through /where first and fail when the join lands elsewhere")
+	where := fs.String("where", whereTarget(), "/where URL for --check")
+	out := addOutputFlags(fs)
+	addProxyFlags(fs)
+	addJoinRetryFlags(fs)
+	_ = fs.Parse(args)
+	out.check("join")
+	clientID := "123"
+	if fs.NArg() > 0 {
+		clientID = fs.Arg(0)
+	}
+	egress.check()
+
+	res := joinResult{ClientID: clientID}
+	if *check {
+		resolver := newWhereResolver(*where, 0, 0)
+		targets, err := resolver.fetch(context.Background(), clientID)
+		if err != nil {
+			res.fail(exitUnresolved, err.Error())
+			res.print(out)
+		}
+		res.Owner = targets[0]
 	}
-	q := url.Values{"client_id": []string{clientID}}
-	urlStr := target + "?" + q.Encode()
 
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Get(urlStr)
+	q := url.Values{"client_id": []string{clientID}}
+	urlStr := targetURL(joinTarget(), "/join") + "?" + q.Encode()
+	client := &http.Client{Timeout: 5 * time.Second, Transport: newTransport()}
+	req, err := http.NewRequest(http.MethodGet, urlStr, nil)
 	if err != nil {
-		log.Fatalf("request failed: %v", err)
+		log.Fatalf("bad join URL: %v", err)
+	}
+	resp, err := doJoin(client, req)
+	if err != nil {
+		res.fail(exitUnreachable, err.Error())
+		res.print(out)
 	}
 	defer resp.Body.Close()
 	body, _ := io.ReadAll(resp.Body)
-	fmt.Printf("status=%d body=%s\n", resp.StatusCode, string(body))
+	res.Status = resp.StatusCode
+	var join struct {
+		Assigned string `json:"assigned"`
+		Code     string `json:"code"`
+	}
+	_ = json.Unmarshal(body, &join)
+	res.Assigned = join.Assigned
+	if json.Valid(body) {
+		res.Response = body
+	}
+	switch {
+	case resp.StatusCode == http.StatusServiceUnavailable && join.Code == "NO_HEALTHY_REPLICA":
+		res.fail(exitUnresolved, join.Code)
+	case joinRetriable(resp, nil):
+		res.fail(exitUnreachable, fmt.Sprintf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body))))
+	case resp.StatusCode != http.StatusOK:
+		res.fail(exitFailed, fmt.Sprintf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body))))
+	case res.Owner != "" && replicaName(res.Assigned) != replicaName(res.Owner):
+		res.fail(exitMismatch, fmt.Sprintf("/where named %s, the join landed on %s", res.Owner, res.Assigned))
+	}
+	res.print(out)
+}
+
+// replicaName reduces a replica name to its first DNS label and port, so /join's ring target
+// (server-1.server-headless...:8081) and /where's hostname:port (server-1:8081) compare equal.
+// IP addresses are kept whole.
+func replicaName(name string) string {
+	host, port, err := net.SplitHostPort(name)
+	if err != nil {
+		host, port = name, ""
+	}
+	if net.ParseIP(host) == nil {
+		host, _, _ = strings.Cut(host, ".")
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// joinResult is the outcome of joinOnce.
+type joinResult struct {
+	ClientID string          `json:"client_id"`
+	Status   int             `json:"status,omitempty"`
+	Assigned string          `json:"assigned,omitempty"`
+	Owner    string          `json:"owner,omitempty"` // from /where, with --check
+	Result   string          `json:"result"`
+	Error    string          `json:"error,omitempty"`
+	Response json.RawMessage `json:"response,omitempty"`
+	exit     int
+}
+
+func (r *joinResult) fail(exit int, msg string) {
+	r.exit, r.Error = exit, msg
+}
+
+// print writes the result and exits with its code.
+func (r *joinResult) print(out *output) {
+	r.Result = exitNames[r.exit]
+	if out.table() {
+		fmt.Printf("%-24s %6s %-32s %-32s %-18s %s\n", "client_id", "status", "assigned", "owner", "result", "error")
+		fmt.Printf("%-24s %6d %-32s %-32s %-18s %s\n", r.ClientID, r.Status, r.Assigned, r.Owner, r.Result, r.Error)
+	} else {
+		out.emit(r, []string{"client_id", "status", "assigned", "owner", "result", "error"},
+			[][]string{{r.ClientID, strconv.Itoa(r.Status), r.Assigned, r.Owner, r.Result, r.Error}})
+	}
+	os.Exit(r.exit)
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+)
+
+// Output formats and exit codes. Commands that print results take --output: table (default,
+// aligned text for people), json (one object) or csv (a header row, then one row per result).
+// --json stays as a shorthand for --output json. Notes and PASS/FAIL lines go to stderr, so
+// stdout holds only the results.
+//
+// Exit codes are shared by every command, so a pipeline can branch on the outcome without parsing
+// stdout:
+//   - 0: every check passed
+//   - 1: a check failed for another reason (verify's deviation, a scenario step that errored, ...)
+//   - 2: resolution failed: /where, or the server behind Envoy, could not name an owner
+//   - 3: owner unreachable: the owner, or Envoy in front of it, could not be reached
+//   - 4: mismatch: a client landed on, or resolved to, a replica other than its owner
+//
+// When a run sees several classes, the first in the order 2, 3, 4, 1 decides the exit code.
+
+const (
+	exitOK          = 0
+	exitFailed      = 1
+	exitUnresolved  = 2
+	exitUnreachable = 3
+	exitMismatch    = 4
+)
+
+// exitNames names the exit codes in JSON and CSV output.
+var exitNames = map[int]string{
+	exitOK:          "ok",
+	exitFailed:      "failed",
+	exitUnresolved:  "resolution_failed",
+	exitUnreachable: "owner_unreachable",
+	exitMismatch:    "mismatch",
+}
+
+// exitRank orders the exit codes by precedence.
+var exitRank = map[int]int{exitFailed: 1, exitMismatch: 2, exitUnreachable: 3, exitUnresolved: 4}
+
+// worseExit returns whichever of a and b takes precedence.
+func worseExit(a, b int) int {
+	if exitRank[b] > exitRank[a] {
+		return b
+	}
+	return a
+}
+
+// output is a command's --output format.
+type output struct {
+	format string
+	json   bool
+}
+
+// addOutputFlags registers --output and its --json shorthand on fs.
+func addOutputFlags(fs *flag.FlagSet) *output {
+	o := &output{}
+	fs.StringVar(&o.format, "output", "table", "output format: table, json or csv")
+	fs.BoolVar(&o.json, "json", false, "shorthand for --output json")
+	return o
+}
+
+// check validates the format once the flags are parsed.
+func (o *output) check(cmd string) {
+	if o.json {
+		o.format = "json"
+	}
+	switch o.format {
+	case "table", "json", "csv":
+	default:
+		log.Fatalf("%s: unknown --output %q (table, json or csv)", cmd, o.format)
+	}
+}
+
+// table reports whether results are printed as a table; the command then prints them itself.
+func (o *output) table() bool {
+	return o.format == "table"
+}
+
+// emit prints doc as JSON, or header and rows as CSV, depending on the format.
+func (o *output) emit(doc any, header []string, rows [][]string) {
+	switch o.format {
+	case "json":
+		_ = json.NewEncoder(os.Stdout).Encode(doc)
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		_ = w.Write(header)
+		_ = w.WriteAll(rows)
+	}
+}
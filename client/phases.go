@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scenario phases. A soak run can be split into named phases (baseline, kill-replica-2,
+// scale-to-5, ...) by whoever drives the failure injection: --phase-file names a file whose first
+// line is the current phase, re-read every 500ms, and --phase-listen serves GET /phase and
+// POST /phase?name= (or the name as the body). Every event and join is tagged with the phase it
+// happened in, and the run ends with a per-phase report of joins, errors, reassignments,
+// reconnects, failovers and join latency.
+
+type phaseStats struct {
+	Name      string         `json:"name"`
+	Started   time.Time      `json:"started"`
+	Joins     int64          `json:"joins"`
+	Counts    map[string]int `json:"counts"`
+	latencies []time.Duration
+}
+
+type phaseTracker struct {
+	mu      sync.Mutex
+	current *phaseStats
+	order   []*phaseStats
+	byName  map[string]*phaseStats
+}
+
+func newPhaseTracker(initial string) *phaseTracker {
+	t := &phaseTracker{byName: make(map[string]*phaseStats)}
+	t.set(initial)
+	return t
+}
+
+// set switches to phase name; returning to an earlier phase adds to its totals.
+func (t *phaseTracker) set(name string) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.current != nil && t.current.Name == name {
+		return
+	}
+	p, ok := t.byName[name]
+	if !ok {
+		p = &phaseStats{Name: name, Started: time.Now(), Counts: make(map[string]int)}
+		t.byName[name] = p
+		t.order = append(t.order, p)
+	}
+	if t.current != nil {
+		log.Printf("phase %s -> %s", t.current.Name, name)
+	}
+	t.current = p
+}
+
+func (t *phaseTracker) name() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.current.Name
+}
+
+// event counts an event of kind in the current phase and returns the phase's name.
+func (t *phaseTracker) event(kind string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.current.Counts[kind]++
+	return t.current.Name
+}
+
+// join counts a join in phase name; latency is recorded for successful joins only (ok).
+func (t *phaseTracker) join(name string, latency time.Duration, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := t.byName[name]
+	p.Joins++
+	if ok {
+		p.latencies = append(p.latencies, latency)
+	}
+}
+
+// phaseSummary is one row of the per-phase report.
+type phaseSummary struct {
+	*phaseStats
+	P50Ms float64 `json:"p50_ms"`
+	P95Ms float64 `json:"p95_ms"`
+	P99Ms float64 `json:"p99_ms"`
+	MaxMs float64 `json:"max_ms"`
+}
+
+func (t *phaseTracker) summaries() []phaseSummary {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]phaseSummary, 0, len(t.order))
+	for _, p := range t.order {
+		lat := append([]time.Duration(nil), p.latencies...)
+		sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+		s := phaseSummary{phaseStats: p}
+		if len(lat) > 0 {
+			s.P50Ms, s.P95Ms, s.P99Ms = quantileMs(lat, 0.50), quantileMs(lat, 0.95), quantileMs(lat, 0.99)
+			s.MaxMs = float64(lat[len(lat)-1].Microseconds()) / 1000
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// quantileMs returns the q-quantile of sorted latencies in milliseconds.
+func quantileMs(sorted []time.Duration, q float64) float64 {
+	i := int(q*float64(len(sorted)-1) + 0.5)
+	return float64(sorted[i].Microseconds()) / 1000
+}
+
+// watchPhaseFile follows the first line of path until ctx is done.
+func (t *phaseTracker) watchPhaseFile(ctx context.Context, path string) {
+	var last time.Time
+	tick := time.NewTicker(500 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		if st, err := os.Stat(path); err == nil && !st.ModTime().Equal(last) {
+			last = st.ModTime()
+			if raw, err := os.ReadFile(path); err == nil {
+				line, _, _ := strings.Cut(string(raw), "\n")
+				t.set(line)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// servePhaseAPI serves GET/POST /phase on addr until ctx is done.
+func (t *phaseTracker) servePhaseAPI(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/phase", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost, http.MethodPut:
+			name := r.URL.Query().Get("name")
+			if name == "" {
+				body, _ := io.ReadAll(io.LimitReader(r.Body, 256))
+				name = string(body)
+			}
+			if strings.TrimSpace(name) == "" {
+				http.Error(w, "missing phase name", http.StatusBadRequest)
+				return
+			}
+			t.set(name)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		fmt.Fprintln(w, t.name())
+	})
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	log.Printf("phase control on http://%s/phase", addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("phase control: %v", err)
+	}
+}
+
+func printPhaseReport(phases []phaseSummary) {
+	fmt.Printf("%-24s %-8s %8s %7s %9s %10s %9s %9s %9s %9s %9s\n",
+		"phase", "started", "joins", "errors", "reassigns", "reconnects", "failovers", "p50_ms", "p95_ms", "p99_ms", "max_ms")
+	for _, p := range phases {
+		fmt.Printf("%-24s %-8s %8d %7d %9d %10d %9d %9.1f %9.1f %9.1f %9.1f\n",
+			p.Name, p.Started.Format("15:04:05"), p.Joins, p.Counts["error"], p.Counts["reassign"], p.Counts["reconnect"], p.Counts["failover"],
+			p.P50Ms, p.P95Ms, p.P99Ms, p.MaxMs)
+	}
+}
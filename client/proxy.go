@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// egressProxy chooses the proxy each request goes through. By default that is HTTPS_PROXY,
+// HTTP_PROXY and NO_PROXY from the environment. --proxy (or CLIENT_PROXY) replaces them with a
+// single http://, https://, socks5:// or socks5h:// proxy, or "direct" for none. --proxy-rule
+// host=proxy (repeatable, or comma-separated in CLIENT_PROXY_RULES) picks a proxy per destination
+// host before that; host may be a glob such as *.lab.internal, and the first match wins. That way
+// /where can cross the egress proxy to Envoy while --direct joins go straight to the replicas.
+type egressProxy struct {
+	proxy string
+	rules proxyRules
+}
+
+type proxyRule struct {
+	host  string
+	proxy *url.URL // nil means direct
+}
+
+type proxyRules []proxyRule
+
+func (r *proxyRules) String() string {
+	parts := make([]string, len(*r))
+	for i, rule := range *r {
+		parts[i] = rule.host + "=" + proxyString(rule.proxy)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (r *proxyRules) Set(v string) error {
+	host, proxy, ok := strings.Cut(v, "=")
+	if !ok || host == "" {
+		return fmt.Errorf("want host=proxy, got %q", v)
+	}
+	if _, err := path.Match(host, ""); err != nil {
+		return fmt.Errorf("bad host pattern %q: %v", host, err)
+	}
+	u, err := parseProxy(proxy)
+	if err != nil {
+		return err
+	}
+	*r = append(*r, proxyRule{host: strings.ToLower(host), proxy: u})
+	return nil
+}
+
+var egress = newEgressProxy()
+
+func newEgressProxy() *egressProxy {
+	e := &egressProxy{proxy: os.Getenv("CLIENT_PROXY")}
+	for _, rule := range strings.Split(os.Getenv("CLIENT_PROXY_RULES"), ",") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		if err := e.rules.Set(rule); err != nil {
+			log.Fatalf("CLIENT_PROXY_RULES: %v", err)
+		}
+	}
+	return e
+}
+
+// addProxyFlags registers --proxy and --proxy-rule on fs.
+func addProxyFlags(fs *flag.FlagSet) {
+	fs.StringVar(&egress.proxy, "proxy", egress.proxy, "proxy for every request (http://, https://, socks5://, socks5h:// or direct; default from HTTPS_PROXY/HTTP_PROXY)")
+	fs.Var(&egress.rules, "proxy-rule", "host=proxy per destination host (glob, repeatable; proxy may be direct)")
+}
+
+func parseProxy(v string) (*url.URL, error) {
+	v = strings.TrimSpace(v)
+	if v == "" || v == "direct" {
+		return nil, nil
+	}
+	u, err := url.Parse(v)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("bad proxy %q", v)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return u, nil
+	}
+	return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+}
+
+func proxyString(u *url.URL) string {
+	if u == nil {
+		return "direct"
+	}
+	return u.Redacted()
+}
+
+// forRequest is the http.Transport Proxy hook.
+func (e *egressProxy) forRequest(req *http.Request) (*url.URL, error) {
+	host := strings.ToLower(req.URL.Hostname())
+	if _, ok := unixSockets.path(host); ok {
+		return nil, nil
+	}
+	for _, rule := range e.rules {
+		if ok, _ := path.Match(rule.host, host); ok {
+			return rule.proxy, nil
+		}
+	}
+	if e.proxy == "" {
+		return http.ProxyFromEnvironment(req)
+	}
+	return parseProxy(e.proxy)
+}
+
+// check fails fast on a bad --proxy instead of on the first request.
+func (e *egressProxy) check() {
+	if _, err := parseProxy(e.proxy); err != nil {
+		log.Fatalf("proxy: %v", err)
+	}
+}
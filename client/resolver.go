@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// whereResolver resolves client IDs to replicas via /where and caches the answers:
+//   - younger than maxAge: served from cache
+//   - older, but within maxAge+stale: served from cache while one background request revalidates it
+//     (If-None-Match, so an unchanged assignment costs a 304)
+//   - older still, or invalidated after a connection failure: resolved before returning
+//
+// An answer is the owner followed by its standby when the server has one configured (STANDBY_PAIRS).
+type whereResolver struct {
+	url    string
+	maxAge time.Duration
+	stale  time.Duration
+	client *http.Client
+
+	mu      sync.Mutex
+	entries map[string]*whereEntry
+
+	hits, staleHits, misses, revalidated atomic.Int64
+}
+
+type whereEntry struct {
+	targets    []string // owner, then standby
+	etag       string
+	fetched    time.Time
+	refreshing bool
+}
+
+// whereTarget returns the /where URL, from WHERE_URL or the local Envoy default.
+func whereTarget() string {
+	if v := os.Getenv("WHERE_URL"); v != "" {
+		return v
+	}
+	return "http://localhost:10000/where"
+}
+
+func newWhereResolver(whereURL string, maxAge, stale time.Duration) *whereResolver {
+	return &whereResolver{
+		url:     targetURL(whereURL, "/where"),
+		maxAge:  maxAge,
+		stale:   stale,
+		client:  &http.Client{Timeout: 5 * time.Second, Transport: newTransport()},
+		entries: make(map[string]*whereEntry),
+	}
+}
+
+// resolve returns the owner host:port for clientID, followed by its standby if it has one.
+func (r *whereResolver) resolve(ctx context.Context, clientID string) ([]string, error) {
+	r.mu.Lock()
+	e, ok := r.entries[clientID]
+	if ok {
+		age := time.Since(e.fetched)
+		switch {
+		case age < r.maxAge:
+			r.mu.Unlock()
+			r.hits.Add(1)
+			return e.targets, nil
+		case age < r.maxAge+r.stale:
+			targets := e.targets
+			if !e.refreshing {
+				e.refreshing = true
+				go func() { _, _ = r.fetch(context.Background(), clientID) }()
+			}
+			r.mu.Unlock()
+			r.staleHits.Add(1)
+			return targets, nil
+		}
+	}
+	r.mu.Unlock()
+	r.misses.Add(1)
+	return r.fetch(ctx, clientID)
+}
+
+// fetch queries /where, revalidating with the cached ETag when there is one.
+func (r *whereResolver) fetch(ctx context.Context, clientID string) ([]string, error) {
+	r.mu.Lock()
+	var etag string
+	var cached []string
+	if e, ok := r.entries[clientID]; ok {
+		etag, cached = e.etag, e.targets
+	}
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		if e, ok := r.entries[clientID]; ok {
+			e.refreshing = false
+		}
+		r.mu.Unlock()
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url+"?"+url.Values{"client_id": {clientID}}.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	targets := cached
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		r.revalidated.Add(1)
+	case http.StatusOK:
+		var body struct {
+			HostPort string `json:"hostport"`
+			Standby  string `json:"standby"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.HostPort == "" {
+			return nil, fmt.Errorf("where: bad response body")
+		}
+		targets = []string{body.HostPort}
+		if body.Standby != "" && body.Standby != body.HostPort {
+			targets = append(targets, body.Standby)
+		}
+	default:
+		return nil, fmt.Errorf("where: status %d", resp.StatusCode)
+	}
+	r.mu.Lock()
+	r.entries[clientID] = &whereEntry{targets: targets, etag: resp.Header.Get("ETag"), fetched: time.Now()}
+	r.mu.Unlock()
+	return targets, nil
+}
+
+// invalidate drops clientID's cached resolution, e.g. after its replica refused a connection.
+func (r *whereResolver) invalidate(clientID string) {
+	r.mu.Lock()
+	delete(r.entries, clientID)
+	r.mu.Unlock()
+}
+
+func (r *whereResolver) stats() string {
+	return fmt.Sprintf("hits=%d stale=%d misses=%d revalidated=%d",
+		r.hits.Load(), r.staleHits.Load(), r.misses.Load(), r.revalidated.Load())
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runScenario implements `client scenario`: it drives a running Compose or Kubernetes environment
+// through a list of disruptive steps and checks the routing invariants after each one. Steps go
+// through the docker / kubectl CLIs (no SDKs, so no module dependencies):
+//   - scale-up / scale-down: one server replica more or fewer
+//   - kill: kill one replica (Compose) or delete its pod (Kubernetes), then bring it back
+//   - rolling-restart: restart the replicas one at a time (Compose) or roll the StatefulSet
+//   - registry-outage: stop the registry (Compose service or Deployment scaled to 0), check, start it
+//
+// After a step the invariants are polled until they all hold or --settle runs out:
+//   - every one of --ids sample clients resolves through /where, to the same owner twice in a row
+//   - every owner is a target on /ring, and a healthy one
+//   - /cluster/status reports config_consistent and the same ring_version as /ring
+//
+// The run ends with a per-step report (--output table, json or csv). When a step failed, the exit
+// code names what broke (see output.go): unresolved clients, owners on /ring marked unhealthy or an
+// unreachable server, clients resolving inconsistently, or else a step that could not be performed.
+//
+// It is a client subcommand rather than a cmd/scenario of its own because it shares the client's
+// --output formats and exit codes (output.go), egress proxy flags (proxy.go, transport.go) and
+// build reports (build.go). The client module is a single main package, so a separate command
+// would have to copy all of that.
+
+// scenarioDownWait is how long the invariants are given to hold while a replica or the registry is down.
+const scenarioDownWait = 15 * time.Second
+
+type scenarioStep struct {
+	Name       string   `json:"name"`
+	Pass       bool     `json:"pass"`
+	Error      string   `json:"error,omitempty"`
+	Violations []string `json:"violations,omitempty"`
+	Replicas   int      `json:"replicas"`
+	Moved      int      `json:"moved"` // sample clients whose owner changed during the step
+	Settled    string   `json:"settled"`
+	Failure    string   `json:"failure,omitempty"` // exit class of a failed step, see output.go
+	exit       int
+}
+
+// orchestrator performs the steps on one kind of environment.
+type orchestrator interface {
+	replicas() (int, error)
+	scale(n int) error
+	kill() (restore func() error, err error)
+	rollingRestart(settle func() error) error
+	setRegistry(up bool) error
+}
+
+func runScenario(args []string) {
+	fs := flag.NewFlagSet("scenario", flag.ExitOnError)
+	env := fs.String("env", "compose", "environment: compose or k8s")
+	steps := fs.String("steps", "scale-up,scale-down,kill,rolling-restart,registry-outage", "comma-separated steps to run in order")
+	server := fs.String("server", "http://localhost:10000", "base URL serving /where, /ring and /cluster/status")
+	ids := fs.Int("ids", 500, "sample client IDs resolved after each step")
+	settle := fs.Duration("settle", 90*time.Second, "how long the invariants may take to hold again after a step")
+	composeFile := fs.String("compose-file", "docker-compose.yaml", "Compose file (compose)")
+	service := fs.String("service", "server", "Compose service or StatefulSet of the replicas")
+	registrySvc := fs.String("registry", "redis", "Compose service or Deployment of the registry")
+	namespace := fs.String("namespace", "poc-routing", "namespace (k8s)")
+	out := addOutputFlags(fs)
+	addProxyFlags(fs)
+	_ = fs.Parse(args)
+	out.check("scenario")
+	egress.check()
+
+	var orch orchestrator
+	switch *env {
+	case "compose":
+		orch = composeOrchestrator{file: *composeFile, service: *service, registry: *registrySvc}
+	case "k8s":
+		orch = k8sOrchestrator{namespace: *namespace, statefulSet: *service, registry: *registrySvc, timeout: *settle}
+	default:
+		log.Fatalf("scenario: unknown --env %q", *env)
+	}
+	c := &scenarioChecker{
+		base:   strings.TrimSuffix(*server, "/"),
+		client: &http.Client{Timeout: 5 * time.Second, Transport: newTransport()},
+	}
+	for i := 0; i < *ids; i++ {
+		c.ids = append(c.ids, fmt.Sprintf("scenario-%04d", i))
+	}
+
+	build := newReportBuild(c.client, c.base+"/version")
+	log.Printf("scenario: client %s, server %s", build.Client, build.serverVersion())
+	before, violations := c.check()
+	if len(violations) > 0 {
+		log.Fatalf("scenario: invariants don't hold before the first step: %s", strings.Join(violations, "; "))
+	}
+	var report []scenarioStep
+	for _, name := range strings.Split(*steps, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		log.Printf("scenario: step %s", name)
+		step := scenarioStep{Name: name}
+		start := time.Now()
+		settleFn := func() error {
+			_, v := c.waitSettled(*settle)
+			if len(v) > 0 {
+				return fmt.Errorf("%s", strings.Join(v, "; "))
+			}
+			return nil
+		}
+		if err := runScenarioStep(orch, name, c, settleFn); err != nil {
+			step.Error = err.Error()
+		}
+		owners, v := c.waitSettled(*settle)
+		step.Violations = v
+		for id, owner := range owners {
+			if before[id] != "" && before[id] != owner {
+				step.Moved++
+			}
+		}
+		before = owners
+		step.Replicas, _ = orch.replicas()
+		step.Settled = time.Since(start).Round(time.Second).String()
+		step.Pass = step.Error == "" && len(step.Violations) == 0
+		if !step.Pass {
+			step.exit = exitFailed
+			if len(step.Violations) > 0 {
+				step.exit = c.exit
+			}
+			step.Failure = exitNames[step.exit]
+		}
+		report = append(report, step)
+	}
+
+	failed, exit := 0, exitOK
+	for _, s := range report {
+		if !s.Pass {
+			failed++
+			exit = worseExit(exit, s.exit)
+		}
+	}
+	if !out.table() {
+		rows := make([][]string, len(report))
+		for i, s := range report {
+			rows[i] = []string{s.Name, strconv.FormatBool(s.Pass), strconv.Itoa(s.Replicas), strconv.Itoa(s.Moved), s.Settled, s.Failure, s.detail()}
+		}
+		out.emit(map[string]any{"env": *env, "ids": *ids, "build": build, "steps": report, "pass": failed == 0},
+			[]string{"step", "pass", "replicas", "moved", "took", "failure", "detail"}, rows)
+	} else {
+		fmt.Printf("client %s, server %s\n", build.Client, build.serverVersion())
+		fmt.Printf("%-18s %-6s %8s %6s %8s  %s\n", "step", "result", "replicas", "moved", "took", "detail")
+		for _, s := range report {
+			result := "PASS"
+			if !s.Pass {
+				result = "FAIL"
+			}
+			fmt.Printf("%-18s %-6s %8d %6d %8s  %s\n", s.Name, result, s.Replicas, s.Moved, s.Settled, s.detail())
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "FAIL: %d of %d steps failed (%s)\n", failed, len(report), exitNames[exit])
+		os.Exit(exit)
+	}
+	fmt.Fprintln(os.Stderr, "PASS")
+}
+
+// detail joins the step's error and violations.
+func (s scenarioStep) detail() string {
+	return strings.TrimPrefix(strings.Join(append([]string{s.Error}, s.Violations...), "; "), "; ")
+}
+
+// runScenarioStep performs step name; settle waits for the invariants between sub-steps.
+func runScenarioStep(orch orchestrator, name string, c *scenarioChecker, settle func() error) error {
+	switch name {
+	case "scale-up", "scale-down":
+		n, err := orch.replicas()
+		if err != nil {
+			return err
+		}
+		if name == "scale-down" {
+			if n <= 1 {
+				return fmt.Errorf("only %d replica, not scaling down", n)
+			}
+			return orch.scale(n - 1)
+		}
+		return orch.scale(n + 1)
+	case "kill":
+		restore, err := orch.kill()
+		if err != nil {
+			return err
+		}
+		// Resolutions must keep working while the replica is down; the owners may move.
+		if _, v := c.waitSettled(scenarioDownWait); len(v) > 0 {
+			log.Printf("scenario: while a replica was down: %s", strings.Join(v, "; "))
+		}
+		return restore()
+	case "rolling-restart":
+		return orch.rollingRestart(settle)
+	case "registry-outage":
+		if err := orch.setRegistry(false); err != nil {
+			return err
+		}
+		// Registry outages must not fail resolutions (see "Assignment registry" in the README).
+		_, v := c.waitSettled(scenarioDownWait)
+		if err := orch.setRegistry(true); err != nil {
+			return err
+		}
+		if len(v) > 0 {
+			return fmt.Errorf("during the outage: %s", strings.Join(v, "; "))
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown step %q", name)
+}
+
+type scenarioChecker struct {
+	base   string
+	client *http.Client
+	ids    []string
+	exit   int // exit class of the last check's violations
+}
+
+// waitSettled polls check until it reports no violations or timeout passes.
+func (c *scenarioChecker) waitSettled(timeout time.Duration) (map[string]string, []string) {
+	deadline := time.Now().Add(timeout)
+	for {
+		owners, v := c.check()
+		if len(v) == 0 || time.Now().After(deadline) {
+			return owners, v
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+func (c *scenarioChecker) getJSON(path string, out any) error {
+	resp, err := c.client.Get(c.base + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: status %d", path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// check resolves the sample clients and checks every invariant once, returning the owners. The
+// exit class of the violations is left in c.exit: a server that cannot be reached or owners marked
+// unhealthy are exitUnreachable, clients that don't resolve exitUnresolved, and disagreement
+// (unstable owners, owners outside the ring, config or ring version drift) exitMismatch.
+func (c *scenarioChecker) check() (map[string]string, []string) {
+	var violations []string
+	c.exit = exitOK
+	var ring struct {
+		RingVersion string `json:"ring_version"`
+		Replicas    []struct {
+			Target  string `json:"target"`
+			Healthy bool   `json:"healthy"`
+		} `json:"replicas"`
+	}
+	if err := c.getJSON("/ring?sample=0", &ring); err != nil {
+		c.exit = exitUnreachable
+		return nil, []string{"ring: " + err.Error()}
+	}
+	healthy := make(map[string]bool, len(ring.Replicas))
+	for _, r := range ring.Replicas {
+		healthy[r.Target] = r.Healthy
+	}
+	var status struct {
+		RingVersion      string `json:"ring_version"`
+		ConfigConsistent bool   `json:"config_consistent"`
+	}
+	if err := c.getJSON("/cluster/status", &status); err != nil {
+		violations = append(violations, "cluster status: "+err.Error())
+		c.exit = worseExit(c.exit, exitUnreachable)
+	} else {
+		if !status.ConfigConsistent {
+			violations = append(violations, "replicas disagree on the routing config")
+			c.exit = worseExit(c.exit, exitMismatch)
+		}
+		if status.RingVersion != ring.RingVersion {
+			violations = append(violations, fmt.Sprintf("ring_version %s on /cluster/status, %s on /ring", status.RingVersion, ring.RingVersion))
+			c.exit = worseExit(c.exit, exitMismatch)
+		}
+	}
+
+	owners := make(map[string]string, len(c.ids))
+	unresolved, unstable, unknown, unhealthy := 0, 0, 0, 0
+	resolver := newWhereResolver(c.base+"/where", 0, 0)
+	for _, id := range c.ids {
+		first, err := resolver.fetch(context.Background(), id)
+		resolver.invalidate(id)
+		if err != nil {
+			unresolved++
+			continue
+		}
+		second, err := resolver.fetch(context.Background(), id)
+		resolver.invalidate(id)
+		if err != nil || second[0] != first[0] {
+			unstable++
+		}
+		owner := first[0]
+		owners[id] = owner
+		if up, ok := healthy[owner]; !ok {
+			unknown++
+		} else if !up {
+			unhealthy++
+		}
+	}
+	for _, v := range []struct {
+		n    int
+		what string
+		exit int
+	}{
+		{unresolved, "did not resolve", exitUnresolved},
+		{unstable, "resolved to different owners back to back", exitMismatch},
+		{unknown, "resolved to a replica not on /ring", exitMismatch},
+		{unhealthy, "resolved to an unhealthy replica", exitUnreachable},
+	} {
+		if v.n > 0 {
+			violations = append(violations, strconv.Itoa(v.n)+" clients "+v.what)
+			c.exit = worseExit(c.exit, v.exit)
+		}
+	}
+	return owners, violations
+}
+
+// run executes an orchestration command, returning its trimmed output.
+func run(name string, args ...string) (string, error) {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+type composeOrchestrator struct {
+	file, service, registry string
+}
+
+func (o composeOrchestrator) compose(args ...string) (string, error) {
+	return run("docker", append([]string{"compose", "-f", o.file}, args...)...)
+}
+
+func (o composeOrchestrator) containers() ([]string, error) {
+	out, err := o.compose("ps", "-q", o.service)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(out), nil
+}
+
+func (o composeOrchestrator) replicas() (int, error) {
+	ids, err := o.containers()
+	return len(ids), err
+}
+
+func (o composeOrchestrator) scale(n int) error {
+	_, err := o.compose("up", "-d", "--no-recreate", "--scale", o.service+"="+strconv.Itoa(n), o.service)
+	return err
+}
+
+func (o composeOrchestrator) kill() (func() error, error) {
+	ids, err := o.containers()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no %s containers", o.service)
+	}
+	if _, err := run("docker", "kill", ids[0]); err != nil {
+		return nil, err
+	}
+	return func() error {
+		_, err := run("docker", "start", ids[0])
+		return err
+	}, nil
+}
+
+func (o composeOrchestrator) rollingRestart(settle func() error) error {
+	ids, err := o.containers()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if _, err := run("docker", "restart", id); err != nil {
+			return err
+		}
+		if err := settle(); err != nil {
+			return fmt.Errorf("after restarting %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+func (o composeOrchestrator) setRegistry(up bool) error {
+	action := "stop"
+	if up {
+		action = "start"
+	}
+	_, err := o.compose(action, o.registry)
+	return err
+}
+
+type k8sOrchestrator struct {
+	namespace, statefulSet, registry string
+	timeout                          time.Duration
+}
+
+func (o k8sOrchestrator) kubectl(args ...string) (string, error) {
+	return run("kubectl", append([]string{"-n", o.namespace}, args...)...)
+}
+
+func (o k8sOrchestrator) rollout() error {
+	_, err := o.kubectl("rollout", "status", "statefulset/"+o.statefulSet, "--timeout="+o.timeout.String())
+	return err
+}
+
+func (o k8sOrchestrator) replicas() (int, error) {
+	out, err := o.kubectl("get", "statefulset", o.statefulSet, "-o", "jsonpath={.spec.replicas}")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(out)
+}
+
+func (o k8sOrchestrator) scale(n int) error {
+	if _, err := o.kubectl("scale", "statefulset/"+o.statefulSet, "--replicas="+strconv.Itoa(n)); err != nil {
+		return err
+	}
+	return o.rollout()
+}
+
+// kill deletes the StatefulSet's first pod; the StatefulSet recreates it, so restoring is waiting.
+func (o k8sOrchestrator) kill() (func() error, error) {
+	if _, err := o.kubectl("delete", "pod", o.statefulSet+"-0", "--wait=false"); err != nil {
+		return nil, err
+	}
+	return o.rollout, nil
+}
+
+func (o k8sOrchestrator) rollingRestart(settle func() error) error {
+	if _, err := o.kubectl("rollout", "restart", "statefulset/"+o.statefulSet); err != nil {
+		return err
+	}
+	if err := o.rollout(); err != nil {
+		return err
+	}
+	return settle()
+}
+
+func (o k8sOrchestrator) setRegistry(up bool) error {
+	n := "0"
+	if up {
+		n = "1"
+	}
+	_, err := o.kubectl("scale", "deployment/"+o.registry, "--replicas="+n)
+	return err
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// soakEvent records a forced reconnection, reassignment, standby failover or failed join for one client.
+type soakEvent struct {
+	Time     time.Time `json:"ts"`
+	ClientID string    `json:"client_id"`
+	Kind     string    `json:"kind"` // reconnect, reassign, failover, error
+	Cause    string    `json:"cause"`
+	From     string    `json:"from,omitempty"`
+	To       string    `json:"to,omitempty"`
+	Phase    string    `json:"phase"`
+}
+
+type soakReport struct {
+	Started     time.Time      `json:"started"`
+	Finished    time.Time      `json:"finished"`
+	Interrupted bool           `json:"interrupted"`
+	Clients     int            `json:"clients"`
+	Build       reportBuild    `json:"build"`
+	Joins       int64          `json:"joins"`
+	Counts      map[string]int `json:"counts"`
+	Phases      []phaseSummary `json:"phases"`
+	Events      []soakEvent    `json:"events"`
+	phases      *phaseTracker
+	mu          sync.Mutex
+}
+
+func (r *soakReport) record(ev soakEvent) {
+	ev.Phase = r.phases.event(ev.Kind)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Events = append(r.Events, ev)
+	r.Counts[ev.Kind]++
+}
+
+// runSoak implements `client soak`: every simulated client keeps its own keep-alive connection
+// and re-joins on an interval, recording each time the connection had to be re-established,
+// the assigned replica changed, or the join failed. Events and joins are tagged with the
+// scenario phase (see phases.go).
+func runSoak(args []string) {
+	fs := flag.NewFlagSet("soak", flag.ExitOnError)
+	duration := fs.Duration("duration", time.Minute, "how long to run")
+	clients := fs.Int("clients", 10, "number of simulated clients")
+	interval := fs.Duration("interval", 5*time.Second, "delay between joins per client")
+	target := fs.String("target", joinTarget(), "/join URL")
+	prefix := fs.String("id-prefix", "soak-", "client_id prefix")
+	out := fs.String("report", "soak-report.csv", "report file (.csv or .json)")
+	direct := fs.Bool("direct", false, "resolve owners via /where (cached) and join them directly instead of through Envoy")
+	where := fs.String("where", whereTarget(), "/where URL for --direct")
+	maxAge := fs.Duration("cache-max-age", 30*time.Second, "with --direct, how long a resolution is served without revalidation")
+	stale := fs.Duration("cache-stale", 5*time.Minute, "with --direct, how long past max-age a resolution is served while revalidating")
+	phase := fs.String("phase", "baseline", "name of the initial scenario phase")
+	phaseFile := fs.String("phase-file", "", "file whose first line names the current phase, re-read as it changes")
+	phaseListen := fs.String("phase-listen", "", "address to serve GET/POST /phase on, e.g. 127.0.0.1:9700")
+	addProxyFlags(fs)
+	addJoinRetryFlags(fs)
+	_ = fs.Parse(args)
+	egress.check()
+
+	var resolver *whereResolver
+	if *direct {
+		resolver = newWhereResolver(*where, *maxAge, *stale)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	report := &soakReport{Started: time.Now(), Clients: *clients, Counts: make(map[string]int), phases: newPhaseTracker(*phase)}
+	report.Build = newReportBuild(&http.Client{Timeout: 5 * time.Second, Transport: newTransport()}, targetURL(*target, "/join"))
+	log.Printf("soak: client %s, server %s", report.Build.Client, report.Build.serverVersion())
+	if *phaseFile != "" {
+		go report.phases.watchPhaseFile(ctx, *phaseFile)
+	}
+	if *phaseListen != "" {
+		go report.phases.servePhaseAPI(ctx, *phaseListen)
+	}
+	var joins sync.WaitGroup
+	for i := 0; i < *clients; i++ {
+		joins.Add(1)
+		go func(clientID string) {
+			defer joins.Done()
+			n := soakClient(ctx, targetURL(*target, "/join"), clientID, *interval, resolver, report)
+			report.mu.Lock()
+			report.Joins += n
+			report.mu.Unlock()
+		}(*prefix + strconv.Itoa(i))
+		// Spread connection setup over the first interval.
+		time.Sleep(*interval / time.Duration(*clients+1))
+	}
+	joins.Wait()
+	report.Finished = time.Now()
+	report.Interrupted = ctx.Err() == context.Canceled
+	report.Phases = report.phases.summaries()
+
+	if err := writeSoakReport(*out, report); err != nil {
+		log.Fatalf("write report: %v", err)
+	}
+	fmt.Printf("soak finished: clients=%d joins=%d reconnects=%d reassignments=%d failovers=%d errors=%d report=%s\n",
+		report.Clients, report.Joins, report.Counts["reconnect"], report.Counts["reassign"], report.Counts["failover"], report.Counts["error"], *out)
+	if resolver != nil {
+		fmt.Printf("where cache: %s\n", resolver.stats())
+	}
+	if *phaseFile != "" || *phaseListen != "" || len(report.Phases) > 1 {
+		printPhaseReport(report.Phases)
+	}
+}
+
+// soakClient runs one client until ctx is done and returns how many joins it made. With a resolver
+// it joins the resolved owner directly, dropping the cached resolution when the join fails or lands
+// elsewhere. If the owner cannot be reached and /where named a standby, the same join is retried
+// on the standby and recorded as a failover.
+func soakClient(ctx context.Context, target, clientID string, interval time.Duration, resolver *whereResolver, report *soakReport) int64 {
+	transport := newTransport()
+	transport.MaxConnsPerHost, transport.MaxIdleConnsPerHost, transport.IdleConnTimeout = 1, 1, 10*interval
+	client := &http.Client{Timeout: 5 * time.Second, Transport: transport}
+	urlStr := target + "?" + url.Values{"client_id": []string{clientID}}.Encode()
+	var assigned string
+	var joins int64
+	connected := false
+	for {
+		var hostport, standby string
+		if resolver != nil {
+			targets, err := resolver.resolve(ctx, clientID)
+			if err != nil {
+				if ctx.Err() != nil {
+					return joins
+				}
+				report.record(soakEvent{Time: time.Now(), ClientID: clientID, Kind: "error", Cause: err.Error()})
+				select {
+				case <-ctx.Done():
+					return joins
+				case <-time.After(interval):
+				}
+				continue
+			}
+			hostport = targets[0]
+			if len(targets) > 1 {
+				standby = targets[1]
+			}
+			urlStr = directJoinURL(hostport, clientID)
+		}
+		phase := report.phases.name()
+		start := time.Now()
+		reused := false
+		trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused }}
+		req, _ := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodGet, urlStr, nil)
+		resp, err := doJoin(client, req)
+		if err != nil && standby != "" && ctx.Err() == nil {
+			report.record(soakEvent{Time: time.Now(), ClientID: clientID, Kind: "failover", Cause: err.Error(), From: hostport, To: standby})
+			resolver.invalidate(clientID)
+			hostport = standby
+			key := req.Header.Get(idempotencyKeyHeader)
+			req, _ = http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodGet, directJoinURL(standby, clientID), nil)
+			req.Header.Set(idempotencyKeyHeader, key)
+			resp, err = doJoin(client, req)
+		}
+		if ctx.Err() != nil {
+			return joins
+		}
+		joins++
+		latency := time.Since(start)
+		report.phases.join(phase, latency, err == nil && resp.StatusCode == http.StatusOK)
+		switch {
+		case err != nil:
+			report.record(soakEvent{Time: time.Now(), ClientID: clientID, Kind: "error", Cause: err.Error()})
+			connected = false
+			if resolver != nil {
+				resolver.invalidate(clientID)
+			}
+		default:
+			var body struct {
+				Assigned string `json:"assigned"`
+			}
+			raw, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			_ = json.Unmarshal(raw, &body)
+			if connected && !reused {
+				report.record(soakEvent{Time: time.Now(), ClientID: clientID, Kind: "reconnect", Cause: "connection closed by peer"})
+			}
+			connected = true
+			if resp.StatusCode != http.StatusOK {
+				report.record(soakEvent{Time: time.Now(), ClientID: clientID, Kind: "error", Cause: fmt.Sprintf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(raw)))})
+				break
+			}
+			if assigned != "" && body.Assigned != assigned {
+				report.record(soakEvent{Time: time.Now(), ClientID: clientID, Kind: "reassign", Cause: "assigned replica changed", From: assigned, To: body.Assigned})
+			}
+			assigned = body.Assigned
+			if resolver != nil && resp.Request.URL.Host != hostport {
+				resolver.invalidate(clientID) // redirected to the new owner
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return joins
+		case <-time.After(interval):
+		}
+	}
+}
+
+func directJoinURL(hostport, clientID string) string {
+	return "http://" + hostport + "/join?" + url.Values{"client_id": []string{clientID}}.Encode()
+}
+
+func writeSoakReport(path string, r *soakReport) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+	w := csv.NewWriter(f)
+	_ = w.Write([]string{"ts", "client_id", "kind", "cause", "from", "to", "phase"})
+	for _, ev := range r.Events {
+		_ = w.Write([]string{ev.Time.Format(time.RFC3339Nano), ev.ClientID, ev.Kind, ev.Cause, ev.From, ev.To, ev.Phase})
+	}
+	w.Flush()
+	return w.Error()
+}
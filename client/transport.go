@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Client transports. Every URL the client takes (ENVOY_URL, WHERE_URL, --target, --where, --ring)
+// may be unix:///path/to.sock instead of http://host:port/..., optionally followed by the HTTP path
+// after a colon (unix:///var/run/routing.sock:/where); without one the command's usual path is used.
+// Such requests are dialled over the Unix domain socket and never go through a proxy. Each socket
+// is given a placeholder host, unix-<n>, which requests carry in place of a real one.
+
+type unixSocketSet struct {
+	mu    sync.Mutex
+	hosts map[string]string // placeholder host -> socket path
+	paths map[string]string // socket path -> placeholder host
+}
+
+var unixSockets = &unixSocketSet{hosts: make(map[string]string), paths: make(map[string]string)}
+
+// host returns the placeholder host for socket path.
+func (s *unixSocketSet) host(path string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h, ok := s.paths[path]; ok {
+		return h
+	}
+	h := "unix-" + strconv.Itoa(len(s.paths))
+	s.paths[path], s.hosts[h] = h, path
+	return h
+}
+
+func (s *unixSocketSet) path(host string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.hosts[host]
+	return p, ok
+}
+
+// targetURL turns a unix:// target into the http:// URL requests use; defPath is the HTTP path when
+// the target names none. Other targets are returned unchanged.
+func targetURL(target, defPath string) string {
+	rest, ok := strings.CutPrefix(target, "unix://")
+	if !ok {
+		return target
+	}
+	sock, path := rest, defPath
+	if i := strings.Index(rest, ":/"); i >= 0 {
+		sock, path = rest[:i], rest[i+1:]
+	}
+	return "http://" + unixSockets.host(sock) + path
+}
+
+// newTransport is http.DefaultTransport with proxy selection from egress and unix:// dialling.
+func newTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = egress.forRequest
+	dial := t.DialContext
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		if path, ok := unixSockets.path(host); ok {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+		return dial(ctx, network, addr)
+	}
+	return t
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runVerify implements `client verify`: it resolves every ID in --ids-file through /where, groups
+// them by replica and compares the counts with the expected share of each replica, taken from the
+// server's /ring (the configured algorithm's exact split of the hash space) or uniform. It exits 1
+// (exitFailed) when any replica deviates from its expected count by more than --max-deviation, and
+// 2 (exitUnresolved) when IDs could not be resolved, so it can gate a deployment.
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	idsFile := fs.String("ids-file", "", "file with one client_id per line (- for stdin)")
+	where := fs.String("where", whereTarget(), "/where URL")
+	ringURL := fs.String("ring", "", "/ring URL for the expected distribution (default: next to --where)")
+	expect := fs.String("expect", "ring", "expected distribution: ring or uniform")
+	maxDev := fs.Float64("max-deviation", 0.10, "largest allowed |observed-expected|/expected per replica")
+	concurrency := fs.Int("concurrency", 32, "parallel /where requests")
+	out := addOutputFlags(fs)
+	addProxyFlags(fs)
+	_ = fs.Parse(args)
+	out.check("verify")
+	egress.check()
+	if *idsFile == "" {
+		log.Fatal("verify: --ids-file is required")
+	}
+	ids, err := readIDs(*idsFile)
+	if err != nil {
+		log.Fatalf("verify: %v", err)
+	}
+	if len(ids) == 0 {
+		log.Fatal("verify: no client IDs in --ids-file")
+	}
+
+	resolver := newWhereResolver(*where, 0, 0)
+	observed := make(map[string]int)
+	failed := 0
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	work := make(chan string)
+	for i := 0; i < max(*concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range work {
+				targets, err := resolver.fetch(context.Background(), id)
+				resolver.invalidate(id)
+				mu.Lock()
+				if err != nil {
+					failed++
+				} else {
+					observed[targets[0]]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, id := range ids {
+		work <- id
+	}
+	close(work)
+	wg.Wait()
+
+	shares := map[string]float64{}
+	if *expect == "ring" {
+		if *ringURL == "" {
+			*ringURL = strings.TrimSuffix(resolver.url, "/where") + "/ring"
+		}
+		*ringURL = targetURL(*ringURL, "/ring")
+		shares, err = fetchRingShares(*ringURL)
+		if err != nil {
+			log.Fatalf("verify: expected distribution from %s: %v (use --expect uniform)", *ringURL, err)
+		}
+	} else {
+		for r := range observed {
+			shares[r] = 1 / float64(len(observed))
+		}
+	}
+
+	type row struct {
+		Replica   string  `json:"replica"`
+		Observed  int     `json:"observed"`
+		Expected  float64 `json:"expected"`
+		Deviation float64 `json:"deviation"`
+	}
+	resolved := len(ids) - failed
+	var rows []row
+	worst, chi2 := 0.0, 0.0
+	seen := make(map[string]bool)
+	for r, share := range shares {
+		seen[r] = true
+		exp := share * float64(resolved)
+		dev := 0.0
+		if exp > 0 {
+			dev = math.Abs(float64(observed[r])-exp) / exp
+			chi2 += math.Pow(float64(observed[r])-exp, 2) / exp
+		}
+		worst = max(worst, dev)
+		rows = append(rows, row{Replica: r, Observed: observed[r], Expected: exp, Deviation: dev})
+	}
+	for r, n := range observed {
+		if !seen[r] {
+			// Resolved to a replica outside the expected set (failover, ROUTING_EXPR, ...).
+			worst = math.Inf(1)
+			rows = append(rows, row{Replica: r, Observed: n, Deviation: math.Inf(1)})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Replica < rows[j].Replica })
+	pass := worst <= *maxDev && failed == 0
+
+	if !out.table() {
+		// JSON has no infinity; an unexpected replica is reported with deviation -1, in CSV too.
+		csvRows := make([][]string, len(rows))
+		for i := range rows {
+			if math.IsInf(rows[i].Deviation, 1) {
+				rows[i].Deviation = -1
+			}
+			r := rows[i]
+			csvRows[i] = []string{r.Replica, strconv.Itoa(r.Observed), strconv.FormatFloat(r.Expected, 'f', 1, 64), strconv.FormatFloat(r.Deviation, 'f', 4, 64)}
+		}
+		out.emit(map[string]any{
+			"ids": len(ids), "failed": failed, "expect": *expect, "max_deviation": *maxDev,
+			"replicas": rows, "chi_square": chi2, "pass": pass,
+		}, []string{"replica", "observed", "expected", "deviation"}, csvRows)
+	} else {
+		fmt.Printf("%-40s %10s %12s %10s\n", "replica", "observed", "expected", "deviation")
+		for _, r := range rows {
+			fmt.Printf("%-40s %10d %12.1f %9.2f%%\n", r.Replica, r.Observed, r.Expected, 100*r.Deviation)
+		}
+		fmt.Printf("ids=%d failed=%d chi_square=%.2f max_deviation=%.2f%% (limit %.2f%%)\n",
+			len(ids), failed, chi2, 100*worst, 100**maxDev)
+	}
+	switch {
+	case failed > 0:
+		fmt.Fprintf(os.Stderr, "FAIL: %d of %d IDs could not be resolved\n", failed, len(ids))
+		os.Exit(exitUnresolved)
+	case !pass:
+		fmt.Fprintf(os.Stderr, "FAIL: distribution deviates by more than %.2f%%\n", 100**maxDev)
+		os.Exit(exitFailed)
+	}
+	fmt.Fprintln(os.Stderr, "PASS")
+}
+
+// readIDs reads one client ID per line, skipping blanks and # comments.
+func readIDs(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var ids []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			ids = append(ids, line)
+		}
+	}
+	return ids, sc.Err()
+}
+
+// fetchRingShares returns each replica's share of the hash space from /ring.
+func fetchRingShares(ringURL string) (map[string]float64, error) {
+	client := &http.Client{Timeout: 10 * time.Second, Transport: newTransport()}
+	resp, err := client.Get(ringURL + "?" + url.Values{"sample": {"0"}}.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	var ring struct {
+		Replicas []struct {
+			Target string  `json:"target"`
+			Share  float64 `json:"share"`
+		} `json:"replicas"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ring); err != nil {
+		return nil, err
+	}
+	shares := make(map[string]float64, len(ring.Replicas))
+	for _, r := range ring.Replicas {
+		shares[r.Target] = r.Share
+	}
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("no replicas in ring")
+	}
+	return shares, nil
+}
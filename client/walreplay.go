@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// runWALReplay implements `client wal-replay`: it reads the assignment WALs written by replicas
+// with ASSIGNMENT_WAL (a path also pulls in its rotated .N files, oldest first), merges them by
+// timestamp and replays the records to reconstruct the placements at --until (default: the end
+// of the logs). It prints the placement table and per-replica counts (--output table, json or
+// csv), the full timeline with --timeline, or one client's history with --client. Gaps in a writer's sequence numbers point
+// at records lost to failed writes and are reported on stderr.
+func runWALReplay(args []string) {
+	fs := flag.NewFlagSet("wal-replay", flag.ExitOnError)
+	until := fs.String("until", "", "replay records up to this RFC 3339 time (default: all)")
+	clientID := fs.String("client", "", "print only this client's history")
+	timeline := fs.Bool("timeline", false, "print every record as it is replayed")
+	out := addOutputFlags(fs)
+	_ = fs.Parse(args)
+	out.check("wal-replay")
+	if fs.NArg() == 0 {
+		log.Fatal("wal-replay: give one or more WAL files")
+	}
+	var cutoff time.Time
+	if *until != "" {
+		t, err := time.Parse(time.RFC3339, *until)
+		if err != nil {
+			log.Fatalf("wal-replay: --until: %v", err)
+		}
+		cutoff = t
+	}
+
+	var records []walRecord
+	for _, path := range fs.Args() {
+		for _, file := range walFiles(path) {
+			recs, err := readWAL(file)
+			if err != nil {
+				log.Fatalf("wal-replay: %v", err)
+			}
+			records = append(records, recs...)
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+
+	type placement struct {
+		Replica string    `json:"replica"`
+		Since   time.Time `json:"since"`
+	}
+	placements := make(map[string]placement)
+	lastSeq := make(map[string]uint64)
+	replayed := 0
+	for _, rec := range records {
+		if !cutoff.IsZero() && rec.Time.After(cutoff) {
+			break
+		}
+		replayed++
+		if rec.Type == "open" {
+			lastSeq[rec.Writer] = rec.Seq
+		} else {
+			if prev, ok := lastSeq[rec.Writer]; ok && rec.Seq != prev+1 {
+				fmt.Fprintf(os.Stderr, "gap: %s seq %d -> %d before %s\n", rec.Writer, prev, rec.Seq, rec.Time.Format(time.RFC3339Nano))
+			}
+			lastSeq[rec.Writer] = rec.Seq
+		}
+		switch rec.Type {
+		case "assigned":
+			placements[rec.ClientID] = placement{Replica: rec.Replica, Since: rec.Time}
+		case "moved":
+			placements[rec.ClientID] = placement{Replica: rec.To, Since: rec.Time}
+		case "expired", "shed":
+			// A session ending on a replica the client has already moved away from changes nothing.
+			if p, ok := placements[rec.ClientID]; ok && p.Replica == rec.Replica {
+				delete(placements, rec.ClientID)
+			}
+		}
+		if *timeline || (*clientID != "" && rec.ClientID == *clientID) {
+			fmt.Println(formatWALRecord(rec))
+		}
+	}
+	if *clientID != "" {
+		if p, ok := placements[*clientID]; ok {
+			fmt.Printf("%s is on %s since %s\n", *clientID, p.Replica, p.Since.Format(time.RFC3339Nano))
+		} else {
+			fmt.Printf("%s has no placement\n", *clientID)
+		}
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, p := range placements {
+		counts[p.Replica]++
+	}
+	ids := make([]string, 0, len(placements))
+	for id := range placements {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if !out.table() {
+		rows := make([][]string, len(ids))
+		for i, id := range ids {
+			rows[i] = []string{id, placements[id].Replica, placements[id].Since.Format(time.RFC3339Nano)}
+		}
+		out.emit(map[string]any{
+			"records": replayed, "placements": placements, "replicas": counts,
+		}, []string{"client_id", "replica", "since"}, rows)
+		return
+	}
+	if !*timeline {
+		fmt.Printf("%-32s %-32s %s\n", "client_id", "replica", "since")
+		for _, id := range ids {
+			p := placements[id]
+			fmt.Printf("%-32s %-32s %s\n", id, p.Replica, p.Since.Format(time.RFC3339Nano))
+		}
+	}
+	replicas := make([]string, 0, len(counts))
+	for r := range counts {
+		replicas = append(replicas, r)
+	}
+	sort.Strings(replicas)
+	for _, r := range replicas {
+		fmt.Printf("replica=%s clients=%d\n", r, counts[r])
+	}
+	fmt.Printf("records=%d clients=%d\n", replayed, len(placements))
+}
+
+// walRecord mirrors the server's WAL record.
+type walRecord struct {
+	Seq      uint64    `json:"seq"`
+	Writer   string    `json:"writer"`
+	Type     string    `json:"type"`
+	ClientID string    `json:"client_id"`
+	Replica  string    `json:"replica"`
+	From     string    `json:"from,omitempty"`
+	To       string    `json:"to,omitempty"`
+	Time     time.Time `json:"ts"`
+}
+
+// walFiles returns path's rotated files, oldest first, followed by path itself.
+func walFiles(path string) []string {
+	var rotated []string
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("%s.%d", path, i)
+		if _, err := os.Stat(name); err != nil {
+			break
+		}
+		rotated = append(rotated, name)
+	}
+	out := make([]string, 0, len(rotated)+1)
+	for i := len(rotated) - 1; i >= 0; i-- {
+		out = append(out, rotated[i])
+	}
+	return append(out, path)
+}
+
+// readWAL parses one WAL file. A torn last line, from a crash mid-write, is skipped.
+func readWAL(path string) ([]walRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []walRecord
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		var rec walRecord
+		if err := json.Unmarshal([]byte(text), &rec); err != nil {
+			fmt.Fprintf(os.Stderr, "%s:%d: skipping unreadable record: %v\n", path, line, err)
+			continue
+		}
+		out = append(out, rec)
+	}
+	return out, sc.Err()
+}
+
+func formatWALRecord(rec walRecord) string {
+	ts := rec.Time.Format(time.RFC3339Nano)
+	switch rec.Type {
+	case "open":
+		return fmt.Sprintf("%s %s started writing", ts, rec.Writer)
+	case "moved":
+		return fmt.Sprintf("%s moved    %s %s -> %s (by %s)", ts, rec.ClientID, rec.From, rec.To, rec.Writer)
+	default:
+		return fmt.Sprintf("%s %-8s %s on %s (by %s)", ts, rec.Type, rec.ClientID, rec.Replica, rec.Writer)
+	}
+}
@@ -20,6 +20,12 @@ function envoy_on_request(handle)
     [":path"] = "/where?client_id=" .. client_id,
     [":authority"] = "resolver",
   }
+  -- Pass x-* request headers on so a ROUTING_EXPR policy can use header().
+  for key, value in pairs(handle:headers()) do
+    if string.sub(key, 1, 2) == "x-" and key ~= "x-routing-target" then
+      req_headers[key] = value
+    end
+  end
   local ok, resp_headers, resp_body = pcall(handle.httpCall, handle, "resolver", req_headers, "", 1000)
   if not ok or resp_headers == nil then
     handle:respond({[":status"] = "502", ["content-type"] = "text/plain"}, "resolver httpCall failed")
@@ -37,4 +43,18 @@ function envoy_on_request(handle)
   end
   local hdr = handle:headers()
   hdr:replace(":authority", hostport)
+  -- Lets the upstream cross-check Envoy's choice against its own owner computation.
+  hdr:replace("x-routing-target", hostport)
+  -- Routing trace headers from /where, for the access log and the owner.
+  for _, name in ipairs({"x-routed-by", "x-routing-mode", "x-ring-version", "x-hops"}) do
+    local value = nil
+    if resp_headers.get then
+      value = resp_headers:get(name)
+    else
+      value = resp_headers[name]
+    end
+    if value then
+      hdr:replace(name, value)
+    end
+  end
 end
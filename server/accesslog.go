@@ -0,0 +1,267 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Access logs in Envoy's format, so log analysis written for Envoy runs unchanged on the Go
+// server's logs. ACCESS_LOG=on writes one line per request on the public listener in Envoy's
+// default format; ACCESS_LOG_FORMAT sets another format string (and turns logging on), e.g. the
+// one in envoy.yaml. ACCESS_LOG_PATH writes to a file instead of stdout. Supported operators:
+// %START_TIME%, %REQ(H?ALT):MAX%, %RESP(H):MAX%, %PROTOCOL%, %RESPONSE_CODE%, %RESPONSE_FLAGS%
+// (always "-"), %BYTES_RECEIVED%, %BYTES_SENT%, %DURATION%, %UPSTREAM_HOST%,
+// %DOWNSTREAM_REMOTE_ADDRESS% and %DOWNSTREAM_REMOTE_ADDRESS_WITHOUT_PORT%. The pseudo-headers
+// :METHOD, :PATH, :AUTHORITY and :SCHEME read the request line. %UPSTREAM_HOST% is the owner
+// the request resolved to (the forward target on the gateway), or "-" when it resolved none or
+// several (/where/batch). Missing values are "-", as in Envoy; an unknown operator is logged at
+// startup and renders "-".
+
+const envoyDefaultAccessLogFormat = `[%START_TIME%] "%REQ(:METHOD)% %REQ(X-ENVOY-ORIGINAL-PATH?:PATH)% %PROTOCOL%" ` +
+	`%RESPONSE_CODE% %RESPONSE_FLAGS% %BYTES_RECEIVED% %BYTES_SENT% %DURATION% %RESP(X-ENVOY-UPSTREAM-SERVICE-TIME)% ` +
+	`"%REQ(X-FORWARDED-FOR)%" "%REQ(USER-AGENT)%" "%REQ(X-REQUEST-ID)%" "%REQ(:AUTHORITY)%" "%UPSTREAM_HOST%"` + "\n"
+
+var accessLogOperator = regexp.MustCompile(`%([A-Z_]+)(?:\(([^)]*)\))?(?::(\d+))?%`)
+
+type accessRecord struct {
+	start    time.Time
+	req      *http.Request
+	status   int
+	sent     int64
+	received int64
+	header   http.Header
+
+	mu       sync.Mutex
+	upstream string
+	multiple bool
+}
+
+type accessKey struct{}
+
+// noteUpstream records target as the upstream of the request being access-logged, if any.
+func noteUpstream(ctx context.Context, target string) {
+	rec, _ := ctx.Value(accessKey{}).(*accessRecord)
+	if rec == nil || target == "" {
+		return
+	}
+	rec.mu.Lock()
+	if rec.upstream == "" {
+		rec.upstream = target
+	} else if rec.upstream != target {
+		rec.multiple = true
+	}
+	rec.mu.Unlock()
+}
+
+type accessField func(*accessRecord) string
+
+func compileAccessLog(format string) []accessField {
+	var fields []accessField
+	literal := func(s string) accessField { return func(*accessRecord) string { return s } }
+	last := 0
+	for _, m := range accessLogOperator.FindAllStringSubmatchIndex(format, -1) {
+		if m[0] > last {
+			fields = append(fields, literal(format[last:m[0]]))
+		}
+		last = m[1]
+		name, arg, maxLen := format[m[2]:m[3]], "", 0
+		if m[4] >= 0 {
+			arg = format[m[4]:m[5]]
+		}
+		if m[6] >= 0 {
+			maxLen, _ = strconv.Atoi(format[m[6]:m[7]])
+		}
+		f := accessOperator(name, arg)
+		if f == nil {
+			log.Printf("ACCESS_LOG_FORMAT: unsupported operator %s", format[m[0]:m[1]])
+			f = literal("-")
+		}
+		if maxLen > 0 {
+			inner := f
+			f = func(rec *accessRecord) string {
+				v := inner(rec)
+				if len(v) > maxLen {
+					v = v[:maxLen]
+				}
+				return v
+			}
+		}
+		fields = append(fields, f)
+	}
+	if last < len(format) {
+		fields = append(fields, literal(format[last:]))
+	}
+	return fields
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func accessOperator(name, arg string) accessField {
+	switch name {
+	case "START_TIME":
+		return func(rec *accessRecord) string { return rec.start.UTC().Format("2006-01-02T15:04:05.000Z") }
+	case "REQ":
+		primary, alt, _ := strings.Cut(arg, "?")
+		return func(rec *accessRecord) string {
+			if v := requestField(rec.req, primary); v != "" || alt == "" {
+				return orDash(v)
+			}
+			return orDash(requestField(rec.req, alt))
+		}
+	case "RESP":
+		return func(rec *accessRecord) string { return orDash(rec.header.Get(arg)) }
+	case "PROTOCOL":
+		return func(rec *accessRecord) string { return rec.req.Proto }
+	case "RESPONSE_CODE":
+		return func(rec *accessRecord) string { return strconv.Itoa(rec.status) }
+	case "RESPONSE_FLAGS":
+		return func(*accessRecord) string { return "-" }
+	case "BYTES_RECEIVED":
+		return func(rec *accessRecord) string { return strconv.FormatInt(rec.received, 10) }
+	case "BYTES_SENT":
+		return func(rec *accessRecord) string { return strconv.FormatInt(rec.sent, 10) }
+	case "DURATION":
+		return func(rec *accessRecord) string { return strconv.FormatInt(time.Since(rec.start).Milliseconds(), 10) }
+	case "UPSTREAM_HOST":
+		return func(rec *accessRecord) string {
+			rec.mu.Lock()
+			defer rec.mu.Unlock()
+			if rec.multiple {
+				return "-"
+			}
+			return orDash(rec.upstream)
+		}
+	case "DOWNSTREAM_REMOTE_ADDRESS":
+		return func(rec *accessRecord) string { return orDash(rec.req.RemoteAddr) }
+	case "DOWNSTREAM_REMOTE_ADDRESS_WITHOUT_PORT":
+		return func(rec *accessRecord) string {
+			host, _, err := net.SplitHostPort(rec.req.RemoteAddr)
+			if err != nil {
+				return orDash(rec.req.RemoteAddr)
+			}
+			return host
+		}
+	}
+	return nil
+}
+
+// requestField reads a request header, or the request line for Envoy's pseudo-headers.
+func requestField(r *http.Request, name string) string {
+	switch strings.ToUpper(name) {
+	case ":METHOD":
+		return r.Method
+	case ":PATH":
+		return r.RequestURI
+	case ":AUTHORITY":
+		return r.Host
+	case ":SCHEME":
+		if r.TLS != nil {
+			return "https"
+		}
+		return "http"
+	}
+	return r.Header.Get(name)
+}
+
+// accessLogWriter counts the response bytes and keeps the status for the access log.
+type accessLogWriter struct {
+	http.ResponseWriter
+	rec *accessRecord
+}
+
+func (w *accessLogWriter) WriteHeader(status int) {
+	if w.rec.status == 0 {
+		w.rec.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogWriter) Write(b []byte) (int, error) {
+	if w.rec.status == 0 {
+		w.rec.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.rec.sent += int64(n)
+	return n, err
+}
+
+func (w *accessLogWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *accessLogWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	if w.rec.status == 0 {
+		w.rec.status = http.StatusSwitchingProtocols
+	}
+	return hj.Hijack()
+}
+
+func (w *accessLogWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+type countingBody struct {
+	io.ReadCloser
+	rec *accessRecord
+}
+
+func (b countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.rec.received += int64(n)
+	return n, err
+}
+
+func withAccessLog(next http.Handler) http.Handler {
+	format := os.Getenv("ACCESS_LOG_FORMAT")
+	if format == "" {
+		if !strings.EqualFold(strings.TrimSpace(os.Getenv("ACCESS_LOG")), "on") {
+			return next
+		}
+		format = envoyDefaultAccessLogFormat
+	}
+	var out io.Writer = os.Stdout
+	if path := os.Getenv("ACCESS_LOG_PATH"); path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Fatalf("ACCESS_LOG_PATH: %v", err)
+		}
+		out = f
+	}
+	logger := log.New(out, "", 0)
+	// Formats copied from Envoy config end in a newline, real or escaped; log adds its own.
+	fields := compileAccessLog(strings.TrimSuffix(strings.TrimSuffix(format, "\n"), `\n`))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &accessRecord{start: time.Now(), req: r, header: w.Header()}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = countingBody{ReadCloser: r.Body, rec: rec}
+		}
+		next.ServeHTTP(&accessLogWriter{ResponseWriter: w, rec: rec}, r.WithContext(context.WithValue(r.Context(), accessKey{}, rec)))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		var b strings.Builder
+		for _, f := range fields {
+			b.WriteString(f(rec))
+		}
+		logger.Print(b.String())
+	})
+}
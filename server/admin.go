@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Bearer-token auth for /admin/*. Tokens come from ADMIN_TOKENS ("token:role[:name],...") and/or
+// ADMIN_TOKENS_FILE (one "token role [name]" per line, # comments). Roles:
+//   - "read": GET and HEAD only
+//   - "admin": every method
+// Every mutating admin call is audit-logged with the caller's name. With no tokens configured the
+// admin API stays open, as before, and a warning is logged at startup.
+
+type adminToken struct {
+	token string
+	role  string
+	name  string
+}
+
+var adminTokens = loadAdminTokens()
+
+func loadAdminTokens() []adminToken {
+	var out []adminToken
+	add := func(token, role, name string) {
+		role = strings.ToLower(role)
+		if token == "" || (role != "read" && role != "admin") {
+			log.Printf("ignoring admin token entry with role %q", role)
+			return
+		}
+		if name == "" {
+			name = fmt.Sprintf("%s-%d", role, len(out)+1)
+		}
+		out = append(out, adminToken{token: token, role: role, name: name})
+	}
+	for _, entry := range strings.Split(os.Getenv("ADMIN_TOKENS"), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		parts = append(parts, "", "")
+		add(parts[0], parts[1], parts[2])
+	}
+	if path := os.Getenv("ADMIN_TOKENS_FILE"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("admin tokens: %v", err)
+		}
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			fields := strings.Fields(sc.Text())
+			if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			fields = append(fields, "")
+			add(fields[0], fields[1], fields[2])
+		}
+	}
+	metrics.counter("routing_admin_requests_total", "Admin API requests, by role and result.")
+	if len(out) == 0 {
+		log.Printf("warning: ADMIN_TOKENS not set, /admin/* is unauthenticated")
+	}
+	return out
+}
+
+// lookupAdminToken returns the configured token matching the request's bearer token.
+func lookupAdminToken(r *http.Request) (adminToken, bool) {
+	return lookupBearerToken(r.Header.Get("Authorization"))
+}
+
+// lookupBearerToken returns the configured token matching an Authorization value.
+func lookupBearerToken(authorization string) (adminToken, bool) {
+	got, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		return adminToken{}, false
+	}
+	for _, t := range adminTokens {
+		if subtle.ConstantTimeCompare([]byte(got), []byte(t.token)) == 1 {
+			return t, true
+		}
+	}
+	return adminToken{}, false
+}
+
+// statusRecorder captures the response status for audit logging.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// requireAdmin enforces admin auth and roles on /admin/* and audit-logs mutating calls.
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		readOnly := r.Method == http.MethodGet || r.Method == http.MethodHead
+		caller := adminToken{role: "anonymous", name: "anonymous"}
+		if len(adminTokens) > 0 {
+			t, ok := lookupAdminToken(r)
+			if !ok {
+				metrics.inc("routing_admin_requests_total", "role", "none", "result", "unauthorized")
+				log.Printf("admin auth rejected method=%s path=%s remote=%s", r.Method, r.URL.Path, r.RemoteAddr)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "missing or invalid admin token")
+				return
+			}
+			if !readOnly && t.role != "admin" {
+				metrics.inc("routing_admin_requests_total", "role", t.role, "result", "forbidden")
+				log.Printf("audit admin=%s role=%s method=%s path=%s status=403 (read-only token)", t.name, t.role, r.Method, r.URL.Path)
+				writeError(w, http.StatusForbidden, "FORBIDDEN", "token is read-only")
+				return
+			}
+			caller = t
+		}
+		metrics.inc("routing_admin_requests_total", "role", caller.role, "result", "allowed")
+		if readOnly {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		log.Printf("audit admin=%s role=%s method=%s path=%s query=%q remote=%s status=%d",
+			caller.name, caller.role, r.Method, r.URL.Path, r.URL.RawQuery, r.RemoteAddr, rec.status)
+	})
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"personal/poc-routing/server/routingpb"
+)
+
+// RoutingAdmin gRPC service. Replicas and gateways serve the RoutingAdmin service of
+// routingpb/admin.proto on the public port, next to HTTP/1.1 and h2c (see protocols.go): an HTTP/2
+// request with an application/grpc content type goes to the gRPC server instead of the HTTP chain.
+// Go tooling can then read routing state as typed messages instead of scraping JSON. Each RPC
+// answers from the same state as its endpoint:
+//   - GetRing: GET /ring (sample 0 skips sampling; there is no default sample here)
+//   - GetSlotMap: the routing table, one slot per target with its STANDBY_PAIRS partner
+//   - ListPins: GET /admin/move
+//   - GetHealth: replicas on GET /cluster/status, plus GET /breakers
+//
+// Server reflection is registered too, so grpcurl and similar tools can list and call the service
+// without the .proto. ListPins reads /admin/ state, so with ADMIN_TOKENS set it needs a read or
+// admin token as "authorization: Bearer <token>" metadata, like GET /admin/move; the rest are as
+// open as their endpoints. The interceptors of grpcchain.go apply the rest of the HTTP chain's
+// checks: request IDs, metrics, panic recovery, deadlines and ownership. ADMIN_GRPC=off leaves gRPC requests to
+// the HTTP chain, which doesn't know them, so it also turns off the xDS streams. Plaintext gRPC needs h2c, so it is off with
+// HTTP_PROTOCOLS=http1.
+
+// adminGRPCAuthMethods need a read or admin token when ADMIN_TOKENS is set.
+var adminGRPCAuthMethods = []string{routingpb.RoutingAdmin_ListPins_FullMethodName}
+
+type routingAdminServer struct {
+	routingpb.UnimplementedRoutingAdminServer
+}
+
+func newAdminGRPCServer() *grpc.Server {
+	srv := grpc.NewServer(grpcServerOptions()...)
+	routingpb.RegisterRoutingAdminServer(srv, routingAdminServer{})
+	registerXDS(srv)
+	reflection.Register(srv)
+	return srv
+}
+
+// withGRPC hands native gRPC calls to the gRPC server (RoutingAdmin, and the xDS services of
+// xdsstream.go with XDS_MODE set) and everything else, gRPC-Web
+// included, to next. The gRPC server doesn't need stopping on shutdown: its calls are handlers of
+// the HTTP server, which waits for them.
+func withGRPC(next http.Handler) http.Handler {
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("ADMIN_GRPC")), "off") {
+		return next
+	}
+	srv := newAdminGRPCServer()
+	log.Printf("grpc RoutingAdmin served on the public port")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); r.ProtoMajor == 2 && strings.HasPrefix(ct, "application/grpc") && !strings.HasPrefix(ct, "application/grpc-web") {
+			srv.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (routingAdminServer) GetRing(_ context.Context, req *routingpb.GetRingRequest) (*routingpb.Ring, error) {
+	sample := int(req.GetSample())
+	if sample < 0 || sample > ringSampleMax {
+		return nil, status.Errorf(codes.InvalidArgument, "sample must be between 0 and %d", ringSampleMax)
+	}
+	prefix := req.GetPrefix()
+	if prefix == "" {
+		prefix = "client-"
+	}
+	ring, maxOverMean := ringReplicas(sample, prefix)
+	out := &routingpb.Ring{
+		Algorithm:    keyHasher.Name() + "-mod-n",
+		IndexMode:    ringIndexMode(),
+		HashSpace:    hashSpace(keyHasher),
+		RingVersion:  ringVersion(),
+		TableVersion: currentTable().Version,
+		Membership:   members.source(),
+		Sample:       int32(sample),
+		SamplePrefix: prefix,
+		MaxOverMean:  maxOverMean,
+	}
+	for _, r := range ring {
+		rr := &routingpb.RingReplica{
+			Index:   int32(r.Index),
+			Target:  r.Target,
+			Healthy: r.Healthy,
+			Weight:  int32(r.Weight),
+			Hashes:  r.Hashes,
+			Share:   r.Share,
+			Sampled: int32(r.Sampled),
+		}
+		if r.Arc != nil {
+			rr.ArcStart, rr.ArcEnd, rr.ArcStride = r.Arc.Start, r.Arc.End, int32(r.Arc.Stride)
+		}
+		out.Replicas = append(out.Replicas, rr)
+	}
+	return out, nil
+}
+
+func (routingAdminServer) GetSlotMap(context.Context, *routingpb.GetSlotMapRequest) (*routingpb.SlotMap, error) {
+	t := currentTable()
+	out := &routingpb.SlotMap{
+		TableVersion: t.Version,
+		Reason:       t.Reason,
+		BuiltAt:      protoTime(t.BuiltAt),
+		RingVersion:  ringVersion(),
+	}
+	for i, target := range t.Targets {
+		standby, _ := standbyFor(target)
+		out.Slots = append(out.Slots, &routingpb.Slot{
+			Index:   int32(indexBase() + i),
+			Target:  target,
+			Healthy: ownerHealthy(target),
+			Standby: standby,
+		})
+	}
+	return out, nil
+}
+
+func (routingAdminServer) ListPins(context.Context, *routingpb.ListPinsRequest) (*routingpb.PinList, error) {
+	out := &routingpb.PinList{}
+	current := pins.current()
+	for _, id := range slices.Sorted(maps.Keys(current)) {
+		out.Pins = append(out.Pins, &routingpb.Pin{ClientId: id, Replica: current[id]})
+	}
+	for _, m := range pins.moves() {
+		out.History = append(out.History, &routingpb.Move{
+			ClientId: m.ClientID,
+			From:     m.From,
+			To:       m.To,
+			Reason:   m.Reason,
+			Ts:       protoTime(m.Time),
+		})
+	}
+	return out, nil
+}
+
+func (routingAdminServer) GetHealth(context.Context, *routingpb.GetHealthRequest) (*routingpb.HealthView, error) {
+	out := &routingpb.HealthView{BreakersEnabled: breakers.enabled}
+	for _, info := range replicas.snapshot() {
+		if info.Healthy {
+			out.ReplicasHealthy++
+		}
+		out.Replicas = append(out.Replicas, &routingpb.ReplicaHealth{
+			Target:            info.Target,
+			Healthy:           info.Healthy,
+			Version:           info.Version,
+			Zone:              info.Zone,
+			Weight:            int32(info.Weight),
+			ActiveSessions:    int32(info.Sessions),
+			ConfigFingerprint: info.ConfigFingerprint,
+			LastSeen:          protoTime(info.LastSeen),
+			Error:             info.Error,
+		})
+	}
+	for _, b := range breakerViews() {
+		out.Breakers = append(out.Breakers, &routingpb.Breaker{
+			Target:              b.Target,
+			State:               b.State,
+			ConsecutiveFailures: int32(b.ConsecutiveFailures),
+			Requests:            int32(b.Requests),
+			ErrorRate:           b.ErrorRate,
+			Opens:               int32(b.Opens),
+			OpenedAt:            protoTime(b.OpenedAt),
+		})
+	}
+	return out, nil
+}
+
+// protoTime converts t, leaving the zero time unset as the JSON endpoints omit it.
+func protoTime(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
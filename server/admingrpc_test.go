@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	edsv3 "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+
+	"personal/poc-routing/server/routingpb"
+)
+
+func TestRoutingAdminGRPC(t *testing.T) {
+	setupBenchRing(t)
+	prevTokens := adminTokens
+	adminTokens = []adminToken{{token: "viewer-secret", role: "read", name: "viewer"}}
+	t.Cleanup(func() { adminTokens = prevTokens })
+
+	// Through the public handler over h2c, as a replica serves it.
+	ts := httptest.NewUnstartedServer(withGRPC(http.NotFoundHandler()))
+	ts.Config.Protocols = serverProtocols()
+	ts.Start()
+	t.Cleanup(ts.Close)
+	conn, err := grpc.NewClient(ts.Listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	client := routingpb.NewRoutingAdminClient(conn)
+	ctx := context.Background()
+
+	ring, err := client.GetRing(ctx, &routingpb.GetRingRequest{Sample: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sampled := int32(0)
+	for i, r := range ring.GetReplicas() {
+		if r.GetTarget() != allTargets()[i] {
+			t.Errorf("ring replica %d is %s, want %s", i, r.GetTarget(), allTargets()[i])
+		}
+		sampled += r.GetSampled()
+	}
+	if len(ring.GetReplicas()) != 5 || sampled != 1000 {
+		t.Errorf("ring: %d replicas, %d sampled; want 5, 1000", len(ring.GetReplicas()), sampled)
+	}
+	if _, err := client.GetRing(ctx, &routingpb.GetRingRequest{Sample: -1}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("negative sample: %v, want InvalidArgument", err)
+	}
+
+	slots, err := client.GetSlotMap(ctx, &routingpb.GetSlotMapRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slots.GetTableVersion() != currentTable().Version || len(slots.GetSlots()) != 5 || slots.GetSlots()[3].GetIndex() != 3 {
+		t.Errorf("slot map: version %d, %d slots", slots.GetTableVersion(), len(slots.GetSlots()))
+	}
+
+	if _, err := client.ListPins(ctx, &routingpb.ListPinsRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("ListPins without a token: %v, want Unauthenticated", err)
+	}
+	authed := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer viewer-secret")
+	if _, err := client.ListPins(authed, &routingpb.ListPinsRequest{}); err != nil {
+		t.Errorf("ListPins with a read token: %v", err)
+	}
+	if _, err := client.GetHealth(ctx, &routingpb.GetHealthRequest{}); err != nil {
+		t.Errorf("GetHealth: %v", err)
+	}
+
+	stream, err := grpc_reflection_v1.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&grpc_reflection_v1.ServerReflectionRequest{MessageRequest: &grpc_reflection_v1.ServerReflectionRequest_ListServices{}}); err != nil {
+		t.Fatal(err)
+	}
+	listed, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, svc := range listed.GetListServicesResponse().GetService() {
+		names = append(names, svc.GetName())
+	}
+	if !slices.Contains(names, routingpb.RoutingAdmin_ServiceDesc.ServiceName) {
+		t.Errorf("reflection lists %v, want %s", names, routingpb.RoutingAdmin_ServiceDesc.ServiceName)
+	}
+}
+
+func TestGRPCInterceptors(t *testing.T) {
+	setupBenchRing(t)
+	ts := httptest.NewUnstartedServer(withGRPC(http.NotFoundHandler()))
+	ts.Config.Protocols = serverProtocols()
+	ts.Start()
+	t.Cleanup(ts.Close)
+	conn, err := grpc.NewClient(ts.Listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	client := routingpb.NewRoutingAdminClient(conn)
+
+	var header metadata.MD
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "req-7")
+	if _, err := client.GetSlotMap(ctx, &routingpb.GetSlotMapRequest{}, grpc.Header(&header)); err != nil {
+		t.Fatal(err)
+	}
+	if got := header.Get("x-request-id"); len(got) != 1 || got[0] != "req-7" {
+		t.Errorf("x-request-id echoed as %v, want req-7", got)
+	}
+	spent := metadata.AppendToOutgoingContext(context.Background(), "x-deadline-ms", "0")
+	if _, err := client.GetSlotMap(spent, &routingpb.GetSlotMapRequest{}); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("no budget left: %v, want DeadlineExceeded", err)
+	}
+
+	panics := func(context.Context, any) (any, error) { panic("boom") }
+	info := &grpc.UnaryServerInfo{FullMethod: "/poc.routing.v1.RoutingAdmin/GetRing"}
+	if _, err := grpcRecoveryUnary(context.Background(), nil, info, panics); status.Code(err) != codes.Internal {
+		t.Errorf("panicking handler: %v, want Internal", err)
+	}
+	w := httptest.NewRecorder()
+	withGRPCWebChecks(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := readGRPCWeb(w, r); ok {
+			panic("boom")
+		}
+	})(w, grpcWebRequest(t, &routingpb.WhereRequest{ClientId: "c-1"}, false))
+	if _, trailer := grpcWebFrames(t, w.Body.Bytes()); !strings.Contains(trailer, "grpc-status: 13\r\n") || w.Header().Get("X-Request-Id") == "" {
+		t.Errorf("gRPC-Web panic: trailer %q, request ID %q; want INTERNAL and an ID", trailer, w.Header().Get("X-Request-Id"))
+	}
+}
+
+func TestXDSStream(t *testing.T) {
+	setupBenchRing(t)
+	prev := xds
+	xds = &xdsPublisher{mode: "serve", cluster: "replicas", interval: time.Second, changed: make(chan struct{})}
+	t.Cleanup(func() { xds = prev })
+	xds.refresh()
+
+	ts := httptest.NewUnstartedServer(withGRPC(http.NotFoundHandler()))
+	ts.Config.Protocols = serverProtocols()
+	ts.Start()
+	t.Cleanup(ts.Close)
+	conn, err := grpc.NewClient(ts.Listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := edsv3.NewEndpointDiscoveryServiceClient(conn).StreamEndpoints(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv := func() (*discoveryv3.DiscoveryResponse, int) {
+		t.Helper()
+		res, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		endpoints := 0
+		for _, a := range res.GetResources() {
+			var cla endpointv3.ClusterLoadAssignment
+			if err := a.UnmarshalTo(&cla); err != nil {
+				t.Fatal(err)
+			}
+			for _, loc := range cla.GetEndpoints() {
+				endpoints += len(loc.GetLbEndpoints())
+			}
+		}
+		return res, endpoints
+	}
+
+	names := []string{"replicas"}
+	if err := stream.Send(&discoveryv3.DiscoveryRequest{TypeUrl: edsTypeURL, ResourceNames: names}); err != nil {
+		t.Fatal(err)
+	}
+	first, n := recv()
+	if n != 5 {
+		t.Fatalf("initial assignment has %d endpoints, want 5", n)
+	}
+	// ACK it; the next response must be pushed by the change, not asked for.
+	if err := stream.Send(&discoveryv3.DiscoveryRequest{TypeUrl: edsTypeURL, ResourceNames: names, VersionInfo: first.GetVersionInfo(), ResponseNonce: first.GetNonce()}); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("REPLICAS", "4")
+	publishTable("config", nil)
+	xds.refresh()
+	next, n := recv()
+	if n != 4 || next.GetVersionInfo() == first.GetVersionInfo() || next.GetNonce() == first.GetNonce() {
+		t.Errorf("pushed assignment: %d endpoints, version %s after %s; want 4 and a new version", n, next.GetVersionInfo(), first.GetVersionInfo())
+	}
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Replica group affinity. AFFINITY_HEADER names a request header carrying a client attribute
+// (e.g. X-Region) and AFFINITY_GROUPS maps its values to subsets of the replicas:
+//
+//	AFFINITY_HEADER=X-Region AFFINITY_GROUPS='eu=server-0,server-1;us=server-2,server-3'
+//
+// A client is hashed within its group's replicas only (rehashed over the group's healthy members
+// while its hash pick is down), and standby and failover never move it outside the group, which
+// models data-residency constrained placement. Members are named like STANDBY_PAIRS. /where,
+// /where/wait, /explain, /join and /ws answer 400 with the allowed values when the header is
+// missing or names no group. Resolutions without a request (handoff, drain, pre-provisioning)
+// see no header and hash over every replica, like ROUTING_EXPR.
+
+type affinityGroup struct {
+	value   string
+	members []string
+}
+
+var (
+	affinityHeader = strings.TrimSpace(os.Getenv("AFFINITY_HEADER"))
+	affinityGroups = parseAffinityGroups(os.Getenv("AFFINITY_GROUPS"))
+)
+
+func init() {
+	metrics.counter("routing_affinity_requests_total", "Requests placed within an AFFINITY_GROUPS group, by group.")
+	metrics.counter("routing_affinity_rejected_total", "Requests refused for a missing or unknown affinity attribute, by reason.")
+	if affinityHeader != "" && len(affinityGroups) == 0 {
+		log.Fatal("AFFINITY_HEADER is set but AFFINITY_GROUPS lists no groups")
+	}
+}
+
+func parseAffinityGroups(spec string) map[string]affinityGroup {
+	out := make(map[string]affinityGroup)
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		value, list, _ := strings.Cut(part, "=")
+		value = strings.ToLower(strings.TrimSpace(value))
+		var members []string
+		for _, m := range strings.Split(list, ",") {
+			if m = strings.TrimSpace(m); m != "" {
+				members = append(members, m)
+			}
+		}
+		if value == "" || len(members) == 0 {
+			log.Fatalf("invalid AFFINITY_GROUPS entry %q (want value=replica,replica)", part)
+		}
+		out[value] = affinityGroup{value: value, members: members}
+	}
+	return out
+}
+
+func affinityValues() []string {
+	values := make([]string, 0, len(affinityGroups))
+	for v := range affinityGroups {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return values
+}
+
+// affinityFor returns the group the headers select, if affinity routing is on and h names one.
+func affinityFor(h http.Header) (affinityGroup, bool) {
+	if affinityHeader == "" || h == nil {
+		return affinityGroup{}, false
+	}
+	g, ok := affinityGroups[strings.ToLower(strings.TrimSpace(h.Get(affinityHeader)))]
+	return g, ok
+}
+
+// targets lists the current routing targets in the group, in index order.
+func (g affinityGroup) targets() []string {
+	var out []string
+	for _, t := range allTargets() {
+		if slices.ContainsFunc(g.members, func(m string) bool { return targetNamed(m, t) }) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// pick hashes clientID over the group's targets, or over its healthy ones when that pick is down.
+func (g affinityGroup) pick(clientID string) (string, bool) {
+	targets := g.targets()
+	if len(targets) == 0 {
+		return "", false
+	}
+	key := routingKey(clientID)
+	mode := os.Getenv("INDEX_MODE")
+	owner := targets[indexFor(key, len(targets), 0, mode)]
+	if !ownerHealthy(owner) {
+		var healthy []string
+		for _, t := range targets {
+			if ownerHealthy(t) {
+				healthy = append(healthy, t)
+			}
+		}
+		if len(healthy) > 0 {
+			owner = healthy[indexFor(key, len(healthy), 0, mode)]
+		}
+	}
+	return owner, true
+}
+
+// checkAffinity answers 400 with the allowed values when affinity routing is on and r's attribute
+// is missing or unknown, or 503 when its group matches no current target.
+func checkAffinity(w http.ResponseWriter, r *http.Request) bool {
+	if affinityHeader == "" {
+		return true
+	}
+	value := strings.TrimSpace(r.Header.Get(affinityHeader))
+	code, msg := "AFFINITY_REQUIRED", fmt.Sprintf("missing %s header", affinityHeader)
+	if value != "" {
+		g, ok := affinityGroups[strings.ToLower(value)]
+		if ok {
+			if len(g.targets()) > 0 {
+				return true
+			}
+			writeError(w, http.StatusServiceUnavailable, "AFFINITY_GROUP_EMPTY", fmt.Sprintf("%s=%s matches no current replica", affinityHeader, g.value))
+			return false
+		}
+		code, msg = "UNKNOWN_AFFINITY", fmt.Sprintf("%s=%q names no affinity group", affinityHeader, value)
+	}
+	metrics.inc("routing_affinity_rejected_total", "reason", strings.ToLower(code))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error":   msg,
+		"code":    code,
+		"header":  affinityHeader,
+		"allowed": affinityValues(),
+	})
+	return false
+}
+
+// affinityStatus lists the groups as resolved against the current targets, for /cluster/status.
+func affinityStatus() []map[string]any {
+	out := []map[string]any{}
+	for _, v := range affinityValues() {
+		g := affinityGroups[v]
+		out = append(out, map[string]any{"value": v, "members": g.members, "targets": g.targets()})
+	}
+	return out
+}
+
+// resolveInGroup finishes resolveOwnerOnce for a client held to an affinity group. Version,
+// maintenance, standby and failover relocate over every replica, so they are skipped: the group's
+// pick is already its healthy members' hash, and when none is healthy the owner is waited for.
+func resolveInGroup(ctx context.Context, clientID, owner string) (string, error) {
+	if err := ownerWait.await(ctx, clientID, owner); err != nil {
+		explainf(ctx, "owner_wait", "", "%s is unhealthy and no other member of the client's %s group is healthy: %v", owner, affinityHeader, err)
+		return "", err
+	}
+	if !ownerHealthy(owner) {
+		explainf(ctx, "unhealthy_owner", owner, "%s is unhealthy, but the client's %s group has no healthy member, so it is used anyway", owner, affinityHeader)
+	}
+	return owner, nil
+}
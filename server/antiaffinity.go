@@ -0,0 +1,137 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Anti-affinity between clients that must not share a replica, e.g. a site's primary and backup
+// controllers. ANTI_AFFINITY is a ';'-separated list of rules, each a ','-separated list of client
+// IDs that must land on distinct replicas; one '*' per member binds the same value across the rule:
+//
+//	ANTI_AFFINITY=*:primary,*:backup;ctrl-a,ctrl-b,ctrl-c
+//
+// The first member keeps its hash position; each later member probes subsequent ring positions
+// until it finds one not taken by an earlier member. Placement depends only on configuration, so
+// every replica computes the same answer. When a rule can't be satisfied (more members than
+// replicas, or failover/version routing moves a member onto a sibling) it is counted in
+// routing_anti_affinity_violations_total{rule}.
+
+type antiAffinityRule struct {
+	text    string
+	members []string
+}
+
+var antiAffinity = parseAntiAffinity(os.Getenv("ANTI_AFFINITY"))
+
+func parseAntiAffinity(spec string) []antiAffinityRule {
+	metrics.counter("routing_anti_affinity_violations_total", "Placements that could not honor an anti-affinity rule.")
+	var rules []antiAffinityRule
+	for _, part := range strings.Split(spec, ";") {
+		var members []string
+		for _, m := range strings.Split(part, ",") {
+			if m = strings.TrimSpace(m); m != "" {
+				members = append(members, m)
+			}
+		}
+		if len(members) < 2 {
+			if len(members) == 1 {
+				log.Printf("ignoring anti-affinity rule %q: needs at least two members", part)
+			}
+			continue
+		}
+		rules = append(rules, antiAffinityRule{text: strings.Join(members, ","), members: members})
+	}
+	return rules
+}
+
+// matchMember reports whether clientID matches pattern, returning what '*' bound to.
+func matchMember(pattern, clientID string) (string, bool) {
+	prefix, suffix, wild := strings.Cut(pattern, "*")
+	if !wild {
+		return "", pattern == clientID
+	}
+	if len(clientID) < len(prefix)+len(suffix) || !strings.HasPrefix(clientID, prefix) || !strings.HasSuffix(clientID, suffix) {
+		return "", false
+	}
+	return clientID[len(prefix) : len(clientID)-len(suffix)], true
+}
+
+// ruleFor returns the first rule clientID belongs to, its member position and the wildcard binding.
+func ruleFor(clientID string) (antiAffinityRule, int, string, bool) {
+	for _, rule := range antiAffinity {
+		for i, m := range rule.members {
+			if binding, ok := matchMember(m, clientID); ok {
+				return rule, i, binding, true
+			}
+		}
+	}
+	return antiAffinityRule{}, 0, "", false
+}
+
+// placeClient returns clientID's ring placement: its hash target (or ROUTING_EXPR's pick), moved
+// along the ring as needed to keep it apart from the earlier members of its anti-affinity rule.
+func placeClient(clientID string) string {
+	return placeRequest(clientID, nil)
+}
+
+// placeRequest is placeClient with the request headers that AFFINITY_HEADER and ROUTING_EXPR may
+// consult; an affinity group's pick wins over both anti-affinity and ROUTING_EXPR.
+func placeRequest(clientID string, h http.Header) string {
+	if g, ok := affinityFor(h); ok {
+		if t, ok := g.pick(clientID); ok {
+			metrics.inc("routing_affinity_requests_total", "group", g.value)
+			return t
+		}
+	}
+	rule, pos, binding, ok := ruleFor(clientID)
+	if !ok {
+		if routingPolicy != nil {
+			if t, ok := routingPolicy.pick(clientID, h); ok {
+				return t
+			}
+		}
+		return pickByHashScaled(clientID)
+	}
+	placed := placeMembers(rule, binding, pos)
+	if slices.Contains(placed[:pos], placed[pos]) {
+		metrics.inc("routing_anti_affinity_violations_total", "rule", rule.text)
+	}
+	return placed[pos]
+}
+
+// placeMembers places members 0..upto of rule in order, each probing forward past taken targets.
+func placeMembers(rule antiAffinityRule, binding string, upto int) []string {
+	targets := allTargets()
+	placed := make([]string, 0, upto+1)
+	for i := 0; i <= upto; i++ {
+		t := pickByHashScaled(strings.Replace(rule.members[i], "*", binding, 1))
+		start := slices.Index(targets, t)
+		for probe := 1; start >= 0 && slices.Contains(placed, t) && probe < len(targets); probe++ {
+			t = targets[(start+probe)%len(targets)]
+		}
+		placed = append(placed, t)
+	}
+	return placed
+}
+
+// checkAntiAffinity counts a violation when owner, the final routing answer for clientID, was
+// moved off its placement onto the placement of another member of its rule.
+func checkAntiAffinity(clientID, placement, owner string) {
+	rule, pos, binding, ok := ruleFor(clientID)
+	if !ok || owner == placement {
+		return
+	}
+	placed := placeMembers(rule, binding, len(rule.members)-1)
+	for i, t := range placed {
+		if i != pos && t == owner {
+			metrics.inc("routing_anti_affinity_violations_total", "rule", rule.text)
+			log.Printf("client_id=%s on %s shares a replica with %s (anti-affinity %q)",
+				clientID, owner, strings.Replace(rule.members[i], "*", binding, 1), rule.text)
+			return
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Paginated /cluster/assignments. ?limit=N (at most 10000) answers with the first N assignments of
+// a snapshot of the registry taken for this listing, its total, and a next_page_token; passing
+// ?page_token= returns the following page of the same snapshot, so a client walking the pages sees
+// one consistent view even while rebalances rewrite the registry underneath. The last page has no
+// next_page_token. Snapshots live in memory on the replica that took them for
+// ASSIGNMENTS_SNAPSHOT_TTL (default 5m, counted from the last page served) and at most 16 are kept;
+// the token names that replica, and a page request reaching another replica is fetched from it over
+// the internal API. An expired or evicted snapshot answers 410 SNAPSHOT_EXPIRED: start over without
+// a token.
+
+const (
+	assignmentPageMax      = 10000
+	assignmentSnapshotsMax = 16
+)
+
+type assignmentSnapshot struct {
+	id       string
+	list     []Assignment
+	limit    int // page size of the first page, used when a page request gives none
+	takenAt  time.Time
+	lastUsed time.Time
+}
+
+type assignmentSnapshots struct {
+	ttl time.Duration
+
+	mu sync.Mutex
+	m  map[string]*assignmentSnapshot
+}
+
+var snapshots = newAssignmentSnapshotsFromEnv()
+
+var snapshotClient = newInternalClient(10 * time.Second)
+
+func newAssignmentSnapshotsFromEnv() *assignmentSnapshots {
+	s := &assignmentSnapshots{ttl: 5 * time.Minute, m: make(map[string]*assignmentSnapshot)}
+	if d, err := time.ParseDuration(os.Getenv("ASSIGNMENTS_SNAPSHOT_TTL")); err == nil && d > 0 {
+		s.ttl = d
+	}
+	return s
+}
+
+// take stores list as a new snapshot, evicting expired ones and, beyond the cap, the least
+// recently used.
+func (s *assignmentSnapshots) take(list []Assignment, limit int) *assignmentSnapshot {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	now := clock.Now()
+	snap := &assignmentSnapshot{id: hex.EncodeToString(b), list: list, limit: limit, takenAt: now, lastUsed: now}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, old := range s.m {
+		if now.Sub(old.lastUsed) > s.ttl {
+			delete(s.m, id)
+		}
+	}
+	for len(s.m) >= assignmentSnapshotsMax {
+		var oldest *assignmentSnapshot
+		for _, old := range s.m {
+			if oldest == nil || old.lastUsed.Before(oldest.lastUsed) {
+				oldest = old
+			}
+		}
+		delete(s.m, oldest.id)
+	}
+	s.m[snap.id] = snap
+	return snap
+}
+
+func (s *assignmentSnapshots) get(id string) (*assignmentSnapshot, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap, ok := s.m[id]
+	if !ok {
+		return nil, false
+	}
+	now := clock.Now()
+	if now.Sub(snap.lastUsed) > s.ttl {
+		delete(s.m, id)
+		return nil, false
+	}
+	snap.lastUsed = now
+	return snap, true
+}
+
+// pageToken locates a page: the replica holding the snapshot, the snapshot and the offset into it.
+type pageToken struct {
+	holder string
+	id     string
+	offset int
+}
+
+func (t pageToken) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(t.holder + "|" + t.id + "|" + strconv.Itoa(t.offset)))
+}
+
+func parsePageToken(s string) (pageToken, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return pageToken{}, fmt.Errorf("invalid page_token")
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 3 {
+		return pageToken{}, fmt.Errorf("invalid page_token")
+	}
+	off, err := strconv.Atoi(parts[2])
+	if err != nil || off < 0 {
+		return pageToken{}, fmt.Errorf("invalid page_token")
+	}
+	return pageToken{holder: parts[0], id: parts[1], offset: off}, nil
+}
+
+// pageLimit reads ?limit=; ok is false when the listing is not paginated.
+func pageLimit(r *http.Request) (limit int, ok bool, err error) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return 0, r.URL.Query().Get("page_token") != "", nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 || n > assignmentPageMax {
+		return 0, false, fmt.Errorf("limit must be between 1 and %d", assignmentPageMax)
+	}
+	return n, true, nil
+}
+
+// serveAssignmentPage answers a paginated listing. list is called only when a new snapshot is
+// needed; forward is false on the internal API, where the page must be held locally.
+func serveAssignmentPage(w http.ResponseWriter, r *http.Request, limit int, list func() ([]Assignment, error), forward bool) {
+	var snap *assignmentSnapshot
+	offset := 0
+	if v := r.URL.Query().Get("page_token"); v != "" {
+		tok, err := parsePageToken(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "INVALID_PAGE_TOKEN", err.Error())
+			return
+		}
+		if tok.holder != selfTarget() {
+			if !forward {
+				writeError(w, http.StatusGone, "SNAPSHOT_EXPIRED", "snapshot is not held here; start over without page_token")
+				return
+			}
+			forwardAssignmentPage(w, r, tok.holder)
+			return
+		}
+		var ok bool
+		if snap, ok = snapshots.get(tok.id); !ok {
+			writeError(w, http.StatusGone, "SNAPSHOT_EXPIRED", "snapshot expired; start over without page_token")
+			return
+		}
+		offset = min(tok.offset, len(snap.list))
+	} else {
+		all, err := list()
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, "REGISTRY_UNAVAILABLE", err.Error())
+			return
+		}
+		snap = snapshots.take(all, limit)
+	}
+	if limit == 0 {
+		limit = snap.limit
+	}
+
+	end := min(offset+limit, len(snap.list))
+	extra := map[string]any{
+		"total":       len(snap.list),
+		"offset":      offset,
+		"snapshot":    snap.id,
+		"snapshot_at": snap.takenAt,
+	}
+	if end < len(snap.list) {
+		extra["next_page_token"] = pageToken{holder: selfTarget(), id: snap.id, offset: end}.String()
+	}
+	writeAssignments(w, r, snap.list[offset:end], extra)
+}
+
+// forwardAssignmentPage fetches the page from the replica holding its snapshot.
+func forwardAssignmentPage(w http.ResponseWriter, r *http.Request, holder string) {
+	req, err := newInternalRequest(http.MethodGet, holder, "/internal/assignments?"+r.URL.RawQuery, nil)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "SNAPSHOT_UNREACHABLE", err.Error())
+		return
+	}
+	resp, err := snapshotClient.Do(req.WithContext(r.Context()))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "SNAPSHOT_UNREACHABLE", fmt.Sprintf("%s holds the snapshot: %v", holder, err))
+		return
+	}
+	defer resp.Body.Close()
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
+// handleInternalAssignments serves pages of snapshots held by this replica to its peers.
+func handleInternalAssignments(w http.ResponseWriter, r *http.Request) {
+	limit, _, err := pageLimit(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_LIMIT", err.Error())
+		return
+	}
+	if r.URL.Query().Get("page_token") == "" {
+		http.Error(w, "missing page_token", http.StatusBadRequest)
+		return
+	}
+	serveAssignmentPage(w, r, limit, nil, false)
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// Hot path benchmarks. Each has an allocation budget in allocBudgets, enforced by
+// TestAllocBudgets on every go test run, so a routing feature that adds allocations to
+// computeIndex, the ring lookup or /where fails the build instead of showing up in a profile:
+//
+//	make -C server bench    # benchmarks with -benchmem, then the budget check
+//
+// Raise a budget only together with the change that needs it.
+//
+// The goal for /where is under 2 allocations per request, and the handler misses it: a repeat
+// client costs 5 (the ETag string and its header slice, the trace context, and the two arguments
+// of the request log line), and a client's first request one more for its quota record, which is
+// what TestAllocBudgets sees. whereBody only covers encoding the answer, not the handler.
+
+var allocBudgets = map[string]float64{
+	"computeIndex": 0,
+	"ringLookup":   0,
+	"where":        6,
+	"whereBody":    1,
+}
+
+// benchIDs is a fixed spread of client IDs, so runs compare like with like.
+var benchIDs = func() []string {
+	ids := make([]string, 1024)
+	for i := range ids {
+		ids[i] = "client-" + strconv.Itoa(i)
+	}
+	return ids
+}()
+
+// setupBenchRing routes over a fixed five-replica SERVICE_PREFIX ring with the server's log
+// silenced.
+func setupBenchRing(tb testing.TB) {
+	tb.Helper()
+	tb.Setenv("SERVICE_PREFIX", "bench")
+	tb.Setenv("REPLICAS", "5")
+	tb.Setenv("INDEX_BASE", "0")
+	publishTable("config", nil)
+	log.SetOutput(io.Discard)
+	tb.Cleanup(func() {
+		log.SetOutput(logOutput)
+		publishTable("config", nil)
+	})
+}
+
+// logOutput is where the server logs outside benchmarks.
+var logOutput = log.Writer()
+
+func benchComputeIndex(i int) {
+	computeIndex(benchIDs[i%len(benchIDs)], 5)
+}
+
+func benchRingLookup(i int) {
+	pickByHashScaled(benchIDs[i%len(benchIDs)])
+}
+
+// whereRequests are built up front so the benchmark measures the handler, not the test setup.
+var whereRequests = func() []*http.Request {
+	reqs := make([]*http.Request, len(benchIDs))
+	for i, id := range benchIDs {
+		reqs[i] = httptest.NewRequest(http.MethodGet, "/where?client_id="+id, nil)
+	}
+	return reqs
+}()
+
+// discardResponse is a reusable http.ResponseWriter that drops the body.
+type discardResponse struct{ h http.Header }
+
+func (d *discardResponse) Header() http.Header         { return d.h }
+func (d *discardResponse) Write(b []byte) (int, error) { return len(b), nil }
+func (d *discardResponse) WriteHeader(int)             {}
+
+func benchWhere(w *discardResponse, i int) {
+	clear(w.h)
+	handleWhere(w, whereRequests[i%len(whereRequests)])
+}
+
+func benchWhereBody(w *discardResponse, i int) {
+	id := benchIDs[i%len(benchIDs)]
+	writeJSON(w, &whereResponse{ClientID: id, HostPort: pickByHashScaled(id), TableVersion: 1})
+}
+
+func BenchmarkComputeIndex(b *testing.B) {
+	setupBenchRing(b)
+	b.ReportAllocs()
+	for i := 0; b.Loop(); i++ {
+		benchComputeIndex(i)
+	}
+}
+
+func BenchmarkRingLookup(b *testing.B) {
+	setupBenchRing(b)
+	b.ReportAllocs()
+	for i := 0; b.Loop(); i++ {
+		benchRingLookup(i)
+	}
+}
+
+func BenchmarkWhere(b *testing.B) {
+	setupBenchRing(b)
+	w := &discardResponse{h: make(http.Header)}
+	b.ReportAllocs()
+	for i := 0; b.Loop(); i++ {
+		benchWhere(w, i)
+	}
+}
+
+func BenchmarkWhereBody(b *testing.B) {
+	setupBenchRing(b)
+	w := &discardResponse{h: make(http.Header)}
+	b.ReportAllocs()
+	for i := 0; b.Loop(); i++ {
+		benchWhereBody(w, i)
+	}
+}
+
+// raceEnabled is set by race_test.go in -race builds.
+var raceEnabled bool
+
+func TestAllocBudgets(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocation counts are not meaningful under -race")
+	}
+	setupBenchRing(t)
+	w := &discardResponse{h: make(http.Header)}
+	runs := map[string]func(int){
+		"computeIndex": benchComputeIndex,
+		"ringLookup":   benchRingLookup,
+		"where":        func(i int) { benchWhere(w, i) },
+		"whereBody":    func(i int) { benchWhereBody(w, i) },
+	}
+	for name, run := range runs {
+		i := 0
+		got := testing.AllocsPerRun(200, func() { run(i); i++ })
+		if got > allocBudgets[name] {
+			t.Errorf("%s: %.1f allocs/op, budget %.0f", name, got, allocBudgets[name])
+		} else {
+			t.Logf("%s: %.1f allocs/op, budget %.0f", name, got, allocBudgets[name])
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"personal/poc-routing/server/internal/resp"
+)
+
+// Bootstrap on first start. On a first deploy every replica starts at once against an empty
+// registry and computes from its own assumptions: one publishes the shared ring view from a ring
+// its peers don't have, another writes assignments under a REPLICAS nobody else uses. With
+// BOOTSTRAP=registry one replica initializes the shared registry and the others wait for it:
+//   - the coordinator is the replica with the first ordinal (INDEX_BASE); when no bootstrap
+//     record shows up within BOOTSTRAP_TIMEOUT (default 60s), e.g. because that replica can't
+//     start, any replica takes over, and the first one to write the record wins
+//   - the coordinator publishes the first shared ring view epoch (with RING_VIEW=registry), then
+//     writes the bootstrap record: its config fingerprint, hash algorithm, and the configured
+//     targets in ordinal order with their ring version
+//   - until the record exists a replica is not ready: /health answers 503 "bootstrapping",
+//     /join, /where and /where/batch answer 503 BOOTSTRAPPING with the header
+//     X-Routing-Bootstrapping (which envoy.yaml retries on another replica), and the replica
+//     leaves the shared ring view alone
+//   - a replica whose config fingerprint differs from the record's stays not ready when the
+//     record belongs to its own deploy (written less than BOOTSTRAP_TIMEOUT before it started);
+//     against an older record the difference is a rolling config change, which it logs and serves
+//     through
+//
+// The record is kept, so restarts and scale-ups find the cluster bootstrapped. Registry errors
+// are retried every second, and the replica stays not ready meanwhile. Needs a registry backend
+// that supports it (redis); with any other the replica starts serving right away.
+
+// bootstrapRecord is what the coordinator initialized the registry with.
+type bootstrapRecord struct {
+	ConfigFingerprint string    `json:"config_fingerprint"`
+	HashAlgorithm     string    `json:"hash_algorithm"`
+	Ring              string    `json:"ring"`
+	Targets           []string  `json:"targets"`
+	BootstrappedBy    string    `json:"bootstrapped_by"`
+	BootstrappedAt    time.Time `json:"bootstrapped_at"`
+}
+
+// bootstrapStore is implemented by registry backends that can hold the bootstrap record.
+type bootstrapStore interface {
+	// BootstrapRecord returns the record, with ok false when the cluster isn't bootstrapped.
+	BootstrapRecord() (rec bootstrapRecord, ok bool, err error)
+	// WriteBootstrap writes rec unless a record exists, and reports whether it did.
+	WriteBootstrap(rec bootstrapRecord) (bool, error)
+}
+
+const bootstrapHeader = "X-Routing-Bootstrapping"
+
+type bootstrapState struct {
+	enabled bool
+	pending atomic.Bool // not ready; read on every lookup
+
+	mu     sync.Mutex
+	state  string // waiting, ready, config_mismatch
+	role   string // coordinator, follower
+	record *bootstrapRecord
+	err    string
+	done   chan struct{}
+}
+
+var bootstrap = newBootstrapFromEnv()
+
+func newBootstrapFromEnv() *bootstrapState {
+	b := &bootstrapState{enabled: os.Getenv("BOOTSTRAP") == "registry", done: make(chan struct{})}
+	if b.enabled {
+		b.state = "waiting"
+		b.pending.Store(true)
+	} else {
+		close(b.done)
+	}
+	return b
+}
+
+func bootstrapTimeout() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("BOOTSTRAP_TIMEOUT")); err == nil && d >= 0 {
+		return d
+	}
+	return 60 * time.Second
+}
+
+// runBootstrap initializes the registry as coordinator, or waits for the coordinator to.
+func runBootstrap() {
+	b := bootstrap
+	if !b.enabled {
+		return
+	}
+	store, ok := registryAs[bootstrapStore]()
+	if !ok {
+		log.Printf("BOOTSTRAP=registry needs a shared registry backend (redis); serving without waiting for a bootstrap")
+		b.finish("ready")
+		return
+	}
+	metrics.counter("routing_bootstrap_rejections_total", "Requests refused while this replica waited for the cluster bootstrap, by endpoint.")
+	metrics.gaugeFunc("routing_bootstrap_pending", "1 while this replica waits for the cluster bootstrap.", func() float64 {
+		if b.pending.Load() {
+			return 1
+		}
+		return 0
+	})
+	started := clock.Now()
+	timeout := bootstrapTimeout()
+	first := selfIndex() == indexBase()
+	b.mu.Lock()
+	b.role = "follower"
+	if first {
+		b.role = "coordinator"
+	}
+	b.mu.Unlock()
+	log.Printf("bootstrap: starting as %s", b.role)
+	for {
+		rec, found, err := store.BootstrapRecord()
+		if err == nil && !found && (first || clock.Now().Sub(started) >= timeout) {
+			rec, found, err = b.initialize(store)
+		}
+		if b.note(rec, found, err, started, timeout) {
+			return
+		}
+		clock.Sleep(time.Second)
+	}
+}
+
+// initialize publishes the first ring view and writes the bootstrap record, unless a peer did.
+func (b *bootstrapState) initialize(store bootstrapStore) (bootstrapRecord, bool, error) {
+	t := currentTable()
+	if rv, ok := store.(ringViewStore); ok && os.Getenv("RING_VIEW") == "registry" {
+		if view, err := rv.RingView(); err != nil {
+			return bootstrapRecord{}, false, err
+		} else if view.Epoch == 0 {
+			next := ringViewRecord{Ring: t.ring, Targets: t.Targets, PublishedBy: selfTarget(), PublishedAt: clock.Now()}
+			if _, err := rv.PublishRingView(0, next, 10*ringViewInterval()); err != nil {
+				return bootstrapRecord{}, false, err
+			}
+		}
+	}
+	targets := staticTargets()
+	algorithm := strings.ToLower(strings.TrimSpace(os.Getenv("HASH_ALGORITHM")))
+	if algorithm == "" {
+		algorithm = "fnv1a32"
+	}
+	rec := bootstrapRecord{
+		ConfigFingerprint: configFingerprint(),
+		HashAlgorithm:     algorithm,
+		Ring:              ringHash(targets),
+		Targets:           targets,
+		BootstrappedBy:    selfTarget(),
+		BootstrappedAt:    clock.Now(),
+	}
+	wrote, err := store.WriteBootstrap(rec)
+	if err != nil {
+		return bootstrapRecord{}, false, err
+	}
+	if wrote {
+		b.mu.Lock()
+		b.role = "coordinator"
+		b.mu.Unlock()
+		log.Printf("bootstrap: initialized the registry (ring %s over %d targets, config %s)", rec.Ring, len(targets), rec.ConfigFingerprint)
+		return rec, true, nil
+	}
+	return store.BootstrapRecord()
+}
+
+// note records the outcome of one attempt and reports whether waiting is over.
+func (b *bootstrapState) note(rec bootstrapRecord, found bool, err error, started time.Time, timeout time.Duration) bool {
+	b.mu.Lock()
+	if err != nil {
+		if b.err == "" {
+			log.Printf("bootstrap: %v; not ready until the registry answers", err)
+		}
+		b.err = err.Error()
+		b.mu.Unlock()
+		return false
+	}
+	b.err = ""
+	if !found {
+		b.mu.Unlock()
+		return false
+	}
+	b.record = &rec
+	b.mu.Unlock()
+	if fp := configFingerprint(); rec.ConfigFingerprint != fp {
+		if !rec.BootstrappedAt.Before(started.Add(-timeout)) {
+			log.Printf("bootstrap: config fingerprint %s differs from %s, bootstrapped by %s; staying not ready until the config matches",
+				fp, rec.ConfigFingerprint, rec.BootstrappedBy)
+			b.finish("config_mismatch")
+			return true
+		}
+		log.Printf("bootstrap: config fingerprint %s differs from %s, bootstrapped by %s at %s; serving as a rolling config change",
+			fp, rec.ConfigFingerprint, rec.BootstrappedBy, rec.BootstrappedAt.Format(time.RFC3339))
+	}
+	log.Printf("bootstrap: ready (bootstrapped by %s)", rec.BootstrappedBy)
+	b.finish("ready")
+	return true
+}
+
+func (b *bootstrapState) finish(state string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = state
+	if state == "ready" {
+		b.pending.Store(false)
+		close(b.done)
+	}
+}
+
+// wait blocks until this replica is bootstrapped.
+func (b *bootstrapState) wait() {
+	<-b.done
+}
+
+// checkBootstrap answers 503 BOOTSTRAPPING and returns false until this replica is bootstrapped.
+func checkBootstrap(w http.ResponseWriter, endpoint string) bool {
+	if !bootstrap.pending.Load() {
+		return true
+	}
+	metrics.inc("routing_bootstrap_rejections_total", "endpoint", endpoint)
+	w.Header().Set(bootstrapHeader, "1")
+	w.Header().Set("Retry-After", "1")
+	writeError(w, http.StatusServiceUnavailable, "BOOTSTRAPPING", "this replica is waiting for the cluster bootstrap ("+bootstrap.stateName()+")")
+	return false
+}
+
+func (b *bootstrapState) stateName() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func bootstrapStatus() map[string]any {
+	b := bootstrap
+	if !b.enabled {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := map[string]any{
+		"state":  b.state,
+		"role":   b.role,
+		"record": b.record,
+	}
+	if b.err != "" {
+		st["error"] = b.err
+	}
+	return st
+}
+
+const bootstrapKey = "poc-routing:bootstrap"
+
+func (r *redisRegistry) BootstrapRecord() (bootstrapRecord, bool, error) {
+	raw, err := r.client.GetString(bootstrapKey)
+	if err == resp.ErrNil {
+		return bootstrapRecord{}, false, nil
+	}
+	if err != nil {
+		return bootstrapRecord{}, false, err
+	}
+	var rec bootstrapRecord
+	if err := json.Unmarshal([]byte(raw), &rec); err != nil {
+		return bootstrapRecord{}, false, fmt.Errorf("bootstrap record: %w", err)
+	}
+	return rec, true, nil
+}
+
+func (r *redisRegistry) WriteBootstrap(rec bootstrapRecord) (bool, error) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return false, err
+	}
+	v, err := r.client.Do("SET", bootstrapKey, string(b), "NX")
+	if err != nil {
+		return false, err
+	}
+	return v != nil, nil
+}
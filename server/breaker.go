@@ -0,0 +1,265 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Circuit breakers per upstream replica. With BREAKER=on, every proxied call to a replica (the
+// gateway's reverse proxy, including hedges, and WebSocket pass-through dials) goes through that
+// replica's breaker. A transport error or a 5xx counts as a failure. A closed breaker opens after
+// BREAKER_FAILURES consecutive failures (default 5), or when at least BREAKER_MIN_REQUESTS
+// (default 10) of its last BREAKER_WINDOW calls (default 20) failed at BREAKER_ERROR_RATE or more
+// (default 0.5). An open breaker refuses calls outright for BREAKER_OPEN_FOR (default 10s), then
+// goes half-open and lets a single probe through: success closes it, failure opens it again.
+// GET /breakers lists every breaker.
+
+var errBreakerOpen = errors.New("circuit breaker open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+func (s breakerState) String() string {
+	return [...]string{"closed", "half_open", "open"}[s]
+}
+
+type breaker struct {
+	state       breakerState
+	consecutive int
+	outcomes    []bool // ring of the last calls, true for a failure
+	next        int
+	openedAt    time.Time
+	probing     bool
+	opens       int
+}
+
+type breakerSet struct {
+	enabled     bool
+	failures    int
+	rate        float64
+	window      int
+	minRequests int
+	openFor     time.Duration
+
+	mu sync.Mutex
+	m  map[string]*breaker
+}
+
+var breakers = newBreakerSetFromEnv()
+
+func newBreakerSetFromEnv() *breakerSet {
+	s := &breakerSet{
+		enabled:     strings.EqualFold(strings.TrimSpace(os.Getenv("BREAKER")), "on"),
+		failures:    5,
+		rate:        0.5,
+		window:      20,
+		minRequests: 10,
+		openFor:     10 * time.Second,
+		m:           make(map[string]*breaker),
+	}
+	if n, err := strconv.Atoi(os.Getenv("BREAKER_FAILURES")); err == nil && n > 0 {
+		s.failures = n
+	}
+	if f, err := strconv.ParseFloat(os.Getenv("BREAKER_ERROR_RATE"), 64); err == nil && f > 0 && f <= 1 {
+		s.rate = f
+	}
+	if n, err := strconv.Atoi(os.Getenv("BREAKER_WINDOW")); err == nil && n > 0 {
+		s.window = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("BREAKER_MIN_REQUESTS")); err == nil && n > 0 {
+		s.minRequests = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("BREAKER_OPEN_FOR")); err == nil && d > 0 {
+		s.openFor = d
+	}
+	metrics.counter("routing_breaker_transitions_total", "Circuit breaker state changes, by target and new state.")
+	metrics.counter("routing_breaker_rejected_total", "Proxied calls refused by an open circuit breaker, by target.")
+	metrics.gauge("routing_breaker_state", "Circuit breaker state per target: 0 closed, 1 half-open, 2 open.")
+	return s
+}
+
+// breakerCall is one call admitted by a breaker. The caller reports how it went with done, or
+// with abandon when the call was canceled before it said anything about the replica.
+type breakerCall struct {
+	set    *breakerSet
+	target string
+	b      *breaker
+}
+
+// allow asks target's breaker for a call; an open breaker returns errBreakerOpen. The call is nil,
+// and its methods no-ops, while breakers are off.
+func (s *breakerSet) allow(target string) (*breakerCall, error) {
+	if !s.enabled {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.m[target]
+	if !ok {
+		b = &breaker{outcomes: make([]bool, 0, s.window)}
+		s.m[target] = b
+	}
+	switch b.state {
+	case breakerOpen:
+		if clock.Now().Sub(b.openedAt) < s.openFor {
+			metrics.inc("routing_breaker_rejected_total", "target", target)
+			return nil, fmt.Errorf("%s: %w", target, errBreakerOpen)
+		}
+		s.transition(target, b, breakerHalfOpen)
+		fallthrough
+	case breakerHalfOpen:
+		if b.probing {
+			metrics.inc("routing_breaker_rejected_total", "target", target)
+			return nil, fmt.Errorf("%s: %w (probe in flight)", target, errBreakerOpen)
+		}
+		b.probing = true
+	}
+	return &breakerCall{set: s, target: target, b: b}, nil
+}
+
+// done records the outcome of the call.
+func (c *breakerCall) done(ok bool) {
+	if c == nil {
+		return
+	}
+	s, b := c.set, c.b
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		b.probing = false
+		if ok {
+			b.consecutive, b.outcomes, b.next = 0, b.outcomes[:0], 0
+			s.transition(c.target, b, breakerClosed)
+		} else {
+			s.trip(c.target, b)
+		}
+		return
+	}
+	if b.state != breakerClosed {
+		return
+	}
+	if len(b.outcomes) < s.window {
+		b.outcomes = append(b.outcomes, !ok)
+	} else {
+		b.outcomes[b.next] = !ok
+		b.next = (b.next + 1) % s.window
+	}
+	if ok {
+		b.consecutive = 0
+		return
+	}
+	b.consecutive++
+	if b.consecutive >= s.failures || (len(b.outcomes) >= s.minRequests && b.errorRate() >= s.rate) {
+		s.trip(c.target, b)
+	}
+}
+
+// abandon releases the call without an outcome, letting a half-open breaker probe again.
+func (c *breakerCall) abandon() {
+	if c == nil {
+		return
+	}
+	c.set.mu.Lock()
+	c.b.probing = false
+	c.set.mu.Unlock()
+}
+
+func (s *breakerSet) trip(target string, b *breaker) {
+	b.openedAt = clock.Now()
+	b.opens++
+	s.transition(target, b, breakerOpen)
+}
+
+func (s *breakerSet) transition(target string, b *breaker, to breakerState) {
+	from := b.state
+	b.state = to
+	metrics.inc("routing_breaker_transitions_total", "target", target, "state", to.String())
+	metrics.set("routing_breaker_state", float64(to), "target", target)
+	log.Printf("breaker %s: %s -> %s", target, from, to)
+}
+
+func (b *breaker) errorRate() float64 {
+	if len(b.outcomes) == 0 {
+		return 0
+	}
+	failed := 0
+	for _, f := range b.outcomes {
+		if f {
+			failed++
+		}
+	}
+	return float64(failed) / float64(len(b.outcomes))
+}
+
+// breakerTransport routes each round trip through the breaker of the host it goes to.
+type breakerTransport struct {
+	base http.RoundTripper
+}
+
+func (t breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	call, err := breakers.allow(req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	// A call canceled by its caller (e.g. a hedge that lost) says nothing about the replica.
+	if req.Context().Err() != nil {
+		call.abandon()
+	} else {
+		call.done(err == nil && resp.StatusCode < http.StatusInternalServerError)
+	}
+	return resp, err
+}
+
+// breakerView is one breaker as GET /breakers shows it.
+type breakerView struct {
+	Target              string    `json:"target"`
+	State               string    `json:"state"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	Requests            int       `json:"requests"`
+	ErrorRate           float64   `json:"error_rate"`
+	Opens               int       `json:"opens"`
+	OpenedAt            time.Time `json:"opened_at,omitzero"`
+}
+
+// breakerViews lists the breakers, sorted by target.
+func breakerViews() []breakerView {
+	breakers.mu.Lock()
+	out := make([]breakerView, 0, len(breakers.m))
+	for target, b := range breakers.m {
+		out = append(out, breakerView{
+			Target:              target,
+			State:               b.state.String(),
+			ConsecutiveFailures: b.consecutive,
+			Requests:            len(b.outcomes),
+			ErrorRate:           b.errorRate(),
+			Opens:               b.opens,
+			OpenedAt:            b.openedAt,
+		})
+	}
+	breakers.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Target < out[j].Target })
+	return out
+}
+
+func handleBreakers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"enabled":  breakers.enabled,
+		"breakers": breakerViews(),
+	})
+}
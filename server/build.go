@@ -0,0 +1,32 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"personal/poc-routing/server/buildinfo"
+)
+
+// Build metadata. The binary's version, commit and build date (see buildinfo) are logged at
+// startup, served at /version, exported as routing_build_info and shown in /cluster/status, so
+// a scenario report or a dashboard can tell which build answered. APP_VERSION, the version a
+// replica advertises for rollouts (TARGET_VERSION), defaults to the build's version.
+
+// logBuildInfo logs the build and registers routing_build_info.
+func logBuildInfo() {
+	info := buildinfo.Get()
+	log.Printf("poc-routing %s %s", serverMode(), info)
+	metrics.gauge("routing_build_info", "Always 1; labels identify the running build.")
+	metrics.set("routing_build_info", 1, "version", info.Version, "commit", info.Commit, "go_version", info.GoVersion, "platform", info.Platform)
+}
+
+// handleVersion returns the build this process runs.
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		buildinfo.Info
+		Mode       string `json:"mode"`
+		AppVersion string `json:"app_version"`
+	}{buildinfo.Get(), serverMode(), appVersion()})
+}
@@ -0,0 +1,88 @@
+// Package buildinfo identifies the build a binary came from, so logs, metrics and scenario
+// reports can say exactly which code produced them. The release build stamps the variables
+// below with the linker (see the Makefile's dist target):
+//
+//	-ldflags "-X personal/poc-routing/server/buildinfo.Version=v1.2.0
+//	          -X personal/poc-routing/server/buildinfo.Commit=3f2c1ab
+//	          -X personal/poc-routing/server/buildinfo.Date=2026-10-15T09:00:00Z"
+//
+// A plain `go build` leaves them empty; Get then falls back to the VCS stamp the go command
+// embeds (commit, commit time, dirty tree), and to "dev" for the version.
+package buildinfo
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// Set at link time with -X.
+var (
+	Version string
+	Commit  string
+	Date    string
+)
+
+// Info describes a build.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// Get returns this binary's build info.
+func Get() Info {
+	return get()
+}
+
+var get = sync.OnceValue(func() Info {
+	i := Info{
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if i.Commit == "" {
+					i.Commit = s.Value
+				}
+			case "vcs.time":
+				if i.Date == "" {
+					i.Date = s.Value
+				}
+			case "vcs.modified":
+				i.Modified = s.Value == "true"
+			}
+		}
+	}
+	if i.Version == "" {
+		i.Version = "dev"
+	}
+	if i.Commit == "" {
+		i.Commit = "unknown"
+	}
+	if len(i.Commit) > 12 {
+		i.Commit = i.Commit[:12]
+	}
+	return i
+})
+
+// String renders the info for log lines, e.g. "v1.2.0 (3f2c1ab, 2026-10-15T09:00:00Z, go1.24.2 linux/arm64)".
+func (i Info) String() string {
+	commit := i.Commit
+	if i.Modified {
+		commit += "-dirty"
+	}
+	if i.Date != "" {
+		commit += ", " + i.Date
+	}
+	return fmt.Sprintf("%s (%s, %s %s)", i.Version, commit, i.GoVersion, i.Platform)
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// Bulk views of the routing table. POST /where/batch resolves many client IDs in one call and
+// GET /cluster/assignments lists the registry (?replica= filters). Both take ?format=compact, which
+// replaces per-entry objects with a replica table and indexes into it: /where/batch answers with one
+// index per requested ID in request order (-1 when unresolved), and /cluster/assignments with
+// [client_id, replica index, assigned_at, updated_at] rows in Unix seconds. Together with response
+// compression this keeps answers for 100k clients well under a megabyte. Large listings can be
+// paged with ?limit= (see assignmentpages.go).
+
+var whereBatchMax = newWhereBatchMax()
+
+func newWhereBatchMax() int {
+	if n, err := strconv.Atoi(os.Getenv("WHERE_BATCH_MAX")); err == nil && n > 0 {
+		return n
+	}
+	return 100000
+}
+
+// replicaTable assigns stable small indexes to replica host:ports for compact responses.
+type replicaTable struct {
+	names []string
+	index map[string]int
+}
+
+func (t *replicaTable) id(replica string) int {
+	if i, ok := t.index[replica]; ok {
+		return i
+	}
+	if t.index == nil {
+		t.index = make(map[string]int)
+	}
+	t.index[replica] = len(t.names)
+	t.names = append(t.names, replica)
+	return t.index[replica]
+}
+
+func compactFormat(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "compact"
+}
+
+func handleWhereBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ClientIDs []string `json:"client_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_BATCH", err.Error())
+		return
+	}
+	if len(req.ClientIDs) == 0 || len(req.ClientIDs) > whereBatchMax {
+		writeError(w, http.StatusBadRequest, "INVALID_BATCH", "client_ids must hold 1 to "+strconv.Itoa(whereBatchMax)+" IDs")
+		return
+	}
+	if !checkBootstrap(w, "where_batch") || !checkRingView(w, "where_batch") {
+		return
+	}
+	if !checkQuota(w, r, quotaResolution, "", len(req.ClientIDs)) {
+		return
+	}
+
+	// Resolution may hit the registry per ID, so a few run in parallel.
+	owners := make([]string, len(req.ClientIDs))
+	codes := make([]string, len(req.ClientIDs))
+	versions := make([]uint64, len(req.ClientIDs))
+	var wg sync.WaitGroup
+	next := make(chan int)
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if req.ClientIDs[i] == "" {
+					codes[i] = "MISSING_CLIENT_ID"
+					continue
+				}
+				owner, v, err := resolveOwnerAt(r.Context(), req.ClientIDs[i])
+				versions[i] = v
+				if err != nil {
+					codes[i] = resolveErrorCode(err)
+					continue
+				}
+				owners[i] = owner
+			}
+		}()
+	}
+	for i := range req.ClientIDs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	version := slices.Max(versions) // the newest table any lookup used
+
+	setTableVersion(w, version)
+	w.Header().Set("Content-Type", "application/json")
+	if compactFormat(r) {
+		var table replicaTable
+		idx := make([]int, len(owners))
+		errs := make(map[string]string)
+		for i, owner := range owners {
+			if codes[i] != "" {
+				idx[i] = -1
+				errs[strconv.Itoa(i)] = codes[i]
+				continue
+			}
+			idx[i] = table.id(owner)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"r": table.names, "a": idx, "e": errs, "v": version})
+		return
+	}
+	type entry struct {
+		ClientID string `json:"client_id"`
+		HostPort string `json:"hostport,omitempty"`
+		Code     string `json:"code,omitempty"`
+	}
+	out := make([]entry, len(owners))
+	for i, owner := range owners {
+		out[i] = entry{ClientID: req.ClientIDs[i], HostPort: owner, Code: codes[i]}
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{"assignments": out, "table_version": version})
+}
+
+func handleClusterAssignments(w http.ResponseWriter, r *http.Request) {
+	list := func() ([]Assignment, error) {
+		list, err := registry.List()
+		if err != nil {
+			return nil, err
+		}
+		if replica := r.URL.Query().Get("replica"); replica != "" {
+			filtered := list[:0]
+			for _, a := range list {
+				if a.Replica == replica {
+					filtered = append(filtered, a)
+				}
+			}
+			list = filtered
+		}
+		return list, nil
+	}
+	if limit, paged, err := pageLimit(r); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_LIMIT", err.Error())
+		return
+	} else if paged {
+		serveAssignmentPage(w, r, limit, list, true)
+		return
+	}
+
+	all, err := list()
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "REGISTRY_UNAVAILABLE", err.Error())
+		return
+	}
+	writeAssignments(w, r, all, nil)
+}
+
+// writeAssignments answers with list in the requested format, adding the extra top-level fields.
+func writeAssignments(w http.ResponseWriter, r *http.Request, list []Assignment, extra map[string]any) {
+	body := make(map[string]any, len(extra)+2)
+	for k, v := range extra {
+		body[k] = v
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if compactFormat(r) {
+		var table replicaTable
+		rows := make([][4]any, len(list))
+		for i, a := range list {
+			rows[i] = [4]any{a.ClientID, table.id(a.Replica), a.AssignedAt.Unix(), a.UpdatedAt.Unix()}
+		}
+		body["r"], body["a"] = table.names, rows
+		_ = json.NewEncoder(w).Encode(body)
+		return
+	}
+	body["count"], body["assignments"] = len(list), list
+	_ = json.NewEncoder(w).Encode(body)
+}
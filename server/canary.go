@@ -0,0 +1,230 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Synthetic canaries. With ENVOY_URL set (e.g. http://envoy:10000), the replica sends
+// CANARY_CLIENTS (default 3) synthetic clients, <CANARY_PREFIX><n> (default canary-1, canary-2,
+// ...), through the full routing path every CANARY_INTERVAL (default 30s; 0 turns the prober off):
+//   - /where through Envoy, which must answer 200 with an owner
+//   - /join through Envoy, which must answer 200 and be registered on that owner; a 307 means the
+//     session was still held elsewhere and handed over, which is correct right after a ring change
+//
+// A probe whose join lands elsewhere is a mismatch: Envoy and the replicas disagree on the owner.
+// The two are compared by replica, not string, since /join names the ring target and /where the
+// replica's hostname:port.
+// Redirects are not followed, so every request goes through Envoy. Canary clients are ordinary
+// clients with sessions and assignments. GET /canary/status reports the last round per client and
+// the running totals; the prober is per replica, so each one reports its own view. Metrics:
+// routing_canary_probes_total{result} (ok, moved, mismatch, where_failed, join_failed),
+// routing_canary_latency_seconds{step} and routing_canary_healthy (1 while the last round passed).
+
+type canaryResult struct {
+	ClientID string    `json:"client_id"`
+	Result   string    `json:"result"`
+	Owner    string    `json:"owner,omitempty"`
+	Assigned string    `json:"assigned,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	WhereMs  float64   `json:"where_ms"`
+	JoinMs   float64   `json:"join_ms,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// failed reports whether the result fails the round.
+func (r canaryResult) failed() bool {
+	return r.Result != "ok" && r.Result != "moved"
+}
+
+type canaryProber struct {
+	base     string
+	interval time.Duration
+	clients  int
+	prefix   string
+	client   *http.Client
+
+	mu          sync.Mutex
+	last        []canaryResult
+	rounds      int
+	failed      int
+	lastRound   time.Time
+	lastSuccess time.Time
+}
+
+var canaries = newCanaryProberFromEnv()
+
+func newCanaryProberFromEnv() *canaryProber {
+	base := strings.TrimSpace(os.Getenv("ENVOY_URL"))
+	if base == "" {
+		return nil
+	}
+	p := &canaryProber{
+		// The client's ENVOY_URL names /join; the prober only needs the origin.
+		base:     strings.TrimSuffix(strings.TrimRight(base, "/"), "/join"),
+		interval: 30 * time.Second,
+		clients:  3,
+		prefix:   "canary-",
+		client: &http.Client{Timeout: 5 * time.Second, CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}},
+	}
+	if d, err := time.ParseDuration(os.Getenv("CANARY_INTERVAL")); err == nil && d >= 0 {
+		p.interval = d
+	}
+	if p.interval == 0 {
+		return nil
+	}
+	if n, err := strconv.Atoi(os.Getenv("CANARY_CLIENTS")); err == nil && n > 0 {
+		p.clients = n
+	}
+	if v := os.Getenv("CANARY_PREFIX"); v != "" {
+		p.prefix = v
+	}
+	metrics.counter("routing_canary_probes_total", "Synthetic canary probes through Envoy, by result.")
+	metrics.histogram("routing_canary_latency_seconds", "Latency of canary requests through Envoy, by step (where, join).")
+	metrics.gauge("routing_canary_healthy", "1 while the last canary round passed.")
+	return p
+}
+
+// runCanaries probes every interval. Disabled without ENVOY_URL.
+func runCanaries() {
+	if canaries == nil {
+		return
+	}
+	log.Printf("canary: probing %d clients through %s every %s", canaries.clients, canaries.base, canaries.interval)
+	canaries.round()
+	for range clock.Tick(canaries.interval) {
+		canaries.round()
+	}
+}
+
+// round probes every canary client once.
+func (p *canaryProber) round() {
+	results := make([]canaryResult, p.clients)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = p.probe(p.prefix + strconv.Itoa(i+1))
+		}()
+	}
+	wg.Wait()
+	ok := true
+	for _, r := range results {
+		metrics.inc("routing_canary_probes_total", "result", r.Result)
+		if r.failed() {
+			ok = false
+			log.Printf("canary client_id=%s %s: owner=%s assigned=%s %s", r.ClientID, r.Result, r.Owner, r.Assigned, r.Error)
+		}
+	}
+	now := clock.Now()
+	p.mu.Lock()
+	p.last, p.lastRound = results, now
+	p.rounds++
+	if ok {
+		p.lastSuccess = now
+	} else {
+		p.failed++
+	}
+	p.mu.Unlock()
+	healthy := 0.0
+	if ok {
+		healthy = 1
+	}
+	metrics.set("routing_canary_healthy", healthy)
+}
+
+// probe resolves clientID through Envoy and joins it, checking the join lands on the owner.
+func (p *canaryProber) probe(clientID string) canaryResult {
+	res := canaryResult{ClientID: clientID, At: clock.Now()}
+	q := "?client_id=" + url.QueryEscape(clientID)
+
+	var where whereResponse
+	start := time.Now()
+	status, err := p.get("/where"+q, &where)
+	res.WhereMs = float64(time.Since(start).Microseconds()) / 1000
+	metrics.observe("routing_canary_latency_seconds", time.Since(start).Seconds(), "step", "where")
+	if err == nil && (status != http.StatusOK || where.HostPort == "") {
+		err = fmt.Errorf("/where answered %d", status)
+	}
+	if err != nil {
+		res.Result, res.Error = "where_failed", err.Error()
+		return res
+	}
+	res.Owner = where.HostPort
+
+	var join struct {
+		Status   string `json:"status"`
+		Assigned string `json:"assigned"`
+	}
+	start = time.Now()
+	status, err = p.get("/join"+q, &join)
+	res.JoinMs = float64(time.Since(start).Microseconds()) / 1000
+	metrics.observe("routing_canary_latency_seconds", time.Since(start).Seconds(), "step", "join")
+	res.Assigned = join.Assigned
+	switch {
+	case err != nil:
+		res.Result, res.Error = "join_failed", err.Error()
+	case status == http.StatusTemporaryRedirect:
+		res.Result = "moved"
+	case status != http.StatusOK:
+		res.Result, res.Error = "join_failed", fmt.Sprintf("/join answered %d", status)
+	case !sameReplica(join.Assigned, res.Owner):
+		res.Result = "mismatch"
+	default:
+		res.Result = "ok"
+	}
+	return res
+}
+
+// get sends a GET for path to Envoy and decodes a JSON body into v.
+func (p *canaryProber) get(path string, v any) (int, error) {
+	resp, err := p.client.Get(p.base + path)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		_ = json.Unmarshal(body, v)
+	}
+	return resp.StatusCode, nil
+}
+
+// handleCanaryStatus reports the last canary round.
+func handleCanaryStatus(w http.ResponseWriter, r *http.Request) {
+	out := map[string]any{"enabled": canaries != nil}
+	if canaries != nil {
+		p := canaries
+		p.mu.Lock()
+		out["envoy_url"] = p.base
+		out["interval"] = p.interval.String()
+		out["rounds"] = p.rounds
+		out["failed_rounds"] = p.failed
+		out["healthy"] = p.rounds > 0 && p.lastSuccess.Equal(p.lastRound)
+		out["results"] = p.last
+		if !p.lastRound.IsZero() {
+			out["last_round_at"] = p.lastRound
+		}
+		if !p.lastSuccess.IsZero() {
+			out["last_success_at"] = p.lastSuccess
+		}
+		p.mu.Unlock()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"personal/poc-routing/server/internal/resp"
+)
+
+// Client ownership locks. With CLIENT_LOCK=on and a shared registry (redis), a replica takes the
+// lock poc-routing:owner:<client_id> before it accepts a /join, and refuses the join with
+// 409 OWNED_ELSEWHERE while another replica holds it, so two replicas never hold the same client
+// at once (e.g. a failover target while the owner is still alive, or two replicas with different
+// views of the ring). Locks last CLIENT_LOCK_TTL (default 15s) and are renewed every TTL/3 for
+// the sessions this replica holds. A handoff releases the lock before sending the session and the
+// receiver takes it on its next renewal; shutdown releases every lock, and a crashed owner's locks
+// expire after one TTL. If the registry cannot be reached, /join fails with 503 LOCK_UNAVAILABLE.
+
+type clientLocker interface {
+	// LockClient takes or extends the lock on clientID for holder. When another holder has it,
+	// it returns that holder and the lock's remaining time.
+	LockClient(clientID, holder string, ttl time.Duration) (other string, left time.Duration, err error)
+	// RenewClients is LockClient for many clients at once; it returns the ones held elsewhere.
+	RenewClients(clientIDs []string, holder string, ttl time.Duration) (lost []string, err error)
+	// UnlockClients releases the locks holder still has.
+	UnlockClients(clientIDs []string, holder string) error
+}
+
+func init() {
+	metrics.counter("routing_client_lock_refused_total", "Joins refused because another replica holds the client's ownership lock.")
+	metrics.counter("routing_client_lock_errors_total", "Ownership lock calls that failed.")
+}
+
+// clientLocks returns the registry's locker, or false when CLIENT_LOCK is off or the backend has no locks.
+func clientLocks() (clientLocker, bool) {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("CLIENT_LOCK"))) {
+	case "on", "true", "1":
+	default:
+		return nil, false
+	}
+	return registryAs[clientLocker]()
+}
+
+func clientLockTTL() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("CLIENT_LOCK_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return 15 * time.Second
+}
+
+// lockClientForJoin takes clientID's lock for this replica. It writes the error response and
+// returns false when the join must be refused.
+func lockClientForJoin(w http.ResponseWriter, clientID string) bool {
+	l, ok := clientLocks()
+	if !ok {
+		return true
+	}
+	other, left, err := l.LockClient(clientID, selfTarget(), clientLockTTL())
+	if err != nil {
+		metrics.inc("routing_client_lock_errors_total")
+		writeError(w, http.StatusServiceUnavailable, "LOCK_UNAVAILABLE", "ownership lock: "+err.Error())
+		return false
+	}
+	if other != "" {
+		metrics.inc("routing_client_lock_refused_total")
+		log.Printf("/join client_id=%s refused: ownership lock held by %s for %s", clientID, other, left.Round(time.Millisecond))
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(left.Seconds()))))
+		writeError(w, http.StatusConflict, "OWNED_ELSEWHERE", fmt.Sprintf("client %s is owned by %s", clientID, other))
+		return false
+	}
+	return true
+}
+
+// unlockClients releases this replica's locks on clientIDs, e.g. before handing a session off.
+func unlockClients(clientIDs ...string) {
+	l, ok := clientLocks()
+	if !ok || len(clientIDs) == 0 {
+		return
+	}
+	if err := l.UnlockClients(clientIDs, selfTarget()); err != nil {
+		metrics.inc("routing_client_lock_errors_total")
+		log.Printf("ownership lock release of %d clients failed: %v", len(clientIDs), err)
+	}
+}
+
+// releaseClientLocks runs on shutdown so the next owners can take over without waiting a TTL.
+func releaseClientLocks() {
+	held := sessions.list()
+	ids := make([]string, len(held))
+	for i, sess := range held {
+		ids[i] = sess.ClientID
+	}
+	unlockClients(ids...)
+}
+
+// runClientLockRenewal keeps the locks of every local session alive.
+func runClientLockRenewal() {
+	l, ok := clientLocks()
+	if !ok {
+		return
+	}
+	ttl := clientLockTTL()
+	for range clock.Tick(ttl / 3) {
+		held := sessions.list()
+		ids := make([]string, len(held))
+		for i, sess := range held {
+			ids[i] = sess.ClientID
+		}
+		for len(ids) > 0 {
+			batch := ids[:min(len(ids), 500)]
+			ids = ids[len(batch):]
+			lost, err := l.RenewClients(batch, selfTarget(), ttl)
+			if err != nil {
+				metrics.inc("routing_client_lock_errors_total")
+				log.Printf("ownership lock renewal of %d clients failed: %v", len(batch), err)
+				continue
+			}
+			for _, id := range lost {
+				log.Printf("ownership lock client_id=%s is held by another replica; keeping the session until it rejoins", id)
+			}
+		}
+	}
+}
+
+const (
+	redisLockScript = `local v = redis.call('GET', KEYS[1])
+if v == false or v == ARGV[1] then
+  redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+  return {'', 0}
+end
+return {v, redis.call('PTTL', KEYS[1])}`
+	redisUnlockScript = `if redis.call('GET', KEYS[1]) == ARGV[1] then return redis.call('DEL', KEYS[1]) else return 0 end`
+)
+
+func clientLockKey(clientID string) string { return "poc-routing:owner:" + clientID }
+
+func (r *redisRegistry) LockClient(clientID, holder string, ttl time.Duration) (string, time.Duration, error) {
+	v, err := r.client.Do("EVAL", redisLockScript, "1", clientLockKey(clientID), holder, strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		return "", 0, err
+	}
+	return parseLockReply(v)
+}
+
+func (r *redisRegistry) RenewClients(clientIDs []string, holder string, ttl time.Duration) ([]string, error) {
+	ms := strconv.FormatInt(ttl.Milliseconds(), 10)
+	cmds := make([][]string, len(clientIDs))
+	for i, id := range clientIDs {
+		cmds[i] = []string{"EVAL", redisLockScript, "1", clientLockKey(id), holder, ms}
+	}
+	replies, err := r.client.Pipeline(cmds)
+	if err != nil {
+		return nil, err
+	}
+	var lost []string
+	for i, v := range replies {
+		if e, ok := v.(resp.Error); ok {
+			return lost, e
+		}
+		if other, _, err := parseLockReply(v); err != nil {
+			return lost, err
+		} else if other != "" {
+			lost = append(lost, clientIDs[i])
+		}
+	}
+	return lost, nil
+}
+
+func (r *redisRegistry) UnlockClients(clientIDs []string, holder string) error {
+	cmds := make([][]string, len(clientIDs))
+	for i, id := range clientIDs {
+		cmds[i] = []string{"EVAL", redisUnlockScript, "1", clientLockKey(id), holder}
+	}
+	replies, err := r.client.Pipeline(cmds)
+	if err != nil {
+		return err
+	}
+	for _, v := range replies {
+		if e, ok := v.(resp.Error); ok {
+			return e
+		}
+	}
+	return nil
+}
+
+func parseLockReply(v any) (string, time.Duration, error) {
+	arr, ok := v.([]any)
+	if !ok || len(arr) != 2 {
+		return "", 0, fmt.Errorf("redis: unexpected lock reply %v", v)
+	}
+	other, _ := arr[0].(string)
+	ms, _ := arr[1].(int64)
+	return other, time.Duration(ms) * time.Millisecond, nil
+}
@@ -0,0 +1,25 @@
+package server
+
+import "time"
+
+// Time source for session TTLs, health polling, membership heartbeats, gossip and leases. That
+// code reads clock.Now() and waits with clock.Tick/clock.Sleep instead of the time package, so
+// tests can install a fakeClock (clock_test.go) and step through expiry and lease renewals,
+// and a server built with -tags testclock can be moved forward through POST /admin/clock.
+// Everything else (latency measurement, request deadlines) keeps using real time.
+
+type clockSource interface {
+	Now() time.Time
+	Tick(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+}
+
+var clock clockSource = realClock{}
+
+func clockSince(t time.Time) time.Duration { return clock.Now().Sub(t) }
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                        { return time.Now() }
+func (realClock) Tick(d time.Duration) <-chan time.Time { return time.Tick(d) }
+func (realClock) Sleep(d time.Duration)                 { time.Sleep(d) }
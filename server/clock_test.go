@@ -0,0 +1,228 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves when advanced. Tickers fire, and sleepers wake, for every deadline
+// an advance passes.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+type fakeWaiter struct {
+	at     time.Time
+	period time.Duration // 0 for a one-shot Sleep
+	c      chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Tick(d time.Duration) <-chan time.Time {
+	if d <= 0 {
+		return nil
+	}
+	return c.wait(d, d)
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	<-c.wait(d, 0)
+}
+
+func (c *fakeClock) wait(d, period time.Duration) chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &fakeWaiter{at: c.now.Add(d), period: period, c: make(chan time.Time, 1)}
+	c.waiters = append(c.waiters, w)
+	return w.c
+}
+
+// advance moves the clock forward by d, firing due tickers and sleepers in deadline order.
+// Like time.Ticker, a ticker whose reader is behind drops ticks.
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	end := c.now.Add(d)
+	for {
+		var next *fakeWaiter
+		for _, w := range c.waiters {
+			if !w.at.After(end) && (next == nil || w.at.Before(next.at)) {
+				next = w
+			}
+		}
+		if next == nil {
+			break
+		}
+		c.now = next.at
+		select {
+		case next.c <- c.now:
+		default:
+		}
+		if next.period > 0 {
+			next.at = next.at.Add(next.period)
+			continue
+		}
+		for i, w := range c.waiters {
+			if w == next {
+				c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+				break
+			}
+		}
+	}
+	c.now = end
+}
+
+// blockUntil waits until at least n tickers or sleepers are registered, so a test can advance
+// the clock only once the goroutine under test is waiting on it.
+func (c *fakeClock) blockUntil(n int) {
+	for {
+		c.mu.Lock()
+		got := len(c.waiters)
+		c.mu.Unlock()
+		if got >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func withFakeClock(t *testing.T) *fakeClock {
+	t.Helper()
+	c := newFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	prev := clock
+	clock = c
+	t.Cleanup(func() { clock = prev })
+	return c
+}
+
+func TestSessionExpiresAfterTTL(t *testing.T) {
+	c := withFakeClock(t)
+	t.Setenv("SESSION_TTL", "1m")
+	prev := sessions
+	sessions = newSessionStore()
+	t.Cleanup(func() { sessions = prev })
+
+	sessions.touch("c-1", "app:1", nil)
+	go runSessionExpiry()
+	c.blockUntil(1)
+
+	c.advance(30 * time.Second)
+	if _, ok := sessions.get("c-1"); !ok {
+		t.Fatal("session expired before SESSION_TTL")
+	}
+	sessions.touch("c-2", "app:1", nil)
+	c.advance(45 * time.Second)
+	waitFor(t, func() bool { _, ok := sessions.get("c-1"); return !ok })
+	if _, ok := sessions.get("c-2"); !ok {
+		t.Fatal("c-2 expired 45s after its join")
+	}
+}
+
+// stubLeaser holds a single lease that can be stolen by another process.
+type stubLeaser struct {
+	assignmentRegistry
+	mu       sync.Mutex
+	holder   string
+	renewals int
+	acquires int
+}
+
+func (s *stubLeaser) AcquireLease(key, holder string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acquires++
+	s.holder = fmt.Sprintf("%s:%d", holder, s.acquires)
+	return int64(s.acquires), nil
+}
+
+func (s *stubLeaser) RenewLease(key, holder string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.renewals++
+	return s.holder == holder, nil
+}
+
+func (s *stubLeaser) LeaseHolder(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.holder, nil
+}
+
+func TestLeaseLossFencesOnNextRenewal(t *testing.T) {
+	c := withFakeClock(t)
+	t.Setenv("LEASE_TTL", "9s")
+	l := &stubLeaser{}
+	prev := registry
+	registry = l
+	t.Cleanup(func() { registry = prev; selfLease.fenced.Store(false) })
+
+	go runOrdinalLease()
+	c.blockUntil(1)
+	c.advance(3 * time.Second)
+	waitFor(t, func() bool { l.mu.Lock(); defer l.mu.Unlock(); return l.renewals == 1 })
+	if isFenced() {
+		t.Fatal("fenced while still holding the lease")
+	}
+
+	l.mu.Lock()
+	l.holder = "other:2"
+	l.mu.Unlock()
+	c.advance(2 * time.Second)
+	if isFenced() {
+		t.Fatal("fenced before the next renewal")
+	}
+	c.advance(time.Second)
+	waitFor(t, isFenced)
+}
+
+func TestExpiredLeaseIsReacquired(t *testing.T) {
+	c := withFakeClock(t)
+	t.Setenv("LEASE_TTL", "9s")
+	l := &stubLeaser{}
+	prev := registry
+	registry = l
+	t.Cleanup(func() { registry = prev; selfLease.fenced.Store(false) })
+
+	go runOrdinalLease()
+	c.blockUntil(1)
+	l.mu.Lock()
+	l.holder = "" // expired, e.g. Redis restarted
+	l.mu.Unlock()
+	c.advance(3 * time.Second)
+	waitFor(t, func() bool { l.mu.Lock(); defer l.mu.Unlock(); return l.acquires == 2 })
+	if isFenced() {
+		t.Fatal("fenced over an expired lease nobody else holds")
+	}
+	if _, holder, epoch := selfLease.snapshot(); epoch != 2 || !strings.HasSuffix(holder, ":2") {
+		t.Fatalf("lease not re-taken with a new epoch: holder=%s epoch=%d", holder, epoch)
+	}
+}
+
+// waitFor polls cond on real time, for effects of goroutines the fake clock has woken.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within 2s")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,102 @@
+//go:build testclock
+
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Time travel for testing, compiled in only with -tags testclock. The clock is real time plus an
+// offset. POST /admin/clock?skip=90s moves it forward (admin role), and every ticker and sleeper
+// on it wakes up right away so expiry, lease and health loops notice. GET /admin/clock reports
+// the offset. Never ship this build.
+
+type skipClock struct {
+	mu     sync.Mutex
+	offset time.Duration
+	wake   chan struct{} // closed and replaced on every skip
+}
+
+func init() {
+	c := &skipClock{wake: make(chan struct{})}
+	clock = c
+	http.HandleFunc("/admin/clock", c.handle)
+	log.Printf("warning: built with testclock, /admin/clock can move time forward")
+}
+
+func (c *skipClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().Add(c.offset)
+}
+
+func (c *skipClock) woken() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.wake
+}
+
+func (c *skipClock) Tick(d time.Duration) <-chan time.Time {
+	if d <= 0 {
+		return nil
+	}
+	out := make(chan time.Time, 1)
+	go func() {
+		t := time.NewTicker(d)
+		for {
+			select {
+			case <-t.C:
+			case <-c.woken():
+			}
+			select {
+			case out <- c.Now():
+			default:
+			}
+		}
+	}()
+	return out
+}
+
+func (c *skipClock) Sleep(d time.Duration) {
+	until := c.Now().Add(d)
+	for left := d; left > 0; left = until.Sub(c.Now()) {
+		select {
+		case <-time.After(left):
+		case <-c.woken():
+		}
+	}
+}
+
+func (c *skipClock) skip(d time.Duration) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.offset += d
+	close(c.wake)
+	c.wake = make(chan struct{})
+	return c.offset
+}
+
+func (c *skipClock) handle(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		d, err := time.ParseDuration(r.URL.Query().Get("skip"))
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "INVALID_SKIP", "skip must be a positive duration such as 90s")
+			return
+		}
+		log.Printf("testclock: skipped %s, offset now %s", d, c.skip(d))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	c.mu.Lock()
+	offset := c.offset
+	c.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"now": time.Now().Add(offset), "offset": offset.String()})
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"personal/poc-routing/server/buildinfo"
+)
+
+// routingEnv lists the settings that must agree across replicas for routing to be consistent.
+var routingEnv = []string{
+	"SERVICE_PREFIX", "SERVICE_SUFFIX", "REPLICAS", "INDEX_MODE", "INDEX_BASE", "PORT",
+	"SERVER_PEERS", "TARGET_VERSION", "FAILOVER_POLICY", "FAILOVER_CANDIDATES", "GROUP_DELIMITER",
+	"ANTI_AFFINITY", "TARGET_TEMPLATE", "TARGET_ZONES", "TARGET_DOMAIN",
+	"MEMBERSHIP", "REPLICA_ADDRESSES", "ROUTING_EXPR", "TOPIC_LEVELS", "TOPIC_SEPARATOR",
+	"ROUTING_SALT", "HASH_ALGORITHM", "TARGET_MODE", "TARGET_LOOKUP", "ROUTING_STICKINESS", "STICKY_TTL",
+	"REGISTRY_SCHEMA",
+}
+
+// configFingerprint hashes the routing settings so config drift between replicas is visible.
+func configFingerprint() string {
+	h := fnv.New64a()
+	for _, k := range routingEnv {
+		fmt.Fprintf(h, "%s=%s\n", k, os.Getenv(k))
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// ringVersion identifies the ordered target set the hash is computed over.
+func ringVersion() string {
+	return currentTable().ring
+}
+
+// ringHash is the ringVersion of targets, computed once per routing table.
+func ringHash(targets []string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(strings.Join(targets, ",")))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// clusterReplica is a replica on /cluster/status: what the view knows, plus the routing table
+// slots naming it and the registry's assignments to it.
+type clusterReplica struct {
+	replicaInfo
+	Slots       int `json:"slots"`
+	Assignments int `json:"assignments"`
+}
+
+// assignmentCountTTL is how long a registry count is reused: counting lists the whole registry.
+const assignmentCountTTL = 5 * time.Second
+
+var assignmentCounts struct {
+	sync.Mutex
+	at        time.Time
+	byReplica map[string]int
+	total     int
+	err       error
+}
+
+// countAssignments returns the registry's assignments per replica and in total.
+func countAssignments() (map[string]int, int, error) {
+	c := &assignmentCounts
+	c.Lock()
+	defer c.Unlock()
+	if time.Since(c.at) < assignmentCountTTL {
+		return c.byReplica, c.total, c.err
+	}
+	all, err := registry.List()
+	c.byReplica, c.total, c.err, c.at = make(map[string]int), len(all), err, time.Now()
+	for _, a := range all {
+		c.byReplica[a.Replica]++
+	}
+	return c.byReplica, c.total, c.err
+}
+
+// handleClusterStatus returns the aggregated routing view as observed by this replica.
+func handleClusterStatus(w http.ResponseWriter, r *http.Request) {
+	slots := make(map[string]int)
+	for _, target := range currentTable().Targets {
+		slots[target]++
+	}
+	byReplica, assigned, countErr := countAssignments()
+	view := make([]clusterReplica, 0, len(slots))
+	for _, info := range replicas.snapshot() {
+		view = append(view, clusterReplica{replicaInfo: info, Slots: slots[info.Target], Assignments: byReplica[info.Target]})
+	}
+	assignments := map[string]any{"total": assigned}
+	if countErr != nil {
+		assignments["error"] = countErr.Error()
+	}
+	versions := make(map[string]int)
+	fingerprints := make(map[string]int)
+	healthy, totalSessions := 0, 0
+	for _, info := range view {
+		if !info.Healthy {
+			continue
+		}
+		healthy++
+		totalSessions += info.Sessions
+		versions[info.Version]++
+		fingerprints[info.ConfigFingerprint]++
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"self":               localInfo(),
+		"build":              buildinfo.Get(),
+		"lease":              leaseStatus(),
+		"bootstrap":          bootstrapStatus(),
+		"registry_schema":    registrySchemaStatus(),
+		"target_version":     os.Getenv("TARGET_VERSION"),
+		"ring_version":       ringVersion(),
+		"membership":         members.source(),
+		"membership_pending": members.pending(),
+		"ring_view":          ringViewStatus(),
+		"routing_table":      tableStatus(),
+		"gossip":             gossip.status(),
+		"standby_pairs":      standbyStatus(),
+		"maintenance":        maintenanceStatus(),
+		"pools":              poolsStatus(),
+		"affinity_groups":    affinityStatus(),
+		"config_fingerprint": configFingerprint(),
+		"config_consistent":  len(fingerprints) <= 1,
+		"replicas":           view,
+		"assignments":        assignments,
+		"replicas_total":     len(view),
+		"replicas_healthy":   healthy,
+		"active_sessions":    totalSessions,
+		"versions":           versions,
+	})
+}
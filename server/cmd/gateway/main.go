@@ -0,0 +1,10 @@
+// Command gateway runs the stateless routing tier: it resolves owners with the replicas' hashing
+// and policies and forwards /join, /counter and /ws to them, without owning clients itself (see
+// gateway.go in the server package). It takes the replicas' routing env.
+package main
+
+import server "personal/poc-routing/server"
+
+func main() {
+	server.MainGateway()
+}
@@ -0,0 +1,204 @@
+// Command migrate upgrades (or rolls back) the assignments stored in the Redis registry from one
+// schema version to another (see registryschema), so a POC iteration that changes the layout keeps
+// the registry's data instead of wiping it.
+//
+//	migrate [-redis redis:6379] [-from N] [-to N] [-batch 500] [-dry-run] [-delete-old]
+//
+// Records are read page by page under the old version's prefix, converted one version step at a
+// time, and written under the new version's prefix; the old keys stay unless -delete-old is given,
+// so replicas still reading the old schema keep working until they are switched over with
+// REGISTRY_SCHEMA. -from defaults to the version stored in poc-routing:schema (1 when the key is
+// missing), -to to the latest. Once every record converted, the new version is stored. Running it
+// again is safe: a record already under the new prefix is only overwritten when the old one has a
+// later updated_at, so assignments written in the meantime are picked up and newer ones kept.
+// The exit status is 1 when any record failed to convert.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"personal/poc-routing/server/internal/resp"
+	"personal/poc-routing/server/registryschema"
+)
+
+func main() {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "redis:6379"
+	}
+	flag.StringVar(&addr, "redis", addr, "Redis address (default REDIS_ADDR, or redis:6379)")
+	from := flag.Int("from", 0, "schema version the data is in (default: the stored version)")
+	to := flag.Int("to", registryschema.Latest, "schema version to migrate to")
+	batch := flag.Int("batch", 500, "records read and written per round trip")
+	dryRun := flag.Bool("dry-run", false, "convert and count records without writing anything")
+	deleteOld := flag.Bool("delete-old", false, "delete each record's old key once the new one is written")
+	flag.Parse()
+
+	conn := resp.NewClient(addr, 1, 30*time.Second)
+	stored, err := storedVersion(conn)
+	if err != nil {
+		log.Fatalf("redis %s: reading %s: %v", addr, registryschema.VersionKey, err)
+	}
+	if *from == 0 {
+		*from = stored
+	}
+	if !registryschema.Valid(*from) || !registryschema.Valid(*to) {
+		log.Fatalf("schema versions run from 1 to %d (from %d, to %d)", registryschema.Latest, *from, *to)
+	}
+	if *batch <= 0 {
+		*batch = 500
+	}
+	mode := ""
+	if *dryRun {
+		mode = " (dry run)"
+	}
+	log.Printf("registry %s: stored schema %d, migrating %d -> %d%s", addr, stored, *from, *to, mode)
+
+	m := &migration{conn: conn, from: *from, to: *to, dryRun: *dryRun, deleteOld: *deleteOld}
+	if *from != *to {
+		if err := m.run(*batch); err != nil {
+			log.Fatalf("migration stopped after %d records: %v", m.migrated, err)
+		}
+	}
+	if m.failed == 0 && !*dryRun && stored != *to {
+		if _, err := conn.Do("SET", registryschema.VersionKey, strconv.Itoa(*to)); err != nil {
+			log.Fatalf("storing schema version: %v", err)
+		}
+	}
+	fmt.Printf("schema %d -> %d: %d migrated, %d kept (newer), %d failed, %d old keys deleted\n", *from, *to, m.migrated, m.kept, m.failed, m.deleted)
+	if m.failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func storedVersion(conn redisConn) (int, error) {
+	v, err := conn.Do("GET", registryschema.VersionKey)
+	if err != nil {
+		return 0, err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return 1, nil
+	}
+	return strconv.Atoi(s)
+}
+
+// redisConn is the part of the RESP client a migration uses.
+type redisConn interface {
+	Do(args ...string) (any, error)
+	Pipeline(cmds [][]string) ([]any, error)
+}
+
+type migration struct {
+	conn      redisConn
+	from, to  int
+	dryRun    bool
+	deleteOld bool
+
+	migrated, kept, failed, deleted int
+}
+
+// run scans the old prefix and migrates each page of keys.
+func (m *migration) run(batch int) error {
+	prefix := registryschema.Prefix(m.from)
+	cursor := "0"
+	for {
+		v, err := m.conn.Do("SCAN", cursor, "MATCH", prefix+"*", "COUNT", strconv.Itoa(batch))
+		if err != nil {
+			return err
+		}
+		arr, ok := v.([]any)
+		if !ok || len(arr) != 2 {
+			return fmt.Errorf("bad SCAN reply")
+		}
+		cursor, _ = arr[0].(string)
+		var keys []string
+		page, _ := arr[1].([]any)
+		for _, k := range page {
+			if s, ok := k.(string); ok {
+				keys = append(keys, s)
+			}
+		}
+		if err := m.page(prefix, keys); err != nil {
+			return err
+		}
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+// page converts the records under keys and writes them under the new prefix, keeping any record
+// there that was updated later than the one being converted.
+func (m *migration) page(prefix string, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	newKeys := make([]string, len(keys))
+	gets := make([][]string, 0, 2*len(keys))
+	for i, k := range keys {
+		newKeys[i] = registryschema.Prefix(m.to) + strings.TrimPrefix(k, prefix)
+		gets = append(gets, []string{"GET", k}, []string{"GET", newKeys[i]})
+	}
+	values, err := m.conn.Pipeline(gets)
+	if err != nil {
+		return err
+	}
+	var writes [][]string
+	for i := range keys {
+		raw, ok := values[2*i].(string)
+		if !ok {
+			continue // deleted since the scan
+		}
+		out, err := registryschema.Decode(m.from, []byte(raw))
+		if err == nil {
+			out, err = registryschema.Convert(out, m.from, m.to)
+		}
+		if err != nil {
+			log.Printf("%s: %v", keys[i], err)
+			m.failed++
+			continue
+		}
+		if cur, ok := values[2*i+1].(string); ok && updatedAt(cur).After(updatedAt(raw)) {
+			m.kept++
+		} else {
+			writes = append(writes, []string{"SET", newKeys[i], string(out)})
+			m.migrated++
+		}
+		if m.deleteOld {
+			writes = append(writes, []string{"DEL", keys[i]})
+		}
+	}
+	if m.dryRun || len(writes) == 0 {
+		return nil
+	}
+	replies, err := m.conn.Pipeline(writes)
+	if err != nil {
+		return err
+	}
+	for i, rep := range replies {
+		if e, ok := rep.(resp.Error); ok {
+			return fmt.Errorf("%s %s: %w", writes[i][0], writes[i][1], e)
+		}
+		if writes[i][0] == "DEL" {
+			m.deleted++
+		}
+	}
+	return nil
+}
+
+// updatedAt returns a record's updated_at, or the zero time when it has none.
+func updatedAt(raw string) time.Time {
+	var rec struct {
+		UpdatedAt time.Time `json:"updated_at"`
+	}
+	_ = json.Unmarshal([]byte(raw), &rec)
+	return rec.UpdatedAt
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"personal/poc-routing/server/registryschema"
+)
+
+// fakeRedis answers GET, SET and DEL from a map, as much of Redis as page uses.
+type fakeRedis map[string]string
+
+func (f fakeRedis) Do(args ...string) (any, error) {
+	replies, err := f.Pipeline([][]string{args})
+	return replies[0], err
+}
+
+func (f fakeRedis) Pipeline(cmds [][]string) ([]any, error) {
+	out := make([]any, len(cmds))
+	for i, c := range cmds {
+		switch c[0] {
+		case "GET":
+			if v, ok := f[c[1]]; ok {
+				out[i] = v
+			}
+		case "SET":
+			f[c[1]] = c[2]
+			out[i] = "OK"
+		case "DEL":
+			delete(f, c[1])
+			out[i] = int64(1)
+		}
+	}
+	return out, nil
+}
+
+func record(id, updated string) string {
+	return `{"client_id":"` + id + `","replica":"server-1:8081","updated_at":"` + updated + `"}`
+}
+
+func TestPageKeepsNewerRecords(t *testing.T) {
+	v1, v2 := registryschema.Prefix(1), registryschema.Prefix(2)
+	for _, deleteOld := range []bool{false, true} {
+		f := fakeRedis{
+			v1 + "new":   record("new", "2026-01-01T00:00:00Z"),
+			v1 + "older": record("older", "2026-01-03T00:00:00Z"), // v2 copy is older: overwritten
+			v1 + "newer": record("newer", "2026-01-01T00:00:00Z"), // v2 copy is newer: kept
+			v1 + "bad":   "not json",
+			v2 + "older": strings.Replace(record("older", "2026-01-02T00:00:00Z"), "}", `,"schema":2}`, 1),
+			v2 + "newer": strings.Replace(record("newer", "2026-01-02T00:00:00Z"), "}", `,"schema":2}`, 1),
+		}
+		keptNewer := f[v2+"newer"]
+		m := &migration{conn: f, from: 1, to: 2, deleteOld: deleteOld}
+		if err := m.page(v1, []string{v1 + "new", v1 + "older", v1 + "newer", v1 + "bad", v1 + "gone"}); err != nil {
+			t.Fatal(err)
+		}
+		wantDeleted := 0
+		if deleteOld {
+			wantDeleted = 3
+		}
+		if m.migrated != 2 || m.kept != 1 || m.failed != 1 || m.deleted != wantDeleted {
+			t.Errorf("deleteOld=%v: migrated %d, kept %d, failed %d, deleted %d; want 2, 1, 1, %d",
+				deleteOld, m.migrated, m.kept, m.failed, m.deleted, wantDeleted)
+		}
+		if f[v2+"newer"] != keptNewer {
+			t.Errorf("deleteOld=%v: newer record overwritten with %s", deleteOld, f[v2+"newer"])
+		}
+		if !strings.Contains(f[v2+"older"], "2026-01-03") || !strings.Contains(f[v2+"new"], `"schema":2`) {
+			t.Errorf("deleteOld=%v: v2 records %s, %s", deleteOld, f[v2+"older"], f[v2+"new"])
+		}
+		if _, ok := f[v1+"bad"]; !ok {
+			t.Errorf("deleteOld=%v: a record that failed to convert was deleted", deleteOld)
+		}
+		if _, ok := f[v1+"newer"]; ok == deleteOld {
+			t.Errorf("deleteOld=%v: old key of the kept record present=%v", deleteOld, ok)
+		}
+	}
+}
+
+func TestPageDryRunWritesNothing(t *testing.T) {
+	v1 := registryschema.Prefix(1)
+	f := fakeRedis{v1 + "a": record("a", "2026-01-01T00:00:00Z")}
+	m := &migration{conn: f, from: 1, to: 2, dryRun: true, deleteOld: true}
+	if err := m.page(v1, []string{v1 + "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(f) != 1 || m.migrated != 1 {
+		t.Errorf("dry run: %d keys, %d migrated; want 1, 1", len(f), m.migrated)
+	}
+}
@@ -0,0 +1,10 @@
+// Command server runs a routing replica: it owns the clients the ring assigns it and answers
+// /where, /join and the rest (see the server package). SERVER_MODE=gateway runs the gateway
+// instead, like cmd/gateway.
+package main
+
+import server "personal/poc-routing/server"
+
+func main() {
+	server.Main()
+}
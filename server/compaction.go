@@ -0,0 +1,278 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Compaction after scale-down. When targets leave the ring (REPLICAS lowered, members gone) the
+// registry still holds assignments, and replicas still hold pins, naming them: a client pinned to
+// server-9 kept resolving to server-9:8081 although it no longer exists. Once a removed target
+// has stayed out of the ring for COMPACTION_DELAY (default 30s, so a restart or a held membership
+// change doesn't trigger it), compaction:
+//   - drops this replica's pins to targets outside the ring
+//   - re-homes every registry assignment whose replica is outside the ring to where the client
+//     now resolves (placement, or its failover candidate while that is unhealthy), committed in
+//     REASSIGN_TX_MAX transactions with a "moved" event per client
+//   - counts the clients it could not re-home as unreachable: no healthy replica to take them
+//
+// Pins are per replica, so each replica drops its own. On a shared registry only the first
+// healthy target in ring order rewrites assignments; with REGISTRY_BACKEND=memory each replica
+// compacts its own. COMPACTION=off stops the automatic runs; POST /admin/compact starts one by hand
+// and GET /admin/compact reports the last run.
+
+type compactionJob struct {
+	mu          sync.Mutex
+	State       string         `json:"state"` // idle, running, done, failed
+	Trigger     string         `json:"trigger,omitempty"`
+	Removed     []string       `json:"removed"`
+	Scanned     int            `json:"scanned"`
+	Orphaned    int            `json:"orphaned"`
+	Rehomed     int            `json:"rehomed"`
+	Unreachable int            `json:"unreachable"`
+	PinsDropped int            `json:"pins_dropped"`
+	PerReplica  map[string]int `json:"per_replica"`
+	Skipped     string         `json:"skipped,omitempty"`
+	Error       string         `json:"error,omitempty"`
+	StartedAt   time.Time      `json:"started_at,omitzero"`
+	FinishedAt  time.Time      `json:"finished_at,omitzero"`
+}
+
+var compaction = &compactionJob{State: "idle", Removed: []string{}}
+
+func compactionDelay() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("COMPACTION_DELAY")); err == nil && d >= 0 {
+		return d
+	}
+	return 30 * time.Second
+}
+
+// runCompaction watches the ring for removed targets and compacts once they stay removed.
+func runCompaction() {
+	metrics.counter("routing_compaction_runs_total", "Compaction runs, by trigger and result.")
+	metrics.counter("routing_compaction_rehomed_total", "Assignments moved off removed replicas.")
+	metrics.gauge("routing_compaction_unreachable", "Clients the last compaction could not re-home.")
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("COMPACTION")), "off") {
+		return
+	}
+	delay := compactionDelay()
+	seen := slices.Clone(allTargets())
+	removed := make(map[string]time.Time) // target -> when it left the ring
+	for range clock.Tick(time.Second) {
+		current := allTargets()
+		now := clock.Now()
+		for _, t := range seen {
+			if _, ok := removed[t]; !ok && !slices.Contains(current, t) {
+				removed[t] = now
+			}
+		}
+		for _, t := range current {
+			delete(removed, t)
+			if !slices.Contains(seen, t) {
+				seen = append(seen, t)
+			}
+		}
+		if len(removed) == 0 {
+			continue
+		}
+		due := true
+		for _, at := range removed {
+			due = due && now.Sub(at) >= delay
+		}
+		if !due {
+			continue
+		}
+		if err := compaction.start("auto"); err == nil {
+			compaction.wait()
+		}
+		seen = slices.DeleteFunc(seen, func(t string) bool { _, gone := removed[t]; return gone })
+		clear(removed)
+	}
+}
+
+// start begins a compaction run in the background.
+func (j *compactionJob) start(trigger string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.State == "running" {
+		return errors.New("a compaction is already running")
+	}
+	j.State, j.Trigger, j.Error, j.Skipped = "running", trigger, "", ""
+	j.Removed = []string{}
+	j.Scanned, j.Orphaned, j.Rehomed, j.Unreachable, j.PinsDropped = 0, 0, 0, 0, 0
+	j.PerReplica = make(map[string]int)
+	j.StartedAt, j.FinishedAt = time.Now(), time.Time{}
+	go j.run()
+	return nil
+}
+
+// wait blocks until the current run has finished.
+func (j *compactionJob) wait() {
+	for {
+		j.mu.Lock()
+		running := j.State == "running"
+		j.mu.Unlock()
+		if !running {
+			return
+		}
+		clock.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (j *compactionJob) run() {
+	ring := allTargets()
+	// Assignments written under a replica's hostname:port still match their target (see ringTarget).
+	inRing := func(t string) bool { _, ok := ringTarget(t, ring); return ok }
+	removed := make(map[string]bool)
+
+	for clientID, to := range pins.current() {
+		if inRing(to) {
+			continue
+		}
+		removed[to] = true
+		pins.set(moveRecord{ClientID: clientID, From: to, Reason: "compaction", Time: time.Now()})
+		j.mu.Lock()
+		j.PinsDropped++
+		j.mu.Unlock()
+	}
+
+	if _, local := registry.(*memoryRegistry); !local && !compactionCoordinator() {
+		j.noteRemoved(removed)
+		j.mu.Lock()
+		j.Skipped = "assignments are rewritten by the first healthy replica"
+		j.mu.Unlock()
+		j.finish(nil)
+		return
+	}
+	all, err := registry.List()
+	if err != nil {
+		j.noteRemoved(removed)
+		j.finish(fmt.Errorf("%w: %v", errRegistryRead, err))
+		return
+	}
+	now := time.Now()
+	var plan []Assignment
+	from := make(map[string]string)
+	unreachable := 0
+	for _, a := range all {
+		if inRing(a.Replica) {
+			continue
+		}
+		removed[a.Replica] = true
+		next := placeClient(a.ClientID)
+		if !ownerHealthy(next) {
+			alt, ok := failover.pick(a.ClientID, next)
+			if !ok {
+				unreachable++
+				continue
+			}
+			next = alt
+		}
+		from[a.ClientID] = a.Replica
+		a.Replica, a.UpdatedAt = next, now
+		plan = append(plan, a)
+	}
+	sort.Slice(plan, func(i, k int) bool { return plan[i].ClientID < plan[k].ClientID })
+	j.noteRemoved(removed)
+	j.mu.Lock()
+	j.Scanned, j.Orphaned, j.Unreachable = len(all), len(plan)+unreachable, unreachable
+	j.mu.Unlock()
+	metrics.set("routing_compaction_unreachable", float64(unreachable))
+
+	for start := 0; start < len(plan); start += reassign.TxMax {
+		tx := plan[start:min(start+reassign.TxMax, len(plan))]
+		if err := putAssignments(tx); err != nil {
+			j.mu.Lock()
+			j.Unreachable += len(plan) - start
+			j.mu.Unlock()
+			metrics.set("routing_compaction_unreachable", float64(unreachable+len(plan)-start))
+			j.finish(err)
+			return
+		}
+		j.mu.Lock()
+		j.Rehomed += len(tx)
+		for _, a := range tx {
+			j.PerReplica[a.Replica]++
+		}
+		j.mu.Unlock()
+		metrics.add("routing_compaction_rehomed_total", float64(len(tx)))
+		for _, a := range tx {
+			events.emit(eventMoved, a.ClientID, a.Replica, from[a.ClientID], a.Replica)
+		}
+	}
+	j.finish(nil)
+}
+
+// putAssignments writes as atomically when the backend supports it, otherwise one by one.
+func putAssignments(as []Assignment) error {
+	if _, ok := registry.(txPutter); ok {
+		return putTx(registry, as)
+	}
+	for _, a := range as {
+		if err := registry.Put(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compactionCoordinator reports whether this replica is the first healthy target in ring order.
+func compactionCoordinator() bool {
+	healthy := healthyTargets()
+	return len(healthy) > 0 && isSelfTarget(healthy[0])
+}
+
+func (j *compactionJob) noteRemoved(removed map[string]bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for t := range removed {
+		j.Removed = append(j.Removed, t)
+	}
+	sort.Strings(j.Removed)
+}
+
+func (j *compactionJob) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.FinishedAt = time.Now()
+	j.State = "done"
+	if err != nil {
+		j.State = "failed"
+		j.Error = err.Error()
+	}
+	metrics.inc("routing_compaction_runs_total", "trigger", j.Trigger, "result", j.State)
+	log.Printf("compaction %s: removed=%v rehomed=%d unreachable=%d pins_dropped=%d", j.State, j.Removed, j.Rehomed, j.Unreachable, j.PinsDropped)
+}
+
+func (j *compactionJob) write(w http.ResponseWriter, status int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(j)
+}
+
+// handleCompact starts (POST) or reports (GET) a compaction run.
+func handleCompact(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		compaction.write(w, http.StatusOK)
+	case http.MethodPost:
+		if err := compaction.start("manual"); err != nil {
+			writeError(w, http.StatusConflict, "COMPACTION_RUNNING", err.Error())
+			return
+		}
+		compaction.write(w, http.StatusAccepted)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
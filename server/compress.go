@@ -0,0 +1,188 @@
+package server
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Response compression negotiated via Accept-Encoding (gzip or deflate). Bodies are buffered until
+// they reach COMPRESSION_MIN_BYTES (default 1024) so small answers such as a single /where are sent
+// as is; COMPRESSION=off disables it. Upgrades, event streams (SSE and gRPC-Web) and responses that
+// already carry a Content-Encoding pass through untouched.
+
+var compressMinBytes = newCompressMinBytes()
+
+func newCompressMinBytes() int {
+	metrics.counter("routing_compressed_responses_total", "Responses sent compressed, by encoding.")
+	metrics.counter("routing_compression_bytes_total", "Bytes before (stage=in) and after (stage=out) compression.")
+	n, err := strconv.Atoi(os.Getenv("COMPRESSION_MIN_BYTES"))
+	if err != nil || n < 0 {
+		return 1024
+	}
+	return n
+}
+
+func withCompression(next http.Handler) http.Handler {
+	if strings.EqualFold(os.Getenv("COMPRESSION"), "off") {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isWebSocketUpgrade(r) || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, status: http.StatusOK}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header, honouring q-values and
+// preferring gzip on a tie; "" means identity.
+func negotiateEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "*" {
+			name = "gzip"
+		}
+		if (name == "gzip" || name == "deflate") && (q > bestQ || (q == bestQ && name == "gzip")) {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressWriter buffers the start of a response and decides on the first flush past the size
+// threshold whether to compress it.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	buf      []byte
+	decided  bool
+	zw       interface {
+		io.WriteCloser
+		Flush() error
+	}
+	inBytes int
+	out     countingWriter
+}
+
+// countingWriter counts bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
+
+func (c *compressWriter) WriteHeader(code int) {
+	if c.decided {
+		return
+	}
+	c.status = code
+	if code == http.StatusNoContent || code == http.StatusNotModified || code < 200 {
+		c.passThrough()
+	}
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if !c.decided {
+		c.buf = append(c.buf, p...)
+		if len(c.buf) >= compressMinBytes {
+			c.decide()
+		}
+		return len(p), nil
+	}
+	if c.zw != nil {
+		c.inBytes += len(p)
+		return c.zw.Write(p)
+	}
+	return c.ResponseWriter.Write(p)
+}
+
+// Flush lets streaming handlers push what they have; it commits to compressing.
+func (c *compressWriter) Flush() {
+	if !c.decided {
+		c.decide()
+	}
+	if c.zw != nil {
+		_ = c.zw.Flush()
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (c *compressWriter) Unwrap() http.ResponseWriter { return c.ResponseWriter }
+
+func (c *compressWriter) decide() {
+	h := c.Header()
+	if ct := h.Get("Content-Type"); h.Get("Content-Encoding") != "" || strings.HasPrefix(ct, "text/event-stream") || strings.HasPrefix(ct, "application/grpc-web") {
+		c.passThrough()
+		return
+	}
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", http.DetectContentType(c.buf))
+	}
+	h.Set("Content-Encoding", c.encoding)
+	h.Del("Content-Length")
+	c.decided = true
+	c.ResponseWriter.WriteHeader(c.status)
+	c.out = countingWriter{w: c.ResponseWriter}
+	if c.encoding == "gzip" {
+		c.zw = gzip.NewWriter(&c.out)
+	} else {
+		c.zw, _ = flate.NewWriter(&c.out, flate.DefaultCompression)
+	}
+	buf := c.buf
+	c.buf = nil
+	_, _ = c.Write(buf)
+}
+
+func (c *compressWriter) passThrough() {
+	c.decided = true
+	c.ResponseWriter.WriteHeader(c.status)
+	if len(c.buf) > 0 {
+		_, _ = c.ResponseWriter.Write(c.buf)
+		c.buf = nil
+	}
+}
+
+// close sends a response that stayed below the threshold as is, or finishes the compressed stream.
+func (c *compressWriter) close() {
+	if !c.decided {
+		c.passThrough()
+		return
+	}
+	if c.zw == nil {
+		return
+	}
+	_ = c.zw.Close()
+	metrics.inc("routing_compressed_responses_total", "encoding", c.encoding)
+	metrics.add("routing_compression_bytes_total", float64(c.inBytes), "stage", "in")
+	metrics.add("routing_compression_bytes_total", float64(c.out.n), "stage", "out")
+}
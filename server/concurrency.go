@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Concurrency limits per endpoint class. MAX_CONCURRENT_REQUESTS (see harden.go) is one pool for
+// everything, so a burst of admin exports can take every slot and starve routing traffic.
+// CONCURRENCY_LIMITS gives each class its own pool, e.g. "read=500,write=200,admin=4:5s":
+//   - read: lookups and views (/where, /where/batch, /explain, /ring, /cluster/status, ...)
+//   - write: /join, /register and /counter
+//   - admin: /admin/*, /export/* and /cluster/assignments
+//
+// A request that finds its class full waits for a slot up to the class's queue timeout (after the
+// colon, default CONCURRENCY_QUEUE_TIMEOUT, 100ms; 0 sheds at once), then gets 503 OVERLOADED
+// with Retry-After: 1. A class without a limit is not capped, nor are the paths
+// MAX_CONCURRENT_REQUESTS exempts (/health, /metrics and the long-lived /ws, /events and its
+// gRPC-Web twin, /where/wait). Both caps apply when both are set: MAX_CONCURRENT_REQUESTS first.
+//
+// Metrics, by class: routing_concurrency_in_flight, routing_concurrency_waiting,
+// routing_concurrency_queued_total (requests that had to wait) and
+// routing_concurrency_shed_total{reason} (timeout, or canceled while waiting).
+
+type concurrencyClass struct {
+	name    string
+	slots   chan struct{}
+	timeout time.Duration
+	waiting atomic.Int64
+}
+
+var endpointClasses = []string{"read", "write", "admin"}
+
+// endpointClass names the class a path belongs to, or "" when it is never capped.
+func endpointClass(path string) string {
+	switch {
+	case slices.Contains(uncappedPaths, path):
+		return ""
+	case path == "/join" || path == "/register" || path == "/counter":
+		return "write"
+	case strings.HasPrefix(path, "/admin/") || strings.HasPrefix(path, "/export/") || path == "/cluster/assignments":
+		return "admin"
+	}
+	return "read"
+}
+
+func concurrencyLimitsFromEnv() map[string]*concurrencyClass {
+	timeout := 100 * time.Millisecond
+	if d, err := time.ParseDuration(os.Getenv("CONCURRENCY_QUEUE_TIMEOUT")); err == nil && d >= 0 {
+		timeout = d
+	}
+	classes := make(map[string]*concurrencyClass)
+	for _, entry := range strings.Split(os.Getenv("CONCURRENCY_LIMITS"), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, spec, _ := strings.Cut(entry, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		limit, wait, hasWait := strings.Cut(spec, ":")
+		n, err := strconv.Atoi(strings.TrimSpace(limit))
+		if !slices.Contains(endpointClasses, name) || err != nil || n <= 0 {
+			log.Printf("CONCURRENCY_LIMITS: ignoring %q", entry)
+			continue
+		}
+		c := &concurrencyClass{name: name, slots: make(chan struct{}, n), timeout: timeout}
+		if hasWait {
+			d, err := time.ParseDuration(strings.TrimSpace(wait))
+			if err != nil || d < 0 {
+				log.Printf("CONCURRENCY_LIMITS: ignoring %q", entry)
+				continue
+			}
+			c.timeout = d
+		}
+		classes[name] = c
+		log.Printf("concurrency: at most %d %s requests, queued up to %s", n, name, c.timeout)
+	}
+	if len(classes) > 0 {
+		metrics.gauge("routing_concurrency_in_flight", "Requests holding a CONCURRENCY_LIMITS slot, by class.")
+		metrics.gauge("routing_concurrency_waiting", "Requests waiting for a CONCURRENCY_LIMITS slot, by class.")
+		metrics.counter("routing_concurrency_queued_total", "Requests that waited for a CONCURRENCY_LIMITS slot, by class.")
+		metrics.counter("routing_concurrency_shed_total", "Requests refused for want of a CONCURRENCY_LIMITS slot, by class and reason.")
+	}
+	return classes
+}
+
+func withConcurrencyLimits(next http.Handler) http.Handler {
+	classes := concurrencyLimitsFromEnv()
+	if len(classes) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := classes[endpointClass(r.URL.Path)]
+		if c == nil || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if reason := c.acquire(r.Context()); reason != "" {
+			metrics.inc("routing_concurrency_shed_total", "class", c.name, "reason", reason)
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusServiceUnavailable, "OVERLOADED", "too many concurrent "+c.name+" requests")
+			return
+		}
+		defer c.release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquire takes a slot, waiting up to the class's queue timeout, and returns why it couldn't.
+func (c *concurrencyClass) acquire(ctx context.Context) string {
+	select {
+	case c.slots <- struct{}{}:
+		c.report()
+		return ""
+	default:
+	}
+	if c.timeout == 0 {
+		return "timeout"
+	}
+	metrics.inc("routing_concurrency_queued_total", "class", c.name)
+	metrics.set("routing_concurrency_waiting", float64(c.waiting.Add(1)), "class", c.name)
+	defer func() {
+		metrics.set("routing_concurrency_waiting", float64(c.waiting.Add(-1)), "class", c.name)
+	}()
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	select {
+	case c.slots <- struct{}{}:
+		c.report()
+		return ""
+	case <-timer.C:
+		return "timeout"
+	case <-ctx.Done():
+		return "canceled"
+	}
+}
+
+func (c *concurrencyClass) release() {
+	<-c.slots
+	c.report()
+}
+
+func (c *concurrencyClass) report() {
+	metrics.set("routing_concurrency_in_flight", float64(len(c.slots)), "class", c.name)
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Split-brain detection. Every CONFLICT_CHECK_INTERVAL (default 30s) this replica collects the
+// sessions held by every replica and flags client IDs that two or more replicas have seen within
+// CONFLICT_WINDOW (default 5m) of each other. Current conflicts are listed on GET /conflicts and
+// counted in routing_split_brain_conflicts; each newly seen one increments
+// routing_split_brain_detected_total. CONFLICT_CHECK_INTERVAL=0 disables the detector.
+
+type sessionClaim struct {
+	Replica  string    `json:"replica"`
+	JoinedAt time.Time `json:"joined_at"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+type ownershipConflict struct {
+	ClientID  string         `json:"client_id"`
+	Claims    []sessionClaim `json:"claims"`
+	FirstSeen time.Time      `json:"first_seen"`
+}
+
+type conflictDetector struct {
+	mu          sync.Mutex
+	conflicts   map[string]*ownershipConflict
+	checkedAt   time.Time
+	unreachable []string
+}
+
+var conflicts = &conflictDetector{conflicts: make(map[string]*ownershipConflict)}
+
+// handleSessionList returns every session this replica holds (internal API).
+func handleSessionList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(sessions.list())
+}
+
+func fetchSessions(target string) ([]Session, error) {
+	if isSelfTarget(target) {
+		return sessions.list(), nil
+	}
+	req, err := newInternalRequest(http.MethodGet, target, "/internal/sessions/all", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := replicaClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	var out []Session
+	err = json.NewDecoder(resp.Body).Decode(&out)
+	return out, err
+}
+
+// check collects claims from every replica and replaces the current conflict set.
+func (d *conflictDetector) check(window time.Duration) {
+	targets := allTargets()
+	if len(targets) == 0 {
+		targets = []string{getSelf()}
+	}
+	claims := make(map[string][]sessionClaim)
+	var unreachable []string
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			held, err := fetchSessions(target)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				unreachable = append(unreachable, target)
+				return
+			}
+			for _, s := range held {
+				claims[s.ClientID] = append(claims[s.ClientID], sessionClaim{Replica: target, JoinedAt: s.JoinedAt, LastSeen: s.LastSeen})
+			}
+		}(t)
+	}
+	wg.Wait()
+
+	now := time.Now()
+	next := make(map[string]*ownershipConflict)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for id, cs := range claims {
+		if len(cs) < 2 {
+			continue
+		}
+		sort.Slice(cs, func(i, j int) bool { return cs[i].LastSeen.After(cs[j].LastSeen) })
+		// Conflicting only if another replica saw the client within window of the latest claim.
+		if cs[0].LastSeen.Sub(cs[1].LastSeen) > window {
+			continue
+		}
+		c := &ownershipConflict{ClientID: id, Claims: cs, FirstSeen: now}
+		if prev, ok := d.conflicts[id]; ok {
+			c.FirstSeen = prev.FirstSeen
+		} else {
+			metrics.inc("routing_split_brain_detected_total")
+			log.Printf("split brain: client_id=%s held by %d replicas (%s, %s)", id, len(cs), cs[0].Replica, cs[1].Replica)
+		}
+		next[id] = c
+	}
+	d.conflicts = next
+	d.checkedAt = now
+	d.unreachable = unreachable
+}
+
+// runConflictDetector checks for split-brain ownership every CONFLICT_CHECK_INTERVAL.
+func runConflictDetector() {
+	interval := 30 * time.Second
+	if v := os.Getenv("CONFLICT_CHECK_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return
+		}
+		interval = d
+	}
+	window := 5 * time.Minute
+	if d, err := time.ParseDuration(os.Getenv("CONFLICT_WINDOW")); err == nil && d > 0 {
+		window = d
+	}
+	metrics.counter("routing_split_brain_detected_total", "Client IDs newly found with sessions on more than one replica.")
+	metrics.gaugeFunc("routing_split_brain_conflicts", "Client IDs currently held by more than one replica.", func() float64 {
+		conflicts.mu.Lock()
+		defer conflicts.mu.Unlock()
+		return float64(len(conflicts.conflicts))
+	})
+	for range time.Tick(interval) {
+		conflicts.check(window)
+	}
+}
+
+func handleConflicts(w http.ResponseWriter, r *http.Request) {
+	conflicts.mu.Lock()
+	list := make([]ownershipConflict, 0, len(conflicts.conflicts))
+	for _, c := range conflicts.conflicts {
+		list = append(list, *c)
+	}
+	checkedAt, unreachable := conflicts.checkedAt, conflicts.unreachable
+	conflicts.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].ClientID < list[j].ClientID })
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"checked_at":  checkedAt,
+		"conflicts":   list,
+		"unreachable": unreachable,
+	})
+}
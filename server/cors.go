@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS for browser-based tools calling the public API directly. CORS_ALLOWED_ORIGINS is "*" or a
+// comma-separated list of origins (empty disables CORS); CORS_ALLOWED_HEADERS overrides the request
+// headers allowed on preflight (default Content-Type, If-None-Match, Authorization, Idempotency-Key,
+// and X-Grpc-Web, X-User-Agent, Grpc-Timeout for gRPC-Web clients), CORS_ALLOWED_METHODS the methods
+// (default GET, POST, OPTIONS) and CORS_MAX_AGE how long browsers may cache a preflight answer
+// (default 10m).
+
+func withCORS(next http.Handler) http.Handler {
+	var origins []string
+	for _, o := range strings.Split(os.Getenv("CORS_ALLOWED_ORIGINS"), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	if len(origins) == 0 {
+		return next
+	}
+	allowHeaders := os.Getenv("CORS_ALLOWED_HEADERS")
+	if allowHeaders == "" {
+		allowHeaders = "Content-Type, If-None-Match, Authorization, Idempotency-Key, X-Grpc-Web, X-User-Agent, Grpc-Timeout"
+	}
+	allowMethods := os.Getenv("CORS_ALLOWED_METHODS")
+	if allowMethods == "" {
+		allowMethods = "GET, POST, OPTIONS"
+	}
+	maxAge := "600"
+	if d, err := time.ParseDuration(os.Getenv("CORS_MAX_AGE")); err == nil && d >= 0 {
+		maxAge = strconv.Itoa(int(d.Seconds()))
+	}
+	allowAll := slices.Contains(origins, "*")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || (!allowAll && !slices.Contains(origins, origin)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Expose-Headers", "ETag, Location, Grpc-Status, Grpc-Message")
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", allowMethods)
+			h.Set("Access-Control-Allow-Headers", allowHeaders)
+			h.Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Demo stateful workload: with DEMO_WORKLOAD=counter, GET /counter?client_id= increments a
+// per-client counter held only in this replica's memory (DELETE resets it). Routed through Envoy
+// like /join, a count that keeps growing shows stickiness preserves state; a reset to 1 shows the
+// client landed on a replica that never saw it.
+
+type counterStore struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+var counters = &counterStore{counts: make(map[string]int)}
+
+func demoWorkloadEnabled() bool {
+	return os.Getenv("DEMO_WORKLOAD") == "counter"
+}
+
+func handleCounter(w http.ResponseWriter, r *http.Request) {
+	if !demoWorkloadEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+	clientID := r.URL.Query().Get("client_id")
+	if clientID == "" {
+		http.Error(w, "missing client_id", http.StatusBadRequest)
+		return
+	}
+	counters.mu.Lock()
+	switch r.Method {
+	case http.MethodDelete:
+		delete(counters.counts, clientID)
+	default:
+		counters.counts[clientID]++
+	}
+	count := counters.counts[clientID]
+	counters.mu.Unlock()
+
+	resp := map[string]any{
+		"client_id": clientID,
+		"count":     count,
+		"served_by": getSelf(),
+	}
+	// Flag requests that reached a replica other than the one routing currently picks.
+	if owner, err := resolveOwner(r.Context(), clientID); err == nil {
+		parity.check(r, clientID, owner)
+		if !isSelfTarget(owner) {
+			resp["owner"] = owner
+			resp["misrouted"] = true
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Per-call deadlines. A caller can send its remaining budget as X-Deadline-Ms (milliseconds) or
+// as gRPC's grpc-timeout (e.g. 250m, 2S). The request context then carries that deadline, so an
+// owner wait or a proxied call gives up when it passes, and the request fails with
+// 504 {"code":"DEADLINE_EXCEEDED"}. A request that arrives with no budget left is refused before
+// any work. Hops forwarded to an owner (gateway proxy, WebSocket pass-through) carry the budget
+// that is left as X-Deadline-Ms, so each hop sees a smaller one. WebSocket upgrades are not cut
+// off by the deadline once connected.
+
+const deadlineHeader = "X-Deadline-Ms"
+
+func init() {
+	metrics.counter("routing_deadline_exceeded_total", "Requests that ran out of their caller's deadline budget, by path.")
+}
+
+// requestBudget returns the budget the caller sent, if any.
+func requestBudget(h http.Header) (time.Duration, bool) {
+	if v := strings.TrimSpace(h.Get(deadlineHeader)); v != "" {
+		if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Duration(ms) * time.Millisecond, true
+		}
+	}
+	return parseGRPCTimeout(h.Get("grpc-timeout"))
+}
+
+// parseGRPCTimeout parses a grpc-timeout value: up to 8 digits and a unit (H, M, S, m, u, n).
+func parseGRPCTimeout(v string) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if len(v) < 2 || len(v) > 9 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	units := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second, 'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond}
+	unit, ok := units[v[len(v)-1]]
+	if !ok {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// withDeadline applies the caller's budget to the request context.
+func withDeadline(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		budget, ok := requestBudget(r.Header)
+		if !ok || isWebSocketUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if budget <= 0 {
+			writeDeadlineExceeded(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), budget)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// propagateDeadline sets X-Deadline-Ms on an outgoing hop's headers h to the budget left: the
+// deadline of ctx, or else the budget in the incoming headers in (upgrades keep no ctx deadline).
+func propagateDeadline(ctx context.Context, h, in http.Header) {
+	var left time.Duration
+	if d, ok := ctx.Deadline(); ok {
+		left = time.Until(d)
+	} else if budget, ok := requestBudget(in); ok {
+		left = budget
+	} else {
+		return
+	}
+	h.Del("grpc-timeout")
+	h.Set(deadlineHeader, strconv.FormatInt(max(left.Milliseconds(), 0), 10))
+}
+
+// deadlineExpired reports whether err comes from the caller's budget running out.
+func deadlineExpired(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// budgetExhausted answers 504 DEADLINE_EXCEEDED and returns true when r's deadline has passed,
+// so handlers can stop before doing work the caller will not wait for.
+func budgetExhausted(w http.ResponseWriter, r *http.Request) bool {
+	if !deadlineExpired(r.Context().Err()) {
+		return false
+	}
+	writeDeadlineExceeded(w, r)
+	return true
+}
+
+func writeDeadlineExceeded(w http.ResponseWriter, r *http.Request) {
+	metrics.inc("routing_deadline_exceeded_total", "path", r.URL.Path)
+	msg := "deadline budget exhausted"
+	if budget, ok := requestBudget(r.Header); ok {
+		msg = fmt.Sprintf("deadline budget of %s exhausted", budget)
+	}
+	writeError(w, http.StatusGatewayTimeout, "DEADLINE_EXCEEDED", msg)
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Debug tracing for selected clients. POST /admin/debug with {"client_id": "c-42"} or
+// {"pattern": "c-4*"} (a path.Match glob) and an optional "duration" (default 5m, at most
+// DEBUG_TRACE_MAX, default 1h) turns on verbose logging for just the matching clients on every
+// replica (via /internal/debug): each resolution logs every /explain step as it happened, and each
+// routing decision (/where, /join, gateway forwards) logs its full record, all prefixed with
+// "debug client_id=". Rules expire on their own; GET /admin/debug lists the active ones and
+// DELETE /admin/debug?id= ends one early. At most 32 rules are active at once. The check on the
+// request path reads an immutable rule list, so it costs nothing while no rule is active.
+
+const debugRulesMax = 32
+
+type debugRule struct {
+	ID      string    `json:"id"`
+	Pattern string    `json:"pattern"` // a client_id or a path.Match glob
+	Until   time.Time `json:"until"`   // a rule already expired removes the rule with its ID
+}
+
+type debugRules struct {
+	max   time.Duration
+	mu    sync.Mutex // serialises writers
+	rules atomic.Pointer[[]debugRule]
+}
+
+var debugTraces = newDebugRulesFromEnv()
+
+var debugClient = newInternalClient(2 * time.Second)
+
+func newDebugRulesFromEnv() *debugRules {
+	d := &debugRules{max: time.Hour}
+	if v, err := time.ParseDuration(os.Getenv("DEBUG_TRACE_MAX")); err == nil && v > 0 {
+		d.max = v
+	}
+	d.rules.Store(&[]debugRule{})
+	return d
+}
+
+// active returns the unexpired rules.
+func (d *debugRules) active() []debugRule {
+	now := clock.Now()
+	return slices.DeleteFunc(slices.Clone(*d.rules.Load()), func(r debugRule) bool { return !now.Before(r.Until) })
+}
+
+// matches reports whether clientID is being traced.
+func (d *debugRules) matches(clientID string) bool {
+	rules := *d.rules.Load()
+	if len(rules) == 0 {
+		return false
+	}
+	now := clock.Now()
+	for _, r := range rules {
+		if now.Before(r.Until) && (r.Pattern == clientID || matchGlob(r.Pattern, clientID)) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchGlob(pattern, clientID string) bool {
+	ok, err := path.Match(pattern, clientID)
+	return err == nil && ok
+}
+
+// apply adds or replaces rule, or removes it once expired, dropping other expired rules too.
+func (d *debugRules) apply(rule debugRule) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	next := slices.DeleteFunc(d.active(), func(r debugRule) bool { return r.ID == rule.ID })
+	if clock.Now().Before(rule.Until) {
+		if len(next) >= debugRulesMax {
+			return fmt.Errorf("at most %d debug rules can be active", debugRulesMax)
+		}
+		next = append(next, rule)
+		log.Printf("debug tracing on for %q until %s", rule.Pattern, rule.Until.Format(time.RFC3339))
+	} else {
+		log.Printf("debug tracing off for rule %s", rule.ID)
+	}
+	d.rules.Store(&next)
+	return nil
+}
+
+// resolveDebug resolves clientID with an explanation attached and logs its steps.
+func resolveDebug(ctx context.Context, clientID string) (string, uint64, error) {
+	e := &explanation{}
+	owner, version, err := resolveOwnerAt(context.WithValue(ctx, explainKey{}, e), clientID)
+	e.mu.Lock()
+	for _, s := range e.steps {
+		log.Printf("debug client_id=%s step=%s target=%s %s", clientID, s.Step, s.Target, s.Detail)
+	}
+	e.mu.Unlock()
+	if err != nil {
+		log.Printf("debug client_id=%s resolved at v%d: %v", clientID, version, err)
+	} else {
+		log.Printf("debug client_id=%s resolved at v%d to %s", clientID, version, owner)
+	}
+	return owner, version, err
+}
+
+// logDecision logs d in full when its client is traced.
+func logDecision(d decision) {
+	if !debugTraces.matches(d.ClientID) {
+		return
+	}
+	log.Printf("debug client_id=%s decision endpoint=%s replica=%s served_by=%s status=%s latency_ms=%.3f version=%d",
+		d.ClientID, d.Endpoint, d.Replica, d.ServedBy, d.Status, d.LatencyMs, d.Version)
+}
+
+func postDebugRule(target string, body []byte) error {
+	req, err := newInternalRequest(http.MethodPost, target, "/internal/debug", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := debugClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// broadcastDebugRule applies rule here and on every replica, returning the ones not reached.
+func broadcastDebugRule(rule debugRule) ([]string, error) {
+	if err := debugTraces.apply(rule); err != nil {
+		return nil, err
+	}
+	body, _ := json.Marshal(rule)
+	var failed []string
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, t := range allTargets() {
+		if isSelfTarget(t) {
+			continue
+		}
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			if err := postDebugRule(target, body); err != nil {
+				log.Printf("debug rule %s on %s failed: %v", rule.ID, target, err)
+				mu.Lock()
+				failed = append(failed, target)
+				mu.Unlock()
+			}
+		}(t)
+	}
+	wg.Wait()
+	return failed, nil
+}
+
+// handleDebug turns tracing on (POST) or off (DELETE) for matching clients, or lists the rules (GET).
+func handleDebug(w http.ResponseWriter, r *http.Request) {
+	var rule debugRule
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"rules": debugTraces.active(), "max_duration": debugTraces.max.String()})
+		return
+	case http.MethodPost:
+		var req struct {
+			ClientID string `json:"client_id"`
+			Pattern  string `json:"pattern"`
+			Duration string `json:"duration"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid debug request", http.StatusBadRequest)
+			return
+		}
+		rule.Pattern = req.ClientID
+		if req.Pattern != "" {
+			if _, err := path.Match(req.Pattern, ""); err != nil || req.ClientID != "" {
+				writeError(w, http.StatusBadRequest, "INVALID_DEBUG_RULE", "give either client_id or a valid pattern")
+				return
+			}
+			rule.Pattern = req.Pattern
+		}
+		if rule.Pattern == "" {
+			writeError(w, http.StatusBadRequest, "INVALID_DEBUG_RULE", "missing client_id or pattern")
+			return
+		}
+		d := 5 * time.Minute
+		if req.Duration != "" {
+			var err error
+			if d, err = time.ParseDuration(req.Duration); err != nil || d <= 0 || d > debugTraces.max {
+				writeError(w, http.StatusBadRequest, "INVALID_DEBUG_RULE", "duration must be between 0 and "+debugTraces.max.String())
+				return
+			}
+		}
+		rule.ID, rule.Until = newHandoffID()[:12], clock.Now().Add(d)
+	case http.MethodDelete:
+		rule.ID = r.URL.Query().Get("id")
+		if rule.ID == "" {
+			http.Error(w, "missing id", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	unreached, err := broadcastDebugRule(rule)
+	if err != nil {
+		writeError(w, http.StatusConflict, "TOO_MANY_DEBUG_RULES", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"rule": rule, "unreachable": unreached})
+}
+
+// handleInternalDebug receives a debug rule broadcast by /admin/debug (internal API).
+func handleInternalDebug(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var rule debugRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil || rule.ID == "" {
+		http.Error(w, "invalid debug rule", http.StatusBadRequest)
+		return
+	}
+	if err := debugTraces.apply(rule); err != nil {
+		writeError(w, http.StatusConflict, "TOO_MANY_DEBUG_RULES", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
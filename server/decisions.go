@@ -0,0 +1,228 @@
+package server
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Decision log: every /where and /join outcome appended to a local CSV for offline analysis.
+// DECISIONS_FILE enables it (e.g. /data/decisions.csv). The file is rotated to <file>.1, <file>.2, ...
+// once it exceeds DECISIONS_MAX_BYTES (default 64MiB), keeping DECISIONS_KEEP rotated files (default 5).
+// Rows are written by a background worker and dropped when its queue is full.
+
+var decisionHeader = []string{"ts", "endpoint", "client_id", "replica", "served_by", "status", "latency_ms", "table_version"}
+
+type decision struct {
+	Time      time.Time
+	Endpoint  string
+	ClientID  string
+	Replica   string
+	ServedBy  string
+	Status    string
+	LatencyMs float64
+	Version   uint64 // routing table version the decision was made on
+}
+
+func (d decision) row() []string {
+	return []string{
+		d.Time.UTC().Format(time.RFC3339Nano),
+		d.Endpoint,
+		d.ClientID,
+		d.Replica,
+		d.ServedBy,
+		d.Status,
+		strconv.FormatFloat(d.LatencyMs, 'f', 3, 64),
+		strconv.FormatUint(d.Version, 10),
+	}
+}
+
+type decisionLog struct {
+	path     string
+	maxBytes int64
+	keep     int
+	queue    chan decision
+	dropped  atomic.Int64
+
+	mu   sync.Mutex // guards the file and rotation
+	f    *os.File
+	size int64
+}
+
+var decisions = newDecisionLogFromEnv()
+
+func newDecisionLogFromEnv() *decisionLog {
+	path := strings.TrimSpace(os.Getenv("DECISIONS_FILE"))
+	if path == "" {
+		return nil
+	}
+	l := &decisionLog{path: path, maxBytes: 64 << 20, keep: 5, queue: make(chan decision, 4096)}
+	if n, err := strconv.ParseInt(os.Getenv("DECISIONS_MAX_BYTES"), 10, 64); err == nil && n > 0 {
+		l.maxBytes = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("DECISIONS_KEEP")); err == nil && n >= 0 {
+		l.keep = n
+	}
+	if err := l.open(); err != nil {
+		log.Printf("decision log disabled: %v", err)
+		return nil
+	}
+	go l.run()
+	log.Printf("recording routing decisions to %s", path)
+	return l
+}
+
+// open opens (or creates) the current file, writing the header when it is new.
+func (l *decisionLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, st.Size()
+	if l.size == 0 {
+		return l.write(decisionHeader)
+	}
+	return nil
+}
+
+func (l *decisionLog) write(row []string) error {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	_ = w.Write(row)
+	w.Flush()
+	n, err := l.f.WriteString(b.String())
+	l.size += int64(n)
+	return err
+}
+
+// rotate shifts <file>.N up by one, moves the current file to <file>.1 and starts a new one.
+func (l *decisionLog) rotate() error {
+	l.f.Close()
+	if l.keep == 0 {
+		_ = os.Remove(l.path)
+	} else {
+		_ = os.Remove(fmt.Sprintf("%s.%d", l.path, l.keep))
+		for i := l.keep - 1; i >= 1; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+		}
+		_ = os.Rename(l.path, l.path+".1")
+	}
+	return l.open()
+}
+
+// record enqueues d without blocking, first logging it when its client is debug-traced. Safe to
+// call on a nil log.
+func (l *decisionLog) record(d decision) {
+	logDecision(d)
+	if l == nil {
+		return
+	}
+	select {
+	case l.queue <- d:
+	default:
+		if n := l.dropped.Add(1); n%1000 == 1 {
+			log.Printf("decision queue full, dropped=%d", n)
+		}
+	}
+}
+
+func (l *decisionLog) run() {
+	for d := range l.queue {
+		l.mu.Lock()
+		if err := l.write(d.row()); err != nil {
+			log.Printf("decision log write failed: %v", err)
+		}
+		if l.size >= l.maxBytes {
+			if err := l.rotate(); err != nil {
+				log.Printf("decision log rotate failed: %v", err)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// files returns the rotated files oldest first, followed by the current file.
+func (l *decisionLog) files() []string {
+	var out []string
+	for i := l.keep; i >= 1; i-- {
+		p := fmt.Sprintf("%s.%d", l.path, i)
+		if _, err := os.Stat(p); err == nil {
+			out = append(out, p)
+		}
+	}
+	return append(out, l.path)
+}
+
+// export writes every recorded row at or after since as one CSV, including rotated files.
+func (l *decisionLog) export(w *csv.Writer, since time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := w.Write(decisionHeader); err != nil {
+		return err
+	}
+	for _, p := range l.files() {
+		f, err := os.Open(p)
+		if err != nil {
+			continue
+		}
+		r := csv.NewReader(bufio.NewReader(f))
+		r.FieldsPerRecord = -1
+		for {
+			row, err := r.Read()
+			if err != nil {
+				break
+			}
+			if len(row) == 0 || row[0] == decisionHeader[0] {
+				continue
+			}
+			if ts, err := time.Parse(time.RFC3339Nano, row[0]); err != nil || ts.Before(since) {
+				continue
+			}
+			if err := w.Write(row); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		f.Close()
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// handleExportDecisions streams this replica's decision log as CSV.
+// since is an RFC 3339 timestamp or a duration back from now (e.g. 1h); empty exports everything.
+func handleExportDecisions(w http.ResponseWriter, r *http.Request) {
+	if decisions == nil {
+		writeError(w, http.StatusNotFound, "DECISIONS_DISABLED", "decision log is not enabled (set DECISIONS_FILE)")
+		return
+	}
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			since = time.Now().Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, v); err == nil {
+			since = t
+		} else {
+			http.Error(w, "invalid since", http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "decisions-"+getSelf()+".csv"))
+	if err := decisions.export(csv.NewWriter(w), since); err != nil {
+		log.Printf("export decisions failed: %v", err)
+	}
+}
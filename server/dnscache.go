@@ -0,0 +1,260 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNS cache for proxied connections. Targets built from SERVICE_PREFIX/SERVICE_SUFFIX (e.g.
+// server-3.server-headless.default.svc.cluster.local) are names, and without a cache every new
+// proxy connection (gateway, /ws, TCP proxy) looks one up, which adds resolver latency to the
+// request and, when a replica fails and every client reconnects at once, floods the resolver.
+// Lookups are therefore cached:
+//   - names are served from the cache and re-resolved in the background every DNS_CACHE_REFRESH
+//     (default 10s); a failed refresh keeps the last good addresses
+//   - every routing table target is resolved ahead of its first connection and kept warm
+//   - a name that doesn't resolve is not retried for 1s, doubling per failure up to
+//     DNS_CACHE_NEGATIVE_MAX (default 30s); dials fail fast with the cached error meanwhile
+//   - when every cached address refuses the dial, the name is re-resolved once (a restarted pod
+//     behind a headless service comes back with a new IP) and the dial retried on a change
+//   - names no connection used for 5m, and not in the ring, are dropped
+//
+// Concurrent lookups of one name share a single query. IP literals bypass the cache, and
+// DNS_CACHE=off dials through the system resolver as before.
+
+type dnsEntry struct {
+	addrs      []string
+	err        error
+	resolvedAt time.Time
+	lastUsed   time.Time
+	backoff    time.Duration
+	retryAt    time.Time     // negative entries: no query before this
+	ready      chan struct{} // closed once the first lookup finished
+}
+
+type dnsCacheState struct {
+	enabled    bool
+	refresh    time.Duration
+	negMax     time.Duration
+	idle       time.Duration
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+}
+
+var dnsCache = newDNSCacheFromEnv()
+
+func newDNSCacheFromEnv() *dnsCacheState {
+	c := &dnsCacheState{
+		enabled: !strings.EqualFold(strings.TrimSpace(os.Getenv("DNS_CACHE")), "off"),
+		refresh: 10 * time.Second,
+		negMax:  30 * time.Second,
+		idle:    5 * time.Minute,
+		entries: make(map[string]*dnsEntry),
+	}
+	c.lookupHost = net.DefaultResolver.LookupHost
+	if d, err := time.ParseDuration(os.Getenv("DNS_CACHE_REFRESH")); err == nil && d > 0 {
+		c.refresh = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("DNS_CACHE_NEGATIVE_MAX")); err == nil && d > 0 {
+		c.negMax = d
+	}
+	metrics.counter("routing_dns_lookups_total", "DNS queries made for proxy targets, by result.")
+	metrics.counter("routing_dns_cache_requests_total", "Proxy dials served by the DNS cache, by result (hit, miss, negative).")
+	metrics.gaugeFunc("routing_dns_cache_entries", "Names held in the proxy DNS cache.", func() float64 {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return float64(len(c.entries))
+	})
+	return c
+}
+
+// lookup returns the cached addresses of host, resolving it on first use.
+func (c *dnsCacheState) lookup(ctx context.Context, host string) ([]string, error) {
+	now := clock.Now()
+	c.mu.Lock()
+	e, ok := c.entries[host]
+	if !ok {
+		e = &dnsEntry{ready: make(chan struct{})}
+		c.entries[host] = e
+		c.mu.Unlock()
+		metrics.inc("routing_dns_cache_requests_total", "result", "miss")
+		c.resolve(ctx, host, e)
+		close(e.ready)
+		c.mu.Lock()
+	} else {
+		c.mu.Unlock()
+		select {
+		case <-e.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		c.mu.Lock()
+		switch {
+		case len(e.addrs) > 0:
+			metrics.inc("routing_dns_cache_requests_total", "result", "hit")
+		case now.Before(e.retryAt):
+			metrics.inc("routing_dns_cache_requests_total", "result", "negative")
+		default:
+			// Backoff over: this caller queries again, the rest keep failing fast meanwhile.
+			e.retryAt = now.Add(e.backoff)
+			c.mu.Unlock()
+			metrics.inc("routing_dns_cache_requests_total", "result", "miss")
+			c.resolve(ctx, host, e)
+			c.mu.Lock()
+		}
+	}
+	defer c.mu.Unlock()
+	e.lastUsed = now
+	if len(e.addrs) > 0 {
+		return e.addrs, nil
+	}
+	return nil, e.err
+}
+
+// resolve queries host and stores the result in e. A failure keeps addresses resolved before.
+func (c *dnsCacheState) resolve(ctx context.Context, host string, e *dnsEntry) bool {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	addrs, err := c.lookupHost(ctx, host)
+	now := clock.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		metrics.inc("routing_dns_lookups_total", "result", "error")
+		e.backoff = min(max(2*e.backoff, time.Second), c.negMax)
+		e.retryAt = now.Add(e.backoff)
+		if len(e.addrs) == 0 {
+			e.err = err
+		} else if e.err == nil {
+			log.Printf("dns cache: refreshing %s failed, keeping %v: %v", host, e.addrs, err)
+			e.err = err
+		}
+		return false
+	}
+	metrics.inc("routing_dns_lookups_total", "result", "ok")
+	changed := !slices.Equal(addrs, e.addrs)
+	e.addrs, e.err, e.backoff, e.retryAt, e.resolvedAt = addrs, nil, 0, time.Time{}, now
+	return changed
+}
+
+// dial connects to addr through d, resolving its host through the cache.
+func (c *dnsCacheState) dial(ctx context.Context, d *net.Dialer, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if !c.enabled || err != nil || net.ParseIP(host) != nil {
+		return d.DialContext(ctx, network, addr)
+	}
+	addrs, err := c.lookup(ctx, host)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	conn, err := dialFirst(ctx, d, network, addrs, port)
+	if err == nil || ctx.Err() != nil {
+		return conn, err
+	}
+	c.mu.Lock()
+	e := c.entries[host]
+	recent := e == nil || clock.Now().Sub(e.resolvedAt) < time.Second
+	c.mu.Unlock()
+	if recent || !c.resolve(ctx, host, e) {
+		return nil, err
+	}
+	c.mu.Lock()
+	addrs = e.addrs
+	c.mu.Unlock()
+	return dialFirst(ctx, d, network, addrs, port)
+}
+
+// dialFirst tries addrs in order and returns the first connection.
+func dialFirst(ctx context.Context, d *net.Dialer, network string, addrs []string, port string) (net.Conn, error) {
+	var errs []error
+	for _, a := range addrs {
+		conn, err := d.DialContext(ctx, network, net.JoinHostPort(a, port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// dialTimeout is net.DialTimeout through the DNS cache.
+func dialTimeout(network, addr string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return dnsCache.dial(ctx, &net.Dialer{}, network, addr)
+}
+
+// runDNSCache refreshes cached names in the background and warms the ring's targets.
+func runDNSCache() {
+	c := dnsCache
+	if !c.enabled {
+		return
+	}
+	c.warm()
+	for range clock.Tick(c.refresh) {
+		c.warm()
+		c.refreshAll()
+	}
+}
+
+// warm adds an entry for every routing table target not cached yet and marks them in use.
+func (c *dnsCacheState) warm() {
+	now := clock.Now()
+	fresh := make(map[string]*dnsEntry)
+	c.mu.Lock()
+	for _, target := range currentTable().Targets {
+		host, _, err := net.SplitHostPort(target)
+		if err != nil || net.ParseIP(host) != nil {
+			continue
+		}
+		if e, ok := c.entries[host]; ok {
+			e.lastUsed = now
+			continue
+		}
+		e := &dnsEntry{ready: make(chan struct{}), lastUsed: now}
+		c.entries[host] = e
+		fresh[host] = e
+	}
+	c.mu.Unlock()
+	for host, e := range fresh {
+		c.resolve(context.Background(), host, e)
+		close(e.ready)
+	}
+}
+
+// refreshAll re-resolves every name in use whose backoff allows it, and drops idle names.
+func (c *dnsCacheState) refreshAll() {
+	now := clock.Now()
+	due := make(map[string]*dnsEntry)
+	c.mu.Lock()
+	for host, e := range c.entries {
+		select {
+		case <-e.ready:
+		default:
+			continue // first lookup still running
+		}
+		switch {
+		case now.Sub(e.lastUsed) > c.idle:
+			delete(c.entries, host)
+		case now.Before(e.retryAt):
+		default:
+			due[host] = e
+		}
+	}
+	c.mu.Unlock()
+	for host, e := range due {
+		c.resolve(context.Background(), host, e)
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+)
+
+// Draining WebSocket clients on shutdown. On SIGTERM, before the listener closes, every WebSocket
+// served here is sent {"type":"drain","next_owner":...} and closed with 1012 (service restart) and
+// the next owner as the close reason, so the client reconnects there without asking /where first.
+// The next owner is the client's hash target on the ring without this replica when membership is
+// dynamic (MEMBERSHIP=registry), since deregistering shrinks the ring; with a fixed target list it
+// is the failover pick for this replica, or none when FAILOVER_POLICY=none, in which case the
+// client should wait for the replica to come back or re-resolve. Connections piped through to
+// another owner just end.
+
+func init() {
+	metrics.counter("routing_drain_notified_total", "WebSocket clients told their next owner on shutdown, by whether one was known.")
+}
+
+// nextOwnerAfterDrain is where clientID is routed once this replica is gone; "" when unknown.
+func nextOwnerAfterDrain(clientID string) string {
+	self := selfTarget()
+	if len(members.targets()) > 0 {
+		remaining := slices.DeleteFunc(slices.Clone(allTargets()), func(t string) bool {
+			return t == self || isSelfTarget(t)
+		})
+		if len(remaining) == 0 {
+			return ""
+		}
+		return remaining[computeIndex(clientID, len(remaining))-indexBase()]
+	}
+	if to, ok := standbyFor(self); ok && ownerHealthy(to) {
+		return to
+	}
+	if alt, ok := failover.pick(clientID, self); ok {
+		return alt
+	}
+	return ""
+}
+
+// drainWebSockets tells every local WebSocket client its next owner and closes the connection.
+func drainWebSockets() {
+	conns := wsClients.takeAll()
+	if len(conns) == 0 {
+		return
+	}
+	version := currentTable().Version
+	notified, unknown := 0, 0
+	for clientID, cs := range conns {
+		next := nextOwnerAfterDrain(clientID)
+		msg, _ := json.Marshal(map[string]any{"type": "drain", "client_id": clientID, "next_owner": next, "table_version": version})
+		reason := next
+		if next == "" || len(reason) > 123 {
+			reason = "draining"
+		}
+		for c := range cs {
+			_ = c.writeFrame(wsText, msg)
+			c.closeWith(1012, reason)
+		}
+		if next == "" {
+			unknown += len(cs)
+			metrics.add("routing_drain_notified_total", float64(len(cs)), "next", "unknown")
+			continue
+		}
+		notified += len(cs)
+		metrics.add("routing_drain_notified_total", float64(len(cs)), "next", "known")
+		events.emit(eventMoved, clientID, next, selfTarget(), next)
+	}
+	log.Printf("drain: closed %d WebSocket connections (%d with a next owner, %d without)", notified+unknown, notified, unknown)
+}
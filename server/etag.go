@@ -0,0 +1,41 @@
+package server
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// whereETag identifies a /where answer: the assignment plus the ring version it was computed on.
+func whereETag(clientID, hostPort string) string {
+	// fnv64a of "client|hostport|ring", hashed in place: /where computes one per request.
+	sum := uint64(14695981039346656037)
+	for _, part := range [...]string{clientID, "|", hostPort, "|", ringVersion()} {
+		for i := 0; i < len(part); i++ {
+			sum ^= uint64(part[i])
+			sum *= 1099511628211
+		}
+	}
+	var raw [8]byte
+	binary.BigEndian.PutUint64(raw[:], sum)
+	var b [18]byte
+	b[0], b[17] = '"', '"'
+	hex.Encode(b[1:17], raw[:])
+	return string(b[:])
+}
+
+// etagMatches reports whether the request's If-None-Match contains etag (weak comparison).
+func etagMatches(r *http.Request, etag string) bool {
+	inm := r.Header.Get("If-None-Match")
+	if inm == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(inm, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,342 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Assignment lifecycle events published to a message bus.
+// EVENTS_BACKEND selects the publisher: "log", "http", "kafka-rest" or "nats" (empty disables).
+// EVENTS_URL is the HTTP/Kafka REST topic URL or nats://host:4222; EVENTS_SUBJECT is the NATS
+// subject (default routing.assignments).
+
+const (
+	eventAssigned = "assigned"
+	eventMoved    = "moved"
+	eventExpired  = "expired"
+	eventShed     = "shed"
+)
+
+type assignmentEvent struct {
+	Type     string    `json:"type"`
+	ClientID string    `json:"client_id"`
+	Replica  string    `json:"replica"`
+	From     string    `json:"from,omitempty"`
+	To       string    `json:"to,omitempty"`
+	Pool     string    `json:"pool,omitempty"`   // DEPLOYMENT_POOL
+	Tenant   string    `json:"tenant,omitempty"` // DEPLOYMENT_TENANT
+	Time     time.Time `json:"ts"`
+}
+
+// eventPublisher delivers a single event to a backend.
+type eventPublisher interface {
+	Publish(ev assignmentEvent) error
+}
+
+// eventBus queues events and publishes them from a background goroutine.
+type eventBus struct {
+	pub   eventPublisher
+	queue chan assignmentEvent
+}
+
+var events = newEventBusFromEnv()
+
+func newEventBusFromEnv() *eventBus {
+	backend := strings.ToLower(strings.TrimSpace(os.Getenv("EVENTS_BACKEND")))
+	target := strings.TrimSpace(os.Getenv("EVENTS_URL"))
+	var pub eventPublisher
+	switch backend {
+	case "":
+		return nil
+	case "log":
+		pub = logPublisher{}
+	case "http":
+		pub = &httpPublisher{url: target, contentType: "application/json", client: &http.Client{Timeout: 2 * time.Second}}
+	case "kafka-rest":
+		pub = &httpPublisher{url: target, contentType: "application/vnd.kafka.json.v2+json", kafka: true, client: &http.Client{Timeout: 2 * time.Second}}
+	case "nats":
+		subject := os.Getenv("EVENTS_SUBJECT")
+		if subject == "" {
+			subject = "routing.assignments"
+		}
+		pub = &natsPublisher{addr: target, subject: subject}
+	default:
+		log.Printf("unknown EVENTS_BACKEND=%q, events disabled", backend)
+		return nil
+	}
+	b := &eventBus{pub: pub, queue: make(chan assignmentEvent, 1024)}
+	go b.run()
+	log.Printf("publishing assignment events via %s %s", backend, target)
+	return b
+}
+
+// emit records the event locally (recent events and the assignment WAL) and enqueues it for
+// publishing without blocking. Safe to call on a nil bus (recording only).
+func (b *eventBus) emit(typ, clientID, replica, from, to string) {
+	ev := assignmentEvent{Type: typ, ClientID: clientID, Replica: replica, From: from, To: to, Pool: deployment.pool, Tenant: deployment.tenant, Time: time.Now()}
+	recentEvents.add(ev)
+	wal.append(ev)
+	if b == nil {
+		return
+	}
+	select {
+	case b.queue <- ev:
+	default:
+		log.Printf("event queue full, dropped %s client_id=%s", typ, clientID)
+	}
+}
+
+func (b *eventBus) run() {
+	for ev := range b.queue {
+		if err := b.pub.Publish(ev); err != nil {
+			log.Printf("publish %s client_id=%s failed: %v", ev.Type, ev.ClientID, err)
+		}
+	}
+}
+
+type logPublisher struct{}
+
+func (logPublisher) Publish(ev assignmentEvent) error {
+	log.Printf("event type=%s client_id=%s replica=%s from=%s to=%s", ev.Type, ev.ClientID, ev.Replica, ev.From, ev.To)
+	return nil
+}
+
+// httpPublisher POSTs each event as JSON, optionally wrapped in the Kafka REST proxy v2 envelope.
+type httpPublisher struct {
+	url         string
+	contentType string
+	kafka       bool
+	client      *http.Client
+}
+
+func (p *httpPublisher) Publish(ev assignmentEvent) error {
+	var payload any = ev
+	if p.kafka {
+		payload = map[string]any{"records": []map[string]any{{"key": ev.ClientID, "value": ev}}}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Post(p.url, p.contentType, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// natsPublisher speaks the NATS text protocol (CONNECT/PUB, answering PING) over a single
+// connection, redialing after any write error.
+type natsPublisher struct {
+	addr    string
+	subject string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (p *natsPublisher) Publish(ev assignmentEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		if err := p.dial(); err != nil {
+			return err
+		}
+	}
+	_ = p.conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+	if _, err := fmt.Fprintf(p.conn, "PUB %s %d\r\n%s\r\n", p.subject, len(body), body); err != nil {
+		p.conn.Close()
+		p.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (p *natsPublisher) dial() error {
+	addr := p.addr
+	if u, err := url.Parse(addr); err == nil && u.Host != "" {
+		addr = u.Host
+	}
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write([]byte("CONNECT {\"verbose\":false,\"pedantic\":false,\"name\":\"poc-routing\"}\r\n")); err != nil {
+		conn.Close()
+		return err
+	}
+	p.conn = conn
+	go p.readLoop(conn)
+	return nil
+}
+
+// readLoop answers server PINGs so the connection is kept open.
+func (p *natsPublisher) readLoop(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch {
+		case strings.HasPrefix(line, "PING"):
+			p.mu.Lock()
+			_, _ = conn.Write([]byte("PONG\r\n"))
+			p.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			log.Printf("nats: %s", strings.TrimSpace(line))
+		}
+	}
+}
+
+// eventLog keeps the most recent events in memory for the dashboard and fans them out to
+// /events subscribers.
+type eventLog struct {
+	mu     sync.Mutex
+	events []assignmentEvent
+	max    int
+	subs   map[chan assignmentEvent]struct{}
+}
+
+var recentEvents = &eventLog{max: memoryLimitFromEnv("MAX_RECENT_EVENTS", 200), subs: make(map[chan assignmentEvent]struct{})}
+
+func (l *eventLog) add(ev assignmentEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, ev)
+	l.trimLocked()
+	for ch := range l.subs {
+		select {
+		case ch <- ev:
+		default: // slow subscriber; drop rather than block emitters
+		}
+	}
+}
+
+// trimLocked drops the oldest events beyond max; callers hold l.mu.
+func (l *eventLog) trimLocked() {
+	if n := len(l.events) - l.max; n > 0 {
+		l.events = l.events[n:]
+		noteEvictions("events", n)
+	}
+}
+
+func (l *eventLog) size() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.events)
+}
+
+func (l *eventLog) limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.max
+}
+
+// resize sets the number of events kept, dropping the oldest beyond it.
+func (l *eventLog) resize(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.max = n
+	l.trimLocked()
+	l.events = slices.Clone(l.events) // let go of a larger ring's backing array
+}
+
+// subscribe registers a channel receiving every event added from now on.
+func (l *eventLog) subscribe() chan assignmentEvent {
+	ch := make(chan assignmentEvent, 64)
+	l.mu.Lock()
+	l.subs[ch] = struct{}{}
+	l.mu.Unlock()
+	return ch
+}
+
+func (l *eventLog) unsubscribe(ch chan assignmentEvent) {
+	l.mu.Lock()
+	delete(l.subs, ch)
+	l.mu.Unlock()
+}
+
+// list returns recorded events, newest first.
+func (l *eventLog) list() []assignmentEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]assignmentEvent, len(l.events))
+	for i, ev := range l.events {
+		out[len(l.events)-1-i] = ev
+	}
+	return out
+}
+
+// handleRecentEvents returns the assignment events recorded by this replica, newest first.
+func handleRecentEvents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"replica": getSelf(),
+		"events":  recentEvents.list(),
+	})
+}
+
+// handleEventStream streams assignment events as Server-Sent Events, so browsers can follow them
+// with EventSource. ?replay=N first sends up to N recent events, oldest first.
+func handleEventStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	ch := recentEvents.subscribe()
+	defer recentEvents.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if n, err := strconv.Atoi(r.URL.Query().Get("replay")); err == nil && n > 0 {
+		recent := recentEvents.list()
+		for i := min(n, len(recent)) - 1; i >= 0; i-- {
+			writeSSE(w, recent[i])
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case ev := <-ch:
+			writeSSE(w, ev)
+			flusher.Flush()
+		case <-heartbeat.C:
+			_, _ = fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeSSE(w io.Writer, ev assignmentEvent) {
+	body, _ := json.Marshal(ev)
+	_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, body)
+}
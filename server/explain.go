@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Routing explanations. GET /explain?client_id= resolves the client the way /where does and
+// reports why it landed where it did: the inputs (index mode and base, targets, routing key,
+// policies in effect), the replicas excluded as unhealthy, and each step of the decision (pin,
+// sticky, affinity group, anti-affinity, ROUTING_EXPR or the hash arithmetic, version preference, maintenance, standby,
+// owner wait, failover) ending with the final target. Steps are recorded by resolveOwnerOnce itself, so the explanation
+// follows the code that answers /where. Nothing is written to the registry, quotas or the
+// decision log, but an unhealthy owner is waited for like any other request.
+
+type explainKey struct{}
+
+type explainStep struct {
+	Step   string `json:"step"`
+	Detail string `json:"detail"`
+	Target string `json:"target,omitempty"`
+}
+
+type explanation struct {
+	mu    sync.Mutex
+	steps []explainStep
+}
+
+func explaining(ctx context.Context) *explanation {
+	e, _ := ctx.Value(explainKey{}).(*explanation)
+	return e
+}
+
+// explainf records a decision step when ctx belongs to an /explain request, and notes it for
+// the trace headers when ctx is traced.
+func explainf(ctx context.Context, step, target, format string, args ...any) {
+	noteRouteStep(ctx, step, target)
+	e := explaining(ctx)
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.steps = append(e.steps, explainStep{Step: step, Detail: fmt.Sprintf(format, args...), Target: target})
+	e.mu.Unlock()
+}
+
+// explainPlacement records how placeRequest arrived at placement.
+func explainPlacement(ctx context.Context, clientID, placement string) {
+	if explaining(ctx) == nil {
+		// Traces keep only the deciding step, so skip formatting details nobody reads.
+		noteRouteStep(ctx, placementStep(ctx, clientID), placement)
+		return
+	}
+	if to, ok := pins.get(clientID); ok && to != "" {
+		if !slices.Contains(allTargets(), to) {
+			explainf(ctx, "pin", "", "pinned to %s, ignored since it is no longer in the ring", to)
+		} else {
+			explainf(ctx, "pin", "", "pinned to %s, ignored while it is unhealthy", to)
+		}
+	}
+	if g, ok := affinityFor(requestHeaders(ctx)); ok {
+		explainf(ctx, "affinity", placement, "%s=%s limits the client to %s; hashed over those, or their healthy members while the hash pick is down",
+			affinityHeader, g.value, strings.Join(g.targets(), ", "))
+		return
+	}
+	if rule, pos, binding, ok := ruleFor(clientID); ok {
+		placed := placeMembers(rule, binding, pos)
+		explainf(ctx, "anti_affinity", placement, "member %d of ANTI_AFFINITY rule %q (* = %q); earlier members are on %s, so the client takes the next free position from its hash target %s",
+			pos, rule.text, binding, strings.Join(placed[:pos], ", "), pickByHashScaled(clientID))
+		return
+	}
+	if routingPolicy != nil {
+		if _, ok := routingPolicy.pick(clientID, requestHeaders(ctx)); ok {
+			explainf(ctx, "routing_expr", placement, "ROUTING_EXPR %q picked position %d", os.Getenv("ROUTING_EXPR"), slices.Index(allTargets(), placement))
+			return
+		}
+		explainf(ctx, "routing_expr", "", "ROUTING_EXPR %q failed or was out of range, using the hash pick", os.Getenv("ROUTING_EXPR"))
+	}
+	key := routingKey(clientID)
+	sum := hashKey(key)
+	t := currentTable()
+	if t.legacy {
+		peers := legacyPeers()
+		if len(peers) == 0 {
+			explainf(ctx, "hash", placement, "no SERVER_PEERS, SERVICE_PREFIX or REPLICA_ADDRESSES: this replica answers for every client")
+			return
+		}
+		explainf(ctx, "hash", placement, "%s(%q) = %d; %d %% %d SERVER_PEERS = %d", keyHasher.Name(), hashInput(key), sum, sum, len(peers), bucket(sum, len(peers)))
+		return
+	}
+	n, base := len(t.Targets), indexBase()
+	idx := computeIndex(clientID, n)
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("INDEX_MODE")), "numeric") {
+		if v, err := strconv.ParseInt(key, 10, 64); err == nil {
+			explainf(ctx, "hash", placement, "INDEX_MODE=numeric: |%d| %% %d replicas = %d; + INDEX_BASE %d = index %d", v, n, idx-base, base, idx)
+			return
+		}
+		explainf(ctx, "hash", placement, "INDEX_MODE=numeric, but %q is not a number: falling back to the hash", key)
+	}
+	explainf(ctx, "hash", placement, "%s(%q) = %d; %d %% %d replicas = %d; + INDEX_BASE %d = index %d", keyHasher.Name(), hashInput(key), sum, sum, n, idx-base, base, idx)
+}
+
+// placementStep names the step explainPlacement would end on, for trace-only resolutions.
+func placementStep(ctx context.Context, clientID string) string {
+	if _, ok := affinityFor(requestHeaders(ctx)); ok {
+		return "affinity"
+	}
+	if _, _, _, ok := ruleFor(clientID); ok {
+		return "anti_affinity"
+	}
+	if routingPolicy != nil {
+		if _, ok := routingPolicy.pick(clientID, requestHeaders(ctx)); ok {
+			return "routing_expr"
+		}
+	}
+	return "hash"
+}
+
+// targetSource names where the routing table's targets come from.
+func targetSource(t *routingTable) string {
+	if len(members.targets()) > 0 {
+		return "membership"
+	}
+	switch targetResolverFromEnv().Mode() {
+	case "addresses":
+		return "REPLICA_ADDRESSES"
+	case "lookup":
+		return "TARGET_LOOKUP"
+	case "peers":
+		return "SERVER_PEERS"
+	default:
+		return "SERVICE_PREFIX"
+	}
+}
+
+func handleExplain(w http.ResponseWriter, r *http.Request) {
+	clientID := r.URL.Query().Get("client_id")
+	if clientID == "" {
+		http.Error(w, "missing client_id", http.StatusBadRequest)
+		return
+	}
+	if !checkAffinity(w, r) {
+		return
+	}
+	t := currentTable()
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv("INDEX_MODE")))
+	if mode == "" {
+		mode = "hash"
+	}
+	inputs := map[string]any{
+		"client_id":       clientID,
+		"routing_key":     routingKey(clientID),
+		"index_mode":      mode,
+		"index_base":      indexBase(),
+		"replicas":        len(t.Targets),
+		"targets":         t.Targets,
+		"target_source":   targetSource(t),
+		"failover_policy": failover.policy,
+		"stickiness":      sticky.mode(),
+		"anti_affinity":   len(antiAffinity) > 0,
+	}
+	if d := os.Getenv("GROUP_DELIMITER"); d != "" {
+		inputs["group_delimiter"] = d
+	}
+	if salt := os.Getenv("ROUTING_SALT"); salt != "" {
+		inputs["routing_salt"] = salt
+	}
+	if g, ok := affinityFor(r.Header); ok {
+		inputs["affinity_group"] = g.value
+	}
+	if v := os.Getenv("ROUTING_EXPR"); v != "" {
+		inputs["routing_expr"] = v
+	}
+	if v := os.Getenv("TARGET_VERSION"); v != "" {
+		inputs["target_version"] = v
+	}
+	type exclusion struct {
+		Target string `json:"target"`
+		Reason string `json:"reason"`
+	}
+	excluded := []exclusion{}
+	if t.polled {
+		for _, target := range t.Targets {
+			info, ok := t.info(target)
+			if !ok || info.Healthy {
+				continue
+			}
+			reason := info.Error
+			if reason == "" {
+				reason = "unhealthy"
+			}
+			excluded = append(excluded, exclusion{Target: target, Reason: reason})
+		}
+	}
+
+	e := &explanation{}
+	ctx := context.WithValue(r.Context(), explainKey{}, e)
+	owner, err := resolveOwnerOnce(ctx, clientID)
+	out := map[string]any{
+		"client_id":     clientID,
+		"inputs":        inputs,
+		"excluded":      excluded,
+		"table_version": t.Version,
+	}
+	if err != nil {
+		explainf(ctx, "result", "", "%v", err)
+		out["code"] = resolveErrorCode(err)
+	} else {
+		explainf(ctx, "result", owner, "routed to %s", owner)
+		out["target"] = owner
+	}
+	e.mu.Lock()
+	out["steps"] = e.steps
+	e.mu.Unlock()
+	if v := currentTable().Version; v != t.Version {
+		out["note"] = fmt.Sprintf("routing table changed from v%d to v%d during the explanation", t.Version, v)
+	}
+	setTableVersion(w, t.Version)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
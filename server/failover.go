@@ -0,0 +1,124 @@
+package server
+
+import (
+	"hash/fnv"
+	"log"
+	"math"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Rehashing away from an unhealthy owner. FAILOVER_POLICY selects the replacement among the next
+// FAILOVER_CANDIDATES (default 3) healthy replicas after the owner in ring order:
+//   - "none" (default): keep answering with the owner (subject to OWNER_WAIT_QUEUE)
+//   - "successor": the first healthy replica after the owner
+//   - "weighted": weighted rendezvous hash over the candidates, so a dead replica's clients spread
+//     across them by WEIGHT but each client still lands on the same one every time
+//   - "random": weighted-random pick per request (anti-herd jitter, no stickiness during failover)
+// With WEIGHT_TUNING=adaptive the weights are scaled by the tuned factors (see weighttune.go).
+// Failover applies after any owner wait has given up.
+
+type failoverConfig struct {
+	policy     string
+	candidates int
+}
+
+var failover = newFailoverConfigFromEnv()
+
+func newFailoverConfigFromEnv() failoverConfig {
+	c := failoverConfig{policy: "none", candidates: 3}
+	switch p := strings.ToLower(strings.TrimSpace(os.Getenv("FAILOVER_POLICY"))); p {
+	case "", "none":
+	case "successor", "weighted", "random":
+		c.policy = p
+	default:
+		log.Printf("unknown FAILOVER_POLICY=%q, failover disabled", p)
+	}
+	if n, err := strconv.Atoi(os.Getenv("FAILOVER_CANDIDATES")); err == nil && n > 0 {
+		c.candidates = n
+	}
+	metrics.counter("routing_failover_total", "Resolutions rehashed away from an unhealthy owner, by policy and target.")
+	return c
+}
+
+// candidatesAfter returns up to c.candidates healthy targets following owner in ring order.
+func (c failoverConfig) candidatesAfter(owner string) []string {
+	targets := allTargets()
+	start := -1
+	for i, t := range targets {
+		if t == owner {
+			start = i
+			break
+		}
+	}
+	out := make([]string, 0, c.candidates)
+	for i := 1; i < len(targets) && len(out) < c.candidates; i++ {
+		t := targets[(start+i+len(targets))%len(targets)]
+		if t != owner && ownerHealthy(t) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// pick returns the replacement for an unhealthy owner, or false when failover is disabled
+// or no candidate is healthy.
+func (c failoverConfig) pick(clientID, owner string) (string, bool) {
+	if c.policy == "none" {
+		return "", false
+	}
+	cands := c.candidatesAfter(owner)
+	if len(cands) == 0 {
+		return "", false
+	}
+	target := cands[0]
+	switch c.policy {
+	case "weighted":
+		best := math.Inf(-1)
+		for _, t := range cands {
+			if s := rendezvousScore(clientID, t, failoverWeight(t)); s > best {
+				best, target = s, t
+			}
+		}
+	case "random":
+		total := 0.0
+		for _, t := range cands {
+			total += failoverWeight(t)
+		}
+		n := rand.Float64() * total
+		for _, t := range cands {
+			if n -= failoverWeight(t); n < 0 {
+				target = t
+				break
+			}
+		}
+	}
+	metrics.inc("routing_failover_total", "policy", c.policy, "target", target)
+	log.Printf("client_id=%s owner %s unhealthy, failing over to %s (%s)", clientID, owner, target, c.policy)
+	return target, true
+}
+
+// failoverWeight is the advertised weight of target scaled by its tuned factor.
+func failoverWeight(target string) float64 {
+	return float64(advertisedWeight(target)) * weights.factor(target)
+}
+
+// advertisedWeight is the WEIGHT a replica advertises, defaulting to 1.
+func advertisedWeight(target string) int {
+	if info, ok := replicas.get(target); ok && info.Weight > 0 {
+		return info.Weight
+	}
+	return 1
+}
+
+// rendezvousScore is the weighted highest-random-weight score of target for clientID.
+func rendezvousScore(clientID, target string, weight float64) float64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(hashInput(routingKey(clientID))))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(target))
+	u := (float64(h.Sum64()>>11) + 0.5) / (1 << 53) // uniform in (0,1)
+	return -weight / math.Log(u)
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Behavior when discovery yields zero healthy replicas, selected by EMPTY_REPLICAS_POLICY:
+//   - "self" (default): answer with this replica, as before
+//   - "fail": respond 503 with code NO_HEALTHY_REPLICA
+//   - "wait": wait up to EMPTY_REPLICAS_WAIT (default 2s) for a replica to become healthy, then
+//     resolve the client as usual; fail if none does or the request is cancelled first
+
+var errNoReplicas = errors.New("no healthy replicas")
+
+func init() {
+	metrics.counter("routing_no_replicas_total", "Resolutions that found zero healthy replicas, by policy and outcome.")
+	metrics.gaugeFunc("routing_replicas_healthy", "Replicas currently considered healthy.", func() float64 {
+		return float64(len(healthyTargets()))
+	})
+}
+
+func emptyReplicasPolicy() string {
+	switch p := strings.ToLower(strings.TrimSpace(os.Getenv("EMPTY_REPLICAS_POLICY"))); p {
+	case "fail", "wait":
+		return p
+	default:
+		return "self"
+	}
+}
+
+// healthyTargets returns the configured targets the replica view considers healthy.
+// Until the first poll completes every configured target counts as healthy.
+func healthyTargets() []string {
+	t := currentTable()
+	if !t.polled {
+		return t.Targets
+	}
+	out := make([]string, 0, len(t.Targets))
+	for _, target := range t.Targets {
+		if info, ok := t.info(target); ok && info.Healthy {
+			out = append(out, target)
+		}
+	}
+	return out
+}
+
+// onNoReplicas applies EMPTY_REPLICAS_POLICY for clientID.
+func onNoReplicas(ctx context.Context, clientID string) (string, error) {
+	policy := emptyReplicasPolicy()
+	switch policy {
+	case "fail":
+		metrics.inc("routing_no_replicas_total", "policy", policy, "outcome", "failed")
+		log.Printf("no healthy replicas for client_id=%s, failing", clientID)
+		return "", errNoReplicas
+	case "wait":
+		wait := 2 * time.Second
+		if d, err := time.ParseDuration(os.Getenv("EMPTY_REPLICAS_WAIT")); err == nil && d >= 0 {
+			wait = d
+		}
+		deadline := clock.Now().Add(wait)
+		tick := clock.Tick(100 * time.Millisecond)
+	poll:
+		for clock.Now().Before(deadline) {
+			select {
+			case <-ctx.Done():
+				break poll
+			case <-tick:
+			}
+			if len(healthyTargets()) > 0 {
+				metrics.inc("routing_no_replicas_total", "policy", policy, "outcome", "recovered")
+				// Resolve from the top, so pins, sticky sessions, maintenance, standby and
+				// failover apply to the recovered replicas as they would have.
+				return resolveOwnerOnce(ctx, clientID)
+			}
+		}
+		metrics.inc("routing_no_replicas_total", "policy", policy, "outcome", "failed")
+		log.Printf("no healthy replicas for client_id=%s after %s, failing", clientID, wait)
+		return "", errNoReplicas
+	default:
+		metrics.inc("routing_no_replicas_total", "policy", policy, "outcome", "self")
+		log.Printf("no healthy replicas for client_id=%s, routing to self", clientID)
+		return getSelf(), nil
+	}
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Session expiry forecast, for timing scale-downs. GET /sessions/expiry-forecast?bucket=5m&horizon=
+// collects, from every replica, how many sessions expire in each upcoming bucket if they aren't
+// renewed (horizon defaults to SESSION_TTL, by when every idle session is gone). The per-replica rows
+// form a heatmap; "remaining" is how many sessions would still be live, and so reassigned, if the
+// replica were removed at the end of each bucket.
+
+type expiryForecast struct {
+	Replica string `json:"replica"`
+	Counts  []int  `json:"counts"`
+	Later   int    `json:"later"`
+}
+
+// forecastParams parses bucket and horizon, defaulting to 5m and SESSION_TTL, at most 288 buckets.
+func forecastParams(q url.Values, ttl time.Duration) (time.Duration, time.Duration, bool) {
+	step, horizon := 5*time.Minute, ttl
+	if v := q.Get("bucket"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < seenBucket {
+			return 0, 0, false
+		}
+		step = d
+	}
+	if v := q.Get("horizon"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return 0, 0, false
+		}
+		horizon = d
+	}
+	horizon = min(horizon, 288*step)
+	return step, horizon, true
+}
+
+// handleLocalExpiryForecast serves this replica's expiry counts on the internal API.
+func handleLocalExpiryForecast(w http.ResponseWriter, r *http.Request) {
+	ttl := sessionTTL()
+	step, horizon, ok := forecastParams(r.URL.Query(), ttl)
+	if ttl == 0 || !ok {
+		http.Error(w, "invalid forecast parameters", http.StatusBadRequest)
+		return
+	}
+	counts, later := sessions.expiryCounts(ttl, step, horizon, clock.Now())
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(expiryForecast{Replica: getSelf(), Counts: counts, Later: later})
+}
+
+func fetchExpiryForecast(target, query string) (expiryForecast, error) {
+	var f expiryForecast
+	req, err := newInternalRequest(http.MethodGet, target, "/internal/expiry-forecast?"+query, nil)
+	if err != nil {
+		return f, err
+	}
+	resp, err := replicaClient.Do(req)
+	if err != nil {
+		return f, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return f, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&f)
+	return f, err
+}
+
+func handleExpiryForecast(w http.ResponseWriter, r *http.Request) {
+	ttl := sessionTTL()
+	if ttl == 0 {
+		writeError(w, http.StatusConflict, "SESSION_TTL_DISABLED", "sessions never expire; set SESSION_TTL")
+		return
+	}
+	step, horizon, ok := forecastParams(r.URL.Query(), ttl)
+	if !ok {
+		http.Error(w, "invalid bucket or horizon", http.StatusBadRequest)
+		return
+	}
+	now := clock.Now()
+	query := url.Values{"bucket": {step.String()}, "horizon": {horizon.String()}}.Encode()
+
+	type row struct {
+		Counts    []int `json:"counts"`
+		Remaining []int `json:"remaining"`
+		Later     int   `json:"later"`
+	}
+	heatmap := make(map[string]row)
+	var unreachable []string
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	targets := allTargets()
+	if len(targets) == 0 {
+		targets = []string{getSelf()}
+	}
+	for _, t := range targets {
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			var f expiryForecast
+			var err error
+			if isSelfTarget(target) {
+				f.Counts, f.Later = sessions.expiryCounts(ttl, step, horizon, now)
+			} else {
+				f, err = fetchExpiryForecast(target, query)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				unreachable = append(unreachable, target)
+				return
+			}
+			heatmap[target] = row{Counts: f.Counts, Remaining: remainingAfter(f.Counts, f.Later), Later: f.Later}
+		}(t)
+	}
+	wg.Wait()
+
+	n := int((horizon + step - 1) / step)
+	total := make([]int, n)
+	later := 0
+	for _, rw := range heatmap {
+		for i := 0; i < n && i < len(rw.Counts); i++ {
+			total[i] += rw.Counts[i]
+		}
+		later += rw.Later
+	}
+	type bucket struct {
+		Start     time.Time `json:"start"`
+		End       time.Time `json:"end"`
+		Expiring  int       `json:"expiring"`
+		Remaining int       `json:"remaining"`
+	}
+	remaining := remainingAfter(total, later)
+	buckets := make([]bucket, n)
+	for i := range buckets {
+		start := now.Add(time.Duration(i) * step)
+		buckets[i] = bucket{Start: start, End: start.Add(step), Expiring: total[i], Remaining: remaining[i]}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"ttl":          ttl.String(),
+		"bucket":       step.String(),
+		"horizon":      horizon.String(),
+		"generated_at": now,
+		"buckets":      buckets,
+		"later":        later,
+		"heatmap":      heatmap,
+		"unreachable":  unreachable,
+	})
+}
+
+// remainingAfter returns, for each bucket, the sessions still live after it ends.
+func remainingAfter(counts []int, later int) []int {
+	out := make([]int, len(counts))
+	left := later
+	for i := len(counts) - 1; i >= 0; i-- {
+		out[i] = left
+		left += counts[i]
+	}
+	return out
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"strings"
+	"time"
+)
+
+// Gateway mode. cmd/gateway (the Dockerfile's gateway target), or the server with
+// SERVER_MODE=gateway, runs this package as a stateless routing tier: it never owns clients, keeps
+// no sessions and takes no lease, and only resolves owners with the same hashing and policies as
+// the replicas. /join, /counter and /ws are forwarded to the owner, by reverse proxy
+// (GATEWAY_FORWARD=proxy, default) or a 307 redirect (GATEWAY_FORWARD=redirect); /where and the
+// read-only views are answered locally. The gateway is configured with the replicas' routing env
+// (SERVICE_PREFIX/REPLICAS, REPLICA_ADDRESSES or MEMBERSHIP=registry) and is not one of the targets.
+// Its internal listener takes /internal/pin, so replicas with GATEWAYS set (see move.go) send it
+// their pins.
+
+// gatewayBinary is set by MainGateway: cmd/gateway always runs as the gateway.
+var gatewayBinary bool
+
+// MainGateway runs the gateway until shutdown. cmd/gateway calls it.
+func MainGateway() {
+	gatewayBinary = true
+	Main()
+}
+
+func gatewayMode() bool {
+	return gatewayBinary || strings.EqualFold(strings.TrimSpace(os.Getenv("SERVER_MODE")), "gateway")
+}
+
+// serverMode names the mode this process runs in: "replica" or "gateway".
+func serverMode() string {
+	if gatewayMode() {
+		return "gateway"
+	}
+	return "replica"
+}
+
+var gatewayProxy = newGatewayProxy()
+
+func newGatewayProxy() *httputil.ReverseProxy {
+	metrics.counter("routing_gateway_requests_total", "Requests forwarded by the gateway, by endpoint and result.")
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			owner := pr.In.Header.Get("x-routing-target")
+			pr.Out.URL.Scheme = "http"
+			pr.Out.URL.Host = owner
+			pr.Out.Host = owner
+			pr.SetXForwarded()
+			propagateDeadline(pr.In.Context(), pr.Out.Header, pr.In.Header)
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			copyRouteTrace(resp.Header, resp.Request.Header)
+			return nil
+		},
+		Transport: newHedgingTransport(newRetryTransport(breakerTransport{base: outlierTransport{base: &timedTransport{base: newUpstreamTransport()}}})),
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if deadlineExpired(r.Context().Err()) {
+				metrics.inc("routing_gateway_requests_total", "endpoint", strings.TrimPrefix(r.URL.Path, "/"), "result", "deadline_exceeded")
+				writeDeadlineExceeded(w, r)
+				return
+			}
+			if errors.Is(err, errBreakerOpen) {
+				metrics.inc("routing_gateway_requests_total", "endpoint", strings.TrimPrefix(r.URL.Path, "/"), "result", "breaker_open")
+				writeError(w, http.StatusServiceUnavailable, "BREAKER_OPEN", err.Error())
+				return
+			}
+			metrics.inc("routing_gateway_requests_total", "endpoint", strings.TrimPrefix(r.URL.Path, "/"), "result", "owner_unreachable")
+			log.Printf("gateway: forwarding %s to %s failed: %v", r.URL.Path, r.Header.Get("x-routing-target"), err)
+			writeError(w, http.StatusBadGateway, "OWNER_UNREACHABLE", err.Error())
+		},
+	}
+}
+
+// handleGatewayForward resolves the client's owner and forwards the request there.
+func handleGatewayForward(w http.ResponseWriter, r *http.Request) {
+	clientID := r.URL.Query().Get("client_id")
+	if clientID == "" {
+		http.Error(w, "missing client_id", http.StatusBadRequest)
+		return
+	}
+	if pool, ok := requestPool(w, r); !ok {
+		return
+	} else if pool != nil {
+		forwardToPool(w, r, clientID, pool)
+		return
+	}
+	if !checkAffinity(w, r) {
+		return
+	}
+	endpoint := strings.TrimPrefix(r.URL.Path, "/")
+	if endpoint == "join" && !checkQuota(w, r, quotaJoin, clientID, 1) {
+		return
+	}
+	start := time.Now()
+	owner, version, mode, err := resolveTraced(r.Context(), clientID)
+	if err != nil {
+		metrics.inc("routing_gateway_requests_total", "endpoint", endpoint, "result", "unresolved")
+		writeResolveError(w, err)
+		return
+	}
+	// Same header the Envoy Lua filter sets, so owners can run their parity check.
+	r.Header.Set("x-routing-target", owner)
+	setRouteTrace(r.Header, r.Header, mode, version)
+	result := "proxied"
+	switch {
+	case isWebSocketUpgrade(r):
+		proxyUpgrade(w, r, owner)
+	case os.Getenv("GATEWAY_FORWARD") == "redirect":
+		result = "redirected"
+		copyRouteTrace(w.Header(), r.Header)
+		http.Redirect(w, r, "http://"+owner+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+	default:
+		gatewayProxy.ServeHTTP(w, r)
+	}
+	metrics.inc("routing_gateway_requests_total", "endpoint", endpoint, "result", result)
+	decisions.record(decision{
+		Time:      start,
+		Endpoint:  endpoint,
+		ClientID:  clientID,
+		Replica:   owner,
+		ServedBy:  getSelf(),
+		Status:    result,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	})
+}
+
+// runGateway serves the gateway endpoints until shutdown.
+func runGateway(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/join", timed("join", handleGatewayForward))
+	mux.HandleFunc("/counter", handleGatewayForward)
+	mux.HandleFunc("/ws", handleGatewayForward)
+	mux.HandleFunc("/where", timed("where", handleWhere))
+	mux.HandleFunc("/where/wait", handleWhereWait)
+	mux.HandleFunc("/explain", handleExplain)
+	mux.HandleFunc("/where/batch", handleWhereBatch)
+	mux.HandleFunc("/topics/where", handleTopicWhere)
+	mux.HandleFunc("/health", handleHealth)
+	mux.HandleFunc("/version", handleVersion)
+	mux.HandleFunc("/cluster/status", handleClusterStatus)
+	mux.HandleFunc("/metrics", handleMetrics)
+	mux.HandleFunc("/slo", handleSLO)
+	mux.HandleFunc("/canary/status", handleCanaryStatus)
+	mux.HandleFunc("/ring", handleRing)
+	mux.HandleFunc("/config/peers", handleConfigPeers)
+	mux.HandleFunc("/weights", handleWeights)
+	mux.HandleFunc("/v3/discovery:endpoints", handleEDS)
+	mux.HandleFunc("/breakers", handleBreakers)
+	mux.HandleFunc("/quota", handleQuota)
+	mux.HandleFunc("/events/recent", handleRecentEvents)
+	mux.HandleFunc("/export/decisions", handleExportDecisions)
+	mux.HandleFunc("/admin/move", handleMove)
+	mux.HandleFunc("/admin/debug", handleDebug)
+	mux.HandleFunc("/admin/memory", handleMemory)
+	mux.Handle("/ui/", uiHandler())
+	mux.Handle("/ui", http.RedirectHandler("/ui/", http.StatusMovedPermanently))
+
+	internal := http.NewServeMux()
+	internal.HandleFunc("/internal/pin", handlePin)
+	internal.HandleFunc("/health", handleHealth)
+	go serveInternal(internal)
+	go runReplicaPoller()
+	go runSLOChecker()
+	go runWeightTuner()
+	go runXDSPublisher()
+	go runBootstrap()
+	go runMembership()
+	go runRingView()
+	go runOutlierDetector()
+	go runQuotaSweeper()
+	go runTCPProxy()
+	go runDNSCache()
+	go runMemoryBounds()
+	go runCanaries()
+
+	log.Printf("gateway starting on %s over %d targets", addr, len(allTargets()))
+	serve(&http.Server{Addr: addr, Handler: withAccessLog(withGRPC(withHardening(withCompression(withCORS(requireAdmin(withConcurrencyLimits(withDeadline(withRequestHeaders(mux))))))))), Protocols: serverProtocols()})
+}
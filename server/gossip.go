@@ -0,0 +1,361 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Health gossip, so a dead replica leaves routing in under a second instead of after the next
+// REPLICA_POLL_INTERVAL. With GOSSIP_PORT set, every replica sends a UDP heartbeat to each peer
+// every GOSSIP_INTERVAL (default 200ms). The heartbeat carries how long ago the sender last heard
+// from every other peer. A peer silent for GOSSIP_SUSPECT_AFTER (default 600ms) is suspect. It is
+// confirmed dead, and marked unhealthy in the routing table, once GOSSIP_CONFIRMATIONS other live
+// peers (default 1, fewer when fewer can vouch) report the same silence, or after GOSSIP_DEAD_AFTER
+// (default 2s) regardless. The next heartbeat from it clears the mark and the HTTP probe decides
+// again. Peers never heard from are left to the probe. Heartbeats are signed with INTERNAL_TOKEN
+// when it is set. Each carries the sender's epoch (its start time) and a sequence number counting up
+// from 1; a heartbeat from an older epoch, or not newer than the last one from the same epoch, is
+// rejected, so a captured heartbeat can't be replayed to keep a dead replica alive, while a
+// restarted one is heard at once. GOSSIP_BIND overrides the listen address (default :GOSSIP_PORT).
+
+type gossipMessage struct {
+	From  string           `json:"from"`
+	Epoch int64            `json:"epoch"` // sender start time, Unix ns
+	Seq   uint64           `json:"seq"`
+	Heard map[string]int64 `json:"heard"` // ms since the sender last heard each peer
+	Sig   string           `json:"sig,omitempty"`
+}
+
+type gossipPeer struct {
+	lastHeard  time.Time
+	heard      map[string]int64 // the peer's latest report
+	state      string           // alive, suspect or dead; "" until first heard
+	epoch      int64            // of the last accepted heartbeat
+	seq        uint64
+	addr       *net.UDPAddr
+	resolvedAt time.Time
+}
+
+type gossipNode struct {
+	mu            sync.Mutex
+	port          string
+	interval      time.Duration
+	suspectAfter  time.Duration
+	deadAfter     time.Duration
+	confirmations int
+	token         []byte
+	self          string
+	epoch         int64
+	seq           uint64
+	peers         map[string]*gossipPeer
+}
+
+var gossip = newGossipNode()
+
+func newGossipNode() *gossipNode {
+	g := &gossipNode{
+		port:          os.Getenv("GOSSIP_PORT"),
+		interval:      gossipDuration("GOSSIP_INTERVAL", 200*time.Millisecond),
+		suspectAfter:  gossipDuration("GOSSIP_SUSPECT_AFTER", 600*time.Millisecond),
+		deadAfter:     gossipDuration("GOSSIP_DEAD_AFTER", 2*time.Second),
+		confirmations: 1,
+		token:         []byte(os.Getenv("INTERNAL_TOKEN")),
+		epoch:         time.Now().UnixNano(),
+		peers:         make(map[string]*gossipPeer),
+	}
+	if n, err := strconv.Atoi(os.Getenv("GOSSIP_CONFIRMATIONS")); err == nil && n >= 0 {
+		g.confirmations = n
+	}
+	if g.port == "" {
+		return g
+	}
+	metrics.counter("routing_gossip_messages_total", "Gossip heartbeats, by direction (sent, received, rejected).")
+	metrics.counter("routing_gossip_transitions_total", "Peer state changes seen through gossip, by new state.")
+	metrics.gaugeFunc("routing_gossip_dead_peers", "Peers currently confirmed dead by gossip.", func() float64 {
+		return float64(len(g.deadPeers()))
+	})
+	return g
+}
+
+func gossipDuration(name string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(name)); err == nil && d > 0 {
+		return d
+	}
+	return def
+}
+
+func (g *gossipNode) enabled() bool { return g.port != "" }
+
+// runGossip listens for and sends heartbeats until shutdown.
+func runGossip() {
+	if !gossip.enabled() {
+		return
+	}
+	bind := os.Getenv("GOSSIP_BIND")
+	if bind == "" {
+		bind = ":" + gossip.port
+	}
+	addr, err := net.ResolveUDPAddr("udp", bind)
+	if err != nil {
+		log.Printf("gossip: %v; relying on HTTP probes", err)
+		return
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		log.Printf("gossip: listen %s: %v; relying on HTTP probes", bind, err)
+		return
+	}
+	gossip.self = selfTarget()
+	log.Printf("gossip: %s on udp %s every %s (suspect %s, dead %s)", gossip.self, bind, gossip.interval, gossip.suspectAfter, gossip.deadAfter)
+	go gossip.receive(conn)
+	for range clock.Tick(gossip.interval) {
+		gossip.send(conn)
+		if gossip.evaluate(clock.Now()) {
+			publishTable("gossip", nil)
+			replicas.changed()
+		}
+	}
+}
+
+func (g *gossipNode) receive(conn *net.UDPConn) {
+	buf := make([]byte, 64<<10)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			log.Printf("gossip: read: %v", err)
+			return
+		}
+		var msg gossipMessage
+		if json.Unmarshal(buf[:n], &msg) != nil || !g.verify(msg) {
+			metrics.inc("routing_gossip_messages_total", "direction", "rejected")
+			continue
+		}
+		revived, fresh := g.heardFrom(msg)
+		if !fresh {
+			metrics.inc("routing_gossip_messages_total", "direction", "rejected")
+			continue
+		}
+		metrics.inc("routing_gossip_messages_total", "direction", "received")
+		if revived {
+			publishTable("gossip", nil)
+			replicas.changed()
+		}
+	}
+}
+
+// heardFrom records a heartbeat. revived is true when it brought a dead peer back; fresh is false
+// for a replayed or out-of-order heartbeat, which is ignored.
+func (g *gossipNode) heardFrom(msg gossipMessage) (revived, fresh bool) {
+	known := isGossipTarget(msg.From)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p, ok := g.peers[msg.From]
+	if !ok {
+		if !known {
+			return false, true
+		}
+		p = &gossipPeer{}
+		g.peers[msg.From] = p
+	}
+	if msg.Epoch < p.epoch || (msg.Epoch == p.epoch && msg.Seq <= p.seq) {
+		return false, false
+	}
+	p.epoch, p.seq = msg.Epoch, msg.Seq
+	p.lastHeard, p.heard = clock.Now(), msg.Heard
+	revived = p.state == "dead"
+	if p.state != "alive" {
+		g.transition(msg.From, p, "alive")
+	}
+	return revived, true
+}
+
+func isGossipTarget(target string) bool {
+	for _, t := range allTargets() {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *gossipNode) send(conn *net.UDPConn) {
+	now := clock.Now()
+	var targets []string
+	for _, t := range allTargets() {
+		if t != g.self && !isSelfTarget(t) {
+			targets = append(targets, t)
+		}
+	}
+	g.mu.Lock()
+	g.seq++
+	msg := gossipMessage{From: g.self, Epoch: g.epoch, Seq: g.seq, Heard: make(map[string]int64, len(g.peers))}
+	for target, p := range g.peers {
+		if !p.lastHeard.IsZero() {
+			msg.Heard[target] = now.Sub(p.lastHeard).Milliseconds()
+		}
+	}
+	var addrs []*net.UDPAddr
+	var resolve []string
+	for _, target := range targets {
+		p, ok := g.peers[target]
+		if !ok {
+			p = &gossipPeer{}
+			g.peers[target] = p
+		}
+		// Re-resolve now and then so rescheduled pods are followed.
+		if p.addr == nil || now.Sub(p.resolvedAt) > 10*time.Second {
+			resolve = append(resolve, target)
+		} else {
+			addrs = append(addrs, p.addr)
+		}
+	}
+	g.mu.Unlock()
+
+	// Lookups run unlocked, so a slow DNS answer doesn't hold up receive.
+	resolved := make(map[string]*net.UDPAddr, len(resolve))
+	for _, target := range resolve {
+		host, _, err := net.SplitHostPort(target)
+		if err != nil {
+			host = target
+		}
+		if a, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, g.port)); err == nil {
+			resolved[target] = a
+		}
+	}
+	if len(resolve) > 0 {
+		g.mu.Lock()
+		for _, target := range resolve {
+			p := g.peers[target]
+			if a, ok := resolved[target]; ok {
+				p.addr, p.resolvedAt = a, now
+			}
+			if p.addr != nil {
+				addrs = append(addrs, p.addr)
+			}
+		}
+		g.mu.Unlock()
+	}
+
+	b, err := json.Marshal(g.sign(msg))
+	if err != nil {
+		return
+	}
+	for _, a := range addrs {
+		if _, err := conn.WriteToUDP(b, a); err == nil {
+			metrics.inc("routing_gossip_messages_total", "direction", "sent")
+		}
+	}
+}
+
+// evaluate updates every heard peer's state; true when the set of dead peers changed.
+func (g *gossipNode) evaluate(now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	changed := false
+	for target, p := range g.peers {
+		if p.state == "" || p.state == "dead" {
+			continue
+		}
+		silent := now.Sub(p.lastHeard)
+		if silent < g.suspectAfter {
+			continue
+		}
+		if p.state == "alive" {
+			g.transition(target, p, "suspect")
+		}
+		if silent >= g.deadAfter || g.confirmed(target, now) {
+			g.transition(target, p, "dead")
+			changed = true
+		}
+	}
+	return changed
+}
+
+// confirmed reports whether enough live peers also lost target, ageing each report by the time
+// since it arrived. Callers hold g.mu.
+func (g *gossipNode) confirmed(target string, now time.Time) bool {
+	vouchers, agree := 0, 0
+	for other, p := range g.peers {
+		if other == target || p.state != "alive" {
+			continue
+		}
+		vouchers++
+		if ago, ok := p.heard[target]; !ok || time.Duration(ago)*time.Millisecond+now.Sub(p.lastHeard) >= g.suspectAfter {
+			agree++
+		}
+	}
+	return agree >= min(g.confirmations, vouchers)
+}
+
+func (g *gossipNode) transition(target string, p *gossipPeer, state string) {
+	if p.state == "dead" || state == "dead" {
+		log.Printf("gossip: %s is %s (last heard %s ago)", target, state, clockSince(p.lastHeard).Round(time.Millisecond))
+	}
+	p.state = state
+	metrics.inc("routing_gossip_transitions_total", "state", state)
+}
+
+// deadPeers lists the targets gossip has confirmed dead.
+func (g *gossipNode) deadPeers() map[string]bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	dead := make(map[string]bool)
+	for target, p := range g.peers {
+		if p.state == "dead" {
+			dead[target] = true
+		}
+	}
+	return dead
+}
+
+// status reports every peer's gossip state for /cluster/status; nil when gossip is off.
+func (g *gossipNode) status() map[string]any {
+	if !g.enabled() {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	out := make(map[string]any, len(g.peers))
+	for target, p := range g.peers {
+		state := p.state
+		if state == "" {
+			state = "unknown"
+		}
+		entry := map[string]any{"state": state}
+		if !p.lastHeard.IsZero() {
+			entry["last_heard_ms"] = clockSince(p.lastHeard).Milliseconds()
+		}
+		out[target] = entry
+	}
+	return out
+}
+
+func (g *gossipNode) mac(msg gossipMessage) string {
+	msg.Sig = ""
+	b, _ := json.Marshal(msg)
+	h := hmac.New(sha256.New, g.token)
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (g *gossipNode) sign(msg gossipMessage) gossipMessage {
+	if len(g.token) > 0 {
+		msg.Sig = g.mac(msg)
+	}
+	return msg
+}
+
+func (g *gossipNode) verify(msg gossipMessage) bool {
+	if msg.From == "" {
+		return false
+	}
+	return len(g.token) == 0 || hmac.Equal([]byte(msg.Sig), []byte(g.mac(msg)))
+}
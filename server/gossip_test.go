@@ -0,0 +1,31 @@
+package server
+
+import "testing"
+
+// A replayed heartbeat must not mark a peer alive again, but a restarted peer, whose sequence
+// starts over under a new epoch, must be heard.
+
+func TestGossipRejectsReplay(t *testing.T) {
+	g := newGossipNode()
+	const peer = "server-2:8081"
+	g.peers[peer] = &gossipPeer{}
+
+	hb := gossipMessage{From: peer, Epoch: 100, Seq: 5}
+	if _, fresh := g.heardFrom(hb); !fresh {
+		t.Fatal("first heartbeat rejected")
+	}
+	g.peers[peer].state = "dead"
+	for _, old := range []gossipMessage{hb, {From: peer, Epoch: 100, Seq: 4}, {From: peer, Epoch: 99, Seq: 9}} {
+		if _, fresh := g.heardFrom(old); fresh {
+			t.Errorf("epoch %d seq %d accepted after epoch 100 seq 5", old.Epoch, old.Seq)
+		}
+	}
+	if s := g.peers[peer].state; s != "dead" {
+		t.Fatalf("replay moved the peer to %q", s)
+	}
+
+	revived, fresh := g.heardFrom(gossipMessage{From: peer, Epoch: 200, Seq: 1})
+	if !fresh || !revived {
+		t.Errorf("restarted peer: revived=%v fresh=%v", revived, fresh)
+	}
+}
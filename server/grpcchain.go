@@ -0,0 +1,319 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// gRPC interceptors. Native gRPC calls skip the HTTP chain, so the gRPC server runs the same
+// checks as unary and stream interceptors, outermost first:
+//   - request ID: x-request-id from the caller (Envoy sets one) or a new one, sent back as response
+//     metadata and named in the log lines below
+//   - metrics: routing_grpc_requests_total{method,code} and
+//     routing_grpc_request_duration_seconds{method}
+//   - recovery: a panicking handler is logged with its stack and the call fails with INTERNAL
+//     instead of taking the connection down
+//   - headers: the metadata becomes the request headers, as withRequestHeaders does, so
+//     ROUTING_EXPR's header() sees it
+//   - deadline: X-Deadline-Ms metadata sets the call's deadline, like on HTTP (grpc-timeout is
+//     applied by gRPC itself); a call with no budget left fails with DEADLINE_EXCEEDED and counts
+//     in routing_deadline_exceeded_total{path}
+//   - auth: methods reading /admin/ state (adminGRPCAuthMethods) need a read or admin token when
+//     ADMIN_TOKENS is set, as requireAdmin does for GET /admin/*
+//   - ownership: a request naming a client (client_id) that carries the parity header
+//     (PARITY_HEADER, default x-routing-target) has it compared with the local owner, counted in
+//     routing_parity_checks_total like /join; on a stream every received message is checked
+//
+// gRPC-Web calls are plain HTTP requests, so the HTTP chain already applies headers, deadlines and
+// auth to them; withGRPCWebChecks adds the request ID, metrics and recovery, and the handlers check
+// ownership.
+
+const requestIDHeader = "X-Request-Id"
+
+func init() {
+	metrics.counter("routing_grpc_requests_total", "gRPC and gRPC-Web calls, by method and status code.")
+	metrics.histogram("routing_grpc_request_duration_seconds", "Latency of gRPC and gRPC-Web calls, by method.")
+}
+
+func grpcServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(grpcRequestIDUnary, grpcMetricsUnary, grpcRecoveryUnary, grpcHeadersUnary,
+			grpcDeadlineUnary, grpcAuthUnary, grpcOwnershipUnary),
+		grpc.ChainStreamInterceptor(grpcRequestIDStream, grpcMetricsStream, grpcRecoveryStream, grpcHeadersStream,
+			grpcDeadlineStream, grpcAuthStream, grpcOwnershipStream),
+	}
+}
+
+// ctxStream is a server stream running under a derived context.
+type ctxStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s ctxStream) Context() context.Context { return s.ctx }
+
+// grpcMethod is the last element of a full method name, as the metrics label it.
+func grpcMethod(fullMethod string) string {
+	return fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+}
+
+// Request ID.
+
+type requestIDKey struct{}
+
+func withRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		id = newHandoffID()
+	}
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func grpcWithRequestID(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	id := ""
+	if v := md.Get(requestIDHeader); len(v) > 0 {
+		id = v[0]
+	}
+	ctx = withRequestID(ctx, id)
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, requestID(ctx)))
+	return ctx
+}
+
+func grpcRequestIDUnary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(grpcWithRequestID(ctx), req)
+}
+
+func grpcRequestIDStream(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, ctxStream{ss, grpcWithRequestID(ss.Context())})
+}
+
+// Metrics.
+
+func observeGRPC(method string, code codes.Code, start time.Time) {
+	metrics.inc("routing_grpc_requests_total", "method", method, "code", code.String())
+	metrics.observe("routing_grpc_request_duration_seconds", time.Since(start).Seconds(), "method", method)
+}
+
+func grpcMetricsUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	observeGRPC(grpcMethod(info.FullMethod), status.Code(err), start)
+	return resp, err
+}
+
+func grpcMetricsStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	observeGRPC(grpcMethod(info.FullMethod), status.Code(err), start)
+	return err
+}
+
+// Recovery.
+
+// grpcRecover, deferred, turns a panic into an INTERNAL error in *err.
+func grpcRecover(ctx context.Context, fullMethod string, err *error) {
+	if p := recover(); p != nil {
+		log.Printf("grpc panic method=%s request_id=%s: %v\n%s", fullMethod, requestID(ctx), p, debug.Stack())
+		*err = status.Error(codes.Internal, "internal error")
+	}
+}
+
+func grpcRecoveryUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer grpcRecover(ctx, info.FullMethod, &err)
+	return handler(ctx, req)
+}
+
+func grpcRecoveryStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer grpcRecover(ss.Context(), info.FullMethod, &err)
+	return handler(srv, ss)
+}
+
+// Headers.
+
+func grpcWithHeaders(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	h := make(http.Header, len(md))
+	for k, vs := range md {
+		for _, v := range vs {
+			h.Add(k, v)
+		}
+	}
+	return context.WithValue(ctx, headersKey{}, h)
+}
+
+func grpcHeadersUnary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(grpcWithHeaders(ctx), req)
+}
+
+func grpcHeadersStream(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, ctxStream{ss, grpcWithHeaders(ss.Context())})
+}
+
+// Deadline.
+
+// grpcWithDeadline applies an X-Deadline-Ms budget to ctx and refuses a call with none left.
+func grpcWithDeadline(ctx context.Context, fullMethod string) (context.Context, context.CancelFunc, error) {
+	cancel := context.CancelFunc(func() {})
+	budget, ok := requestBudget(requestHeaders(ctx))
+	if ok && budget > 0 {
+		ctx, cancel = context.WithTimeout(ctx, budget)
+	}
+	if (ok && budget <= 0) || deadlineExpired(ctx.Err()) {
+		metrics.inc("routing_deadline_exceeded_total", "path", fullMethod)
+		return ctx, cancel, status.Error(codes.DeadlineExceeded, "deadline budget exhausted")
+	}
+	return ctx, cancel, nil
+}
+
+func grpcDeadlineUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, cancel, err := grpcWithDeadline(ctx, info.FullMethod)
+	defer cancel()
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func grpcDeadlineStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, cancel, err := grpcWithDeadline(ss.Context(), info.FullMethod)
+	defer cancel()
+	if err != nil {
+		return err
+	}
+	return handler(srv, ctxStream{ss, ctx})
+}
+
+// Auth.
+
+// grpcCheckAdmin enforces the admin token on the methods that need one.
+func grpcCheckAdmin(ctx context.Context, fullMethod string) error {
+	if len(adminTokens) == 0 || !slices.Contains(adminGRPCAuthMethods, fullMethod) {
+		return nil
+	}
+	t, ok := lookupBearerToken(requestHeaders(ctx).Get("Authorization"))
+	if !ok {
+		metrics.inc("routing_admin_requests_total", "role", "none", "result", "unauthorized")
+		remote := ""
+		if p, found := peer.FromContext(ctx); found {
+			remote = p.Addr.String()
+		}
+		log.Printf("admin auth rejected grpc method=%s remote=%s request_id=%s", grpcMethod(fullMethod), remote, requestID(ctx))
+		return status.Error(codes.Unauthenticated, "missing or invalid admin token")
+	}
+	metrics.inc("routing_admin_requests_total", "role", t.role, "result", "allowed")
+	return nil
+}
+
+func grpcAuthUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := grpcCheckAdmin(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func grpcAuthStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := grpcCheckAdmin(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// Ownership.
+
+// checkRequestOwner compares the parity header of a request naming a client with the owner this
+// replica resolves for it. Such calls answer for any client, so only the owner is compared, not
+// which replica serves them.
+func checkRequestOwner(ctx context.Context, req any) {
+	named, ok := req.(interface{ GetClientId() string })
+	if !ok || named.GetClientId() == "" {
+		return
+	}
+	target := requestHeaders(ctx).Get(parity.header)
+	if target == "" {
+		return
+	}
+	if owner, err := resolveOwner(ctx, named.GetClientId()); err == nil {
+		parity.compare(target, named.GetClientId(), owner, false)
+	}
+}
+
+func grpcOwnershipUnary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	checkRequestOwner(ctx, req)
+	return handler(ctx, req)
+}
+
+// ownershipStream checks each message the client sends.
+type ownershipStream struct {
+	grpc.ServerStream
+}
+
+func (s ownershipStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		checkRequestOwner(s.Context(), m)
+	}
+	return err
+}
+
+func grpcOwnershipStream(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, ownershipStream{ss})
+}
+
+// gRPC-Web.
+
+type grpcWebStateKey struct{}
+
+// grpcWebState lets withGRPCWebChecks see the call its handler answered.
+type grpcWebState struct {
+	call *grpcWebCall
+}
+
+// withGRPCWebChecks wraps a gRPC-Web handler with the request ID, metrics and recovery the
+// native interceptors apply. A request refused before it is read as gRPC-Web (wrong method or
+// content type) is an HTTP error and isn't counted.
+func withGRPCWebChecks(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := withRequestID(r.Context(), r.Header.Get(requestIDHeader))
+		w.Header().Set(requestIDHeader, requestID(ctx))
+		st := &grpcWebState{}
+		ctx = context.WithValue(ctx, grpcWebStateKey{}, st)
+		method := grpcMethod(r.URL.Path)
+		start := time.Now()
+		defer func() {
+			if p := recover(); p != nil {
+				log.Printf("grpc-web panic method=%s request_id=%s: %v\n%s", method, requestID(ctx), p, debug.Stack())
+				if st.call == nil {
+					st.call = &grpcWebCall{w: w, text: strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc-web-text")}
+				}
+				if !st.call.done {
+					st.call.finish(grpcInternal, "internal error")
+				}
+			}
+			if st.call == nil {
+				return
+			}
+			code := codes.Canceled // the client went away mid-stream
+			if st.call.done {
+				code = codes.Code(st.call.code)
+			}
+			observeGRPC(method, code, start)
+		}()
+		h(w, r.WithContext(ctx))
+	}
+}
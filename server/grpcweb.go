@@ -0,0 +1,318 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"personal/poc-routing/server/routingpb"
+)
+
+// gRPC-Web for browser clients. The Routing service (routingpb/routing.proto) is served natively
+// on the public listener, so a browser tool built on grpc-web or Connect calls it without an
+// Envoy grpc_web filter or another proxy:
+//   - POST /poc.routing.v1.Routing/Where answers like GET /where: it runs the same handler, so
+//     pools, affinity, quotas and reachability behave the same.
+//   - POST /poc.routing.v1.Routing/Events streams this replica's assignment events like
+//     GET /events, replaying up to replay recent events first.
+//
+// Both encodings are accepted, binary (application/grpc-web, application/grpc-web+proto) and
+// base64 text (application/grpc-web-text); the answer uses the request's. Compressed messages are
+// refused with UNIMPLEMENTED. An HTTP error from /where becomes the grpc-status in the trailers
+// (400 INVALID_ARGUMENT, 429 RESOURCE_EXHAUSTED, 503 UNAVAILABLE, 504 DEADLINE_EXCEEDED, ...),
+// with its message as grpc-message. grpc-timeout sets the call's deadline (see deadline.go); an
+// Events stream without one runs until the client goes away. Both calls get the request ID,
+// metrics and recovery of the native gRPC interceptors (see grpcchain.go), and Where compares the
+// parity header with the owner it answers.
+
+const (
+	grpcWebWherePath  = "/poc.routing.v1.Routing/Where"
+	grpcWebEventsPath = "/poc.routing.v1.Routing/Events"
+)
+
+// gRPC status codes used here.
+const (
+	grpcOK                 = 0
+	grpcInvalidArgument    = 3
+	grpcDeadlineExceeded   = 4
+	grpcNotFound           = 5
+	grpcPermissionDenied   = 7
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnavailable        = 14
+	grpcUnauthenticated    = 16
+)
+
+// grpcWebCall answers one gRPC-Web request, in the encoding it came in.
+type grpcWebCall struct {
+	w       http.ResponseWriter
+	text    bool
+	started bool
+	done    bool
+	code    int
+}
+
+// readGRPCWeb checks r is a gRPC-Web POST and returns its single request message. On failure it
+// has already answered.
+func readGRPCWeb(w http.ResponseWriter, r *http.Request) (*grpcWebCall, []byte, bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "gRPC-Web calls are POST")
+		return nil, nil, false
+	}
+	ct := r.Header.Get("Content-Type")
+	if !strings.HasPrefix(ct, "application/grpc-web") {
+		writeError(w, http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA_TYPE", "expected application/grpc-web or application/grpc-web-text")
+		return nil, nil, false
+	}
+	c := &grpcWebCall{w: w, text: strings.HasPrefix(ct, "application/grpc-web-text")}
+	if st, ok := r.Context().Value(grpcWebStateKey{}).(*grpcWebState); ok {
+		st.call = c
+	}
+	body, err := io.ReadAll(r.Body)
+	if err == nil && c.text {
+		body, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(body)))
+	}
+	if err != nil {
+		c.finish(grpcInvalidArgument, "reading the request: "+err.Error())
+		return nil, nil, false
+	}
+	if len(body) < 5 || int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 {
+		c.finish(grpcInvalidArgument, "the request is not one length-prefixed message")
+		return nil, nil, false
+	}
+	if body[0]&1 != 0 {
+		c.finish(grpcUnimplemented, "compressed messages are not supported")
+		return nil, nil, false
+	}
+	return c, body[5:], true
+}
+
+// start sends the response headers once. Errors still go in the trailers, after them.
+func (c *grpcWebCall) start() {
+	if c.started {
+		return
+	}
+	c.started = true
+	h := c.w.Header()
+	if c.text {
+		h.Set("Content-Type", "application/grpc-web-text+proto")
+	} else {
+		h.Set("Content-Type", "application/grpc-web+proto")
+	}
+	h.Del("Content-Length")
+	c.w.WriteHeader(http.StatusOK)
+}
+
+// frame writes one gRPC-Web frame: a flag byte (0x80 for trailers), the length and the payload.
+func (c *grpcWebCall) frame(flag byte, payload []byte) {
+	buf := make([]byte, 5, 5+len(payload))
+	buf[0] = flag
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(payload)))
+	buf = append(buf, payload...)
+	if c.text {
+		_, _ = io.WriteString(c.w, base64.StdEncoding.EncodeToString(buf))
+	} else {
+		_, _ = c.w.Write(buf)
+	}
+	if f, ok := c.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// send writes msg as a data frame, starting the response if needed.
+func (c *grpcWebCall) send(msg proto.Message) error {
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.start()
+	c.frame(0, b)
+	return nil
+}
+
+// finish ends the call with its status in a trailer frame.
+func (c *grpcWebCall) finish(code int, msg string) {
+	c.done, c.code = true, code
+	c.start()
+	trailer := "grpc-status: " + strconv.Itoa(code) + "\r\n"
+	if msg != "" {
+		trailer += "grpc-message: " + url.PathEscape(msg) + "\r\n"
+	}
+	c.frame(0x80, []byte(trailer))
+}
+
+// grpcWebRecorder buffers the /where handler's answer for handleGRPCWebWhere to convert.
+type grpcWebRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *grpcWebRecorder) Header() http.Header { return r.header }
+
+func (r *grpcWebRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+}
+
+func (r *grpcWebRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+var grpcWebWhere = timed("where", handleWhere)
+
+func handleGRPCWebWhere(w http.ResponseWriter, r *http.Request) {
+	c, body, ok := readGRPCWeb(w, r)
+	if !ok {
+		return
+	}
+	var req routingpb.WhereRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		c.finish(grpcInvalidArgument, "decoding WhereRequest: "+err.Error())
+		return
+	}
+	q := url.Values{"client_id": {req.GetClientId()}}
+	if req.GetReachable() {
+		q.Set("reachable", "1")
+	}
+	hr := r.Clone(r.Context())
+	hr.Method = http.MethodGet
+	hr.URL = &url.URL{Path: "/where", RawQuery: q.Encode()}
+	hr.RequestURI = hr.URL.RequestURI()
+	hr.Body = http.NoBody
+	hr.ContentLength = 0
+	hr.Header.Del("If-None-Match")
+	rec := &grpcWebRecorder{header: make(http.Header)}
+	grpcWebWhere(rec, hr)
+
+	// The routing trace and degraded-mode headers travel as response metadata.
+	for k, v := range rec.header {
+		if strings.HasPrefix(k, "X-") {
+			w.Header()[k] = v
+		}
+	}
+	if rec.status != http.StatusOK {
+		c.finish(grpcStatusFromHTTP(rec.status), httpErrorMessage(rec.body.Bytes()))
+		return
+	}
+	var res whereResponse
+	if err := json.Unmarshal(rec.body.Bytes(), &res); err != nil {
+		c.finish(grpcInternal, "decoding the /where answer: "+err.Error())
+		return
+	}
+	if target := r.Header.Get(parity.header); target != "" {
+		parity.compare(target, res.ClientID, res.HostPort, false)
+	}
+	if err := c.send(&routingpb.WhereResponse{
+		ClientId:     res.ClientID,
+		Hostport:     res.HostPort,
+		Standby:      res.Standby,
+		TableVersion: res.TableVersion,
+		Targets:      res.Targets,
+		Degraded:     res.Degraded,
+		Reachable:    res.Reachable,
+	}); err != nil {
+		c.finish(grpcInternal, err.Error())
+		return
+	}
+	c.finish(grpcOK, "")
+}
+
+func handleGRPCWebEvents(w http.ResponseWriter, r *http.Request) {
+	c, body, ok := readGRPCWeb(w, r)
+	if !ok {
+		return
+	}
+	var req routingpb.EventsRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		c.finish(grpcInvalidArgument, "decoding EventsRequest: "+err.Error())
+		return
+	}
+	ch := recentEvents.subscribe()
+	defer recentEvents.unsubscribe(ch)
+
+	c.start()
+	if n := int(req.GetReplay()); n > 0 {
+		recent := recentEvents.list()
+		for i := min(n, len(recent)) - 1; i >= 0; i-- {
+			_ = c.send(eventProto(recent[i]))
+		}
+	}
+	for {
+		select {
+		case ev := <-ch:
+			_ = c.send(eventProto(ev))
+		case <-r.Context().Done():
+			if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+				c.finish(grpcDeadlineExceeded, "grpc-timeout passed")
+			}
+			return
+		}
+	}
+}
+
+func eventProto(ev assignmentEvent) *routingpb.AssignmentEvent {
+	return &routingpb.AssignmentEvent{
+		Type:     ev.Type,
+		ClientId: ev.ClientID,
+		Replica:  ev.Replica,
+		From:     ev.From,
+		To:       ev.To,
+		Pool:     ev.Pool,
+		Tenant:   ev.Tenant,
+		Ts:       timestamppb.New(ev.Time),
+	}
+}
+
+// grpcStatusFromHTTP maps an HTTP error status to the gRPC code a client would expect.
+func grpcStatusFromHTTP(status int) int {
+	switch status {
+	case http.StatusBadRequest:
+		return grpcInvalidArgument
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusNotFound:
+		return grpcNotFound
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return grpcFailedPrecondition
+	case http.StatusTooManyRequests:
+		return grpcResourceExhausted
+	case http.StatusGatewayTimeout:
+		return grpcDeadlineExceeded
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return grpcUnavailable
+	}
+	return grpcInternal
+}
+
+// httpErrorMessage returns the message of a writeError body, "CODE: message", or the body itself.
+func httpErrorMessage(body []byte) string {
+	var e struct{ Error, Code string }
+	if json.Unmarshal(body, &e) == nil && e.Error != "" {
+		if e.Code != "" {
+			return e.Code + ": " + e.Error
+		}
+		return e.Error
+	}
+	return strings.TrimSpace(string(body))
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"personal/poc-routing/server/routingpb"
+)
+
+// grpcWebFrames splits a gRPC-Web response body into its data messages and trailer block.
+func grpcWebFrames(t *testing.T, body []byte) (msgs [][]byte, trailer string) {
+	t.Helper()
+	for len(body) > 0 {
+		if len(body) < 5 {
+			t.Fatalf("truncated frame header: %x", body)
+		}
+		n := int(binary.BigEndian.Uint32(body[1:5]))
+		if len(body) < 5+n {
+			t.Fatalf("frame of %d bytes has %d", n, len(body)-5)
+		}
+		if body[0]&0x80 != 0 {
+			trailer = string(body[5 : 5+n])
+		} else {
+			msgs = append(msgs, body[5:5+n])
+		}
+		body = body[5+n:]
+	}
+	return msgs, trailer
+}
+
+func grpcWebRequest(t *testing.T, msg proto.Message, text bool) *http.Request {
+	t.Helper()
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := append([]byte{0, 0, 0, 0, 0}, b...)
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(b)))
+	ct := "application/grpc-web+proto"
+	if text {
+		frame, ct = []byte(base64.StdEncoding.EncodeToString(frame)), "application/grpc-web-text"
+	}
+	r := httptest.NewRequest(http.MethodPost, grpcWebWherePath, bytes.NewReader(frame))
+	r.Header.Set("Content-Type", ct)
+	return r
+}
+
+func TestGRPCWebWhere(t *testing.T) {
+	setupBenchRing(t)
+	for _, text := range []bool{false, true} {
+		w := httptest.NewRecorder()
+		handleGRPCWebWhere(w, grpcWebRequest(t, &routingpb.WhereRequest{ClientId: "c-42"}, text))
+		body := w.Body.Bytes()
+		if text {
+			// Each frame is its own padded base64 chunk, so decode 4 characters at a time.
+			var raw []byte
+			for i := 0; i+4 <= len(body); i += 4 {
+				b, err := base64.StdEncoding.DecodeString(string(body[i : i+4]))
+				if err != nil {
+					t.Fatalf("text body %q: %v", body, err)
+				}
+				raw = append(raw, b...)
+			}
+			body = raw
+		}
+		msgs, trailer := grpcWebFrames(t, body)
+		if len(msgs) != 1 || !strings.Contains(trailer, "grpc-status: 0\r\n") {
+			t.Fatalf("text=%v: %d messages, trailer %q", text, len(msgs), trailer)
+		}
+		var res routingpb.WhereResponse
+		if err := proto.Unmarshal(msgs[0], &res); err != nil {
+			t.Fatal(err)
+		}
+		if want := pickByHashScaled("c-42"); res.GetClientId() != "c-42" || res.GetHostport() != want {
+			t.Errorf("text=%v: got %s -> %s, want %s", text, res.GetClientId(), res.GetHostport(), want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handleGRPCWebWhere(w, grpcWebRequest(t, &routingpb.WhereRequest{}, false))
+	if msgs, trailer := grpcWebFrames(t, w.Body.Bytes()); len(msgs) != 0 || !strings.Contains(trailer, "grpc-status: 3\r\n") {
+		t.Errorf("missing client_id: %d messages, trailer %q, want INVALID_ARGUMENT", len(msgs), trailer)
+	}
+}
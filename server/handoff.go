@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// handoffRecord is the payload sent from the old owner to the new owner on /internal/handoff.
+type handoffRecord struct {
+	ID      string  `json:"handoff_id"`
+	From    string  `json:"from"`
+	To      string  `json:"to"`
+	Session Session `json:"session"`
+}
+
+// handoffDedup remembers handoff IDs already applied so a retried handoff is applied at most once.
+type handoffDedup struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+	ttl  time.Duration
+}
+
+var handoffsSeen = &handoffDedup{seen: make(map[string]time.Time), ttl: 10 * time.Minute}
+
+// firstSeen reports whether id has not been applied before, and records it.
+func (d *handoffDedup) firstSeen(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for k, t := range d.seen {
+		if now.Sub(t) > d.ttl {
+			delete(d.seen, k)
+		}
+	}
+	if _, ok := d.seen[id]; ok {
+		return false
+	}
+	d.seen[id] = now
+	return true
+}
+
+var handoffClient = newInternalClient(2 * time.Second)
+
+func newHandoffID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// isSelfTarget reports whether hostport names this replica. It matches SELF_HOSTPORT when set,
+// an IP target against the local interface addresses, and otherwise compares the first DNS label
+// of the target host with os.Hostname() (StatefulSet pods are named after their ordinal, e.g. server-1).
+// Failing that, a host that resolves to a local address is this replica: Compose containers have
+// an ID as hostname, but their name (poc-routing-server-1) resolves to their own IP.
+func isSelfTarget(hostport string) bool {
+	if v := strings.TrimSpace(os.Getenv("SELF_HOSTPORT")); v != "" {
+		return v == hostport
+	}
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return isLocalIP(ip) && (port == "" || port == selfPort())
+	}
+	label, _, _ := strings.Cut(host, ".")
+	hostname, _ := os.Hostname()
+	if label == hostname {
+		return true
+	}
+	return (port == "" || port == selfPort()) && resolvesToSelf(host)
+}
+
+// selfHostTTL is how long resolvesToSelf trusts an answer.
+const selfHostTTL = 30 * time.Second
+
+type selfHostEntry struct {
+	self bool
+	at   time.Time
+}
+
+// selfHosts caches resolvesToSelf per host, since isSelfTarget runs on every /join.
+var selfHosts sync.Map // host -> selfHostEntry
+
+// resolvesToSelf reports whether host resolves to one of this replica's addresses. A failed lookup
+// counts as not self until the entry expires.
+func resolvesToSelf(host string) bool {
+	if e, ok := selfHosts.Load(host); ok && time.Since(e.(selfHostEntry).at) < selfHostTTL {
+		return e.(selfHostEntry).self
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	addrs, _ := net.DefaultResolver.LookupIPAddr(ctx, host)
+	self := false
+	for _, a := range addrs {
+		if isLocalIP(a.IP) {
+			self = true
+			break
+		}
+	}
+	selfHosts.Store(host, selfHostEntry{self: self, at: time.Now()})
+	return self
+}
+
+var localIPs = sync.OnceValue(func() []net.IP {
+	var out []net.IP
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok {
+			out = append(out, n.IP)
+		}
+	}
+	return out
+})
+
+func isLocalIP(ip net.IP) bool {
+	for _, l := range localIPs() {
+		if l.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func selfPort() string {
+	if p := os.Getenv("PORT"); p != "" {
+		return p
+	}
+	return "8081"
+}
+
+// handOff moves the local session for clientID to the replica at to.
+// The session is removed locally before sending, and every retry reuses the same handoff ID,
+// so the receiver applies it at most once. The send and its retries run in the background, so a
+// slow or dead new owner doesn't hold up the client's redirect. If all attempts fail the session
+// is dropped.
+func handOff(clientID, to string) error {
+	sess, ok := sessions.take(clientID)
+	if !ok {
+		return nil
+	}
+	unlockClients(clientID)
+	rec := handoffRecord{ID: newHandoffID(), From: getSelf(), To: to, Session: sess}
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	go sendHandoff(rec, body)
+	return nil
+}
+
+// sendHandoff posts a handoff to its new owner, retrying up to 3 times.
+func sendHandoff(rec handoffRecord, body []byte) {
+	var lastErr error
+	for attempt := 1; attempt <= 3; attempt++ {
+		lastErr = postHandoff(rec.To, body)
+		if lastErr == nil {
+			log.Printf("handoff id=%s client_id=%s from=%s to=%s", rec.ID, rec.Session.ClientID, rec.From, rec.To)
+			events.emit(eventMoved, rec.Session.ClientID, rec.To, rec.From, rec.To)
+			return
+		}
+		time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+	}
+	log.Printf("handoff id=%s client_id=%s to=%s failed, session dropped: %v", rec.ID, rec.Session.ClientID, rec.To, lastErr)
+}
+
+func postHandoff(to string, body []byte) error {
+	req, err := newInternalRequest(http.MethodPost, to, "/internal/handoff", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := handoffClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// handleHandoff accepts a session transferred from the previous owner.
+func handleHandoff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if isFenced() {
+		writeFenced(w)
+		return
+	}
+	var rec handoffRecord
+	if err := json.NewDecoder(r.Body).Decode(&rec); err != nil || rec.ID == "" || rec.Session.ClientID == "" {
+		http.Error(w, "invalid handoff", http.StatusBadRequest)
+		return
+	}
+
+	status := "applied"
+	if !handoffsSeen.firstSeen(rec.ID) {
+		status = "duplicate"
+	} else {
+		rec.Session.Owner = selfTarget()
+		if !sessions.put(rec.Session) {
+			status = "stale"
+		}
+	}
+	log.Printf("/internal/handoff id=%s client_id=%s from=%s status=%s", rec.ID, rec.Session.ClientID, rec.From, status)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"status":     status,
+		"handoff_id": rec.ID,
+	})
+}
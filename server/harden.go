@@ -0,0 +1,146 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Basic protection for the public listener, applied before anything else looks at a request:
+//   - MAX_BODY_BYTES (default 8MiB) caps request bodies: a larger Content-Length is refused with
+//     413 BODY_TOO_LARGE, and a body that grows past it mid-read fails the handler's decode
+//   - MAX_URL_BYTES (default 8192) caps the request URI (path and query): 414 URI_TOO_LONG
+//   - every response gets X-Content-Type-Options: nosniff, X-Frame-Options: DENY,
+//     Referrer-Policy: no-referrer and Content-Security-Policy: frame-ancestors 'none'
+//     (SECURITY_HEADERS=off leaves them out)
+//   - ENDPOINT_METHODS restricts methods per path, e.g. "/join=GET,POST;/counter=GET"; by default
+//     the read-only endpoints (/where, /where/wait, /explain, /cluster/status,
+//     /cluster/assignments, /ring, /metrics, /slo, /health, /breakers, /weights, /config/peers)
+//     take GET and HEAD only. Other methods get 405 METHOD_NOT_ALLOWED with an Allow header;
+//     OPTIONS is always let through for CORS preflights
+//   - MAX_CONCURRENT_REQUESTS (default 0, off) caps requests in progress; requests beyond it get
+//     503 OVERLOADED with Retry-After: 1 instead of queueing. /health and /metrics are exempt, so
+//     probes and scrapes still answer under load, and so are the long-lived /ws, /events (and its
+//     gRPC-Web twin) and /where/wait, which would otherwise hold slots for minutes
+//
+// routing_rejected_requests_total{reason} counts refusals and routing_requests_in_flight the
+// requests holding a slot.
+
+var defaultEndpointMethods = map[string][]string{
+	"/where":               {http.MethodGet, http.MethodHead},
+	"/where/wait":          {http.MethodGet, http.MethodHead},
+	"/explain":             {http.MethodGet, http.MethodHead},
+	"/cluster/status":      {http.MethodGet, http.MethodHead},
+	"/cluster/assignments": {http.MethodGet, http.MethodHead},
+	"/ring":                {http.MethodGet, http.MethodHead},
+	"/metrics":             {http.MethodGet, http.MethodHead},
+	"/slo":                 {http.MethodGet, http.MethodHead},
+	"/health":              {http.MethodGet, http.MethodHead},
+	"/breakers":            {http.MethodGet, http.MethodHead},
+	"/weights":             {http.MethodGet, http.MethodHead},
+	"/config/peers":        {http.MethodGet, http.MethodHead},
+	"/canary/status":       {http.MethodGet, http.MethodHead},
+}
+
+// uncappedPaths don't take a concurrency slot.
+var uncappedPaths = []string{"/health", "/metrics", "/ws", "/events", grpcWebEventsPath, "/where/wait"}
+
+type hardening struct {
+	maxBody int64
+	maxURL  int
+	headers bool
+	methods map[string][]string
+	slots   chan struct{} // nil when uncapped
+}
+
+func hardeningFromEnv() *hardening {
+	h := &hardening{
+		maxBody: 8 << 20,
+		maxURL:  8192,
+		headers: !strings.EqualFold(strings.TrimSpace(os.Getenv("SECURITY_HEADERS")), "off"),
+		methods: make(map[string][]string, len(defaultEndpointMethods)),
+	}
+	if n, err := strconv.ParseInt(os.Getenv("MAX_BODY_BYTES"), 10, 64); err == nil && n > 0 {
+		h.maxBody = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("MAX_URL_BYTES")); err == nil && n > 0 {
+		h.maxURL = n
+	}
+	for path, ms := range defaultEndpointMethods {
+		h.methods[path] = ms
+	}
+	for _, entry := range strings.Split(os.Getenv("ENDPOINT_METHODS"), ";") {
+		path, list, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || !strings.HasPrefix(path, "/") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				log.Printf("ENDPOINT_METHODS: ignoring %q", entry)
+			}
+			continue
+		}
+		var ms []string
+		for _, m := range strings.Split(list, ",") {
+			if m = strings.ToUpper(strings.TrimSpace(m)); m != "" {
+				ms = append(ms, m)
+			}
+		}
+		h.methods[strings.TrimSpace(path)] = ms
+	}
+	if n, err := strconv.Atoi(os.Getenv("MAX_CONCURRENT_REQUESTS")); err == nil && n > 0 {
+		h.slots = make(chan struct{}, n)
+		metrics.gaugeFunc("routing_requests_in_flight", "Requests holding a MAX_CONCURRENT_REQUESTS slot.", func() float64 {
+			return float64(len(h.slots))
+		})
+		log.Printf("hardening: at most %d concurrent requests", n)
+	}
+	metrics.counter("routing_rejected_requests_total", "Requests refused by the hardening layer, by reason.")
+	return h
+}
+
+func withHardening(next http.Handler) http.Handler {
+	h := hardeningFromEnv()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.headers {
+			hd := w.Header()
+			hd.Set("X-Content-Type-Options", "nosniff")
+			hd.Set("X-Frame-Options", "DENY")
+			hd.Set("Referrer-Policy", "no-referrer")
+			hd.Set("Content-Security-Policy", "frame-ancestors 'none'")
+		}
+		if len(r.RequestURI) > h.maxURL {
+			h.reject(w, "uri_too_long", http.StatusRequestURITooLong, "URI_TOO_LONG", fmt.Sprintf("request URI exceeds %d bytes", h.maxURL))
+			return
+		}
+		if allowed, ok := h.methods[r.URL.Path]; ok && r.Method != http.MethodOptions && !slices.Contains(allowed, r.Method) {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			h.reject(w, "method", http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", r.Method+" is not allowed on "+r.URL.Path)
+			return
+		}
+		if r.ContentLength > h.maxBody {
+			h.reject(w, "body_too_large", http.StatusRequestEntityTooLarge, "BODY_TOO_LARGE", fmt.Sprintf("request body exceeds %d bytes", h.maxBody))
+			return
+		}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = http.MaxBytesReader(w, r.Body, h.maxBody)
+		}
+		if h.slots != nil && !slices.Contains(uncappedPaths, r.URL.Path) {
+			select {
+			case h.slots <- struct{}{}:
+				defer func() { <-h.slots }()
+			default:
+				w.Header().Set("Retry-After", "1")
+				h.reject(w, "overloaded", http.StatusServiceUnavailable, "OVERLOADED", "too many concurrent requests")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *hardening) reject(w http.ResponseWriter, reason string, status int, code, msg string) {
+	metrics.inc("routing_rejected_requests_total", "reason", reason)
+	writeError(w, status, code, msg)
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"log"
+	"math"
+	"os"
+	"strings"
+)
+
+// Key hashing. HASH_ALGORITHM picks the Hasher every hash placement goes through:
+//   - fnv1a32 (default): 32-bit FNV-1a, the placement this service has always used
+//   - fnv1a64: 64-bit FNV-1a, for a hash space too large for any ring to show 32-bit bias
+//   - sha256: the first 8 bytes of SHA-256, slower but evenly spread for adversarial IDs
+//
+// Index math stays in uint64 throughout (sum % n, never int(sum) % n), so no key maps to a
+// negative index on 32-bit builds, and numeric keys take their absolute value without
+// overflowing at the most negative int. Changing the algorithm moves most clients, so it is part
+// of the config fingerprint.
+
+// Hasher turns a routing key into a hash value of Bits() bits.
+type Hasher interface {
+	Name() string
+	Bits() int
+	Sum64(key string) uint64
+}
+
+// FNV-1a parameters, applied by hand so hashing a key doesn't allocate.
+const (
+	fnv32Offset = 2166136261
+	fnv32Prime  = 16777619
+	fnv64Offset = 14695981039346656037
+	fnv64Prime  = 1099511628211
+)
+
+type fnv1a32Hasher struct{}
+
+func (fnv1a32Hasher) Name() string { return "fnv1a32" }
+func (fnv1a32Hasher) Bits() int    { return 32 }
+func (fnv1a32Hasher) Sum64(key string) uint64 {
+	h := uint32(fnv32Offset)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= fnv32Prime
+	}
+	return uint64(h)
+}
+
+type fnv1a64Hasher struct{}
+
+func (fnv1a64Hasher) Name() string { return "fnv1a64" }
+func (fnv1a64Hasher) Bits() int    { return 64 }
+func (fnv1a64Hasher) Sum64(key string) uint64 {
+	h := uint64(fnv64Offset)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= fnv64Prime
+	}
+	return h
+}
+
+type sha256Hasher struct{}
+
+func (sha256Hasher) Name() string { return "sha256" }
+func (sha256Hasher) Bits() int    { return 64 }
+func (sha256Hasher) Sum64(key string) uint64 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+var hashers = map[string]Hasher{
+	"fnv1a32": fnv1a32Hasher{},
+	"fnv1a64": fnv1a64Hasher{},
+	"sha256":  sha256Hasher{},
+}
+
+var keyHasher = newHasherFromEnv()
+
+func newHasherFromEnv() Hasher {
+	name := strings.ToLower(strings.TrimSpace(os.Getenv("HASH_ALGORITHM")))
+	if name == "" {
+		return fnv1a32Hasher{}
+	}
+	h, ok := hashers[name]
+	if !ok {
+		log.Printf("unknown HASH_ALGORITHM=%q, using fnv1a32", name)
+		return fnv1a32Hasher{}
+	}
+	return h
+}
+
+// hashKey hashes a routing key, salted (see hashInput), with the configured Hasher.
+func hashKey(key string) uint64 {
+	return keyHasher.Sum64(hashInput(key))
+}
+
+// bucket maps sum onto [0, n) without leaving unsigned arithmetic. n must be positive.
+func bucket(sum uint64, n int) int {
+	return int(sum % uint64(n))
+}
+
+// absBucket is bucket for a signed number, using its absolute value.
+func absBucket(v int64, n int) int {
+	u := uint64(v)
+	if v < 0 {
+		u = -u // two's complement: exact even for math.MinInt64
+	}
+	return bucket(u, n)
+}
+
+// hashSpace is the number of values h can produce, as a float since 2^64 doesn't fit a uint64.
+func hashSpace(h Hasher) float64 {
+	return math.Ldexp(1, h.Bits())
+}
+
+// hashesInBucket counts the values of h's space that bucket to i out of n.
+func hashesInBucket(h Hasher, i, n int) uint64 {
+	top := ^uint64(0) >> (64 - h.Bits()) // largest value
+	if uint64(i) > top {
+		return 0
+	}
+	return (top-uint64(i))/uint64(n) + 1
+}
+
+// lastInBucket returns the largest value of h's space that buckets to i out of n. Only meaningful
+// when hashesInBucket is not 0.
+func lastInBucket(h Hasher, i, n int) uint64 {
+	top := ^uint64(0) >> (64 - h.Bits())
+	return top - (top-uint64(i))%uint64(n)
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"hash/fnv"
+	"math"
+	"strconv"
+	"testing"
+	"testing/quick"
+)
+
+// Properties of key hashing and index math. The small-space cases are exhaustive: every hash
+// value of an 8-bit Hasher against every ring size up to 300, so the bucket counts /ring reports
+// are checked against a brute-force count, not a formula.
+
+type tinyHasher struct{}
+
+func (tinyHasher) Name() string            { return "tiny" }
+func (tinyHasher) Bits() int               { return 8 }
+func (tinyHasher) Sum64(key string) uint64 { return uint64(len(key)) & 0xff }
+
+func TestFNVMatchesStdlib(t *testing.T) {
+	f := func(key string) bool {
+		h32, h64 := fnv.New32a(), fnv.New64a()
+		_, _ = h32.Write([]byte(key))
+		_, _ = h64.Write([]byte(key))
+		return fnv1a32Hasher{}.Sum64(key) == uint64(h32.Sum32()) && fnv1a64Hasher{}.Sum64(key) == h64.Sum64()
+	}
+	if err := quick.Check(f, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHashersStayInTheirSpace(t *testing.T) {
+	for name, h := range hashers {
+		f := func(key string) bool {
+			return h.Bits() == 64 || h.Sum64(key) < uint64(hashSpace(h))
+		}
+		if err := quick.Check(f, nil); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestBucketInRange(t *testing.T) {
+	edges := []uint64{0, 1, math.MaxInt32, math.MaxUint32, math.MaxUint32 + 1, math.MaxInt64, 1 << 63, math.MaxUint64 - 1, math.MaxUint64}
+	for n := 1; n <= 1024; n++ {
+		for _, sum := range edges {
+			if b := bucket(sum, n); b < 0 || b >= n {
+				t.Fatalf("bucket(%d, %d) = %d", sum, n, b)
+			}
+		}
+	}
+	f := func(sum uint64, n uint16) bool {
+		m := int(n) + 1
+		b := bucket(sum, m)
+		return b >= 0 && b < m && uint64(b) == sum%uint64(m)
+	}
+	if err := quick.Check(f, &quick.Config{MaxCount: 5000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAbsBucket(t *testing.T) {
+	for n := 1; n <= 1024; n++ {
+		for _, v := range []int64{math.MinInt64, math.MinInt64 + 1, -1, 0, 1, math.MaxInt64} {
+			if b := absBucket(v, n); b < 0 || b >= n {
+				t.Fatalf("absBucket(%d, %d) = %d", v, n, b)
+			}
+		}
+		if got, want := absBucket(math.MinInt64, n), bucket(1<<63, n); got != want {
+			t.Fatalf("absBucket(MinInt64, %d) = %d, want %d", n, got, want)
+		}
+	}
+	f := func(v int64, n uint16) bool {
+		m := int(n) + 1
+		return v == math.MinInt64 || absBucket(v, m) == absBucket(-v, m)
+	}
+	if err := quick.Check(f, &quick.Config{MaxCount: 5000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHashesInBucketExhaustive(t *testing.T) {
+	h := tinyHasher{}
+	for n := 1; n <= 300; n++ {
+		counts := make([]uint64, n)
+		last := make([]uint64, n)
+		for v := 0; v < 256; v++ {
+			counts[bucket(uint64(v), n)]++
+			last[bucket(uint64(v), n)] = uint64(v)
+		}
+		for i := range n {
+			if got := hashesInBucket(h, i, n); got != counts[i] {
+				t.Fatalf("n=%d bucket %d: hashesInBucket = %d, brute force %d", n, i, got, counts[i])
+			}
+			if got := lastInBucket(h, i, n); counts[i] > 0 && got != last[i] {
+				t.Fatalf("n=%d bucket %d: lastInBucket = %d, brute force %d", n, i, got, last[i])
+			}
+		}
+	}
+}
+
+func TestHashesInBucketCoverSpace(t *testing.T) {
+	for name, h := range hashers {
+		for _, n := range []int{1, 2, 3, 5, 7, 64, 1000, 65537} {
+			var total uint64
+			lo, hi := uint64(math.MaxUint64), uint64(0)
+			for i := range n {
+				c := hashesInBucket(h, i, n)
+				total += c
+				lo, hi = min(lo, c), max(hi, c)
+			}
+			// 2^64 wraps to 0 in a uint64.
+			if want := uint64(hashSpace(h)); h.Bits() < 64 && total != want || h.Bits() == 64 && total != 0 {
+				t.Errorf("%s n=%d: buckets cover %d values", name, n, total)
+			}
+			if hi-lo > 1 {
+				t.Errorf("%s n=%d: bucket sizes range %d..%d", name, n, lo, hi)
+			}
+		}
+	}
+}
+
+func TestIndexForInRange(t *testing.T) {
+	saved := keyHasher
+	t.Cleanup(func() { keyHasher = saved })
+	for name, h := range hashers {
+		keyHasher = h
+		for _, mode := range []string{"hash", "numeric"} {
+			f := func(key string, n uint8, base int8) bool {
+				replicas := int(n) + 1
+				idx := indexFor(key, replicas, int(base), mode)
+				return idx >= int(base) && idx < int(base)+replicas
+			}
+			if err := quick.Check(f, &quick.Config{MaxCount: 2000}); err != nil {
+				t.Errorf("%s/%s: %v", name, mode, err)
+			}
+			for _, v := range []int64{math.MinInt64, -1, math.MaxInt64} {
+				if idx := indexFor(strconv.FormatInt(v, 10), 7, 1, mode); idx < 1 || idx > 7 {
+					t.Errorf("%s/%s: indexFor(%d) = %d", name, mode, v, idx)
+				}
+			}
+		}
+	}
+}
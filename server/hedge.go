@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Request hedging on the gateway's reverse proxy. With HEDGE_DELAY set (e.g. 50ms), a forwarded
+// GET or HEAD that the owner has not answered within the delay is also sent to the owner's next
+// healthy ring candidate (the failover successor); the first successful response (any status
+// below 500) is used and the other request is canceled. Both replicas may execute the request, so
+// only the endpoints in HEDGE_ENDPOINTS (default "counter") are hedged: /join is not, since a
+// second join would register the client on the candidate too. Upgrades are never hedged.
+
+type hedgingTransport struct {
+	base      http.RoundTripper
+	delay     time.Duration
+	endpoints []string
+}
+
+func newHedgingTransport(base http.RoundTripper) http.RoundTripper {
+	d, err := time.ParseDuration(os.Getenv("HEDGE_DELAY"))
+	if err != nil || d <= 0 {
+		return base
+	}
+	t := &hedgingTransport{base: base, delay: d, endpoints: []string{"counter"}}
+	if v, ok := os.LookupEnv("HEDGE_ENDPOINTS"); ok {
+		t.endpoints = nil
+		for _, e := range strings.Split(v, ",") {
+			if e = strings.Trim(strings.TrimSpace(e), "/"); e != "" {
+				t.endpoints = append(t.endpoints, e)
+			}
+		}
+	}
+	metrics.counter("routing_gateway_hedges_total", "Hedged forwards, by which request answered (primary, hedge or none).")
+	log.Printf("gateway: hedging %s after %s", strings.Join(t.endpoints, ","), d)
+	return t
+}
+
+func (t *hedgingTransport) hedges(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		return false
+	}
+	// Pool forwards go to targets outside the ring, which has no candidate for them.
+	return slices.Contains(t.endpoints, strings.Trim(req.URL.Path, "/")) && slices.Contains(allTargets(), req.URL.Host)
+}
+
+type hedgeResult struct {
+	resp   *http.Response
+	err    error
+	hedge  bool
+	cancel context.CancelFunc
+}
+
+func (r hedgeResult) ok() bool {
+	return r.err == nil && r.resp.StatusCode < http.StatusInternalServerError
+}
+
+// discard releases a response nobody will read.
+func (r hedgeResult) discard() {
+	if r.resp != nil {
+		r.resp.Body.Close()
+	}
+	r.cancel()
+}
+
+func (t *hedgingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.hedges(req) {
+		return t.base.RoundTrip(req)
+	}
+	results := make(chan hedgeResult, 2)
+	send := func(r *http.Request, hedge bool) context.CancelFunc {
+		ctx, cancel := context.WithCancel(r.Context())
+		go func() {
+			resp, err := t.base.RoundTrip(r.WithContext(ctx))
+			results <- hedgeResult{resp: resp, err: err, hedge: hedge, cancel: cancel}
+		}()
+		return cancel
+	}
+	cancelPrimary := send(req, false)
+
+	timer := time.NewTimer(t.delay)
+	defer timer.Stop()
+	select {
+	case first := <-results:
+		return first.answer()
+	case <-timer.C:
+	}
+
+	cands := failover.candidatesAfter(req.URL.Host)
+	if len(cands) == 0 {
+		return (<-results).answer()
+	}
+	alt := req.Clone(req.Context())
+	alt.URL.Host, alt.Host = cands[0], cands[0]
+	alt.Header.Set("x-routing-target", cands[0])
+	cancelHedge := send(alt, true)
+
+	first := <-results
+	if first.ok() {
+		// Cancel the loser now; its result is released whenever it comes back.
+		if first.hedge {
+			cancelPrimary()
+		} else {
+			cancelHedge()
+		}
+		go func() { (<-results).discard() }()
+		return first.won()
+	}
+	second := <-results
+	if second.ok() {
+		first.discard()
+		return second.won()
+	}
+	// Neither succeeded: answer with the owner's failure, as an unhedged forward would.
+	if first.hedge {
+		first, second = second, first
+	}
+	second.discard()
+	metrics.inc("routing_gateway_hedges_total", "winner", "none")
+	return first.answer()
+}
+
+// won counts r as the winner of a hedge and returns its response.
+func (r hedgeResult) won() (*http.Response, error) {
+	if r.hedge {
+		metrics.inc("routing_gateway_hedges_total", "winner", "hedge")
+		r.resp.Header.Set("X-Hedged-To", r.resp.Request.URL.Host)
+	} else {
+		metrics.inc("routing_gateway_hedges_total", "winner", "primary")
+	}
+	return r.answer()
+}
+
+// answer returns r's response, keeping its request alive until the body is closed.
+func (r hedgeResult) answer() (*http.Response, error) {
+	if r.err != nil {
+		r.cancel()
+		return nil, r.err
+	}
+	r.resp.Body = &onClose{ReadCloser: r.resp.Body, fn: r.cancel}
+	return r.resp, nil
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Idempotent joins. A client that retries /join after a timeout can't tell whether the first
+// attempt registered it, and the retry repeats its side effects: another registry write, another
+// "assigned" event, another handoff and mirror record. A /join carrying an Idempotency-Key header
+// is therefore run once per key: the first request's response (status, body, Content-Type and
+// Location) is kept for IDEMPOTENCY_WINDOW (default 5m, 0 turns deduplication off), and a repeat
+// of the key within the window gets it back with Idempotent-Replayed: true, without touching
+// sessions or the registry. A repeat that arrives while the first request is still running waits
+// for it. Responses of 500 and above are not kept, so a retry after a failure runs again. A key
+// belongs to the client_id it was first used with; reusing it for another client answers 422
+// IDEMPOTENCY_KEY_REUSED.
+//
+// Keys are held per replica, at most IDEMPOTENCY_MAX_KEYS (default 10000; the oldest go first).
+// Envoy and the gateway send a client's joins to its owner, so a retry meets the first attempt
+// unless the owner changed in between, and then the join runs on the new owner as it should.
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+type idempotentJoin struct {
+	key      string
+	clientID string
+	at       time.Time
+	ready    chan struct{} // closed once the first request finished
+
+	// Set before ready is closed.
+	kept        bool
+	status      int
+	contentType string
+	location    string
+	body        []byte
+}
+
+type idempotencyCache struct {
+	window  time.Duration
+	maxKeys int
+
+	mu      sync.Mutex
+	entries map[string]*idempotentJoin
+	order   []*idempotentJoin // oldest first
+}
+
+var joinIdempotency = newIdempotencyCacheFromEnv()
+
+func newIdempotencyCacheFromEnv() *idempotencyCache {
+	c := &idempotencyCache{window: 5 * time.Minute, maxKeys: 10000, entries: make(map[string]*idempotentJoin)}
+	if d, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_WINDOW")); err == nil && d >= 0 {
+		c.window = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("IDEMPOTENCY_MAX_KEYS")); err == nil && n > 0 {
+		c.maxKeys = n
+	}
+	metrics.counter("routing_join_idempotency_total", "Joins carrying an Idempotency-Key, by result (first, replayed, reused).")
+	metrics.gaugeFunc("routing_join_idempotency_keys", "Idempotency keys held for replay.", func() float64 {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return float64(len(c.entries))
+	})
+	return c
+}
+
+// begin looks up the request's Idempotency-Key. It answers a repeat itself and returns done;
+// otherwise it returns the writer the join should respond through (w itself without a key) and a
+// finish func to call once the response is written.
+func (c *idempotencyCache) begin(w http.ResponseWriter, r *http.Request, clientID string) (http.ResponseWriter, func(), bool) {
+	key := r.Header.Get(idempotencyKeyHeader)
+	if key == "" || c.window == 0 {
+		return w, func() {}, false
+	}
+	if len(key) > 255 {
+		writeError(w, http.StatusBadRequest, "INVALID_IDEMPOTENCY_KEY", "Idempotency-Key is longer than 255 bytes")
+		return w, nil, true
+	}
+	for {
+		now := clock.Now()
+		c.mu.Lock()
+		c.pruneLocked(now)
+		e, ok := c.entries[key]
+		if !ok {
+			e = &idempotentJoin{key: key, clientID: clientID, at: now, ready: make(chan struct{})}
+			c.entries[key] = e
+			c.order = append(c.order, e)
+			c.mu.Unlock()
+			metrics.inc("routing_join_idempotency_total", "result", "first")
+			rec := &idempotencyRecorder{ResponseWriter: w, status: http.StatusOK}
+			return rec, func() { c.finish(e, rec) }, false
+		}
+		c.mu.Unlock()
+		if e.clientID != clientID {
+			metrics.inc("routing_join_idempotency_total", "result", "reused")
+			writeError(w, http.StatusUnprocessableEntity, "IDEMPOTENCY_KEY_REUSED", "Idempotency-Key was used for client_id "+e.clientID)
+			return w, nil, true
+		}
+		select {
+		case <-e.ready:
+		case <-r.Context().Done():
+			return w, nil, true
+		}
+		if !e.kept {
+			continue // the first attempt failed and was dropped: run this one
+		}
+		metrics.inc("routing_join_idempotency_total", "result", "replayed")
+		h := w.Header()
+		h.Set("Idempotent-Replayed", "true")
+		if e.contentType != "" {
+			h.Set("Content-Type", e.contentType)
+		}
+		if e.location != "" {
+			h.Set("Location", e.location)
+		}
+		w.WriteHeader(e.status)
+		_, _ = w.Write(e.body)
+		return w, nil, true
+	}
+}
+
+// finish keeps the first response for replay, or drops the key when it failed.
+func (c *idempotencyCache) finish(e *idempotentJoin, rec *idempotencyRecorder) {
+	h := rec.Header()
+	e.status, e.contentType, e.location = rec.status, h.Get("Content-Type"), h.Get("Location")
+	e.body = rec.body.Bytes()
+	e.kept = rec.status < http.StatusInternalServerError
+	if !e.kept {
+		c.mu.Lock()
+		if c.entries[e.key] == e {
+			delete(c.entries, e.key)
+		}
+		c.mu.Unlock()
+	}
+	close(e.ready)
+}
+
+// pruneLocked drops keys older than the window, and the oldest beyond maxKeys.
+func (c *idempotencyCache) pruneLocked(now time.Time) {
+	n, evicted := 0, 0
+	for n < len(c.order) {
+		e := c.order[n]
+		live := c.entries[e.key] == e
+		if live && now.Sub(e.at) < c.window && len(c.order)-n < c.maxKeys {
+			break
+		}
+		if live {
+			if now.Sub(e.at) < c.window {
+				evicted++
+			}
+			delete(c.entries, e.key)
+		}
+		n++
+	}
+	if n > 0 {
+		c.order = append(c.order[:0], c.order[n:]...)
+	}
+	noteEvictions("idempotency_keys", evicted)
+}
+
+func (c *idempotencyCache) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+func (c *idempotencyCache) limit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.maxKeys
+}
+
+// resize sets the most keys held, dropping the oldest beyond it.
+func (c *idempotencyCache) resize(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxKeys = n
+	c.pruneLocked(clock.Now())
+}
+
+// idempotencyRecorder passes a join's response through while keeping a copy.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *idempotencyRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *idempotencyRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
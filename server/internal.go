@@ -0,0 +1,130 @@
+package server
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Internal (replica-to-replica) API. It listens on INTERNAL_PORT, separate from the
+// Envoy-facing PORT, and authenticates peers with a shared secret (INTERNAL_TOKEN, sent as
+// X-Internal-Token) and/or mTLS (INTERNAL_TLS_CERT, INTERNAL_TLS_KEY, INTERNAL_TLS_CA).
+
+const internalTokenHeader = "X-Internal-Token"
+
+func internalPort() string {
+	if v := strings.TrimSpace(os.Getenv("INTERNAL_PORT")); v != "" {
+		return v
+	}
+	return "8082"
+}
+
+func internalTLSEnabled() bool {
+	return os.Getenv("INTERNAL_TLS_CERT") != "" && os.Getenv("INTERNAL_TLS_KEY") != ""
+}
+
+// internalURL builds the internal API URL for a peer given its public host:port.
+func internalURL(hostport, path string) string {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	scheme := "http"
+	if internalTLSEnabled() {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(host, internalPort()), path)
+}
+
+// requireInternalAuth rejects requests that don't carry the shared secret, when one is configured.
+// Client certificates are already verified by the TLS layer when mTLS is enabled.
+func requireInternalAuth(next http.Handler) http.Handler {
+	token := os.Getenv("INTERNAL_TOKEN")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(internalTokenHeader)), []byte(token)) != 1 {
+			log.Printf("internal auth rejected path=%s remote=%s", r.URL.Path, r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newInternalRequest builds a request to a peer's internal API with auth attached.
+func newInternalRequest(method, hostport, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, internalURL(hostport, path), body)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("INTERNAL_TOKEN"); token != "" {
+		req.Header.Set(internalTokenHeader, token)
+	}
+	return req, nil
+}
+
+// internalTLSConfig loads the mTLS material shared by the internal listener and client.
+func internalTLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(os.Getenv("INTERNAL_TLS_CERT"), os.Getenv("INTERNAL_TLS_KEY"))
+	if err != nil {
+		return nil, fmt.Errorf("load internal cert: %w", err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if caFile := os.Getenv("INTERNAL_TLS_CA"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read internal ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// newInternalClient returns the HTTP client used for replica-to-replica calls.
+func newInternalClient(timeout time.Duration) *http.Client {
+	var base http.RoundTripper = http.DefaultTransport
+	if internalTLSEnabled() {
+		cfg, err := internalTLSConfig()
+		if err != nil {
+			log.Fatalf("internal tls: %v", err)
+		}
+		base = &http.Transport{TLSClientConfig: cfg}
+	}
+	return &http.Client{Timeout: timeout, Transport: timedTransport{base: base}}
+}
+
+// serveInternal starts the internal listener with its own mux.
+func serveInternal(mux *http.ServeMux) {
+	addr := ":" + internalPort()
+	if v := strings.TrimSpace(os.Getenv("INTERNAL_BIND")); v != "" {
+		addr = v
+	}
+	srv := &http.Server{Addr: addr, Handler: requireInternalAuth(mux), Protocols: serverProtocols()}
+	if internalTLSEnabled() {
+		cfg, err := internalTLSConfig()
+		if err != nil {
+			log.Fatalf("internal tls: %v", err)
+		}
+		srv.TLSConfig = cfg
+		log.Printf("internal api starting on %s (mtls)", addr)
+		log.Fatalf("internal listen and serve: %v", srv.ListenAndServeTLS("", ""))
+	}
+	if os.Getenv("INTERNAL_TOKEN") == "" {
+		log.Printf("warning: INTERNAL_TOKEN not set, internal api on %s is unauthenticated", addr)
+	}
+	log.Printf("internal api starting on %s", addr)
+	log.Fatalf("internal listen and serve: %v", srv.ListenAndServe())
+}
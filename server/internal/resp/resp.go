@@ -0,0 +1,191 @@
+// Package resp is a minimal RESP2 client with a small connection pool, enough for the server's
+// Redis-backed registry, leases and membership, and for cmd/migrate.
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// ErrNil is returned for a nil bulk reply (missing key).
+var ErrNil = errors.New("redis: nil")
+
+// Error is an error reply from the server.
+type Error string
+
+func (e Error) Error() string { return "redis: " + string(e) }
+
+// Client sends commands to one Redis address over pooled connections.
+type Client struct {
+	addr    string
+	timeout time.Duration
+	pool    chan *conn
+}
+
+type conn struct {
+	c net.Conn
+	r *bufio.Reader
+}
+
+// NewClient returns a client keeping up to poolSize idle connections. timeout bounds dialing and
+// each round trip.
+func NewClient(addr string, poolSize int, timeout time.Duration) *Client {
+	return &Client{addr: addr, timeout: timeout, pool: make(chan *conn, poolSize)}
+}
+
+func (c *Client) get() (*conn, error) {
+	select {
+	case cn := <-c.pool:
+		return cn, nil
+	default:
+	}
+	nc, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{c: nc, r: bufio.NewReader(nc)}, nil
+}
+
+func (c *Client) put(cn *conn) {
+	select {
+	case c.pool <- cn:
+	default:
+		cn.c.Close()
+	}
+}
+
+// Do sends one command and returns its reply: string, int64, nil, []any, or an Error.
+func (c *Client) Do(args ...string) (any, error) {
+	replies, err := c.Pipeline([][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := replies[0].(Error); ok {
+		return nil, e
+	}
+	return replies[0], nil
+}
+
+// Pipeline sends several commands on one connection and reads all replies in order.
+// Server error replies are returned in place as Error values.
+func (c *Client) Pipeline(cmds [][]string) ([]any, error) {
+	cn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+	_ = cn.c.SetDeadline(time.Now().Add(c.timeout))
+	w := bufio.NewWriter(cn.c)
+	for _, args := range cmds {
+		fmt.Fprintf(w, "*%d\r\n", len(args))
+		for _, a := range args {
+			fmt.Fprintf(w, "$%d\r\n%s\r\n", len(a), a)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		cn.c.Close()
+		return nil, err
+	}
+	out := make([]any, 0, len(cmds))
+	for range cmds {
+		v, err := read(cn.r)
+		if err != nil {
+			cn.c.Close()
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	c.put(cn)
+	return out, nil
+}
+
+func read(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 {
+		return nil, fmt.Errorf("redis: short reply %q", line)
+	}
+	line = line[:len(line)-2]
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		arr := make([]any, n)
+		for i := range arr {
+			if arr[i], err = read(r); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// GetString runs GET key, returning ErrNil when the key is missing.
+func (c *Client) GetString(key string) (string, error) {
+	v, err := c.Do("GET", key)
+	if err != nil {
+		return "", err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", ErrNil
+	}
+	return s, nil
+}
+
+// ScanKeys returns all keys matching pattern using SCAN.
+func (c *Client) ScanKeys(pattern string) ([]string, error) {
+	var keys []string
+	cursor := "0"
+	for {
+		v, err := c.Do("SCAN", cursor, "MATCH", pattern, "COUNT", "1000")
+		if err != nil {
+			return nil, err
+		}
+		arr, ok := v.([]any)
+		if !ok || len(arr) != 2 {
+			return nil, fmt.Errorf("redis: bad SCAN reply")
+		}
+		cursor, _ = arr[0].(string)
+		batch, _ := arr[1].([]any)
+		for _, k := range batch {
+			if s, ok := k.(string); ok {
+				keys = append(keys, s)
+			}
+		}
+		if cursor == "0" {
+			return keys, nil
+		}
+	}
+}
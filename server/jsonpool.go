@@ -0,0 +1,53 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// whereResponse is the /where answer. The field order and omitempty keep the body identical to
+// the map it replaced, which cost a map and its boxed values on every request. Degraded is set
+// while registry writes are deferred.
+type whereResponse struct {
+	ClientID     string   `json:"client_id"`
+	HostPort     string   `json:"hostport"`
+	Standby      string   `json:"standby,omitempty"`
+	TableVersion uint64   `json:"table_version"`
+	Targets      []string `json:"targets,omitempty"`
+	Degraded     bool     `json:"degraded,omitempty"`
+	Reachable    *bool    `json:"reachable,omitempty"`
+}
+
+// whereResponses recycles /where answers, which escape to the encoder.
+var whereResponses = sync.Pool{New: func() any { return new(whereResponse) }}
+
+// jsonContentType is shared by the hot path responses; it must not be modified in place.
+var jsonContentType = []string{"application/json"}
+
+// pooledEncoder is an encoder bound to its own buffer, reused across responses.
+type pooledEncoder struct {
+	buf bytes.Buffer
+	enc *json.Encoder
+}
+
+var encoders = sync.Pool{New: func() any {
+	e := &pooledEncoder{}
+	e.enc = json.NewEncoder(&e.buf)
+	return e
+}}
+
+// writeJSON encodes v into a pooled buffer and writes it to w in one call. Encoding errors are
+// ignored like json.NewEncoder(w).Encode's are elsewhere.
+func writeJSON(w http.ResponseWriter, v any) {
+	e := encoders.Get().(*pooledEncoder)
+	e.buf.Reset()
+	if e.enc.Encode(v) == nil {
+		_, _ = w.Write(e.buf.Bytes())
+	}
+	// A rare huge body would pin its buffer in the pool.
+	if e.buf.Cap() <= 64<<10 {
+		encoders.Put(e)
+	}
+}
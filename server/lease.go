@@ -0,0 +1,214 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"personal/poc-routing/server/internal/resp"
+)
+
+// Ordinal fencing. On boot each replica takes the registry lease for its own target name with a
+// fresh epoch (newest claimant wins) and renews it every LEASE_TTL/3 (LEASE_TTL default 10s).
+// If a renewal finds another holder, a second process has claimed the same ordinal (e.g. during a
+// forced pod replacement): this process is fenced for the rest of its life. It refuses /join and
+// incoming handoffs with 409 FENCED, reports unhealthy, and sheds its local sessions.
+// Needs a shared registry backend (redis); with REGISTRY_BACKEND=memory fencing is off.
+
+var errFenced = errors.New("replica is fenced: another process owns this ordinal")
+
+// leaser is implemented by registry backends that support ownership leases.
+type leaser interface {
+	// AcquireLease takes key for holder unconditionally and returns the new epoch.
+	AcquireLease(key, holder string, ttl time.Duration) (int64, error)
+	// RenewLease extends key if it is still held by holder.
+	RenewLease(key, holder string, ttl time.Duration) (bool, error)
+	// LeaseHolder returns the current holder of key ("" if free).
+	LeaseHolder(key string) (string, error)
+}
+
+type ordinalLease struct {
+	mu     sync.Mutex // guards key, holder and epoch, read by leaseStatus
+	key    string
+	holder string
+	epoch  int64
+	fenced atomic.Bool
+}
+
+// acquire takes the lease for this process with a new epoch.
+func (o *ordinalLease) acquire(l leaser, ttl time.Duration) error {
+	key, _, _ := o.snapshot()
+	epoch, err := l.AcquireLease(key, instanceID, ttl)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	o.epoch = epoch
+	o.holder = fmt.Sprintf("%s:%d", instanceID, epoch)
+	o.mu.Unlock()
+	log.Printf("lease %s acquired epoch=%d instance=%s", key, epoch, instanceID)
+	return nil
+}
+
+func (o *ordinalLease) snapshot() (key, holder string, epoch int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.key, o.holder, o.epoch
+}
+
+var selfLease = &ordinalLease{}
+
+// instanceID distinguishes this process from any other claiming the same ordinal.
+var instanceID = newHandoffID()
+
+// selfTarget returns this replica's configured routing target name, falling back to
+// SELF_HOSTPORT and then getSelf().
+func selfTarget() string {
+	for _, t := range staticTargets() {
+		if isSelfTarget(t) {
+			return t
+		}
+	}
+	if v := os.Getenv("SELF_HOSTPORT"); v != "" {
+		return v
+	}
+	return getSelf()
+}
+
+func isFenced() bool {
+	return selfLease.fenced.Load()
+}
+
+func leaseTTL() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("LEASE_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return 10 * time.Second
+}
+
+// runOrdinalLease acquires and keeps renewing this replica's ordinal lease.
+func runOrdinalLease() {
+	l, ok := registryAs[leaser]()
+	if !ok {
+		return
+	}
+	metrics.gaugeFunc("routing_fenced", "1 when this replica lost its ordinal lease to another process.", func() float64 {
+		if isFenced() {
+			return 1
+		}
+		return 0
+	})
+	metrics.counter("routing_lease_errors_total", "Lease acquire/renew calls that failed.")
+
+	ttl := leaseTTL()
+	selfLease.mu.Lock()
+	selfLease.key = "poc-routing:lease:" + selfTarget()
+	selfLease.mu.Unlock()
+	for {
+		err := selfLease.acquire(l, ttl)
+		if err == nil {
+			break
+		}
+		metrics.inc("routing_lease_errors_total")
+		log.Printf("lease %s acquire failed: %v", selfLease.key, err)
+		clock.Sleep(ttl / 3)
+	}
+
+	for range clock.Tick(ttl / 3) {
+		key, holder, _ := selfLease.snapshot()
+		held, err := l.RenewLease(key, holder, ttl)
+		if err != nil {
+			metrics.inc("routing_lease_errors_total")
+			log.Printf("lease %s renew failed: %v", key, err)
+			continue
+		}
+		if held {
+			continue
+		}
+		other, err := l.LeaseHolder(key)
+		if err != nil {
+			metrics.inc("routing_lease_errors_total")
+			log.Printf("lease %s holder lookup failed: %v", key, err)
+			continue
+		}
+		if other == "" {
+			// The lease expired with nobody else claiming it, e.g. after a registry restart or an
+			// outage longer than LEASE_TTL: take it again rather than fence a healthy replica.
+			log.Printf("lease %s expired, re-acquiring", key)
+			if err := selfLease.acquire(l, ttl); err != nil {
+				metrics.inc("routing_lease_errors_total")
+				log.Printf("lease %s acquire failed: %v", key, err)
+			}
+			continue
+		}
+		fence(other)
+		return
+	}
+}
+
+// fence marks this replica stale and sheds every local session.
+func fence(other string) {
+	if selfLease.fenced.Swap(true) {
+		return
+	}
+	shed := sessions.takeAll()
+	key, holder, _ := selfLease.snapshot()
+	log.Printf("FENCED: lease %s now held by %q (we were %s); shedding %d sessions", key, other, holder, len(shed))
+	for _, sess := range shed {
+		events.emit(eventShed, sess.ClientID, sess.Owner, "", "")
+	}
+}
+
+const redisRenewScript = `if redis.call('GET', KEYS[1]) == ARGV[1] then return redis.call('PEXPIRE', KEYS[1], ARGV[2]) else return 0 end`
+
+func (r *redisRegistry) AcquireLease(key, holder string, ttl time.Duration) (int64, error) {
+	v, err := r.client.Do("INCR", key+":epoch")
+	if err != nil {
+		return 0, err
+	}
+	epoch, _ := v.(int64)
+	_, err = r.client.Do("SET", key, fmt.Sprintf("%s:%d", holder, epoch), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return epoch, err
+}
+
+func (r *redisRegistry) RenewLease(key, holder string, ttl time.Duration) (bool, error) {
+	v, err := r.client.Do("EVAL", redisRenewScript, "1", key, holder, strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		return false, err
+	}
+	n, _ := v.(int64)
+	return n == 1, nil
+}
+
+func (r *redisRegistry) LeaseHolder(key string) (string, error) {
+	s, err := r.client.GetString(key)
+	if err == resp.ErrNil {
+		return "", nil
+	}
+	return s, err
+}
+
+// writeFenced responds 409 FENCED.
+func writeFenced(w http.ResponseWriter) {
+	writeError(w, http.StatusConflict, "FENCED", errFenced.Error())
+}
+
+// leaseStatus summarizes the lease for status endpoints (nil when fencing is off).
+func leaseStatus() map[string]any {
+	key, holder, epoch := selfLease.snapshot()
+	if key == "" {
+		return nil
+	}
+	return map[string]any{
+		"key":    key,
+		"holder": holder,
+		"epoch":  epoch,
+		"fenced": isFenced(),
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Public listeners. LISTEN replaces the default :PORT with a comma-separated list of addresses:
+// host:port or tcp://host:port, and unix:///path for a Unix domain socket, so a sidecar Envoy in
+// the same pod can reach the server without the localhost TCP hop
+// (LISTEN=unix:///var/run/routing.sock,:8081 serves both). A stale socket file left by a crashed
+// process is removed first, and the socket gets LISTEN_SOCKET_MODE (octal, default 0660). The
+// internal API and target names still use INTERNAL_PORT and PORT.
+
+// publicListeners opens the LISTEN addresses, or addr when LISTEN is unset.
+func publicListeners(addr string) ([]net.Listener, error) {
+	spec := os.Getenv("LISTEN")
+	if strings.TrimSpace(spec) == "" {
+		spec = addr
+	}
+	var out []net.Listener
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		ln, err := listenOn(entry)
+		if err != nil {
+			for _, l := range out {
+				_ = l.Close()
+			}
+			return nil, err
+		}
+		log.Printf("listening on %s", entry)
+		out = append(out, ln)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("LISTEN %q has no addresses", spec)
+	}
+	return out, nil
+}
+
+func listenOn(entry string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(entry, "unix://")
+	if !ok {
+		return net.Listen("tcp", strings.TrimPrefix(entry, "tcp://"))
+	}
+	if path == "" {
+		return nil, fmt.Errorf("listen %s: missing socket path", entry)
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&fs.ModeSocket != 0 {
+		if c, err := net.Dial("unix", path); err == nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("listen %s: socket is in use", entry)
+		}
+		_ = os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	mode := os.FileMode(0o660)
+	if v := os.Getenv("LISTEN_SOCKET_MODE"); v != "" {
+		if m, err := strconv.ParseUint(v, 8, 32); err == nil {
+			mode = os.FileMode(m)
+		} else {
+			log.Printf("ignoring invalid LISTEN_SOCKET_MODE=%q", v)
+		}
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
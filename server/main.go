@@ -1,105 +1,226 @@
-package main
+// Package server is the routing replica and, in gateway mode, the stateless routing tier in front
+// of the replicas. cmd/server and cmd/gateway run it.
+package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"hash/fnv"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
-// getSelf returns this container's host:port string using env PORT and os.Hostname().
-func getSelf() string {
+// getSelf returns this container's host:port string using env PORT and os.Hostname(). Both are
+// fixed for the life of the process, so the answer is computed once.
+var getSelf = sync.OnceValue(func() string {
 	hostname, _ := os.Hostname()
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8081"
 	}
 	return fmt.Sprintf("%s:%s", hostname, port)
-}
+})
 
 // pickByHashLegacy uses SERVER_PEERS if provided (legacy path)
 func pickByHashLegacy(clientID string) string {
-	peers := os.Getenv("SERVER_PEERS")
-	if peers == "" {
+	filtered := legacyPeers()
+	if len(filtered) == 0 {
 		return getSelf()
 	}
-	parts := strings.Split(peers, ",")
-	filtered := make([]string, 0, len(parts))
-	for _, p := range parts {
-		p = strings.TrimSpace(p)
-		if p != "" {
-			filtered = append(filtered, p)
+	return filtered[bucket(hashKey(routingKey(clientID)), len(filtered))]
+}
+
+// routingKey is the part of clientID that is hashed. With GROUP_DELIMITER set (e.g. ":"), IDs
+// sharing a group prefix such as site42:device7 and site42:controller hash to the same replica.
+// TOPIC_LEVELS takes precedence and keeps the leading levels of a topic (see topics.go).
+func routingKey(clientID string) string {
+	if key, ok := topicKey(clientID); ok {
+		return key
+	}
+	if d := os.Getenv("GROUP_DELIMITER"); d != "" {
+		if group, _, ok := strings.Cut(clientID, d); ok {
+			return group
 		}
 	}
-	if len(filtered) == 0 {
-		return getSelf()
+	return clientID
+}
+
+// hashInput is what a routing key is hashed as: with ROUTING_SALT set (e.g. the cluster name),
+// the salt and a NUL byte come first, so the same client_id lands independently in each
+// environment. Numeric INDEX_MODE keys are used as numbers and are not salted.
+func hashInput(key string) string {
+	if salt := os.Getenv("ROUTING_SALT"); salt != "" {
+		return salt + "\x00" + key
 	}
-	h := fnv.New32a()
-	_, _ = h.Write([]byte(clientID))
-	idx := int(h.Sum32()) % len(filtered)
-	return filtered[idx]
+	return key
 }
 
 // computeIndex returns the replica index using either numeric or hash mode,
 // and applies INDEX_BASE offset (1 for Compose, 0 for K8s StatefulSet).
 func computeIndex(clientID string, replicas int) int {
+	return indexFor(routingKey(clientID), replicas, indexBase(), os.Getenv("INDEX_MODE"))
+}
+
+// indexFor maps a routing key to an index in [base, base+replicas) in indexMode ("numeric" or "hash").
+func indexFor(key string, replicas, base int, indexMode string) int {
 	if replicas <= 0 {
 		replicas = 1
 	}
-	indexMode := strings.ToLower(strings.TrimSpace(os.Getenv("INDEX_MODE"))) // "numeric" or "hash"
+	if strings.EqualFold(strings.TrimSpace(indexMode), "numeric") {
+		if n, err := strconv.ParseInt(key, 10, 64); err == nil {
+			return absBucket(n, replicas) + base
+		}
+		// fallback to hash if not numeric
+	}
+	return bucket(hashKey(key), replicas) + base
+}
+
+// replicaCount returns REPLICAS, defaulting to 1.
+func replicaCount() int {
+	replicas, err := strconv.Atoi(os.Getenv("REPLICAS"))
+	if err != nil || replicas <= 0 {
+		replicas = 1
+	}
+	return replicas
+}
+
+// indexBase returns INDEX_BASE, defaulting to 1.
+func indexBase() int {
 	base := 1
 	if v := strings.TrimSpace(os.Getenv("INDEX_BASE")); v != "" {
 		if b, err := strconv.Atoi(v); err == nil {
 			base = b
 		}
 	}
+	return base
+}
 
-	var remainder int
-	if indexMode == "numeric" {
-		if n, err := strconv.Atoi(clientID); err == nil {
-			if n < 0 {
-				n = -n
-			}
-			remainder = n % replicas
+// pickByHashScaled returns the hash target of clientID among the configured targets (see
+// targets.go for how each deployment names them). With MEMBERSHIP=registry and live members, the
+// ring is the registered members instead.
+func pickByHashScaled(clientID string) string {
+	return currentTable().pick(clientID)
+}
+
+// allTargets lists every routable replica in index order, from the routing table in use.
+func allTargets() []string {
+	return currentTable().Targets
+}
+
+// configuredTargets lists the targets a routing table is built over: the live registered members
+// when MEMBERSHIP=registry has any, otherwise the configured targets.
+func configuredTargets() []string {
+	if live := members.targets(); len(live) > 0 {
+		return live
+	}
+	return staticTargets()
+}
+
+// staticTargets lists the replicas configured by env, through the TargetResolver for the
+// deployment (see targets.go).
+func staticTargets() []string {
+	return targetResolverFromEnv().Targets()
+}
+
+// resolveOwner returns the replica clientID should be served by: the hash target,
+// adjusted by the routing policies in effect.
+func resolveOwner(ctx context.Context, clientID string) (string, error) {
+	owner, _, err := resolveOwnerAt(ctx, clientID)
+	return owner, err
+}
+
+func resolveOwnerOnce(ctx context.Context, clientID string) (string, error) {
+	if len(healthyTargets()) == 0 {
+		explainf(ctx, "no_healthy_replicas", "", "no target is healthy; EMPTY_REPLICAS_POLICY=%s", emptyReplicasPolicy())
+		return onNoReplicas(ctx, clientID)
+	}
+	if to, ok := pinnedOwner(clientID); ok {
+		explainf(ctx, "pin", to, "pinned with /admin/move; the pin wins over placement while the target is healthy")
+		return to, nil
+	}
+	if to, ok := sticky.owner(ctx, clientID); ok {
+		return to, nil
+	}
+	placement := placeRequest(clientID, requestHeaders(ctx))
+	explainPlacement(ctx, clientID, placement)
+	if _, ok := affinityFor(requestHeaders(ctx)); ok {
+		return resolveInGroup(ctx, clientID, placement)
+	}
+	owner := preferTargetVersion(clientID, placement)
+	if owner != placement {
+		explainf(ctx, "target_version", owner, "%s does not run TARGET_VERSION=%s and holds no session for the client", placement, os.Getenv("TARGET_VERSION"))
+	}
+	if to, ok := divertFromMaintenance(clientID, owner); ok {
+		explainf(ctx, "maintenance", to, "%s is in a MAINTENANCE_WINDOWS window and holds no session for the client", owner)
+		owner = to
+	}
+	if !ownerHealthy(owner) {
+		if to, ok := standbyFailover(clientID, owner); ok {
+			explainf(ctx, "standby", to, "%s is unhealthy; STANDBY_PAIRS names %s as its standby", owner, to)
+			checkAntiAffinity(clientID, placement, to)
+			return to, nil
+		}
+	}
+	if err := ownerWait.await(ctx, clientID, owner); err != nil {
+		if !errors.Is(err, errOwnerUnavailable) {
+			explainf(ctx, "owner_wait", "", "%s is unhealthy and could not be waited for: %v", owner, err)
+			return "", err
+		}
+		explainf(ctx, "owner_wait", "", "%s stayed unhealthy for OWNER_WAIT_DEADLINE", owner)
+		alt, ok := failover.pick(clientID, owner)
+		if !ok {
+			return "", err
+		}
+		explainf(ctx, "failover", alt, "FAILOVER_POLICY=%s moved the client off %s", failover.policy, owner)
+		owner = alt
+	} else if !ownerHealthy(owner) {
+		if alt, ok := failover.pick(clientID, owner); ok {
+			explainf(ctx, "failover", alt, "%s is unhealthy; FAILOVER_POLICY=%s moved the client", owner, failover.policy)
+			owner = alt
 		} else {
-			// fallback to hash if not numeric
-			h := fnv.New32a()
-			_, _ = h.Write([]byte(clientID))
-			remainder = int(h.Sum32()) % replicas
+			explainf(ctx, "unhealthy_owner", owner, "%s is unhealthy, but FAILOVER_POLICY=%s has no healthy candidate, so it is used anyway", owner, failover.policy)
 		}
-	} else {
-		// default: hash mode
-		h := fnv.New32a()
-		_, _ = h.Write([]byte(clientID))
-		remainder = int(h.Sum32()) % replicas
 	}
-	return remainder + base
+	checkAntiAffinity(clientID, placement, owner)
+	return owner, nil
 }
 
-// pickScaledTarget computes <SERVICE_PREFIX>-<idx><SERVICE_SUFFIX>:PORT
-// Compatible with both Docker Compose (INDEX_BASE=1, no SERVICE_SUFFIX)
-// and K8s StatefulSet (INDEX_BASE=0, SERVICE_SUFFIX like .server-headless.ns.svc.cluster.local).
-func pickByHashScaled(clientID string) string {
-	prefix := os.Getenv("SERVICE_PREFIX")
-	if prefix == "" {
-		return pickByHashLegacy(clientID)
+// writeResolveError maps a resolveOwner error to a 503 with its code, or a 504 when the
+// caller's deadline ran out.
+func writeResolveError(w http.ResponseWriter, err error) {
+	if deadlineExpired(err) {
+		writeError(w, http.StatusGatewayTimeout, "DEADLINE_EXCEEDED", err.Error())
+		return
 	}
-	replicasStr := os.Getenv("REPLICAS")
-	replicas, err := strconv.Atoi(replicasStr)
-	if err != nil || replicas <= 0 {
-		replicas = 1
+	writeError(w, http.StatusServiceUnavailable, resolveErrorCode(err), err.Error())
+}
+
+// resolveErrorCode maps a resolveOwner error to its API error code.
+func resolveErrorCode(err error) string {
+	if deadlineExpired(err) {
+		return "DEADLINE_EXCEEDED"
 	}
-	idx := computeIndex(clientID, replicas)
-	suffix := os.Getenv("SERVICE_SUFFIX")
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8081"
+	if errors.Is(err, errOwnerUnavailable) || errors.Is(err, errOwnerQueueFull) {
+		return "OWNER_UNAVAILABLE"
 	}
-	return fmt.Sprintf("%s-%d%s:%s", prefix, idx, suffix, port)
+	return "NO_HEALTHY_REPLICA"
+}
+
+// writeError responds with a JSON error body carrying a machine-readable code.
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"error": msg,
+		"code":  code,
+	})
 }
 
 func handleJoin(w http.ResponseWriter, r *http.Request) {
@@ -108,53 +229,372 @@ func handleJoin(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing client_id", http.StatusBadRequest)
 		return
 	}
+	if pool, ok := requestPool(w, r); !ok {
+		return
+	} else if pool != nil {
+		forwardToPool(w, r, clientID, pool)
+		return
+	}
+	if !checkAffinity(w, r) {
+		return
+	}
+	if !checkBootstrap(w, "join") {
+		return
+	}
+
+	if isFenced() {
+		writeFenced(w)
+		return
+	}
+	w, finish, replayed := joinIdempotency.begin(w, r, clientID)
+	if replayed {
+		return
+	}
+	defer finish()
+	if !checkQuota(w, r, quotaJoin, clientID, 1) {
+		return
+	}
+
+	start := time.Now()
+	// The target name, not the hostname, so stored assignments compare equal to ring targets.
+	self := selfTarget()
+	owner, version, err := resolveOwnerAt(r.Context(), clientID)
+	if err != nil {
+		writeResolveError(w, err)
+		return
+	}
+	setTableVersion(w, version)
+	parity.check(r, clientID, owner)
+	status := "ok"
+	defer func() {
+		joinMirror.offer(mirrorEvent{
+			ClientID:  clientID,
+			Target:    owner,
+			HandledBy: self,
+			Status:    status,
+			LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			Time:      start,
+		})
+		decisions.record(decision{
+			Time:      start,
+			Endpoint:  "join",
+			ClientID:  clientID,
+			Replica:   owner,
+			ServedBy:  self,
+			Status:    status,
+			LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			Version:   version,
+		})
+	}()
 
-	self := getSelf()
+	// A client reaching us while we still hold its session but no longer own it:
+	// transfer the session to the new owner first, then redirect the client there.
+	// After an operator move the session is already gone, and the client is told to reconnect.
+	_, held := sessions.get(clientID)
+	reconnect := pins.takeClosing(clientID)
+	if (held || reconnect) && !isSelfTarget(owner) {
+		status = "moved"
+		_ = handOff(clientID, owner)
+		log.Printf("/join client_id=%s moved from %s to %s", clientID, self, owner)
+		body := map[string]string{
+			"status":    "moved",
+			"client_id": clientID,
+			"assigned":  owner,
+		}
+		if reconnect {
+			body["reason"] = "reconnect"
+			w.Header().Set("Connection", "close")
+		}
+		w.Header().Set("Location", "http://"+owner+"/join?client_id="+url.QueryEscape(clientID))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTemporaryRedirect)
+		_ = json.NewEncoder(w).Encode(body)
+		return
+	}
+
+	meta := make(map[string]string)
+	for k, v := range r.URL.Query() {
+		if name, ok := strings.CutPrefix(k, "meta."); ok && len(v) > 0 {
+			meta[name] = v[0]
+		}
+	}
+	if budgetExhausted(w, r) {
+		status = "deadline_exceeded"
+		return
+	}
+	if !lockClientForJoin(w, clientID) {
+		status = "locked"
+		return
+	}
+	sess, created := sessions.touch(clientID, self, meta)
+	// A pre-provisioned client's first join finds its assignment already in the registry.
+	if !created || !preassigned.take(clientID) {
+		err = assignments.persist(clientID, self, sess.JoinedAt)
+	}
+	if err != nil {
+		status = "error"
+		writeError(w, http.StatusServiceUnavailable, "REGISTRY_WRITE_FAILED", err.Error())
+		return
+	}
+	if created {
+		events.emit(eventAssigned, clientID, self, "", "")
+	}
 
 	log.Printf("/join client_id=%s registered to %s", clientID, self)
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(map[string]string{
+	body := map[string]any{
 		"status":    "ok",
 		"client_id": clientID,
 		"assigned":  self,
-	})
+	}
+	if outage.noteDegraded("join") {
+		body["degraded"] = true
+	}
+	_ = json.NewEncoder(w).Encode(body)
 }
 
 func handleWhere(w http.ResponseWriter, r *http.Request) {
-	clientID := r.URL.Query().Get("client_id")
+	clientID := queryValue(r, "client_id")
 	if clientID == "" {
 		http.Error(w, "missing client_id", http.StatusBadRequest)
 		return
 	}
+	if pool, ok := requestPool(w, r); !ok {
+		return
+	} else if pool != nil {
+		handlePoolWhere(w, clientID, pool)
+		return
+	}
+	if !checkAffinity(w, r) {
+		return
+	}
+	if !checkBootstrap(w, "where") || !checkRingView(w, "where") {
+		return
+	}
+	if !checkQuota(w, r, quotaResolution, clientID, 1) {
+		return
+	}
 
-	hostPort := pickByHashScaled(clientID)
-	log.Printf("/where client_id=%s assigned to %s", clientID, hostPort)
+	start := time.Now()
+	hostPort, version, mode, err := resolveTraced(r.Context(), clientID)
+	if err != nil {
+		log.Printf("/where client_id=%s failed: %v", clientID, err)
+		writeResolveError(w, err)
+		return
+	}
+	setTableVersion(w, version)
+	setRouteTrace(w.Header(), r.Header, mode, version)
 
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(map[string]string{
-		"client_id": clientID,
-		"hostport":  hostPort,
+	var reach *bool
+	if report, strict := whereReachability(r); report || strict {
+		ok, why := reachable(r.Context(), hostPort)
+		if !ok && strict {
+			log.Printf("/where client_id=%s strict: %s is unreachable (%s)", clientID, hostPort, why)
+			writeError(w, http.StatusServiceUnavailable, "NO_HEALTHY_REPLICA", hostPort+" is unreachable: "+why)
+			return
+		}
+		if report {
+			reach = &ok
+		}
+	}
+
+	// Polling clients send back the ETag; an unchanged assignment costs a bodyless 304.
+	etagKey := hostPort
+	if reach != nil && !*reach {
+		etagKey += "|unreachable"
+	}
+	etag := whereETag(clientID, etagKey)
+	w.Header().Set("ETag", etag)
+	whereCache.set(w.Header(), currentTable())
+	if reach != nil && !*reach {
+		w.Header()["Cache-Control"] = whereNoCache
+		w.Header().Del("Expires")
+	}
+	notModified := etagMatches(r, etag)
+	status := "ok"
+	if notModified {
+		status = "not_modified"
+	}
+	decisions.record(decision{
+		Time:      start,
+		Endpoint:  "where",
+		ClientID:  clientID,
+		Replica:   hostPort,
+		ServedBy:  getSelf(),
+		Status:    status,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+		Version:   version,
 	})
+	if notModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	log.Printf("/where client_id=%s assigned to %s", clientID, hostPort)
+
+	w.Header()["Content-Type"] = jsonContentType
+	resp := whereResponses.Get().(*whereResponse)
+	*resp = whereResponse{ClientID: clientID, HostPort: hostPort, TableVersion: version, Degraded: outage.noteDegraded("where"), Reachable: reach}
+	if standby, ok := standbyFor(hostPort); ok {
+		resp.Standby = standby
+		resp.Targets = []string{hostPort, standby}
+	}
+	writeJSON(w, resp)
+	*resp = whereResponse{}
+	whereResponses.Put(resp)
+}
+
+// queryValue returns the first value of key in r's query, like r.URL.Query().Get(key) but
+// without building the whole map: /where reads one or two parameters on every request.
+func queryValue(r *http.Request, key string) string {
+	q := r.URL.RawQuery
+	for q != "" {
+		var pair string
+		pair, q, _ = strings.Cut(q, "&")
+		k, v, _ := strings.Cut(pair, "=")
+		if strings.ContainsAny(k, "%+") {
+			var err error
+			if k, err = url.QueryUnescape(k); err != nil {
+				continue
+			}
+		}
+		if k != key {
+			continue
+		}
+		if strings.ContainsAny(v, "%+") {
+			var err error
+			if v, err = url.QueryUnescape(v); err != nil {
+				continue
+			}
+		}
+		return v
+	}
+	return ""
 }
 
 func handleHealth(w http.ResponseWriter, r *http.Request) {
+	if isFenced() {
+		http.Error(w, "fenced", http.StatusServiceUnavailable)
+		return
+	}
+	if bootstrap.pending.Load() {
+		http.Error(w, "bootstrapping", http.StatusServiceUnavailable)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte("ok"))
 }
 
-func main() {
-	http.HandleFunc("/join", handleJoin)
-	http.HandleFunc("/where", handleWhere)
-	http.HandleFunc("/health", handleHealth)
-
+// Main runs a replica until shutdown, or the gateway with SERVER_MODE=gateway. cmd/server calls it.
+func Main() {
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8081"
 	}
-
 	addr := ":" + port
+	logBuildInfo()
+	if gatewayMode() {
+		runGateway(addr)
+		return
+	}
+
+	http.HandleFunc("/join", timed("join", handleJoin))
+	http.HandleFunc("/where", timed("where", handleWhere))
+	http.HandleFunc("/where/wait", handleWhereWait)
+	http.HandleFunc("/explain", handleExplain)
+	http.HandleFunc("/where/batch", handleWhereBatch)
+	http.HandleFunc("/topics/where", handleTopicWhere)
+	http.HandleFunc("/counter", handleCounter)
+	http.HandleFunc("/health", handleHealth)
+	http.HandleFunc("/version", handleVersion)
+	http.HandleFunc("/cluster/status", handleClusterStatus)
+	http.HandleFunc("/breakers", handleBreakers)
+	http.HandleFunc("/cluster/assignments", handleClusterAssignments)
+	http.HandleFunc("/register", handleRegister)
+	http.HandleFunc("/metrics", handleMetrics)
+	http.HandleFunc("/slo", handleSLO)
+	http.HandleFunc("/canary/status", handleCanaryStatus)
+	http.HandleFunc("/sessions/expiry-forecast", handleExpiryForecast)
+	http.HandleFunc("/parity/summary", handleParitySummary)
+	http.HandleFunc("/conflicts", handleConflicts)
+	http.HandleFunc("/quota", handleQuota)
+	http.HandleFunc("/ring", handleRing)
+	http.HandleFunc("/config/peers", handleConfigPeers)
+	http.HandleFunc("/weights", handleWeights)
+	http.HandleFunc("/v3/discovery:endpoints", handleEDS)
+	http.HandleFunc("/ws", handleWebSocket)
+	http.HandleFunc("/events", handleEventStream)
+	http.HandleFunc("/events/recent", handleRecentEvents)
+	http.HandleFunc(grpcWebWherePath, withGRPCWebChecks(handleGRPCWebWhere))
+	http.HandleFunc(grpcWebEventsPath, withGRPCWebChecks(handleGRPCWebEvents))
+	http.HandleFunc("/export/decisions", handleExportDecisions)
+	http.HandleFunc("/admin/migrate-registry", handleMigrateRegistry)
+	http.HandleFunc("/admin/move", handleMove)
+	http.HandleFunc("/admin/preassign", handlePreassign)
+	http.HandleFunc("/admin/reassign", handleReassign)
+	http.HandleFunc("/admin/compact", handleCompact)
+	http.HandleFunc("/admin/debug", handleDebug)
+	http.HandleFunc("/admin/memory", handleMemory)
+	http.Handle("/ui/", uiHandler())
+	http.Handle("/ui", http.RedirectHandler("/ui/", http.StatusMovedPermanently))
+
+	internal := http.NewServeMux()
+	internal.HandleFunc("/internal/handoff", handleHandoff)
+	internal.HandleFunc("/internal/info", handleInfo)
+	internal.HandleFunc("/internal/sessions", handleSessionLookup)
+	internal.HandleFunc("/internal/sessions/all", handleSessionList)
+	internal.HandleFunc("/internal/pin", handlePin)
+	internal.HandleFunc("/internal/preassigned", handlePreassigned)
+	internal.HandleFunc("/internal/expiry-forecast", handleLocalExpiryForecast)
+	internal.HandleFunc("/internal/assignments", handleInternalAssignments)
+	internal.HandleFunc("/internal/debug", handleInternalDebug)
+	internal.HandleFunc("/internal/memory", handleInternalMemory)
+	internal.HandleFunc("/health", handleHealth)
+	go serveInternal(internal)
+	go runSessionExpiry()
+	go runReplicaPoller()
+	go runSLOChecker()
+	go runWeightTuner()
+	go runXDSPublisher()
+	go runOrdinalLease()
+	go runClientLockRenewal()
+	go runBootstrap()
+	go runMembership()
+	go runRingView()
+	go runConflictDetector()
+	go runQuotaSweeper()
+	go runGossip()
+	go runTCPProxy()
+	go runDNSCache()
+	go runCompaction()
+	go runMemoryBounds()
+	go runCanaries()
+	go checkRegistrySchema()
+	onShutdown(drainWebSockets)
+	onShutdown(releaseClientLocks)
+
 	log.Printf("server starting on %s (hostname=%s)", addr, func() string { h, _ := os.Hostname(); return h }())
-	if err := http.ListenAndServe(addr, nil); err != nil {
-		log.Fatalf("listen and serve: %v", err)
+	serve(&http.Server{Addr: addr, Handler: withAccessLog(withGRPC(withHardening(withCompression(withCORS(requireAdmin(withConcurrencyLimits(withDeadline(withRequestHeaders(http.DefaultServeMux))))))))), Protocols: serverProtocols()})
+}
+
+// serve runs srv until it is shut down by a signal.
+func serve(srv *http.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		shutdownOnSignal(srv)
+		close(stopped)
+	}()
+	lns, err := publicListeners(srv.Addr)
+	if err != nil {
+		log.Fatalf("listen: %v", err)
+	}
+	served := make(chan error, len(lns))
+	for _, ln := range lns {
+		go func() { served <- srv.Serve(ln) }()
+	}
+	for range lns {
+		if err := <-served; err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("listen and serve: %v", err)
+		}
 	}
+	<-stopped
 }
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scheduled maintenance. MAINTENANCE_WINDOWS lists daily windows per replica, e.g.
+// "server-2=02:00-02:30,server-3=02:30-03:00" (times in MAINTENANCE_TZ, default UTC; a window may
+// wrap past midnight, and a replica may have several). During its window a replica is cordoned:
+// clients it doesn't already hold a session for are placed on the healthy uncordoned replicas
+// instead (hashed over them), while its existing sessions stay put. The window is evaluated on
+// every resolution against clock.Now(), so replicas sharing the config agree without coordination
+// and the cordon lifts by itself when the window ends. Replicas are named like STANDBY_PAIRS.
+
+type maintenanceWindow struct {
+	name       string
+	start, end time.Duration // offsets from local midnight
+	spec       string
+}
+
+var (
+	maintenanceWindows = parseMaintenanceWindows(os.Getenv("MAINTENANCE_WINDOWS"))
+	maintenanceZone    = maintenanceLocation(os.Getenv("MAINTENANCE_TZ"))
+)
+
+func init() {
+	metrics.counter("routing_maintenance_diverted_total", "New clients placed elsewhere because their owner was in a maintenance window, by owner.")
+}
+
+func maintenanceLocation(name string) *time.Location {
+	if name == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("invalid MAINTENANCE_TZ=%q, using UTC: %v", name, err)
+		return time.UTC
+	}
+	return loc
+}
+
+func parseMaintenanceWindows(spec string) []maintenanceWindow {
+	var out []maintenanceWindow
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, span, _ := strings.Cut(part, "=")
+		from, to, ok := strings.Cut(strings.TrimSpace(span), "-")
+		start, err1 := parseClockTime(from)
+		end, err2 := parseClockTime(to)
+		if name = strings.TrimSpace(name); name == "" || !ok || err1 != nil || err2 != nil || start == end {
+			log.Printf("ignoring invalid MAINTENANCE_WINDOWS entry %q (want replica=HH:MM-HH:MM)", part)
+			continue
+		}
+		out = append(out, maintenanceWindow{name: name, start: start, end: end, spec: strings.TrimSpace(span)})
+	}
+	return out
+}
+
+// parseClockTime parses HH:MM as an offset from midnight.
+func parseClockTime(v string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(v))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// bounds returns the occurrence of w that contains now, or else the next one.
+func (w maintenanceWindow) bounds(now time.Time) (from, to time.Time, active bool) {
+	now = now.In(maintenanceZone)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, maintenanceZone)
+	for _, day := range []int{-1, 0, 1} {
+		from = midnight.AddDate(0, 0, day).Add(w.start)
+		to = midnight.AddDate(0, 0, day).Add(w.end)
+		if w.end < w.start {
+			to = to.AddDate(0, 0, 1)
+		}
+		if !now.Before(from) && now.Before(to) {
+			return from, to, true
+		}
+		if now.Before(from) {
+			return from, to, false
+		}
+	}
+	return from, to, false
+}
+
+// inMaintenance returns the window target is in right now, if any.
+func inMaintenance(target string, now time.Time) (maintenanceWindow, time.Time, bool) {
+	for _, w := range maintenanceWindows {
+		if !targetNamed(w.name, target) {
+			continue
+		}
+		if _, to, active := w.bounds(now); active {
+			return w, to, true
+		}
+	}
+	return maintenanceWindow{}, time.Time{}, false
+}
+
+// maintenanceLog remembers which targets were cordoned, to log when a window starts and ends.
+var maintenanceLog = struct {
+	mu       sync.Mutex
+	cordoned map[string]bool
+}{cordoned: make(map[string]bool)}
+
+func noteCordon(target string, cordoned bool, w maintenanceWindow) {
+	maintenanceLog.mu.Lock()
+	defer maintenanceLog.mu.Unlock()
+	if maintenanceLog.cordoned[target] == cordoned {
+		return
+	}
+	maintenanceLog.cordoned[target] = cordoned
+	if cordoned {
+		log.Printf("maintenance window %s started for %s: cordoned", w.spec, target)
+	} else {
+		log.Printf("maintenance window ended for %s: uncordoned", target)
+	}
+}
+
+// divertFromMaintenance places a new client elsewhere while owner is in a maintenance window.
+func divertFromMaintenance(clientID, owner string) (string, bool) {
+	if len(maintenanceWindows) == 0 {
+		return owner, false
+	}
+	now := clock.Now()
+	w, _, active := inMaintenance(owner, now)
+	noteCordon(owner, active, w)
+	if !active {
+		return owner, false
+	}
+	var candidates []string
+	for _, t := range allTargets() {
+		if t == owner || !ownerHealthy(t) {
+			continue
+		}
+		if _, _, busy := inMaintenance(t, now); !busy {
+			candidates = append(candidates, t)
+		}
+	}
+	if len(candidates) == 0 || ownerHasSession(owner, clientID) {
+		return owner, false
+	}
+	to := candidates[bucket(hashKey(routingKey(clientID)), len(candidates))]
+	metrics.inc("routing_maintenance_diverted_total", "owner", owner)
+	return to, true
+}
+
+// maintenanceStatus reports every configured window for /cluster/status.
+func maintenanceStatus() []map[string]any {
+	now := clock.Now()
+	out := []map[string]any{}
+	for _, w := range maintenanceWindows {
+		from, to, active := w.bounds(now)
+		entry := map[string]any{"replica": w.name, "window": w.spec, "active": active}
+		if active {
+			entry["ends_at"] = to.UTC()
+		} else {
+			entry["next_start"] = from.UTC()
+		}
+		var targets []string
+		for _, t := range allTargets() {
+			if targetNamed(w.name, t) {
+				targets = append(targets, t)
+			}
+		}
+		if len(targets) == 0 {
+			entry["note"] = fmt.Sprintf("%q matches no current target", w.name)
+		}
+		entry["targets"] = targets
+		out = append(out, entry)
+	}
+	return out
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Live membership. With MEMBERSHIP=registry each replica registers itself in the shared registry
+// under a heartbeat lease (MEMBER_TTL, default 10s, refreshed every MEMBER_TTL/3) and deregisters
+// on shutdown. The ring is then the registered members ordered by index instead of the static
+// REPLICAS / SERVER_PEERS configuration, which remains the fallback while no member is visible.
+// A changed listing only replaces the ring once it has been stable for MEMBERSHIP_STABLE_WINDOW,
+// so pods churning through a rolling restart don't reshuffle placements on every heartbeat.
+// Needs a registry backend that supports membership (redis).
+
+// Member is a replica's registration in the shared registry.
+type Member struct {
+	Target      string    `json:"target"`
+	Address     string    `json:"address"`
+	Index       int       `json:"index"` // ordinal used for ring order; -1 when unknown
+	Zone        string    `json:"zone,omitempty"`
+	Version     string    `json:"version"`
+	Capacity    int       `json:"capacity"`
+	Instance    string    `json:"instance"`
+	HeartbeatAt time.Time `json:"heartbeat_at"`
+}
+
+// memberRegistry is implemented by registry backends that can hold replica registrations.
+type memberRegistry interface {
+	RegisterMember(m Member, ttl time.Duration) error
+	DeregisterMember(target string) error
+	ListMembers() ([]Member, error)
+}
+
+type memberView struct {
+	mu         sync.RWMutex
+	enabled    bool
+	live       []string  // the ring in use
+	observed   []string  // the latest registry listing, adopted once stable
+	observedAt time.Time // when observed last changed
+}
+
+var members = &memberView{}
+
+// targets returns the live member targets in ring order, or nil when membership is off.
+func (v *memberView) targets() []string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.live
+}
+
+// source reports where the ring comes from: "registry" while live members are in use, else "static".
+func (v *memberView) source() string {
+	if len(v.targets()) > 0 {
+		return "registry"
+	}
+	return "static"
+}
+
+func (v *memberView) set(ms []Member) {
+	sort.Slice(ms, func(i, j int) bool {
+		if ms[i].Index != ms[j].Index {
+			return ms[i].Index < ms[j].Index
+		}
+		return ms[i].Target < ms[j].Target
+	})
+	live := make([]string, 0, len(ms))
+	for _, m := range ms {
+		live = append(live, m.Target)
+	}
+	now := clock.Now()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !slices.Equal(live, v.observed) {
+		metrics.inc("routing_membership_changes_total")
+		if !slices.Equal(v.observed, v.live) {
+			// Changed again before the previous change was adopted.
+			metrics.inc("routing_membership_flaps_total")
+		}
+		v.observed, v.observedAt = live, now
+	}
+	if slices.Equal(v.observed, v.live) {
+		return
+	}
+	if held := now.Sub(v.observedAt); len(v.live) > 0 && held < membershipStableWindow() {
+		if held == 0 {
+			log.Printf("membership: holding ring %v, observed %v", v.live, live)
+		}
+		return
+	}
+	v.live = v.observed
+	log.Printf("membership: ring is now %v", v.live)
+}
+
+// pending reports whether an observed membership change is being held back.
+func (v *memberView) pending() bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return !slices.Equal(v.observed, v.live)
+}
+
+// membershipStableWindow is how long a membership listing must stay unchanged before the ring
+// follows it (MEMBERSHIP_STABLE_WINDOW, default 10s; 0 follows every change immediately).
+func membershipStableWindow() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("MEMBERSHIP_STABLE_WINDOW")); err == nil && d >= 0 {
+		return d
+	}
+	return 10 * time.Second
+}
+
+// selfIndex returns this replica's ordinal: its position among the configured targets, or the
+// trailing -N of its hostname (StatefulSet style), or -1.
+func selfIndex() int {
+	for i, t := range staticTargets() {
+		if isSelfTarget(t) {
+			return indexBase() + i
+		}
+	}
+	hostname, _ := os.Hostname()
+	if i := strings.LastIndexByte(hostname, '-'); i >= 0 {
+		if n, err := strconv.Atoi(hostname[i+1:]); err == nil {
+			return n
+		}
+	}
+	return -1
+}
+
+func selfMember() Member {
+	return Member{
+		Target:      selfTarget(),
+		Address:     getSelf(),
+		Index:       selfIndex(),
+		Zone:        os.Getenv("ZONE"),
+		Version:     appVersion(),
+		Capacity:    replicaWeight(),
+		Instance:    instanceID,
+		HeartbeatAt: clock.Now(),
+	}
+}
+
+func memberTTL() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("MEMBER_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return 10 * time.Second
+}
+
+// runMembership registers this replica and keeps the live member view fresh until shutdown.
+func runMembership() {
+	if os.Getenv("MEMBERSHIP") != "registry" {
+		return
+	}
+	reg, ok := registryAs[memberRegistry]()
+	if !ok {
+		log.Printf("MEMBERSHIP=registry needs a shared registry backend (redis); using static targets")
+		return
+	}
+	metrics.counter("routing_membership_errors_total", "Membership register/list calls that failed.")
+	metrics.gaugeFunc("routing_members_live", "Replicas in the ring built from the shared registry.", func() float64 {
+		return float64(len(members.targets()))
+	})
+	metrics.counter("routing_membership_changes_total", "Registry listings that differed from the previous one.")
+	metrics.counter("routing_membership_flaps_total", "Membership changes observed while an earlier change was still being held.")
+	metrics.gaugeFunc("routing_membership_pending", "1 while an observed membership change is held back for stability.", func() float64 {
+		if members.pending() {
+			return 1
+		}
+		return 0
+	})
+	// A gateway follows the membership without being a member.
+	register := !gatewayMode()
+	self := selfMember()
+	ttl := memberTTL()
+	if register {
+		onShutdown(func() {
+			if err := reg.DeregisterMember(self.Target); err != nil {
+				log.Printf("membership: deregister %s failed: %v", self.Target, err)
+				return
+			}
+			log.Printf("membership: deregistered %s", self.Target)
+		})
+		log.Printf("membership: registering %s (index %d) with ttl %s", self.Target, self.Index, ttl)
+	}
+	for {
+		if register {
+			self.HeartbeatAt = clock.Now()
+			if err := reg.RegisterMember(self, ttl); err != nil {
+				metrics.inc("routing_membership_errors_total")
+				log.Printf("membership: register failed: %v", err)
+			}
+		}
+		if ms, err := reg.ListMembers(); err != nil {
+			metrics.inc("routing_membership_errors_total")
+			log.Printf("membership: list failed: %v", err)
+		} else {
+			members.set(ms)
+			publishTable("membership", nil)
+		}
+		clock.Sleep(ttl / 3)
+	}
+}
+
+const memberPrefix = "poc-routing:member:"
+
+func (r *redisRegistry) RegisterMember(m Member, ttl time.Duration) error {
+	defer slowOps.discoveryOp("member_register", m.Target, time.Now())
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = r.client.Do("SET", memberPrefix+m.Target, string(b), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+func (r *redisRegistry) DeregisterMember(target string) error {
+	_, err := r.client.Do("DEL", memberPrefix+target)
+	return err
+}
+
+func (r *redisRegistry) ListMembers() ([]Member, error) {
+	defer slowOps.discoveryOp("member_list", "*", time.Now())
+	keys, err := r.client.ScanKeys(memberPrefix + "*")
+	if err != nil {
+		return nil, err
+	}
+	cmds := make([][]string, 0, len(keys))
+	for _, k := range keys {
+		cmds = append(cmds, []string{"GET", k})
+	}
+	replies, err := r.client.Pipeline(cmds)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Member, 0, len(replies))
+	for _, rep := range replies {
+		raw, ok := rep.(string)
+		if !ok {
+			continue // expired between SCAN and GET
+		}
+		var m Member
+		if json.Unmarshal([]byte(raw), &m) == nil {
+			out = append(out, m)
+		}
+	}
+	return out, nil
+}
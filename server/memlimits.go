@@ -0,0 +1,211 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Memory bounds. A replica keeps several maps that grow with the number of clients it has seen,
+// which over a multi-day soak is far more than are active. Each is capped, and a store at its cap
+// evicts its oldest entries to make room:
+//   - sessions: MAX_SESSIONS (default 100000), the least recently seen go first; sessions are
+//     evicted 1% at a time, so a full store doesn't pay for an eviction on every join
+//   - assignments: MAX_ASSIGNMENTS (default 100000), the in-memory registry only; the least recently
+//     written go first, also 1% at a time, and an evicted client is routed by hash on its next join
+//   - events: MAX_RECENT_EVENTS (default 200), the ring behind /events/recent and the dashboard
+//   - idempotency_keys: IDEMPOTENCY_MAX_KEYS (default 10000, see idempotency.go)
+//
+// Other maps are bounded already: by target (breakers, outlier hosts, gossip peers), by TTL
+// (handoff IDs, assignment snapshots, quota usage) or by rebuilding them (conflicts). The admin
+// audit trail goes to the log, not to memory.
+//
+// GET /admin/memory reports each store's entries and limit; POST /admin/memory with e.g.
+// {"sessions": 50000, "events": 1000} changes limits at runtime on every replica (via
+// /internal/memory), evicting at once down to a lowered limit. Limits set this way last until the
+// replica restarts. Metrics, by store: routing_memory_entries and routing_memory_limit (updated
+// every 15s) and routing_memory_evictions_total.
+
+// memoryStore is one bounded in-memory store.
+type memoryStore struct {
+	name   string
+	size   func() int
+	limit  func() int
+	resize func(n int) // sets the limit, evicting down to it
+}
+
+// memoryStores returns the bounded stores this process holds.
+func memoryStores() []memoryStore {
+	stores := []memoryStore{
+		{"sessions", sessions.count, sessions.limit, sessions.resize},
+		{"events", recentEvents.size, recentEvents.limit, recentEvents.resize},
+		{"idempotency_keys", joinIdempotency.size, joinIdempotency.limit, joinIdempotency.resize},
+	}
+	if r, ok := registryAs[*memoryRegistry](); ok {
+		stores = slices.Insert(stores, 1, memoryStore{"assignments", r.size, r.limit, r.resize})
+	}
+	return stores
+}
+
+// memoryLimitFromEnv returns the positive limit in env var name, or def.
+func memoryLimitFromEnv(name string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(name)); err == nil && n > 0 {
+		return n
+	}
+	return def
+}
+
+// evictionBatch is how many entries a store at limit evicts at once: 1%, at least one.
+func evictionBatch(limit int) int {
+	return max(limit/100, 1)
+}
+
+// noteEvictions counts n entries evicted from store.
+func noteEvictions(store string, n int) {
+	if n > 0 {
+		metrics.add("routing_memory_evictions_total", float64(n), "store", store)
+	}
+}
+
+// runMemoryBounds publishes the size and limit of each store.
+func runMemoryBounds() {
+	metrics.gauge("routing_memory_entries", "Entries held by a bounded in-memory store, by store.")
+	metrics.gauge("routing_memory_limit", "Entry limit of a bounded in-memory store, by store.")
+	metrics.counter("routing_memory_evictions_total", "Entries evicted from a bounded in-memory store to stay within its limit, by store.")
+	report := func() {
+		for _, s := range memoryStores() {
+			metrics.set("routing_memory_entries", float64(s.size()), "store", s.name)
+			metrics.set("routing_memory_limit", float64(s.limit()), "store", s.name)
+		}
+	}
+	report()
+	for range clock.Tick(15 * time.Second) {
+		report()
+	}
+}
+
+type memoryStoreReport struct {
+	Store   string `json:"store"`
+	Entries int    `json:"entries"`
+	Limit   int    `json:"limit"`
+}
+
+func memoryReport() []memoryStoreReport {
+	var out []memoryStoreReport
+	for _, s := range memoryStores() {
+		out = append(out, memoryStoreReport{s.name, s.size(), s.limit()})
+	}
+	return out
+}
+
+var memoryStoreNames = []string{"sessions", "assignments", "events", "idempotency_keys"}
+
+// applyMemoryLimits checks every limit before setting any, so a bad request changes nothing. A
+// store this process doesn't hold (the in-memory registry on a gateway or with Redis) is skipped.
+func applyMemoryLimits(limits map[string]int) error {
+	for name, n := range limits {
+		if !slices.Contains(memoryStoreNames, name) {
+			return fmt.Errorf("unknown store %q", name)
+		}
+		if n <= 0 {
+			return fmt.Errorf("limit for %s must be positive", name)
+		}
+	}
+	for _, s := range memoryStores() {
+		if n, ok := limits[s.name]; ok {
+			before := s.size()
+			s.resize(n)
+			log.Printf("memory: %s limit set to %d (%d -> %d entries)", s.name, n, before, s.size())
+		}
+	}
+	return nil
+}
+
+var memoryClient = newInternalClient(2 * time.Second)
+
+func postMemoryLimits(target string, body []byte) error {
+	req, err := newInternalRequest(http.MethodPost, target, "/internal/memory", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := memoryClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// handleMemory reports the bounded stores (GET) or changes their limits on every replica (POST).
+func handleMemory(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"stores": memoryReport()})
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var limits map[string]int
+	if err := json.NewDecoder(r.Body).Decode(&limits); err != nil || len(limits) == 0 {
+		http.Error(w, "invalid memory limits", http.StatusBadRequest)
+		return
+	}
+	if err := applyMemoryLimits(limits); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_MEMORY_LIMITS", err.Error())
+		return
+	}
+	body, _ := json.Marshal(limits)
+	var unreached []string
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, t := range allTargets() {
+		if isSelfTarget(t) {
+			continue
+		}
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			if err := postMemoryLimits(target, body); err != nil {
+				log.Printf("memory limits on %s failed: %v", target, err)
+				mu.Lock()
+				unreached = append(unreached, target)
+				mu.Unlock()
+			}
+		}(t)
+	}
+	wg.Wait()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"stores": memoryReport(), "unreachable": unreached})
+}
+
+// handleInternalMemory receives limits broadcast by /admin/memory (internal API).
+func handleInternalMemory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var limits map[string]int
+	if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+		http.Error(w, "invalid memory limits", http.StatusBadRequest)
+		return
+	}
+	if err := applyMemoryLimits(limits); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_MEMORY_LIMITS", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Minimal Prometheus text-format metrics: counters, gauges and histograms with label pairs,
+// plus gauges computed on scrape.
+
+// latencyBuckets are the histogram upper bounds, in seconds.
+var latencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+type histogram struct {
+	counts []uint64 // per bucket, non-cumulative
+	sum    float64
+	count  uint64
+}
+
+type metricFamily struct {
+	kind   string // "counter", "gauge" or "histogram"
+	help   string
+	values map[string]float64 // rendered label set -> value
+	hists  map[string]*histogram
+	keys   map[string]string // rendered label sets, interned so a repeat costs no allocation
+	fn     func() float64
+}
+
+type metricsRegistry struct {
+	mu       sync.Mutex
+	families map[string]*metricFamily
+}
+
+var metrics = &metricsRegistry{families: make(map[string]*metricFamily)}
+
+func (m *metricsRegistry) family(name, kind, help string) *metricFamily {
+	f, ok := m.families[name]
+	if !ok {
+		f = &metricFamily{kind: kind, help: help, values: make(map[string]float64), hists: make(map[string]*histogram), keys: make(map[string]string)}
+		m.families[name] = f
+	}
+	return f
+}
+
+// counter declares a counter so it is exported (at zero) before the first increment.
+func (m *metricsRegistry) counter(name, help string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.family(name, "counter", help)
+}
+
+// gauge declares a gauge.
+func (m *metricsRegistry) gauge(name, help string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.family(name, "gauge", help)
+}
+
+// add increments name{labels} by v. labels are key, value pairs.
+func (m *metricsRegistry) add(name string, v float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f := m.family(name, "counter", "")
+	f.values[f.key(labels)] += v
+}
+
+// inc increments name{labels} by one.
+func (m *metricsRegistry) inc(name string, labels ...string) {
+	m.add(name, 1, labels...)
+}
+
+// set stores v as the value of gauge name{labels}.
+func (m *metricsRegistry) set(name string, v float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f := m.family(name, "gauge", "")
+	f.values[f.key(labels)] = v
+}
+
+// histogram declares a histogram over latencyBuckets.
+func (m *metricsRegistry) histogram(name, help string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.family(name, "histogram", help)
+}
+
+// observe records v (seconds) in histogram name{labels}.
+func (m *metricsRegistry) observe(name string, v float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f := m.family(name, "histogram", "")
+	key := f.key(labels)
+	h, ok := f.hists[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		f.hists[key] = h
+	}
+	for i, le := range latencyBuckets {
+		if v <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// gaugeFunc registers a gauge whose value is computed at scrape time.
+func (m *metricsRegistry) gaugeFunc(name, help string, fn func() float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.family(name, "gauge", help).fn = fn
+}
+
+// labelEscaper escapes label values; NewReplacer is costly, so it is built once.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// key renders name/value pairs as {a="x",b="y"}. It runs on every counted request, so the set is
+// built on the stack and only allocated the first time it is seen.
+func (f *metricFamily) key(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	var scratch [256]byte
+	b := append(scratch[:0], '{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, labels[i]...)
+		b = append(b, '=')
+		b = strconv.AppendQuote(b, labelEscaper.Replace(labels[i+1]))
+	}
+	b = append(b, '}')
+	if k, ok := f.keys[string(b)]; ok {
+		return k
+	}
+	k := string(b)
+	f.keys[k] = k
+	return k
+}
+
+// metricSample is one exposition line; key is the base label set used for ordering.
+type metricSample struct {
+	key    string
+	series string // name with suffix and labels
+	value  float64
+}
+
+func (m *metricsRegistry) writeTo(w io.Writer) {
+	type snapshot struct {
+		name, kind, help string
+		samples          []metricSample
+		fn               func() float64
+	}
+	m.mu.Lock()
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make([]snapshot, 0, len(names))
+	for _, name := range names {
+		f := m.families[name]
+		full := deployment.metricName(name)
+		s := snapshot{name: full, kind: f.kind, help: f.help, fn: f.fn}
+		for l, v := range f.values {
+			s.samples = append(s.samples, metricSample{key: l, series: full + deployment.withLabels(l), value: v})
+		}
+		for l, h := range f.hists {
+			s.samples = append(s.samples, histogramSamples(full, deployment.withLabels(l), h)...)
+		}
+		sort.SliceStable(s.samples, func(i, j int) bool { return s.samples[i].key < s.samples[j].key })
+		out = append(out, s)
+	}
+	m.mu.Unlock()
+
+	for _, s := range out {
+		if s.help != "" {
+			fmt.Fprintf(w, "# HELP %s %s\n", s.name, s.help)
+		}
+		fmt.Fprintf(w, "# TYPE %s %s\n", s.name, s.kind)
+		if s.fn != nil {
+			fmt.Fprintf(w, "%s%s %g\n", s.name, deployment.withLabels(""), s.fn())
+		}
+		for _, sm := range s.samples {
+			fmt.Fprintf(w, "%s %g\n", sm.series, sm.value)
+		}
+	}
+}
+
+// histogramSamples renders the cumulative _bucket, _sum and _count series of one histogram, in order.
+func histogramSamples(name, labels string, h *histogram) []metricSample {
+	inner := strings.TrimSuffix(strings.TrimPrefix(labels, "{"), "}")
+	withLe := func(le string) string {
+		if inner == "" {
+			return fmt.Sprintf("{le=%q}", le)
+		}
+		return fmt.Sprintf("{%s,le=%q}", inner, le)
+	}
+	out := make([]metricSample, 0, len(h.counts)+3)
+	var cum uint64
+	for i, le := range latencyBuckets {
+		cum += h.counts[i]
+		out = append(out, metricSample{labels, name + "_bucket" + withLe(fmt.Sprint(le)), float64(cum)})
+	}
+	out = append(out,
+		metricSample{labels, name + "_bucket" + withLe("+Inf"), float64(h.count)},
+		metricSample{labels, name + "_sum" + labels, h.sum},
+		metricSample{labels, name + "_count" + labels, float64(h.count)},
+	)
+	return out
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.writeTo(w)
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Migrating between registry backends without losing stickiness. REGISTRY_MIGRATE_TO names the
+// new backend (MIGRATE_REDIS_ADDR overrides REDIS_ADDR for it); every write then goes to both the
+// current REGISTRY_BACKEND (primary) and the new one (secondary). REGISTRY_READ_FROM is "primary"
+// (default) or "secondary"; a miss or error on the preferred side falls back to the other.
+// POST /admin/migrate-registry copies existing assignments from primary to secondary;
+// GET reports the job's progress. Cut over by making the new backend REGISTRY_BACKEND.
+
+// dualRegistry writes to two backends and reads from the preferred one.
+type dualRegistry struct {
+	primary   assignmentRegistry
+	secondary assignmentRegistry
+	readFrom  string
+}
+
+func newDualRegistry(primary, secondary assignmentRegistry, readFrom string) *dualRegistry {
+	d := &dualRegistry{primary: primary, secondary: secondary, readFrom: "primary"}
+	if strings.ToLower(strings.TrimSpace(readFrom)) == "secondary" {
+		d.readFrom = "secondary"
+	}
+	metrics.counter("routing_registry_dual_write_errors_total", "Writes that failed on the secondary registry during migration.")
+	metrics.counter("routing_registry_read_fallback_total", "Reads served by the non-preferred registry during migration.")
+	log.Printf("registry migration: dual writes enabled, reads from %s", d.readFrom)
+	return d
+}
+
+func (d *dualRegistry) preferred() (assignmentRegistry, assignmentRegistry) {
+	if d.readFrom == "secondary" {
+		return d.secondary, d.primary
+	}
+	return d.primary, d.secondary
+}
+
+func (d *dualRegistry) Get(clientID string) (Assignment, bool, error) {
+	first, second := d.preferred()
+	a, ok, err := first.Get(clientID)
+	if ok && err == nil {
+		return a, true, nil
+	}
+	a2, ok2, err2 := second.Get(clientID)
+	if ok2 && err2 == nil {
+		metrics.inc("routing_registry_read_fallback_total")
+		return a2, true, nil
+	}
+	if err != nil {
+		return Assignment{}, false, err
+	}
+	return Assignment{}, false, nil
+}
+
+// secondaryErr records a failed secondary write; only primary failures fail the caller.
+func (d *dualRegistry) secondaryErr(op string, err error) {
+	if err != nil {
+		metrics.inc("routing_registry_dual_write_errors_total")
+		log.Printf("registry migration: secondary %s failed: %v", op, err)
+	}
+}
+
+func (d *dualRegistry) Put(a Assignment) error {
+	if err := d.primary.Put(a); err != nil {
+		return err
+	}
+	d.secondaryErr("put", d.secondary.Put(a))
+	return nil
+}
+
+func (d *dualRegistry) PutBatch(as []Assignment) error {
+	if err := putBatch(d.primary, as); err != nil {
+		return err
+	}
+	d.secondaryErr("put", putBatch(d.secondary, as))
+	return nil
+}
+
+func (d *dualRegistry) PutTx(as []Assignment) error {
+	if err := putTx(d.primary, as); err != nil {
+		return err
+	}
+	d.secondaryErr("put", putTx(d.secondary, as))
+	return nil
+}
+
+func (d *dualRegistry) Delete(clientID string) error {
+	if err := d.primary.Delete(clientID); err != nil {
+		return err
+	}
+	d.secondaryErr("delete", d.secondary.Delete(clientID))
+	return nil
+}
+
+func (d *dualRegistry) List() ([]Assignment, error) {
+	first, _ := d.preferred()
+	return first.List()
+}
+
+// migrationJob is the state of the most recent /admin/migrate-registry run.
+type migrationJob struct {
+	mu         sync.Mutex
+	State      string    `json:"state"` // idle, running, done, failed
+	Total      int       `json:"total"`
+	Copied     int       `json:"copied"`
+	Skipped    int       `json:"skipped"` // secondary already had a newer record
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at,omitzero"`
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+}
+
+var migration = &migrationJob{State: "idle"}
+
+// run copies every primary assignment to the secondary in batches, keeping newer secondary records.
+func (j *migrationJob) run(d *dualRegistry) {
+	all, err := d.primary.List()
+	if err != nil {
+		j.finish(err)
+		return
+	}
+	j.mu.Lock()
+	j.Total = len(all)
+	j.mu.Unlock()
+	for start := 0; start < len(all); start += 100 {
+		end := min(start+100, len(all))
+		batch := make([]Assignment, 0, end-start)
+		for _, a := range all[start:end] {
+			if cur, ok, err := d.secondary.Get(a.ClientID); err == nil && ok && cur.UpdatedAt.After(a.UpdatedAt) {
+				j.mu.Lock()
+				j.Skipped++
+				j.mu.Unlock()
+				continue
+			}
+			batch = append(batch, a)
+		}
+		if err := putBatch(d.secondary, batch); err != nil {
+			j.finish(err)
+			return
+		}
+		j.mu.Lock()
+		j.Copied += len(batch)
+		j.mu.Unlock()
+	}
+	j.finish(nil)
+}
+
+func (j *migrationJob) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.FinishedAt = time.Now()
+	j.State = "done"
+	if err != nil {
+		j.State = "failed"
+		j.Error = err.Error()
+	}
+	log.Printf("registry migration %s: copied=%d skipped=%d total=%d", j.State, j.Copied, j.Skipped, j.Total)
+}
+
+func (j *migrationJob) write(w http.ResponseWriter, status int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(j)
+}
+
+// handleMigrateRegistry starts (POST) or reports (GET) the registry copy job.
+func handleMigrateRegistry(w http.ResponseWriter, r *http.Request) {
+	d, ok := registry.(*dualRegistry)
+	if !ok {
+		writeError(w, http.StatusConflict, "MIGRATION_DISABLED", "set REGISTRY_MIGRATE_TO to enable registry migration")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		migration.write(w, http.StatusOK)
+	case http.MethodPost:
+		migration.mu.Lock()
+		if migration.State == "running" {
+			migration.mu.Unlock()
+			writeError(w, http.StatusConflict, "MIGRATION_RUNNING", "a migration is already running")
+			return
+		}
+		migration.State, migration.Error = "running", ""
+		migration.Total, migration.Copied, migration.Skipped = 0, 0, 0
+		migration.StartedAt, migration.FinishedAt = time.Now(), time.Time{}
+		migration.mu.Unlock()
+		go migration.run(d)
+		migration.write(w, http.StatusAccepted)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Mirroring of /join traffic to an external sink for offline analysis.
+// MIRROR_URL enables it; MIRROR_PERCENT (0-100, default 100) samples requests;
+// MIRROR_FORMAT is "json" (default, one event per POST) or "kafka-rest"
+// (Kafka REST proxy v2 envelope, e.g. MIRROR_URL=http://rest-proxy:8082/topics/joins).
+// Events are queued and sent by a background worker; when the queue is full they are dropped,
+// so the primary response path is never blocked.
+
+type mirrorEvent struct {
+	ClientID  string    `json:"client_id"`
+	Target    string    `json:"target"`
+	HandledBy string    `json:"handled_by"`
+	Status    string    `json:"status"`
+	LatencyMs float64   `json:"latency_ms"`
+	Time      time.Time `json:"ts"`
+}
+
+type mirror struct {
+	url     string
+	format  string
+	percent float64
+	queue   chan mirrorEvent
+	client  *http.Client
+	dropped atomic.Int64
+}
+
+var joinMirror = newMirrorFromEnv()
+
+func newMirrorFromEnv() *mirror {
+	u := strings.TrimSpace(os.Getenv("MIRROR_URL"))
+	if u == "" {
+		return nil
+	}
+	percent := 100.0
+	if v := os.Getenv("MIRROR_PERCENT"); v != "" {
+		if p, err := strconv.ParseFloat(v, 64); err == nil && p >= 0 && p <= 100 {
+			percent = p
+		}
+	}
+	m := &mirror{
+		url:     u,
+		format:  strings.ToLower(strings.TrimSpace(os.Getenv("MIRROR_FORMAT"))),
+		percent: percent,
+		queue:   make(chan mirrorEvent, 1024),
+		client:  &http.Client{Timeout: 2 * time.Second},
+	}
+	go m.run()
+	log.Printf("mirroring %.1f%% of /join to %s", percent, u)
+	return m
+}
+
+// offer samples and enqueues ev without blocking. Safe to call on a nil mirror.
+func (m *mirror) offer(ev mirrorEvent) {
+	if m == nil || rand.Float64()*100 >= m.percent {
+		return
+	}
+	select {
+	case m.queue <- ev:
+	default:
+		if n := m.dropped.Add(1); n%1000 == 1 {
+			log.Printf("mirror queue full, dropped=%d", n)
+		}
+	}
+}
+
+func (m *mirror) run() {
+	for ev := range m.queue {
+		var payload any = ev
+		contentType := "application/json"
+		if m.format == "kafka-rest" {
+			payload = map[string]any{"records": []map[string]any{{"key": ev.ClientID, "value": ev}}}
+			contentType = "application/vnd.kafka.json.v2+json"
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			continue
+		}
+		resp, err := m.client.Post(m.url, contentType, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("mirror post failed: %v", err)
+			continue
+		}
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,277 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Operator-initiated moves. POST /admin/move?client_id=&to=server-3 pins the client to the given
+// replica on every replica (via /internal/pin), which then wins over the hash placement while that
+// replica is healthy. The replica holding the client's session hands it to the new owner and answers
+// the client's next request with a redirect carrying reason "reconnect" and Connection: close, so a
+// kept-alive connection is dropped and re-established against the new owner; open /ws connections
+// are closed with code 4000 and reason "reconnect". Moves are emitted as "moved" events and kept in
+// a history on GET /admin/move. DELETE /admin/move?client_id= unpins.
+// Gateways aren't targets, so GATEWAYS lists them (host:port, comma-separated) for the broadcast;
+// each entry is resolved and every address gets the pin, so one headless service name covers a
+// scaled gateway tier. Pins live in memory: a replica or gateway that starts after a move doesn't
+// know it.
+
+type moveRecord struct {
+	ClientID string    `json:"client_id"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Reason   string    `json:"reason"`
+	Time     time.Time `json:"ts"`
+}
+
+// pinTable keeps the pins in an immutable map that set replaces copy-on-write, so the lookup
+// every resolution makes takes no lock. mu serialises writers and guards closing and history.
+type pinTable struct {
+	mu      sync.Mutex
+	pins    atomic.Pointer[map[string]string]
+	closing map[string]bool // clients whose next request should reconnect to their new owner
+	history []moveRecord
+	notify  atomic.Pointer[chan struct{}] // closed and replaced after every pin change
+}
+
+const moveHistoryMax = 100
+
+var pins = newPinTable()
+
+var pinClient = newInternalClient(2 * time.Second)
+
+func newPinTable() *pinTable {
+	p := &pinTable{closing: make(map[string]bool)}
+	empty, ch := map[string]string{}, make(chan struct{})
+	p.pins.Store(&empty)
+	p.notify.Store(&ch)
+	return p
+}
+
+// get returns the replica clientID is pinned to.
+func (p *pinTable) get(clientID string) (string, bool) {
+	to, ok := (*p.pins.Load())[clientID]
+	return to, ok
+}
+
+// current returns the pins in effect; the map must not be modified.
+func (p *pinTable) current() map[string]string {
+	return *p.pins.Load()
+}
+
+func (p *pinTable) set(rec moveRecord) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	next := maps.Clone(p.current())
+	if rec.To == "" {
+		delete(next, rec.ClientID)
+	} else {
+		next[rec.ClientID] = rec.To
+	}
+	p.pins.Store(&next)
+	p.history = append(p.history, rec)
+	if len(p.history) > moveHistoryMax {
+		p.history = p.history[len(p.history)-moveHistoryMax:]
+	}
+	ch := make(chan struct{})
+	close(*p.notify.Swap(&ch))
+}
+
+// moves returns a copy of the move history, oldest first.
+func (p *pinTable) moves() []moveRecord {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]moveRecord(nil), p.history...)
+}
+
+// updated returns a channel that is closed after the next pin change.
+func (p *pinTable) updated() <-chan struct{} {
+	return *p.notify.Load()
+}
+
+// markClosing flags clientID so its next request here is told to reconnect.
+func (p *pinTable) markClosing(clientID string) {
+	p.mu.Lock()
+	p.closing[clientID] = true
+	p.mu.Unlock()
+}
+
+// takeClosing reports and clears the reconnect flag for clientID.
+func (p *pinTable) takeClosing(clientID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ok := p.closing[clientID]
+	delete(p.closing, clientID)
+	return ok
+}
+
+// pinnedOwner returns clientID's pinned replica if it has one, it is still in the ring (see
+// compaction.go) and it is healthy.
+func pinnedOwner(clientID string) (string, bool) {
+	to, ok := pins.get(clientID)
+	if !ok || !slices.Contains(allTargets(), to) || !ownerHealthy(to) {
+		return "", false
+	}
+	return to, true
+}
+
+// applyPin records a move locally and, if this replica holds the session, hands it to the new owner.
+func applyPin(rec moveRecord) {
+	pins.set(rec)
+	if rec.To == "" {
+		return
+	}
+	if isSelfTarget(rec.To) {
+		return
+	}
+	if n := wsClients.closeClient(rec.ClientID, "reconnect"); n > 0 {
+		log.Printf("move client_id=%s: closed %d websocket connection(s) with reason reconnect", rec.ClientID, n)
+	}
+	if _, ok := sessions.get(rec.ClientID); ok {
+		pins.markClosing(rec.ClientID)
+		_ = handOff(rec.ClientID, rec.To)
+	}
+}
+
+// handlePin receives a move from the replica that accepted POST /admin/move (internal API).
+func handlePin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var rec moveRecord
+	if err := json.NewDecoder(r.Body).Decode(&rec); err != nil || rec.ClientID == "" {
+		http.Error(w, "invalid pin", http.StatusBadRequest)
+		return
+	}
+	applyPin(rec)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func postPin(target string, body []byte) error {
+	req, err := newInternalRequest(http.MethodPost, target, "/internal/pin", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := pinClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// gatewayTargets expands GATEWAYS into one host:port per gateway address.
+func gatewayTargets() []string {
+	var out []string
+	for _, entry := range strings.Split(os.Getenv("GATEWAYS"), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(entry)
+		if err != nil {
+			host, port = entry, "8081"
+		}
+		addrs, err := net.LookupHost(host)
+		if err != nil {
+			log.Printf("move: resolving gateway %s failed: %v", entry, err)
+			out = append(out, entry) // reported as failed by the post
+			continue
+		}
+		for _, a := range addrs {
+			out = append(out, net.JoinHostPort(a, port))
+		}
+	}
+	return out
+}
+
+// broadcastPin applies rec on every replica and gateway and returns the ones that could not be
+// reached.
+func broadcastPin(rec moveRecord) []string {
+	body, _ := json.Marshal(rec)
+	var failed []string
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, t := range slices.Concat(allTargets(), gatewayTargets()) {
+		if isSelfTarget(t) {
+			continue
+		}
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			if err := postPin(target, body); err != nil {
+				log.Printf("move client_id=%s: pin on %s failed: %v", rec.ClientID, target, err)
+				mu.Lock()
+				failed = append(failed, target)
+				mu.Unlock()
+			}
+		}(t)
+	}
+	applyPin(rec)
+	wg.Wait()
+	return failed
+}
+
+func handleMove(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"pins": pins.current(), "history": pins.moves()})
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	clientID := q.Get("client_id")
+	if clientID == "" {
+		http.Error(w, "missing client_id", http.StatusBadRequest)
+		return
+	}
+	from, err := resolveOwner(r.Context(), clientID)
+	if err != nil {
+		writeResolveError(w, err)
+		return
+	}
+	rec := moveRecord{ClientID: clientID, From: from, Reason: "unpin", Time: time.Now()}
+	if r.Method == http.MethodPost {
+		for _, t := range allTargets() {
+			if sameReplica(q.Get("to"), t) {
+				rec.To = t
+				break
+			}
+		}
+		if rec.To == "" {
+			writeError(w, http.StatusBadRequest, "UNKNOWN_REPLICA", fmt.Sprintf("to=%q is not a known replica", q.Get("to")))
+			return
+		}
+		if !ownerHealthy(rec.To) {
+			writeError(w, http.StatusConflict, "REPLICA_UNHEALTHY", rec.To+" is not healthy")
+			return
+		}
+		rec.Reason = "reconnect"
+	}
+	unreached := broadcastPin(rec)
+	log.Printf("move client_id=%s from=%s to=%s reason=%s unreached=%v", clientID, rec.From, rec.To, rec.Reason, unreached)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"move":        rec,
+		"unreachable": unreached,
+	})
+}
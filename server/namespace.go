@@ -0,0 +1,78 @@
+package server
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Telling deployments apart. Several routing deployments (one per routing pool or tenant) may be
+// scraped by one Prometheus and ship logs and events to the same sinks. DEPLOYMENT_POOL and
+// DEPLOYMENT_TENANT name this one: every metric series gets pool="..." and tenant="..." labels
+// (a series that already has a label of that name, e.g. routing_pool_requests_total{pool}, keeps
+// its own), every log line is prefixed with pool=... tenant=..., and every assignment event
+// carries "pool" and "tenant". METRICS_NAMESPACE (e.g. "orders") is prepended with an underscore to
+// every metric name, so orders_routing_failover_total and billing_routing_failover_total don't collide
+// even without the labels.
+
+type deploymentInfo struct {
+	pool      string
+	tenant    string
+	namespace string // with its trailing underscore
+	labels    []string
+}
+
+var deployment = newDeploymentFromEnv()
+
+func newDeploymentFromEnv() deploymentInfo {
+	d := deploymentInfo{
+		pool:   strings.TrimSpace(os.Getenv("DEPLOYMENT_POOL")),
+		tenant: strings.TrimSpace(os.Getenv("DEPLOYMENT_TENANT")),
+	}
+	if ns := strings.Trim(strings.TrimSpace(os.Getenv("METRICS_NAMESPACE")), "_"); ns != "" {
+		d.namespace = ns + "_"
+	}
+	var prefix []string
+	if d.pool != "" {
+		d.labels = append(d.labels, "pool", d.pool)
+		prefix = append(prefix, "pool="+d.pool)
+	}
+	if d.tenant != "" {
+		d.labels = append(d.labels, "tenant", d.tenant)
+		prefix = append(prefix, "tenant="+d.tenant)
+	}
+	if len(prefix) > 0 {
+		log.SetFlags(log.Flags() | log.Lmsgprefix)
+		log.SetPrefix(strings.Join(prefix, " ") + " ")
+	}
+	return d
+}
+
+// metricName is name within METRICS_NAMESPACE.
+func (d deploymentInfo) metricName(name string) string {
+	return d.namespace + name
+}
+
+// withLabels adds the deployment labels to a rendered label set, skipping any it already has.
+func (d deploymentInfo) withLabels(rendered string) string {
+	if len(d.labels) == 0 {
+		return rendered
+	}
+	inner := strings.TrimSuffix(strings.TrimPrefix(rendered, "{"), "}")
+	b := []byte{'{'}
+	b = append(b, inner...)
+	for i := 0; i+1 < len(d.labels); i += 2 {
+		name := d.labels[i]
+		if strings.HasPrefix(inner, name+"=") || strings.Contains(inner, ","+name+"=") {
+			continue
+		}
+		if len(b) > 1 {
+			b = append(b, ',')
+		}
+		b = append(b, name...)
+		b = append(b, '=')
+		b = strconv.AppendQuote(b, labelEscaper.Replace(d.labels[i+1]))
+	}
+	return string(append(b, '}'))
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// Target naming. TARGET_TEMPLATE is a Go template rendering a replica's host:port from:
+//   - .Prefix (SERVICE_PREFIX), .Suffix (SERVICE_SUFFIX), .Index, .Ordinal (Index - INDEX_BASE)
+//   - .Port (PORT), .Domain (TARGET_DOMAIN)
+//   - .Zone: TARGET_ZONES entry for the replica, assigned round-robin by ordinal
+//
+// The default reproduces the original <SERVICE_PREFIX>-<idx><SERVICE_SUFFIX>:PORT. Per-zone headless
+// services, for example:
+//
+//	TARGET_TEMPLATE='{{.Prefix}}-{{.Index}}.{{.Prefix}}-{{.Zone}}.{{.Domain}}:{{.Port}}'
+//	TARGET_ZONES=zone-a,zone-b,zone-c TARGET_DOMAIN=poc-routing.svc.cluster.local
+
+const defaultTargetTemplate = "{{.Prefix}}-{{.Index}}{{.Suffix}}:{{.Port}}"
+
+type targetFields struct {
+	Prefix  string
+	Suffix  string
+	Index   int
+	Ordinal int
+	Port    string
+	Zone    string
+	Domain  string
+}
+
+var targetTemplate = parseTargetTemplate(os.Getenv("TARGET_TEMPLATE"))
+
+func parseTargetTemplate(text string) *template.Template {
+	if strings.TrimSpace(text) == "" {
+		text = defaultTargetTemplate
+	}
+	t, err := template.New("target").Option("missingkey=error").Parse(text)
+	if err != nil {
+		log.Fatalf("invalid TARGET_TEMPLATE: %v", err)
+	}
+	return t
+}
+
+// targetZones returns the TARGET_ZONES list.
+func targetZones() []string {
+	var out []string
+	for _, z := range strings.Split(os.Getenv("TARGET_ZONES"), ",") {
+		if z = strings.TrimSpace(z); z != "" {
+			out = append(out, z)
+		}
+	}
+	return out
+}
+
+// renderTarget renders the target name of replica idx.
+func renderTarget(idx int, port string) (string, error) {
+	f := targetFields{
+		Prefix:  os.Getenv("SERVICE_PREFIX"),
+		Suffix:  os.Getenv("SERVICE_SUFFIX"),
+		Index:   idx,
+		Ordinal: idx - indexBase(),
+		Port:    port,
+		Domain:  os.Getenv("TARGET_DOMAIN"),
+	}
+	if zones := targetZones(); len(zones) > 0 && f.Ordinal >= 0 {
+		f.Zone = zones[f.Ordinal%len(zones)]
+	}
+	var b strings.Builder
+	if err := targetTemplate.Execute(&b, f); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// replicaAddresses returns REPLICA_ADDRESSES, an ordered list of replica hosts or IPs that don't
+// follow the <prefix>-<idx> pattern (VMs, plain Docker containers). Entries without a port get PORT.
+// Index i of the list is replica INDEX_BASE+i.
+func replicaAddresses() []string {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8081"
+	}
+	var out []string
+	for _, a := range strings.Split(os.Getenv("REPLICA_ADDRESSES"), ",") {
+		if a = strings.TrimSpace(a); a == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(a); err != nil {
+			a = net.JoinHostPort(strings.Trim(a, "[]"), port)
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
+// targetNamed reports whether a replica name from config (STANDBY_PAIRS, MAINTENANCE_WINDOWS)
+// refers to target: the target itself, its host, or the host's first DNS label (the pod name).
+func targetNamed(name, target string) bool {
+	if name == target {
+		return true
+	}
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		host = target
+	}
+	label, _, _ := strings.Cut(host, ".")
+	return name == host || name == label
+}
+
+// ringTarget maps a replica name stored in the registry onto its target in ring: the target
+// itself or, for assignments recorded under a replica's hostname:port (before joins stored
+// selfTarget), the target whose first DNS label and port match.
+func ringTarget(name string, ring []string) (string, bool) {
+	if name == "" {
+		return "", false
+	}
+	if slices.Contains(ring, name) {
+		return name, true
+	}
+	host, port, err := net.SplitHostPort(name)
+	if err != nil {
+		host = name
+	}
+	for _, t := range ring {
+		if _, tp, err := net.SplitHostPort(t); targetNamed(host, t) && (port == "" || err != nil || port == tp) {
+			return t, true
+		}
+	}
+	return "", false
+}
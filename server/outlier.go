@@ -0,0 +1,204 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Outlier detection on the gateway's upstream replicas, modelled on Envoy's consecutive_5xx
+// detector. With OUTLIER_DETECTION=on, a replica whose proxied calls fail OUTLIER_CONSECUTIVE_5XX
+// times in a row (default 5; a transport error counts as a 5xx, as in Envoy) is ejected: the
+// routing table marks it unhealthy, so failover places its clients on their candidates, as it
+// would for a failed probe. It stays ejected for OUTLIER_BASE_EJECTION_TIME (default 30s) times
+// the number of times it has been ejected, at most 300s, checked every OUTLIER_INTERVAL (default
+// 10s); each interval spent back in service lowers that multiplier again. At most
+// OUTLIER_MAX_EJECTION_PERCENT of the ring (default 10, but always at least one replica) is
+// ejected at once. Where breakers refuse calls to a failing replica, ejection routes around it.
+//
+// Metrics use Envoy's names and its envoy_cluster_name label (XDS_CLUSTER), so one dashboard
+// covers both proxies: envoy_cluster_outlier_detection_ejections_active,
+// _ejections_enforced_total, _ejections_enforced_consecutive_5xx,
+// _ejections_detected_consecutive_5xx and _ejections_overflow.
+
+const outlierMaxEjectionTime = 300 * time.Second
+
+type outlierHost struct {
+	consecutive int
+	ejected     bool
+	ejectedAt   time.Time
+	ejections   int // multiplier for the next ejection time
+	returnedAt  time.Time
+}
+
+type outlierDetector struct {
+	enabled     bool
+	consecutive int
+	baseTime    time.Duration
+	interval    time.Duration
+	maxPercent  int
+
+	mu    sync.Mutex
+	hosts map[string]*outlierHost
+}
+
+var outliers = newOutlierDetectorFromEnv()
+
+func newOutlierDetectorFromEnv() *outlierDetector {
+	d := &outlierDetector{
+		enabled:     os.Getenv("OUTLIER_DETECTION") == "on",
+		consecutive: 5,
+		baseTime:    30 * time.Second,
+		interval:    10 * time.Second,
+		maxPercent:  10,
+		hosts:       make(map[string]*outlierHost),
+	}
+	if n, err := strconv.Atoi(os.Getenv("OUTLIER_CONSECUTIVE_5XX")); err == nil && n > 0 {
+		d.consecutive = n
+	}
+	if v, err := time.ParseDuration(os.Getenv("OUTLIER_BASE_EJECTION_TIME")); err == nil && v > 0 {
+		d.baseTime = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("OUTLIER_INTERVAL")); err == nil && v > 0 {
+		d.interval = v
+	}
+	if n, err := strconv.Atoi(os.Getenv("OUTLIER_MAX_EJECTION_PERCENT")); err == nil && n >= 0 && n <= 100 {
+		d.maxPercent = n
+	}
+	if d.enabled {
+		metrics.gauge("envoy_cluster_outlier_detection_ejections_active", "Replicas currently ejected.")
+		metrics.counter("envoy_cluster_outlier_detection_ejections_enforced_total", "Ejections carried out.")
+		metrics.counter("envoy_cluster_outlier_detection_ejections_enforced_consecutive_5xx", "Ejections carried out for consecutive 5xx.")
+		metrics.counter("envoy_cluster_outlier_detection_ejections_detected_consecutive_5xx", "Replicas detected as consecutive 5xx outliers, ejected or not.")
+		metrics.counter("envoy_cluster_outlier_detection_ejections_overflow", "Ejections skipped because OUTLIER_MAX_EJECTION_PERCENT was reached.")
+	}
+	return d
+}
+
+// observe records the outcome of a proxied call to target.
+func (d *outlierDetector) observe(target string, ok bool) {
+	if !d.enabled || !slices.Contains(allTargets(), target) {
+		return
+	}
+	d.mu.Lock()
+	h := d.hosts[target]
+	if h == nil {
+		h = &outlierHost{}
+		d.hosts[target] = h
+	}
+	if ok || h.ejected {
+		h.consecutive = 0
+		d.mu.Unlock()
+		return
+	}
+	h.consecutive++
+	if h.consecutive < d.consecutive {
+		d.mu.Unlock()
+		return
+	}
+	h.consecutive = 0
+	cluster := xds.cluster
+	metrics.inc("envoy_cluster_outlier_detection_ejections_detected_consecutive_5xx", "envoy_cluster_name", cluster)
+	if d.activeLocked() >= d.maxEjected() {
+		d.mu.Unlock()
+		metrics.inc("envoy_cluster_outlier_detection_ejections_overflow", "envoy_cluster_name", cluster)
+		log.Printf("outlier: %s failed %d calls in a row, not ejected: %d%% of the ring already is", target, d.consecutive, d.maxPercent)
+		return
+	}
+	h.ejected, h.ejectedAt = true, clock.Now()
+	h.ejections++
+	ejectFor := d.ejectionTime(h)
+	active := d.activeLocked()
+	d.mu.Unlock()
+	metrics.inc("envoy_cluster_outlier_detection_ejections_enforced_total", "envoy_cluster_name", cluster)
+	metrics.inc("envoy_cluster_outlier_detection_ejections_enforced_consecutive_5xx", "envoy_cluster_name", cluster)
+	metrics.set("envoy_cluster_outlier_detection_ejections_active", float64(active), "envoy_cluster_name", cluster)
+	log.Printf("outlier: ejecting %s for %s after %d consecutive 5xx", target, ejectFor, d.consecutive)
+	publishTable("outlier", nil)
+}
+
+// maxEjected is how many replicas may be ejected at once.
+func (d *outlierDetector) maxEjected() int {
+	return max(1, len(allTargets())*d.maxPercent/100)
+}
+
+func (d *outlierDetector) activeLocked() int {
+	n := 0
+	for _, h := range d.hosts {
+		if h.ejected {
+			n++
+		}
+	}
+	return n
+}
+
+func (d *outlierDetector) ejectionTime(h *outlierHost) time.Duration {
+	return min(d.baseTime*time.Duration(h.ejections), max(d.baseTime, outlierMaxEjectionTime))
+}
+
+// ejected lists the replicas currently ejected.
+func (d *outlierDetector) ejected() map[string]bool {
+	if !d.enabled {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make(map[string]bool)
+	for target, h := range d.hosts {
+		if h.ejected {
+			out[target] = true
+		}
+	}
+	return out
+}
+
+// runOutlierDetector returns ejected replicas to service once their ejection time is up.
+func runOutlierDetector() {
+	d := outliers
+	if !d.enabled {
+		return
+	}
+	for range clock.Tick(d.interval) {
+		d.sweep()
+	}
+}
+
+func (d *outlierDetector) sweep() {
+	now := clock.Now()
+	var returned []string
+	d.mu.Lock()
+	for target, h := range d.hosts {
+		switch {
+		case h.ejected && now.Sub(h.ejectedAt) >= d.ejectionTime(h):
+			h.ejected, h.returnedAt = false, now
+			returned = append(returned, target)
+		case !h.ejected && h.ejections > 0 && now.Sub(h.returnedAt) >= d.interval:
+			h.ejections--
+			h.returnedAt = now
+		}
+	}
+	active := d.activeLocked()
+	d.mu.Unlock()
+	if len(returned) == 0 {
+		return
+	}
+	metrics.set("envoy_cluster_outlier_detection_ejections_active", float64(active), "envoy_cluster_name", xds.cluster)
+	log.Printf("outlier: returning %v to service", returned)
+	publishTable("outlier", nil)
+}
+
+// outlierTransport reports the outcome of every call to the outlier detector.
+type outlierTransport struct{ base http.RoundTripper }
+
+func (t outlierTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	// A call canceled by its caller (e.g. a hedge that lost) says nothing about the replica.
+	if req.Context().Err() == nil {
+		outliers.observe(req.URL.Host, err == nil && resp.StatusCode < http.StatusInternalServerError)
+	}
+	return resp, err
+}
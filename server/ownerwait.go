@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Holding requests while the hash owner is briefly unhealthy (e.g. a pod restart), rather than
+// answering with a target that is down. OWNER_WAIT_QUEUE sets how many requests may wait at once
+// (0, the default, disables waiting); OWNER_WAIT_DEADLINE (default 3s) bounds each wait.
+// Requests past the queue depth or the deadline get 503 OWNER_UNAVAILABLE.
+
+var (
+	errOwnerUnavailable = errors.New("owner replica unavailable")
+	errOwnerQueueFull   = errors.New("owner wait queue full")
+)
+
+type ownerWaitQueue struct {
+	max      int64
+	deadline time.Duration
+	depth    atomic.Int64
+}
+
+var ownerWait = newOwnerWaitQueueFromEnv()
+
+func newOwnerWaitQueueFromEnv() *ownerWaitQueue {
+	q := &ownerWaitQueue{deadline: 3 * time.Second}
+	if n, err := strconv.ParseInt(os.Getenv("OWNER_WAIT_QUEUE"), 10, 64); err == nil && n > 0 {
+		q.max = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("OWNER_WAIT_DEADLINE")); err == nil && d > 0 {
+		q.deadline = d
+	}
+	metrics.counter("routing_owner_wait_total", "Requests held because their owner was unhealthy, by outcome.")
+	metrics.counter("routing_owner_wait_seconds_total", "Total time requests spent waiting for their owner.")
+	metrics.gaugeFunc("routing_owner_wait_queue_depth", "Requests currently waiting for their owner.", func() float64 {
+		return float64(q.depth.Load())
+	})
+	return q
+}
+
+// waiting reports whether any request is currently held.
+func (q *ownerWaitQueue) waiting() bool {
+	return q.depth.Load() > 0
+}
+
+// ownerHealthy reports whether the replica view considers owner healthy. Targets the view
+// doesn't know about (or before the first poll) count as healthy.
+func ownerHealthy(owner string) bool {
+	t := currentTable()
+	if !t.polled {
+		return true
+	}
+	info, ok := t.info(owner)
+	return !ok || info.Healthy
+}
+
+// await returns once owner is healthy, or fails when queuing is disabled, the queue is full,
+// the deadline passes or ctx is done.
+func (q *ownerWaitQueue) await(ctx context.Context, clientID, owner string) error {
+	if q.max == 0 || ownerHealthy(owner) {
+		return nil
+	}
+	if q.depth.Add(1) > q.max {
+		q.depth.Add(-1)
+		metrics.inc("routing_owner_wait_total", "outcome", "rejected")
+		return errOwnerQueueFull
+	}
+	defer q.depth.Add(-1)
+
+	start := time.Now()
+	timer := time.NewTimer(q.deadline)
+	defer timer.Stop()
+	defer func() { metrics.add("routing_owner_wait_seconds_total", time.Since(start).Seconds()) }()
+	for {
+		select {
+		case <-replicas.updated():
+			if ownerHealthy(owner) {
+				metrics.inc("routing_owner_wait_total", "outcome", "recovered")
+				log.Printf("client_id=%s owner %s recovered after %s", clientID, owner, time.Since(start))
+				return nil
+			}
+		case <-timer.C:
+			metrics.inc("routing_owner_wait_total", "outcome", "timeout")
+			log.Printf("client_id=%s owner %s still unhealthy after %s", clientID, owner, q.deadline)
+			return errOwnerUnavailable
+		case <-ctx.Done():
+			metrics.inc("routing_owner_wait_total", "outcome", "canceled")
+			return ctx.Err()
+		}
+	}
+}
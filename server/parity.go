@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Live routing parity. The Envoy Lua filter forwards the target it resolved in a request header
+// (PARITY_HEADER, default x-routing-target). On sticky requests the receiving replica compares it
+// with the owner it computes itself and with its own identity, counting the result in
+// routing_parity_checks_total{result} and keeping recent mismatches for GET /parity/summary.
+
+type parityMismatch struct {
+	ClientID    string    `json:"client_id"`
+	EnvoyTarget string    `json:"envoy_target"`
+	LocalOwner  string    `json:"local_owner"`
+	ServedBy    string    `json:"served_by"`
+	Reason      string    `json:"reason"` // owner_differs or wrong_replica
+	Time        time.Time `json:"ts"`
+}
+
+type parityTracker struct {
+	header string
+
+	mu         sync.Mutex
+	checked    int
+	mismatched int
+	recent     []parityMismatch
+}
+
+var parity = newParityTrackerFromEnv()
+
+func newParityTrackerFromEnv() *parityTracker {
+	h := os.Getenv("PARITY_HEADER")
+	if h == "" {
+		h = "x-routing-target"
+	}
+	metrics.counter("routing_parity_checks_total", "Sticky requests whose Envoy-chosen target was compared with the local owner, by result.")
+	return &parityTracker{header: h, recent: []parityMismatch{}}
+}
+
+// check compares the Envoy-chosen target on r, if any, with owner.
+func (p *parityTracker) check(r *http.Request, clientID, owner string) {
+	if target := r.Header.Get(p.header); target != "" {
+		p.compare(target, clientID, owner, true)
+	}
+}
+
+// compare counts whether target matches owner and, for a sticky request (one that must run on its
+// owner), whether this replica is that target.
+func (p *parityTracker) compare(target, clientID, owner string, sticky bool) {
+	reason := ""
+	switch {
+	case target != owner:
+		reason = "owner_differs"
+	case sticky && !isSelfTarget(target):
+		reason = "wrong_replica"
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.checked++
+	if reason == "" {
+		metrics.inc("routing_parity_checks_total", "result", "match")
+		return
+	}
+	metrics.inc("routing_parity_checks_total", "result", reason)
+	p.mismatched++
+	p.recent = append(p.recent, parityMismatch{
+		ClientID: clientID, EnvoyTarget: target, LocalOwner: owner, ServedBy: getSelf(), Reason: reason, Time: time.Now(),
+	})
+	if len(p.recent) > 50 {
+		p.recent = p.recent[len(p.recent)-50:]
+	}
+	log.Printf("parity mismatch client_id=%s envoy=%s local=%s (%s)", clientID, target, owner, reason)
+}
+
+func handleParitySummary(w http.ResponseWriter, r *http.Request) {
+	parity.mu.Lock()
+	defer parity.mu.Unlock()
+	ratio := 1.0
+	if parity.checked > 0 {
+		ratio = float64(parity.checked-parity.mismatched) / float64(parity.checked)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"replica":           getSelf(),
+		"header":            parity.header,
+		"checked":           parity.checked,
+		"mismatched":        parity.mismatched,
+		"match_ratio":       ratio,
+		"recent_mismatches": parity.recent,
+	})
+}
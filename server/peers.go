@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SERVER_PEERS parsing. The legacy peer list is hashed over as given, so a stray duplicate or a
+// typo silently shifts every client's share. Entries are therefore normalised before use:
+//   - whitespace around entries is dropped, and empty entries are skipped
+//   - an entry without a port gets PORT (default 8081); IPv6 hosts may be bracketed or bare
+//   - host names are lower-cased, since DNS is case-insensitive
+//   - an entry whose port isn't 1-65535, or whose host is empty or contains spaces, is dropped
+//   - a repeat of an earlier peer is dropped, so the first occurrence keeps its position
+//
+// Declared order is otherwise preserved. Each dropped entry is logged once per configuration,
+// and GET /config/peers shows every entry with what became of it next to the effective list.
+
+type peerEntry struct {
+	Entry  string `json:"entry"`
+	Peer   string `json:"peer,omitempty"`
+	Status string `json:"status"` // ok, duplicate or invalid
+	Reason string `json:"reason,omitempty"`
+}
+
+type peerList struct {
+	raw     string
+	port    string
+	peers   []string
+	entries []peerEntry
+}
+
+var peerCache struct {
+	mu   sync.Mutex
+	last *peerList
+}
+
+// legacyPeers returns the effective SERVER_PEERS list.
+func legacyPeers() []string {
+	return currentPeerList().peers
+}
+
+// currentPeerList parses SERVER_PEERS, reusing the last parse while it and PORT are unchanged.
+func currentPeerList() *peerList {
+	raw, port := os.Getenv("SERVER_PEERS"), selfPort()
+	peerCache.mu.Lock()
+	defer peerCache.mu.Unlock()
+	if l := peerCache.last; l != nil && l.raw == raw && l.port == port {
+		return l
+	}
+	l := parsePeers(raw, port)
+	for _, e := range l.entries {
+		if e.Status != "ok" {
+			log.Printf("SERVER_PEERS: dropping %q (%s: %s)", e.Entry, e.Status, e.Reason)
+		}
+	}
+	peerCache.last = l
+	return l
+}
+
+func parsePeers(raw, defaultPort string) *peerList {
+	l := &peerList{raw: raw, port: defaultPort}
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		e := peerEntry{Entry: entry}
+		peer, reason := normalizePeer(entry, defaultPort)
+		switch {
+		case reason != "":
+			e.Status, e.Reason = "invalid", reason
+		case seen[peer]:
+			e.Peer, e.Status, e.Reason = peer, "duplicate", "same peer as an earlier entry"
+		default:
+			e.Peer, e.Status = peer, "ok"
+			seen[peer] = true
+			l.peers = append(l.peers, peer)
+		}
+		l.entries = append(l.entries, e)
+	}
+	return l
+}
+
+// normalizePeer returns entry as host:port, or why it can't be used.
+func normalizePeer(entry, defaultPort string) (string, string) {
+	host, port, err := net.SplitHostPort(entry)
+	if err != nil {
+		// No port: a plain host, a bracketed IPv6 address or a bare one.
+		host, port = strings.TrimSuffix(strings.TrimPrefix(entry, "["), "]"), defaultPort
+		if strings.Contains(host, ":") && net.ParseIP(host) == nil {
+			return "", "not host:port"
+		}
+	}
+	if host == "" {
+		return "", "missing host"
+	}
+	if strings.ContainsAny(host, " \t/[]") {
+		return "", "invalid host"
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", "invalid port " + strconv.Quote(port)
+	}
+	return net.JoinHostPort(strings.ToLower(host), port), ""
+}
+
+// handleConfigPeers shows how SERVER_PEERS was parsed.
+func handleConfigPeers(w http.ResponseWriter, r *http.Request) {
+	l := currentPeerList()
+	peers, entries := l.peers, l.entries
+	if peers == nil {
+		peers = []string{}
+	}
+	if entries == nil {
+		entries = []peerEntry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"raw":          l.raw,
+		"default_port": l.port,
+		"peers":        peers,
+		"entries":      entries,
+		"in_use":       currentTable().legacy,
+	})
+}
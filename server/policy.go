@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// Scriptable routing policy. ROUTING_EXPR is an expr-lang expression (github.com/expr-lang/expr)
+// that computes the target position (0..replicas-1, in the order of /cluster/status) from the
+// request, replacing the hash pick:
+//
+//	has_prefix(client_id, "vip-") ? 0 : 1 + hash(client_id) % (replicas - 1)
+//	header("x-tenant") == "acme" ? hash(key) % 2 : hash(key) % replicas
+//
+// Variables: client_id, key (the routing key, see GROUP_DELIMITER), replicas, index_base.
+// Functions: hash(s) (FNV-1a 32), header(name), has_prefix(s, p), has_suffix(s, p), and int(v),
+// which truncates a number and turns a string that isn't one into -1; expr's own builtins (len,
+// lower, ...) and operators (s contains sub, ?:, ??, ...) work too. / divides as floats, so an
+// integer position needs % or int(). The expression is compiled and type-checked at startup, and a
+// bad one stops the server. Each evaluation is capped at ROUTING_EXPR_TIMEOUT (default 5ms), on
+// top of expr's own node and memory budgets. An error, timeout or result that isn't a position in
+// range falls back to the hash pick and counts in routing_expr_errors_total{reason}.
+
+var routingPolicy = parseRoutingPolicy(os.Getenv("ROUTING_EXPR"))
+
+type policyExpr struct {
+	program *vm.Program
+	timeout time.Duration
+}
+
+// policyEnvTypes declares the variables for type-checking; each evaluation gets its own values.
+var policyEnvTypes = map[string]any{
+	"client_id":  "",
+	"key":        "",
+	"replicas":   0,
+	"index_base": 0,
+	"header":     func(string) string { return "" },
+}
+
+var policyFunctions = []expr.Option{
+	expr.Function("hash", func(args ...any) (any, error) {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(args[0].(string)))
+		return int(h.Sum32()), nil
+	}, new(func(string) int)),
+	expr.Function("has_prefix", func(args ...any) (any, error) {
+		return strings.HasPrefix(args[0].(string), args[1].(string)), nil
+	}, new(func(string, string) bool)),
+	expr.Function("has_suffix", func(args ...any) (any, error) {
+		return strings.HasSuffix(args[0].(string), args[1].(string)), nil
+	}, new(func(string, string) bool)),
+	expr.Function("int", func(args ...any) (any, error) {
+		switch v := args[0].(type) {
+		case int:
+			return v, nil
+		case float64:
+			return int(v), nil
+		case string:
+			if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+				return n, nil
+			}
+		}
+		return -1, nil
+	}, new(func(string) int), new(func(int) int), new(func(float64) int)),
+}
+
+// compilePolicy compiles src against the policy variables and functions.
+func compilePolicy(src string) (*vm.Program, error) {
+	return expr.Compile(src, append([]expr.Option{expr.Env(policyEnvTypes)}, policyFunctions...)...)
+}
+
+func parseRoutingPolicy(src string) *policyExpr {
+	if strings.TrimSpace(src) == "" {
+		return nil
+	}
+	program, err := compilePolicy(src)
+	if err != nil {
+		log.Fatalf("invalid ROUTING_EXPR: %v", err)
+	}
+	timeout := 5 * time.Millisecond
+	if d, err := time.ParseDuration(os.Getenv("ROUTING_EXPR_TIMEOUT")); err == nil && d > 0 {
+		timeout = d
+	}
+	metrics.counter("routing_expr_errors_total", "ROUTING_EXPR evaluations that fell back to the hash pick, by reason.")
+	log.Printf("routing policy: %s", src)
+	return &policyExpr{program: program, timeout: timeout}
+}
+
+type policyResult struct {
+	v   any
+	err error
+}
+
+// eval runs the policy for clientID over n targets. An evaluation past the timeout is abandoned;
+// expr's budgets bound how long it keeps running.
+func (p *policyExpr) eval(clientID string, h http.Header, n int) (any, string) {
+	env := map[string]any{
+		"client_id":  clientID,
+		"key":        routingKey(clientID),
+		"replicas":   n,
+		"index_base": indexBase(),
+		"header":     func(name string) string { return h.Get(name) },
+	}
+	done := make(chan policyResult, 1)
+	go func() {
+		v, err := expr.Run(p.program, env)
+		done <- policyResult{v, err}
+	}()
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		if res.err != nil {
+			return nil, "error"
+		}
+		return res.v, ""
+	case <-timer.C:
+		return nil, "timeout"
+	}
+}
+
+// pick evaluates the policy for clientID and returns the chosen target.
+func (p *policyExpr) pick(clientID string, h http.Header) (string, bool) {
+	targets := allTargets()
+	if len(targets) == 0 {
+		return "", false
+	}
+	v, reason := p.eval(clientID, h, len(targets))
+	idx, isInt := v.(int)
+	if reason == "" && (!isInt || idx < 0 || idx >= len(targets)) {
+		reason = "out_of_range"
+	}
+	if reason != "" {
+		metrics.inc("routing_expr_errors_total", "reason", reason)
+		return "", false
+	}
+	return targets[idx], true
+}
+
+// Request headers travel to resolveOwner in the request context.
+
+type headersKey struct{}
+
+func withRequestHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), headersKey{}, r.Header)))
+	})
+}
+
+func requestHeaders(ctx context.Context) http.Header {
+	h, _ := ctx.Value(headersKey{}).(http.Header)
+	return h
+}
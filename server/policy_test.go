@@ -0,0 +1,102 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// ROUTING_EXPR decides where clients go, so the documented variables and functions must behave
+// as described, a bad expression must fail at startup, and anything an expression can't answer
+// must fall back to the hash pick instead of failing.
+
+// evalPolicy compiles and evaluates src for client "c-42" over 4 replicas.
+func evalPolicy(t *testing.T, src string, h http.Header) (any, string) {
+	t.Helper()
+	program, err := compilePolicy(src)
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	return (&policyExpr{program: program, timeout: time.Second}).eval("c-42", h, 4)
+}
+
+func TestPolicyEval(t *testing.T) {
+	h := http.Header{"X-Tenant": {"acme"}}
+	for _, tc := range []struct {
+		src  string
+		want any
+	}{
+		{"1 + 2 * 3", 7},
+		{"7 % 4 * 2", 6},
+		{"false ? 1 : true ? 2 : 3", 2},
+		{"false && 1 % (replicas - 4) == 1", false},
+		{`"a" + "b" == "ab"`, true},
+		{`has_prefix(client_id, "c-") ? 1 : 0`, 1},
+		{`has_suffix(key, "2") && client_id contains "-4"`, true},
+		{`len(key) + int("7")`, 11},
+		{`int("x")`, -1},
+		{"int(7 / 2)", 3},
+		{`lower(header("x-tenant")) == "acme"`, true},
+		{`header("x-missing")`, ""},
+		{`hash("c-42") % replicas == hash(key) % 4`, true},
+		{"replicas - 1", 3},
+	} {
+		got, reason := evalPolicy(t, tc.src, h)
+		if reason != "" || got != tc.want {
+			t.Errorf("%s = %#v (%s), want %#v", tc.src, got, reason, tc.want)
+		}
+	}
+}
+
+func TestPolicyCompileErrors(t *testing.T) {
+	for _, src := range []string{
+		"1 ? 2 : 3",
+		"len(1)",
+		"hash()",
+		`has_prefix("a")`,
+		"nope(1)",
+		"clientid",
+		"1 +",
+		"(1 + 2",
+		`"open`,
+	} {
+		if _, err := compilePolicy(src); err == nil {
+			t.Errorf("%s: compiled, want an error", src)
+		}
+	}
+}
+
+func TestPolicyPickFallsBack(t *testing.T) {
+	setupBenchRing(t)
+	targets := allTargets()
+	for _, tc := range []struct {
+		src     string
+		timeout time.Duration
+		want    string // "" falls back to the hash pick
+	}{
+		{"2", time.Second, targets[2]},
+		{`header("x-slot") == "" ? 0 : 1`, time.Second, targets[0]},
+		{"replicas - 1", time.Second, targets[len(targets)-1]},
+		{"replicas", time.Second, ""},
+		{"-1", time.Second, ""},
+		{`"server-1"`, time.Second, ""},
+		{"true", time.Second, ""},
+		{"1 / 2", time.Second, ""}, // a float
+		{"5 % (replicas - replicas)", time.Second, ""},
+		{"len(filter(1..100000, # % 7 == 0)) % replicas", time.Nanosecond, ""},
+	} {
+		program, err := compilePolicy(tc.src)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.src, err)
+		}
+		p := &policyExpr{program: program, timeout: tc.timeout}
+		got, ok := p.pick("c-42", http.Header{})
+		if ok != (tc.want != "") || got != tc.want {
+			t.Errorf("%s: pick %q, %v; want %q", tc.src, got, ok, tc.want)
+		}
+	}
+	program, _ := compilePolicy("len(filter(1..100000, # % 7 == 0))")
+	if _, reason := (&policyExpr{program: program, timeout: time.Nanosecond}).eval("c-42", nil, 4); reason != "timeout" {
+		t.Errorf("slow expression: reason %q, want timeout", reason)
+	}
+}
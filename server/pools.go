@@ -0,0 +1,183 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Routing pools. ROUTING_POOLS lets one routing deployment place clients of several scaled
+// services, each described by its own naming and hash settings:
+//
+//	ROUTING_POOLS='orders:prefix=orders,suffix=.orders-headless,replicas=3;billing:prefix=billing,replicas=2,index_mode=numeric'
+//
+// Keys are prefix (required), suffix, replicas (default 1), index_mode (hash or numeric, default
+// hash), index_base (default INDEX_BASE) and port (default PORT); targets are
+// <prefix>-<idx><suffix>:<port>. A request picks a pool with ?pool= or the X-Routing-Pool header;
+// without one, or with pool=default, it is routed over this deployment's own replicas as before.
+// Pool clients are placed by the hash arithmetic alone: pool replicas are not health-polled and
+// hold no sessions here, so failover, pins and the other placement policies only apply to the
+// default pool. /where answers for a pool directly; /join, /counter and /ws are forwarded to
+// the pool owner by a gateway and redirected there (307) by a replica.
+
+const poolHeader = "X-Routing-Pool"
+
+type routingPool struct {
+	Name      string `json:"name"`
+	Prefix    string `json:"prefix"`
+	Suffix    string `json:"suffix,omitempty"`
+	Replicas  int    `json:"replicas"`
+	IndexMode string `json:"index_mode"`
+	IndexBase int    `json:"index_base"`
+	Port      string `json:"port"`
+}
+
+var routingPools = parseRoutingPools(os.Getenv("ROUTING_POOLS"))
+
+func init() {
+	metrics.counter("routing_pool_requests_total", "Requests routed within a named ROUTING_POOLS pool, by pool and endpoint.")
+}
+
+func parseRoutingPools(spec string) map[string]routingPool {
+	out := make(map[string]routingPool)
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, settings, _ := strings.Cut(part, ":")
+		name = strings.TrimSpace(name)
+		if name == "" || name == "default" {
+			log.Fatalf("invalid ROUTING_POOLS entry %q: a pool needs a name other than default", part)
+		}
+		p := routingPool{Name: name, Replicas: 1, IndexMode: "hash", IndexBase: indexBase(), Port: selfPort()}
+		for _, kv := range strings.Split(settings, ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(kv), "=")
+			k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+			var err error
+			switch k {
+			case "":
+			case "prefix":
+				p.Prefix = v
+			case "suffix":
+				p.Suffix = v
+			case "port":
+				p.Port = v
+			case "replicas":
+				p.Replicas, err = strconv.Atoi(v)
+				if err == nil && p.Replicas <= 0 {
+					err = fmt.Errorf("must be positive")
+				}
+			case "index_base":
+				p.IndexBase, err = strconv.Atoi(v)
+			case "index_mode":
+				p.IndexMode = strings.ToLower(v)
+				if p.IndexMode != "hash" && p.IndexMode != "numeric" {
+					err = fmt.Errorf("want hash or numeric")
+				}
+			default:
+				err = fmt.Errorf("unknown key")
+			}
+			if err != nil {
+				log.Fatalf("invalid ROUTING_POOLS setting %q for pool %s: %v", kv, name, err)
+			}
+		}
+		if p.Prefix == "" {
+			log.Fatalf("invalid ROUTING_POOLS entry %q: prefix is required", part)
+		}
+		out[name] = p
+	}
+	return out
+}
+
+// requestPool returns the pool r asks for. ok is false, after a 400 UNKNOWN_POOL has been
+// written, when the pool is not configured; p is nil for the default pool.
+func requestPool(w http.ResponseWriter, r *http.Request) (p *routingPool, ok bool) {
+	name := queryValue(r, "pool")
+	if name == "" {
+		name = r.Header.Get(poolHeader)
+	}
+	if name == "" || name == "default" {
+		return nil, true
+	}
+	pool, found := routingPools[name]
+	if !found {
+		writeError(w, http.StatusBadRequest, "UNKNOWN_POOL", fmt.Sprintf("pool %q is not configured", name))
+		return nil, false
+	}
+	return &pool, true
+}
+
+func (p *routingPool) target(idx int) string {
+	return fmt.Sprintf("%s-%d%s:%s", p.Prefix, idx, p.Suffix, p.Port)
+}
+
+func (p *routingPool) targets() []string {
+	out := make([]string, p.Replicas)
+	for i := range out {
+		out[i] = p.target(p.IndexBase + i)
+	}
+	return out
+}
+
+// owner returns the index and target clientID is placed on within the pool.
+func (p *routingPool) owner(clientID string) (int, string) {
+	idx := indexFor(routingKey(clientID), p.Replicas, p.IndexBase, p.IndexMode)
+	return idx, p.target(idx)
+}
+
+// handlePoolWhere answers /where for a client of pool p.
+func handlePoolWhere(w http.ResponseWriter, clientID string, p *routingPool) {
+	metrics.inc("routing_pool_requests_total", "pool", p.Name, "endpoint", "where")
+	idx, target := p.owner(clientID)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"client_id": clientID,
+		"hostport":  target,
+		"pool":      p.Name,
+		"index":     idx,
+	})
+}
+
+// forwardToPool sends a pool client's /join, /counter or /ws to its owner in the pool: proxied
+// by a gateway (or redirected with GATEWAY_FORWARD=redirect), redirected by a replica.
+func forwardToPool(w http.ResponseWriter, r *http.Request, clientID string, p *routingPool) {
+	metrics.inc("routing_pool_requests_total", "pool", p.Name, "endpoint", strings.TrimPrefix(r.URL.Path, "/"))
+	_, target := p.owner(clientID)
+	noteUpstream(r.Context(), target)
+	setRouteTrace(r.Header, r.Header, "pool", 0)
+	switch {
+	case !gatewayMode() || os.Getenv("GATEWAY_FORWARD") == "redirect":
+		copyRouteTrace(w.Header(), r.Header)
+		scheme := "http://"
+		if isWebSocketUpgrade(r) {
+			scheme = "ws://"
+		}
+		http.Redirect(w, r, scheme+target+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+	case isWebSocketUpgrade(r):
+		proxyUpgrade(w, r, target)
+	default:
+		r.Header.Set("x-routing-target", target)
+		gatewayProxy.ServeHTTP(w, r)
+	}
+}
+
+// poolsStatus lists the configured pools for /cluster/status.
+func poolsStatus() []map[string]any {
+	names := make([]string, 0, len(routingPools))
+	for name := range routingPools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make([]map[string]any, 0, len(names))
+	for _, name := range names {
+		p := routingPools[name]
+		out = append(out, map[string]any{"pool": p, "targets": p.targets()})
+	}
+	return out
+}
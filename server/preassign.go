@@ -0,0 +1,232 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Bulk pre-provisioning. POST /admin/preassign with {"client_ids": [...]} or
+// {"prefix": "c-", "from": 1, "count": 100000} computes each client's placement and writes the
+// assignments to the registry in batches of 500 before the fleet connects. Each replica is then
+// told which of the clients it owns (/internal/preassigned), so the first /join of those clients
+// skips the registry write. GET /admin/preassign reports the job's progress.
+
+const (
+	preassignBatch = 500
+	preassignMax   = 1_000_000
+)
+
+type preassignRequest struct {
+	ClientIDs []string `json:"client_ids"`
+	Prefix    string   `json:"prefix"`
+	From      int      `json:"from"`
+	Count     int      `json:"count"`
+}
+
+// ids expands the request into client IDs.
+func (p preassignRequest) ids() ([]string, error) {
+	if len(p.ClientIDs) > 0 {
+		if len(p.ClientIDs) > preassignMax {
+			return nil, fmt.Errorf("at most %d client IDs per job", preassignMax)
+		}
+		return p.ClientIDs, nil
+	}
+	if p.Count <= 0 || p.Count > preassignMax {
+		return nil, fmt.Errorf("count must be between 1 and %d", preassignMax)
+	}
+	out := make([]string, p.Count)
+	for i := range out {
+		out[i] = p.Prefix + strconv.Itoa(p.From+i)
+	}
+	return out, nil
+}
+
+// preassignJob is the state of the most recent /admin/preassign run.
+type preassignJob struct {
+	mu         sync.Mutex
+	State      string         `json:"state"` // idle, running, done, failed
+	Total      int            `json:"total"`
+	Written    int            `json:"written"`
+	PerReplica map[string]int `json:"per_replica"`
+	Unnotified []string       `json:"unnotified,omitempty"` // replicas that will write on first join
+	Error      string         `json:"error,omitempty"`
+	StartedAt  time.Time      `json:"started_at,omitzero"`
+	FinishedAt time.Time      `json:"finished_at,omitzero"`
+	RatePerSec float64        `json:"rate_per_sec,omitempty"`
+}
+
+var preassign = &preassignJob{State: "idle"}
+
+var preassignClient = newInternalClient(10 * time.Second)
+
+func (j *preassignJob) run(ids []string) {
+	byReplica := make(map[string][]string)
+	for start := 0; start < len(ids); start += preassignBatch {
+		end := min(start+preassignBatch, len(ids))
+		now := time.Now()
+		batch := make([]Assignment, 0, end-start)
+		for _, id := range ids[start:end] {
+			owner, ok := pinnedOwner(id)
+			if !ok {
+				owner = placeClient(id)
+			}
+			batch = append(batch, Assignment{ClientID: id, Replica: owner, AssignedAt: now, UpdatedAt: now})
+			byReplica[owner] = append(byReplica[owner], id)
+		}
+		if err := putBatch(registry, batch); err != nil {
+			j.finish(err)
+			return
+		}
+		j.mu.Lock()
+		j.Written += len(batch)
+		for _, a := range batch {
+			j.PerReplica[a.Replica]++
+		}
+		j.mu.Unlock()
+	}
+	for target, owned := range byReplica {
+		var err error
+		if isSelfTarget(target) {
+			preassigned.add(owned)
+		} else {
+			err = postPreassigned(target, owned)
+		}
+		if err != nil {
+			log.Printf("preassign: notifying %s failed: %v", target, err)
+			j.mu.Lock()
+			j.Unnotified = append(j.Unnotified, target)
+			j.mu.Unlock()
+		}
+	}
+	j.finish(nil)
+}
+
+func (j *preassignJob) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.FinishedAt = time.Now()
+	j.State = "done"
+	if err != nil {
+		j.State = "failed"
+		j.Error = err.Error()
+	}
+	if d := j.FinishedAt.Sub(j.StartedAt).Seconds(); d > 0 {
+		j.RatePerSec = float64(j.Written) / d
+	}
+	log.Printf("preassign %s: written=%d total=%d", j.State, j.Written, j.Total)
+}
+
+func (j *preassignJob) write(w http.ResponseWriter, status int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(j)
+}
+
+// handlePreassign starts (POST) or reports (GET) the pre-provisioning job.
+func handlePreassign(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		preassign.write(w, http.StatusOK)
+	case http.MethodPost:
+		var req preassignRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid preassign request", http.StatusBadRequest)
+			return
+		}
+		ids, err := req.ids()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "INVALID_PREASSIGN", err.Error())
+			return
+		}
+		if len(allTargets()) == 0 {
+			writeError(w, http.StatusServiceUnavailable, "NO_HEALTHY_REPLICA", "no replicas configured")
+			return
+		}
+		preassign.mu.Lock()
+		if preassign.State == "running" {
+			preassign.mu.Unlock()
+			writeError(w, http.StatusConflict, "PREASSIGN_RUNNING", "a preassign job is already running")
+			return
+		}
+		preassign.State, preassign.Error = "running", ""
+		preassign.Total, preassign.Written, preassign.RatePerSec = len(ids), 0, 0
+		preassign.PerReplica, preassign.Unnotified = make(map[string]int), nil
+		preassign.StartedAt, preassign.FinishedAt = time.Now(), time.Time{}
+		preassign.mu.Unlock()
+		go preassign.run(ids)
+		preassign.write(w, http.StatusAccepted)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// preassignedSet holds the client IDs pre-provisioned onto this replica whose first /join
+// hasn't arrived yet.
+type preassignedSet struct {
+	mu  sync.Mutex
+	ids map[string]struct{}
+}
+
+var preassigned = &preassignedSet{ids: make(map[string]struct{})}
+
+func (s *preassignedSet) add(ids []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		s.ids[id] = struct{}{}
+	}
+}
+
+// take reports whether clientID was pre-provisioned here, and forgets it.
+func (s *preassignedSet) take(clientID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.ids[clientID]
+	delete(s.ids, clientID)
+	return ok
+}
+
+// handlePreassigned receives the client IDs pre-provisioned onto this replica (internal API).
+func handlePreassigned(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var ids []string
+	if err := json.NewDecoder(r.Body).Decode(&ids); err != nil {
+		http.Error(w, "invalid client id list", http.StatusBadRequest)
+		return
+	}
+	preassigned.add(ids)
+	log.Printf("/internal/preassigned received %d client ids", len(ids))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func postPreassigned(target string, ids []string) error {
+	body, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+	req, err := newInternalRequest(http.MethodPost, target, "/internal/preassigned", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := preassignClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// serverProtocols returns the protocols both listeners accept, from HTTP_PROTOCOLS (comma-separated
+// "http1", "h2c"; default both). With h2c enabled, plaintext HTTP/2 with prior knowledge (as used by
+// gRPC and Envoy http2_protocol_options) is detected per connection on the same port as HTTP/1.1.
+// HTTP/2 over TLS is always offered on TLS listeners via ALPN.
+func serverProtocols() *http.Protocols {
+	spec := os.Getenv("HTTP_PROTOCOLS")
+	if strings.TrimSpace(spec) == "" {
+		spec = "http1,h2c"
+	}
+	p := new(http.Protocols)
+	p.SetHTTP2(true)
+	for _, name := range strings.Split(spec, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "http1":
+			p.SetHTTP1(true)
+		case "h2c":
+			p.SetUnencryptedHTTP2(true)
+		case "":
+		default:
+			log.Printf("ignoring unknown HTTP_PROTOCOLS entry %q", name)
+		}
+	}
+	if !p.HTTP1() && !p.UnencryptedHTTP2() {
+		log.Printf("HTTP_PROTOCOLS=%q enables nothing, serving http1", spec)
+		p.SetHTTP1(true)
+	}
+	return p
+}
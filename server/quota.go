@@ -0,0 +1,309 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Quota accounting for noisy-neighbour experiments. Joins and resolutions (/where, /where/batch) are
+// counted per tenant and per client over a sliding QUOTA_WINDOW (default 1m). The tenant is the
+// TENANT_HEADER request header (default x-tenant), else the routing group (see GROUP_DELIMITER),
+// else "default". Limits are per window: QUOTA_TENANT_JOINS, QUOTA_TENANT_RESOLUTIONS,
+// QUOTA_CLIENT_JOINS and QUOTA_CLIENT_RESOLUTIONS each take a default and per-key overrides, e.g.
+// "1000,acme=5000"; 0 or unset is unlimited. Requests over a limit get 429 QUOTA_EXCEEDED with
+// Retry-After, or are only counted as over when QUOTA_MODE=observe. Counts are kept by the replica
+// that serves the request; GET /quota reports them.
+
+const (
+	quotaJoin = iota
+	quotaResolution
+)
+
+var quotaKinds = [...]string{"join", "resolution"}
+
+// quotaLimits is a default limit plus per-tenant or per-client overrides.
+type quotaLimits struct {
+	def       int
+	overrides map[string]int
+}
+
+func parseQuotaLimits(v string) quotaLimits {
+	l := quotaLimits{overrides: make(map[string]int)}
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if key, n, ok := strings.Cut(part, "="); ok {
+			if i, err := strconv.Atoi(strings.TrimSpace(n)); err == nil && i >= 0 {
+				l.overrides[strings.TrimSpace(key)] = i
+			}
+		} else if i, err := strconv.Atoi(part); err == nil && i >= 0 {
+			l.def = i
+		}
+	}
+	return l
+}
+
+func (l quotaLimits) limit(key string) int {
+	if n, ok := l.overrides[key]; ok {
+		return n
+	}
+	return l.def
+}
+
+// windowCount approximates a sliding-window count from the current and previous fixed windows.
+type windowCount struct {
+	start     time.Time
+	cur, prev float64
+}
+
+func (c *windowCount) estimate(now time.Time, window time.Duration) float64 {
+	c.roll(now, window)
+	elapsed := float64(now.Sub(c.start)) / float64(window)
+	return c.prev*(1-elapsed) + c.cur
+}
+
+func (c *windowCount) roll(now time.Time, window time.Duration) {
+	start := now.Truncate(window)
+	switch {
+	case start.Equal(c.start):
+		return
+	case start.Sub(c.start) == window:
+		c.prev = c.cur
+	default:
+		c.prev = 0
+	}
+	c.cur = 0
+	c.start = start
+}
+
+type quotaUsage struct {
+	tenant string
+	counts [len(quotaKinds)]windowCount
+	over   [len(quotaKinds)]int
+}
+
+type quotaTracker struct {
+	mu      sync.Mutex
+	window  time.Duration
+	enforce bool
+	header  string
+	tenant  [len(quotaKinds)]quotaLimits
+	client  [len(quotaKinds)]quotaLimits
+	tenants map[string]*quotaUsage
+	clients map[string]*quotaUsage
+}
+
+var quotas = newQuotaTracker()
+
+func newQuotaTracker() *quotaTracker {
+	q := &quotaTracker{
+		window:  time.Minute,
+		enforce: !strings.EqualFold(os.Getenv("QUOTA_MODE"), "observe"),
+		header:  os.Getenv("TENANT_HEADER"),
+		tenants: make(map[string]*quotaUsage),
+		clients: make(map[string]*quotaUsage),
+	}
+	if d, err := time.ParseDuration(os.Getenv("QUOTA_WINDOW")); err == nil && d > 0 {
+		q.window = d
+	}
+	if q.header == "" {
+		q.header = "x-tenant"
+	}
+	// Canonical up front, so tenantOf's Header.Get doesn't canonicalise on every request.
+	q.header = http.CanonicalHeaderKey(q.header)
+	q.tenant[quotaJoin] = parseQuotaLimits(os.Getenv("QUOTA_TENANT_JOINS"))
+	q.tenant[quotaResolution] = parseQuotaLimits(os.Getenv("QUOTA_TENANT_RESOLUTIONS"))
+	q.client[quotaJoin] = parseQuotaLimits(os.Getenv("QUOTA_CLIENT_JOINS"))
+	q.client[quotaResolution] = parseQuotaLimits(os.Getenv("QUOTA_CLIENT_RESOLUTIONS"))
+	metrics.counter("routing_quota_requests_total", "Joins and resolutions counted against quotas, by kind and tenant.")
+	metrics.counter("routing_quota_over_total", "Requests over a quota, by kind, scope (tenant, client) and action (rejected, observed).")
+	metrics.gaugeFunc("routing_quota_tracked_clients", "Clients with quota usage in the current or previous window.", func() float64 {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return float64(len(q.clients))
+	})
+	return q
+}
+
+func (q *quotaTracker) tenantOf(r *http.Request, clientID string) string {
+	if t := strings.TrimSpace(r.Header.Get(q.header)); t != "" {
+		return t
+	}
+	if key := routingKey(clientID); key != clientID {
+		return key
+	}
+	return "default"
+}
+
+func usageFor(m map[string]*quotaUsage, key, tenant string) *quotaUsage {
+	u, ok := m[key]
+	if !ok {
+		u = &quotaUsage{tenant: tenant}
+		m[key] = u
+	}
+	return u
+}
+
+// take counts n requests of kind for the tenant and, when clientID is set, the client. If that
+// would exceed a limit it reports the scope; in enforce mode nothing is counted and the caller
+// rejects the request.
+func (q *quotaTracker) take(kind int, tenant, clientID string, n int) (scope string, ok bool) {
+	now := time.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	tu := usageFor(q.tenants, tenant, tenant)
+	var cu *quotaUsage
+	if clientID != "" {
+		cu = usageFor(q.clients, clientID, tenant)
+	}
+	if limit := q.tenant[kind].limit(tenant); limit > 0 && tu.counts[kind].estimate(now, q.window)+float64(n) > float64(limit) {
+		scope = "tenant"
+		tu.over[kind]++
+	} else if limit := q.client[kind].limit(clientID); cu != nil && limit > 0 && cu.counts[kind].estimate(now, q.window)+float64(n) > float64(limit) {
+		scope = "client"
+		cu.over[kind]++
+	}
+	if scope != "" && q.enforce {
+		return scope, false
+	}
+	tu.counts[kind].roll(now, q.window)
+	tu.counts[kind].cur += float64(n)
+	if cu != nil {
+		cu.counts[kind].roll(now, q.window)
+		cu.counts[kind].cur += float64(n)
+	}
+	return scope, true
+}
+
+// retryAfter is the time until the current window closes.
+func (q *quotaTracker) retryAfter() time.Duration {
+	now := time.Now()
+	return now.Truncate(q.window).Add(q.window).Sub(now)
+}
+
+// checkQuota accounts a request and writes 429 when it is over quota; false means do not serve it.
+// clientID may be empty for batch calls, which are counted against the tenant only.
+func checkQuota(w http.ResponseWriter, r *http.Request, kind int, clientID string, n int) bool {
+	tenant := quotas.tenantOf(r, clientID)
+	metrics.add("routing_quota_requests_total", float64(n), "kind", quotaKinds[kind], "tenant", tenant)
+	scope, ok := quotas.take(kind, tenant, clientID, n)
+	if scope == "" {
+		return true
+	}
+	action := "observed"
+	if !ok {
+		action = "rejected"
+	}
+	metrics.inc("routing_quota_over_total", "kind", quotaKinds[kind], "scope", scope, "action", action)
+	if ok {
+		return true
+	}
+	who := tenant
+	if scope == "client" {
+		who = clientID
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(quotas.retryAfter().Seconds()))))
+	writeError(w, http.StatusTooManyRequests, "QUOTA_EXCEEDED", fmt.Sprintf("%s quota exceeded for %s %q", quotaKinds[kind], scope, who))
+	return false
+}
+
+// runQuotaSweeper drops usage that has aged out of both windows.
+func runQuotaSweeper() {
+	for range time.Tick(quotas.window) {
+		quotas.sweep(time.Now())
+	}
+}
+
+func (q *quotaTracker) sweep(now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, m := range []map[string]*quotaUsage{q.tenants, q.clients} {
+		for key, u := range m {
+			idle := true
+			for i := range u.counts {
+				if u.counts[i].estimate(now, q.window) > 0 {
+					idle = false
+				}
+			}
+			if idle {
+				delete(m, key)
+			}
+		}
+	}
+}
+
+type quotaReport struct {
+	Key    string             `json:"key"`
+	Tenant string             `json:"tenant,omitempty"`
+	Usage  map[string]float64 `json:"usage"`
+	Limits map[string]int     `json:"limits"`
+	Over   map[string]int     `json:"over"`
+}
+
+func (q *quotaTracker) report(key string, u *quotaUsage, limits *[len(quotaKinds)]quotaLimits, now time.Time) quotaReport {
+	rep := quotaReport{Key: key, Usage: map[string]float64{}, Limits: map[string]int{}, Over: map[string]int{}}
+	for i, kind := range quotaKinds {
+		rep.Usage[kind] = math.Round(u.counts[i].estimate(now, q.window)*10) / 10
+		rep.Limits[kind] = limits[i].limit(key)
+		rep.Over[kind] = u.over[i]
+	}
+	return rep
+}
+
+// handleQuota reports usage: every tenant, plus the ?top=N (default 20) busiest clients, or a
+// single ?tenant= or ?client_id=.
+func handleQuota(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	top := 20
+	if v := query.Get("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid top", http.StatusBadRequest)
+			return
+		}
+		top = n
+	}
+	tenant, clientID := query.Get("tenant"), query.Get("client_id")
+	now := time.Now()
+	quotas.mu.Lock()
+	var tenants, clients []quotaReport
+	for key, u := range quotas.tenants {
+		if tenant == "" || tenant == key {
+			tenants = append(tenants, quotas.report(key, u, &quotas.tenant, now))
+		}
+	}
+	for key, u := range quotas.clients {
+		if (clientID != "" && clientID != key) || (tenant != "" && tenant != u.tenant) {
+			continue
+		}
+		rep := quotas.report(key, u, &quotas.client, now)
+		rep.Tenant = u.tenant
+		clients = append(clients, rep)
+	}
+	quotas.mu.Unlock()
+
+	total := func(rep quotaReport) float64 { return rep.Usage["join"] + rep.Usage["resolution"] }
+	sort.Slice(tenants, func(i, j int) bool { return total(tenants[i]) > total(tenants[j]) })
+	sort.Slice(clients, func(i, j int) bool { return total(clients[i]) > total(clients[j]) })
+	if clientID == "" && len(clients) > top {
+		clients = clients[:top]
+	}
+	mode := "enforce"
+	if !quotas.enforce {
+		mode = "observe"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"window":  quotas.window.String(),
+		"mode":    mode,
+		"tenants": tenants,
+		"clients": clients,
+	})
+}
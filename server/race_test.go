@@ -0,0 +1,7 @@
+//go:build race
+
+package server
+
+// The race detector allocates on its own and makes sync.Pool drop items, so allocation budgets
+// are meaningless under -race.
+func init() { raceEnabled = true }
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reachability of /where answers. Resolution already fails over from an unhealthy owner, but it
+// still returns one when no candidate is left (or EMPTY_REPLICAS_POLICY=self answers with this
+// replica), and a client then dials a target that won't answer. /where?reachable=true adds
+// "reachable" to the answer, WHERE_REACHABILITY=on adds it to every answer, and /where?strict=true
+// refuses an unreachable target with 503 NO_HEALTHY_REPLICA instead. A target is reachable when:
+//   - it is in the current ring, and
+//   - its latest health probe succeeded, or, before the first probe completes (or for a target
+//     the poller doesn't know), its host name resolves (through the proxy DNS cache)
+//
+// The answer's ETag covers reachability when it is reported, and unreachable answers are not
+// cached.
+
+var whereReachabilityAlways = strings.EqualFold(strings.TrimSpace(os.Getenv("WHERE_REACHABILITY")), "on")
+
+// reachable reports whether target looks reachable now, and why not.
+func reachable(ctx context.Context, target string) (bool, string) {
+	t := currentTable()
+	if !slices.Contains(t.Targets, target) {
+		return false, "not in the ring"
+	}
+	if t.polled {
+		if info, ok := t.info(target); ok {
+			if !info.Healthy {
+				return false, "health probe failed: " + info.Error
+			}
+			return true, ""
+		}
+	}
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		host = target
+	}
+	if net.ParseIP(host) != nil {
+		return true, ""
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if _, err := dnsCache.lookup(ctx, host); err != nil {
+		return false, "does not resolve: " + err.Error()
+	}
+	return true, ""
+}
+
+// whereReachability reads ?reachable= and ?strict= on a /where request.
+func whereReachability(r *http.Request) (report, strict bool) {
+	// Most lookups carry neither, so skip parsing the query again.
+	if !strings.Contains(r.URL.RawQuery, "strict=") && !strings.Contains(r.URL.RawQuery, "reachable=") {
+		return whereReachabilityAlways, false
+	}
+	q := r.URL.Query()
+	strict, _ = strconv.ParseBool(q.Get("strict"))
+	report, _ = strconv.ParseBool(q.Get("reachable"))
+	return report || whereReachabilityAlways, strict
+}
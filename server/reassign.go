@@ -0,0 +1,246 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Transactional reassignment. POST /admin/reassign rewrites the registry assignments of a set of
+// clients in transactions (MULTI/EXEC on redis, one lock on memory), so observers never see half
+// of a transaction applied:
+//   - {"from": "<replica>"} takes every client assigned to that replica, e.g. one being drained,
+//     and spreads them over the remaining healthy replicas by hash
+//   - {"client_ids": [...]} takes the listed clients and assigns each to its current placement
+//   - "to": "<replica>" sends all of them to that replica instead
+//
+// A transaction holds at most REASSIGN_TX_MAX clients (default 1000); a larger set is committed
+// as several transactions in client ID order, each all or nothing. GET /admin/reassign reports
+// the job's progress. Only the registry records change: sessions move on the clients' next
+// connection, as after /admin/move.
+
+const reassignMax = 1_000_000
+
+// errRegistryRead marks a plan that failed reading the registry rather than on the request.
+var errRegistryRead = errors.New("registry read failed")
+
+type reassignRequest struct {
+	From      string   `json:"from"`
+	ClientIDs []string `json:"client_ids"`
+	To        string   `json:"to"`
+}
+
+// reassignJob is the state of the most recent /admin/reassign run.
+type reassignJob struct {
+	mu           sync.Mutex
+	State        string         `json:"state"` // idle, running, done, failed
+	Total        int            `json:"total"`
+	Committed    int            `json:"committed"`
+	Transactions int            `json:"transactions"`
+	TxMax        int            `json:"tx_max"`
+	PerReplica   map[string]int `json:"per_replica"`
+	Error        string         `json:"error,omitempty"`
+	StartedAt    time.Time      `json:"started_at,omitzero"`
+	FinishedAt   time.Time      `json:"finished_at,omitzero"`
+}
+
+var reassign = &reassignJob{State: "idle", TxMax: reassignTxMaxFromEnv()}
+
+func reassignTxMaxFromEnv() int {
+	if n, err := strconv.Atoi(os.Getenv("REASSIGN_TX_MAX")); err == nil && n > 0 {
+		return n
+	}
+	return 1000
+}
+
+// putTx writes as to r atomically.
+func putTx(r assignmentRegistry, as []Assignment) error {
+	tp, ok := r.(txPutter)
+	if !ok {
+		return errors.New("registry backend does not support transactions")
+	}
+	return tp.PutTx(as)
+}
+
+// plan works out the new assignment of every client the request covers, in client ID order, and
+// each one's previous replica. Clients already on their new replica are left out.
+func (req reassignRequest) plan() ([]Assignment, map[string]string, error) {
+	to := ""
+	if req.To != "" {
+		for _, t := range allTargets() {
+			if sameReplica(req.To, t) {
+				to = t
+				break
+			}
+		}
+		if to == "" {
+			return nil, nil, fmt.Errorf("to=%q is not a known replica", req.To)
+		}
+		if !ownerHealthy(to) {
+			return nil, nil, fmt.Errorf("%s is not healthy", to)
+		}
+	}
+
+	var current []Assignment
+	switch {
+	case req.From != "" && len(req.ClientIDs) > 0:
+		return nil, nil, errors.New("give either from or client_ids, not both")
+	case req.From != "":
+		all, err := registry.List()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", errRegistryRead, err)
+		}
+		for _, a := range all {
+			if sameReplica(req.From, a.Replica) {
+				current = append(current, a)
+			}
+		}
+	case len(req.ClientIDs) > 0:
+		if len(req.ClientIDs) > reassignMax {
+			return nil, nil, fmt.Errorf("at most %d client IDs per job", reassignMax)
+		}
+		ids := slices.Compact(slices.Sorted(slices.Values(req.ClientIDs)))
+		for _, id := range ids {
+			a, ok, err := registry.Get(id)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%w: %v", errRegistryRead, err)
+			}
+			if !ok {
+				a = Assignment{ClientID: id}
+			}
+			current = append(current, a)
+		}
+	default:
+		return nil, nil, errors.New("missing from or client_ids")
+	}
+
+	var remaining []string
+	if req.From != "" && to == "" {
+		remaining = slices.DeleteFunc(slices.Clone(allTargets()), func(t string) bool {
+			return sameReplica(req.From, t) || !ownerHealthy(t)
+		})
+		if len(remaining) == 0 {
+			return nil, nil, errors.New("no healthy replica left to take the clients")
+		}
+	}
+	now := time.Now()
+	out := make([]Assignment, 0, len(current))
+	from := make(map[string]string, len(current))
+	for _, a := range current {
+		next := to
+		switch {
+		case next != "":
+		case remaining != nil:
+			next = remaining[computeIndex(a.ClientID, len(remaining))-indexBase()]
+		default:
+			next = placeClient(a.ClientID)
+		}
+		if next == a.Replica {
+			continue
+		}
+		from[a.ClientID] = a.Replica
+		if a.AssignedAt.IsZero() {
+			a.AssignedAt = now
+		}
+		a.Replica, a.UpdatedAt = next, now
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ClientID < out[j].ClientID })
+	return out, from, nil
+}
+
+// run commits plan in transactions of at most TxMax assignments; from holds each client's
+// previous replica for the moved events.
+func (j *reassignJob) run(plan []Assignment, from map[string]string) {
+	for start := 0; start < len(plan); start += j.TxMax {
+		tx := plan[start:min(start+j.TxMax, len(plan))]
+		if err := putTx(registry, tx); err != nil {
+			j.finish(err)
+			return
+		}
+		j.mu.Lock()
+		j.Committed += len(tx)
+		j.Transactions++
+		for _, a := range tx {
+			j.PerReplica[a.Replica]++
+		}
+		j.mu.Unlock()
+		for _, a := range tx {
+			events.emit(eventMoved, a.ClientID, a.Replica, from[a.ClientID], a.Replica)
+		}
+	}
+	j.finish(nil)
+}
+
+func (j *reassignJob) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.FinishedAt = time.Now()
+	j.State = "done"
+	if err != nil {
+		j.State = "failed"
+		j.Error = err.Error()
+	}
+	log.Printf("reassign %s: committed=%d total=%d transactions=%d", j.State, j.Committed, j.Total, j.Transactions)
+}
+
+func (j *reassignJob) write(w http.ResponseWriter, status int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(j)
+}
+
+// handleReassign starts (POST) or reports (GET) the reassignment job.
+func handleReassign(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		reassign.write(w, http.StatusOK)
+	case http.MethodPost:
+		var req reassignRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid reassign request", http.StatusBadRequest)
+			return
+		}
+		reassign.mu.Lock()
+		running := reassign.State == "running"
+		reassign.mu.Unlock()
+		if running {
+			writeError(w, http.StatusConflict, "REASSIGN_RUNNING", "a reassign job is already running")
+			return
+		}
+		plan, from, err := req.plan()
+		if errors.Is(err, errRegistryRead) {
+			writeError(w, http.StatusServiceUnavailable, "REGISTRY_UNAVAILABLE", err.Error())
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "INVALID_REASSIGN", err.Error())
+			return
+		}
+		reassign.mu.Lock()
+		if reassign.State == "running" {
+			reassign.mu.Unlock()
+			writeError(w, http.StatusConflict, "REASSIGN_RUNNING", "a reassign job is already running")
+			return
+		}
+		reassign.State, reassign.Error = "running", ""
+		reassign.Total, reassign.Committed, reassign.Transactions = len(plan), 0, 0
+		reassign.PerReplica = make(map[string]int)
+		reassign.StartedAt, reassign.FinishedAt = time.Now(), time.Time{}
+		reassign.mu.Unlock()
+		go reassign.run(plan, from)
+		reassign.write(w, http.StatusAccepted)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server-generated client IDs. POST /register mints a new client_id, resolves and persists its
+// assignment like a first /join would, and answers 201 with the ID, the assigned replica and the
+// URL to join it there, so a fresh device can onboard without a pre-provisioned ID.
+// CLIENT_ID_FORMAT picks the ID:
+//   - snowflake (default): a decimal 63-bit ID of 41 bits of milliseconds since 2024-01-01,
+//     10 bits of this replica's ordinal (selfIndex; a random node number when it has none) and a
+//     12-bit per-millisecond sequence. Ordinals are unique per replica, so replicas never mint the
+//     same ID, and IDs sort by creation time.
+//   - uuidv7: an RFC 9562 version 7 UUID, time-ordered with 74 random bits.
+//
+// CLIENT_ID_PREFIX (e.g. "c-") is prepended to either. The owner, if it is another replica, is told
+// about the ID as for /admin/preassign, so the client's first /join skips the registry write.
+
+var snowflakeEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type idMinter struct {
+	format string
+	prefix string
+	node   func() int64
+
+	mu   sync.Mutex
+	last int64 // milliseconds since snowflakeEpoch of the last snowflake
+	seq  int64
+}
+
+var clientIDs = newIDMinterFromEnv()
+
+func newIDMinterFromEnv() *idMinter {
+	m := &idMinter{format: "snowflake", prefix: os.Getenv("CLIENT_ID_PREFIX")}
+	m.node = sync.OnceValue(m.nodeID)
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("CLIENT_ID_FORMAT")), "uuidv7") {
+		m.format = "uuidv7"
+	}
+	metrics.counter("routing_registrations_total", "Client IDs minted by /register, by result.")
+	return m
+}
+
+// nodeID is this replica's snowflake node number, resolved on first use once the targets are known.
+func (m *idMinter) nodeID() int64 {
+	n := int64(selfIndex())
+	if n < 0 {
+		var b [2]byte
+		_, _ = rand.Read(b[:])
+		n = int64(binary.BigEndian.Uint16(b[:]))
+		log.Printf("register: no ordinal known, using random snowflake node %d", n&0x3ff)
+	}
+	return n & 0x3ff
+}
+
+func (m *idMinter) next() string {
+	if m.format == "uuidv7" {
+		return m.prefix + newUUIDv7(clock.Now())
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	node := m.node()
+	// Never step back in time: a clock that jumped backwards keeps counting from the last millisecond.
+	ms := max(clock.Now().Sub(snowflakeEpoch).Milliseconds(), m.last)
+	if ms == m.last {
+		m.seq = (m.seq + 1) & 0xfff
+		if m.seq == 0 {
+			ms++ // sequence exhausted within this millisecond: borrow the next one
+		}
+	} else {
+		m.seq = 0
+	}
+	m.last = ms
+	return m.prefix + strconv.FormatInt(ms<<22|node<<12|m.seq, 10)
+}
+
+func newUUIDv7(now time.Time) string {
+	var b [16]byte
+	_, _ = rand.Read(b[6:])
+	binary.BigEndian.PutUint64(b[:8], uint64(now.UnixMilli())<<16|uint64(binary.BigEndian.Uint16(b[6:8])))
+	b[6] = 0x70 | b[6]&0x0f
+	b[8] = 0x80 | b[8]&0x3f
+	h := hex.EncodeToString(b[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+func handleRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkAffinity(w, r) {
+		return
+	}
+	if isFenced() {
+		writeFenced(w)
+		return
+	}
+	clientID := clientIDs.next()
+	owner, version, err := resolveOwnerAt(r.Context(), clientID)
+	if err != nil {
+		metrics.inc("routing_registrations_total", "result", "unresolved")
+		writeResolveError(w, err)
+		return
+	}
+	setTableVersion(w, version)
+	if err := assignments.persist(clientID, owner, time.Now()); err != nil {
+		metrics.inc("routing_registrations_total", "result", "error")
+		writeError(w, http.StatusServiceUnavailable, "REGISTRY_WRITE_FAILED", err.Error())
+		return
+	}
+	if isSelfTarget(owner) {
+		preassigned.add([]string{clientID})
+	} else if err := postPreassigned(owner, []string{clientID}); err != nil {
+		// The owner just writes the assignment again on the first /join.
+		log.Printf("register: notifying %s of client_id=%s failed: %v", owner, clientID, err)
+	}
+	metrics.inc("routing_registrations_total", "result", "ok")
+	log.Printf("/register client_id=%s assigned to %s", clientID, owner)
+
+	body := map[string]any{
+		"client_id": clientID,
+		"assigned":  owner,
+		"join_url":  "http://" + owner + "/join?client_id=" + url.QueryEscape(clientID),
+	}
+	if outage.noteDegraded("register") {
+		body["degraded"] = true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(body)
+}
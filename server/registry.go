@@ -0,0 +1,376 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"personal/poc-routing/server/internal/resp"
+	"personal/poc-routing/server/registryschema"
+)
+
+// Assignment is the persisted record of which replica a client is bound to.
+type Assignment struct {
+	ClientID   string    `json:"client_id"`
+	Replica    string    `json:"replica"`
+	AssignedAt time.Time `json:"assigned_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// assignmentRegistry stores assignments. REGISTRY_BACKEND selects "memory" (default,
+// per-replica) or "redis" (shared, REDIS_ADDR, default redis:6379).
+type assignmentRegistry interface {
+	Get(clientID string) (Assignment, bool, error)
+	Put(a Assignment) error
+	Delete(clientID string) error
+	List() ([]Assignment, error)
+}
+
+// batchPutter is implemented by backends that can write several assignments in one round trip.
+type batchPutter interface {
+	PutBatch(as []Assignment) error
+}
+
+// txPutter is implemented by backends that can write several assignments atomically: readers see
+// all of them or none.
+type txPutter interface {
+	PutTx(as []Assignment) error
+}
+
+var registry = newRegistryFromEnv()
+
+func newRegistryFromEnv() assignmentRegistry {
+	primary := newRegistryBackend(os.Getenv("REGISTRY_BACKEND"), os.Getenv("REDIS_ADDR"))
+	if to := strings.TrimSpace(os.Getenv("REGISTRY_MIGRATE_TO")); to != "" {
+		addr := os.Getenv("MIGRATE_REDIS_ADDR")
+		if addr == "" {
+			addr = os.Getenv("REDIS_ADDR")
+		}
+		return newDualRegistry(primary, newRegistryBackend(to, addr), os.Getenv("REGISTRY_READ_FROM"))
+	}
+	return primary
+}
+
+// registryAs returns the registry backend implementing T (an optional capability such as leaser).
+// While migrating between backends that is the primary if it qualifies, otherwise the secondary.
+func registryAs[T any]() (T, bool) {
+	if d, ok := registry.(*dualRegistry); ok {
+		if v, ok := d.primary.(T); ok {
+			return v, true
+		}
+		v, ok := d.secondary.(T)
+		return v, ok
+	}
+	v, ok := registry.(T)
+	return v, ok
+}
+
+// newRegistryBackend builds the named backend; redisAddr defaults to redis:6379.
+func newRegistryBackend(name, redisAddr string) assignmentRegistry {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "redis":
+		if redisAddr == "" {
+			redisAddr = "redis:6379"
+		}
+		schema := registrySchemaFromEnv()
+		log.Printf("registry backend: redis %s, schema %d", redisAddr, schema)
+		return &redisRegistry{client: resp.NewClient(redisAddr, 16, 2*time.Second), schema: schema, prefix: registryschema.Prefix(schema)}
+	default:
+		return newMemoryRegistry()
+	}
+}
+
+// memoryRegistry holds at most max assignments (MAX_ASSIGNMENTS, see memlimits.go).
+type memoryRegistry struct {
+	mu  sync.RWMutex
+	m   map[string]Assignment
+	max int
+}
+
+func newMemoryRegistry() *memoryRegistry {
+	return &memoryRegistry{m: make(map[string]Assignment), max: memoryLimitFromEnv("MAX_ASSIGNMENTS", 100000)}
+}
+
+func (r *memoryRegistry) Get(clientID string) (Assignment, bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	a, ok := r.m[clientID]
+	return a, ok, nil
+}
+
+func (r *memoryRegistry) Put(a Assignment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.putLocked(a)
+	return nil
+}
+
+func (r *memoryRegistry) PutTx(as []Assignment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, a := range as {
+		r.putLocked(a)
+	}
+	return nil
+}
+
+// putLocked stores a, first evicting a batch of the least recently written assignments when a new
+// client would take the registry past max.
+func (r *memoryRegistry) putLocked(a Assignment) {
+	if _, ok := r.m[a.ClientID]; !ok && len(r.m) >= r.max {
+		r.evictLocked(len(r.m) - r.max + evictionBatch(r.max))
+	}
+	r.m[a.ClientID] = a
+}
+
+// evictLocked removes the n least recently written assignments.
+func (r *memoryRegistry) evictLocked(n int) {
+	if n <= 0 {
+		return
+	}
+	all := slices.SortedFunc(maps.Values(r.m), func(a, b Assignment) int { return a.UpdatedAt.Compare(b.UpdatedAt) })
+	for _, a := range all[:min(n, len(all))] {
+		delete(r.m, a.ClientID)
+	}
+	log.Printf("memory: evicted %d least recently written assignments (MAX_ASSIGNMENTS=%d)", min(n, len(all)), r.max)
+	noteEvictions("assignments", min(n, len(all)))
+}
+
+func (r *memoryRegistry) size() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.m)
+}
+
+func (r *memoryRegistry) limit() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.max
+}
+
+// resize sets the most assignments held, evicting the least recently written beyond it.
+func (r *memoryRegistry) resize(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.max = n
+	r.evictLocked(len(r.m) - n)
+}
+
+func (r *memoryRegistry) Delete(clientID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.m, clientID)
+	return nil
+}
+
+func (r *memoryRegistry) List() ([]Assignment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]Assignment, 0, len(r.m))
+	for _, a := range r.m {
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ClientID < out[j].ClientID })
+	return out, nil
+}
+
+// redisRegistry stores each assignment as JSON under prefix+client_id, laid out as schema version
+// schema (see registryschema).
+type redisRegistry struct {
+	client *resp.Client
+	schema int
+	prefix string
+}
+
+// registrySchemaFromEnv returns REGISTRY_SCHEMA, the registry layout to read and write. It defaults
+// to 1, the layout from before versioning, so existing data stays readable until it is migrated.
+func registrySchemaFromEnv() int {
+	v := strings.TrimSpace(os.Getenv("REGISTRY_SCHEMA"))
+	if v == "" {
+		return 1
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || !registryschema.Valid(n) {
+		log.Printf("unknown REGISTRY_SCHEMA=%q, using 1", v)
+		return 1
+	}
+	return n
+}
+
+// storedSchema returns the schema version cmd/migrate last moved the data to (1 when it never ran).
+func (r *redisRegistry) storedSchema() (int, error) {
+	raw, err := r.client.GetString(registryschema.VersionKey)
+	if err == resp.ErrNil {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(raw)
+}
+
+// checkRegistrySchema warns when the stored data is laid out in another schema version than the one
+// this replica reads, which would make every stored assignment look missing.
+func checkRegistrySchema() {
+	r, ok := registryAs[*redisRegistry]()
+	if !ok {
+		return
+	}
+	for attempt := 1; attempt <= 5; attempt++ {
+		stored, err := r.storedSchema()
+		if err != nil {
+			clock.Sleep(2 * time.Second)
+			continue
+		}
+		if stored != r.schema {
+			log.Printf("WARNING: registry data is schema %d but REGISTRY_SCHEMA is %d; run cmd/migrate -to %d or set REGISTRY_SCHEMA=%d", stored, r.schema, r.schema, stored)
+		}
+		return
+	}
+	log.Printf("registry schema check: %s unreadable", registryschema.VersionKey)
+}
+
+// registrySchemaStatus reports the schema in use for /cluster/status, or nil without Redis.
+func registrySchemaStatus() map[string]any {
+	r, ok := registryAs[*redisRegistry]()
+	if !ok {
+		return nil
+	}
+	out := map[string]any{"configured": r.schema, "latest": registryschema.Latest}
+	if stored, err := r.storedSchema(); err == nil {
+		out["stored"] = stored
+	}
+	return out
+}
+
+// encode renders a in the configured schema.
+func (r *redisRegistry) encode(a Assignment) (string, error) {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return "", err
+	}
+	if b, err = registryschema.Encode(r.schema, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (r *redisRegistry) Get(clientID string) (Assignment, bool, error) {
+	defer slowOps.registryOp("get", clientID, time.Now())
+	raw, err := r.client.GetString(r.prefix + clientID)
+	if err == resp.ErrNil {
+		return Assignment{}, false, nil
+	}
+	if err != nil {
+		return Assignment{}, false, err
+	}
+	plain, err := registryschema.Decode(r.schema, []byte(raw))
+	if err != nil {
+		return Assignment{}, false, err
+	}
+	var a Assignment
+	if err := json.Unmarshal(plain, &a); err != nil {
+		return Assignment{}, false, err
+	}
+	return a, true, nil
+}
+
+func (r *redisRegistry) Put(a Assignment) error {
+	return r.PutBatch([]Assignment{a})
+}
+
+func (r *redisRegistry) PutBatch(as []Assignment) error {
+	if len(as) > 0 {
+		id := as[0].ClientID
+		if len(as) > 1 {
+			id += fmt.Sprintf(" (+%d more)", len(as)-1)
+		}
+		defer slowOps.registryOp("set", id, time.Now())
+	}
+	cmds := make([][]string, 0, len(as))
+	for _, a := range as {
+		b, err := r.encode(a)
+		if err != nil {
+			return err
+		}
+		cmds = append(cmds, []string{"SET", r.prefix + a.ClientID, b})
+	}
+	replies, err := r.client.Pipeline(cmds)
+	if err != nil {
+		return err
+	}
+	for _, rep := range replies {
+		if e, ok := rep.(resp.Error); ok {
+			return e
+		}
+	}
+	return nil
+}
+
+// PutTx writes as inside MULTI/EXEC, pipelined in one round trip.
+func (r *redisRegistry) PutTx(as []Assignment) error {
+	if len(as) == 0 {
+		return nil
+	}
+	defer slowOps.registryOp("multi", fmt.Sprintf("%s (+%d more)", as[0].ClientID, len(as)-1), time.Now())
+	cmds := make([][]string, 0, len(as)+2)
+	cmds = append(cmds, []string{"MULTI"})
+	for _, a := range as {
+		b, err := r.encode(a)
+		if err != nil {
+			return err
+		}
+		cmds = append(cmds, []string{"SET", r.prefix + a.ClientID, b})
+	}
+	cmds = append(cmds, []string{"EXEC"})
+	replies, err := r.client.Pipeline(cmds)
+	if err != nil {
+		return err
+	}
+	// A command rejected while queueing makes EXEC fail with EXECABORT, and nothing is applied.
+	for _, rep := range replies {
+		if e, ok := rep.(resp.Error); ok {
+			return e
+		}
+	}
+	if replies[len(replies)-1] == nil {
+		return errors.New("redis: transaction aborted")
+	}
+	return nil
+}
+
+func (r *redisRegistry) Delete(clientID string) error {
+	defer slowOps.registryOp("delete", clientID, time.Now())
+	_, err := r.client.Do("DEL", r.prefix+clientID)
+	return err
+}
+
+func (r *redisRegistry) List() ([]Assignment, error) {
+	start := time.Now()
+	keys, err := r.client.ScanKeys(r.prefix + "*")
+	slowOps.registryOp("scan", "*", start)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	out := make([]Assignment, 0, len(keys))
+	for _, k := range keys {
+		a, ok, err := r.Get(strings.TrimPrefix(k, r.prefix))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			out = append(out, a)
+		}
+	}
+	return out, nil
+}
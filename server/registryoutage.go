@@ -0,0 +1,159 @@
+package server
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Riding out registry outages. With REGISTRY_OUTAGE=degrade (default) a /join whose sticky write
+// fails still succeeds: the assignment is deferred, and a retry loop flushes the deferred writes
+// with exponential backoff (500ms doubling to 30s) until the registry answers again. While writes
+// are deferred the registry counts as degraded, and /where and /join answer with
+// "degraded": true, since routing falls back to the hash computation alone and stickiness is not
+// persisted. The async write-behind queue hands batches it gives up on to the same loop instead of
+// dropping them. At most REGISTRY_DEFERRED_MAX (default 100000) clients are held, the latest
+// assignment per client; beyond that new writes are dropped and counted. REGISTRY_OUTAGE=fail
+// keeps the old behaviour of failing the /join with 503 REGISTRY_WRITE_FAILED.
+
+type registryOutage struct {
+	enabled bool
+	max     int
+	down    atomic.Bool // read on every /where, so kept outside mu
+
+	mu       sync.Mutex
+	since    time.Time // zero while the registry is healthy
+	deferred map[string]Assignment
+	retrying bool
+}
+
+var outage = newRegistryOutageFromEnv()
+
+func newRegistryOutageFromEnv() *registryOutage {
+	o := &registryOutage{
+		enabled:  !strings.EqualFold(strings.TrimSpace(os.Getenv("REGISTRY_OUTAGE")), "fail"),
+		max:      100000,
+		deferred: make(map[string]Assignment),
+	}
+	if n, err := strconv.Atoi(os.Getenv("REGISTRY_DEFERRED_MAX")); err == nil && n > 0 {
+		o.max = n
+	}
+	metrics.gaugeFunc("routing_registry_degraded", "1 while registry writes are deferred because the registry is unreachable.", func() float64 {
+		if o.degraded() {
+			return 1
+		}
+		return 0
+	})
+	metrics.gaugeFunc("routing_registry_deferred", "Assignments waiting for the registry to come back.", func() float64 {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		return float64(len(o.deferred))
+	})
+	metrics.counter("routing_registry_outages_total", "Registry outages ridden out by deferring writes.")
+	metrics.counter("routing_registry_outage_seconds_total", "Time spent degraded by registry outages.")
+	metrics.counter("routing_registry_degraded_responses_total", "Responses flagged degraded, by endpoint.")
+	return o
+}
+
+// degraded reports whether assignments are currently being deferred.
+func (o *registryOutage) degraded() bool {
+	return o.down.Load()
+}
+
+// deferWrites holds as for the retry loop, starting it if needed. It returns false when
+// REGISTRY_OUTAGE=fail, so the caller fails as before.
+func (o *registryOutage) deferWrites(as []Assignment, cause error) bool {
+	if !o.enabled {
+		return false
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.since.IsZero() {
+		o.since = time.Now()
+		o.down.Store(true)
+		metrics.inc("routing_registry_outages_total")
+		log.Printf("registry unreachable, deferring assignment writes: %v", cause)
+	}
+	for _, a := range as {
+		if _, ok := o.deferred[a.ClientID]; !ok && len(o.deferred) >= o.max {
+			metrics.inc("routing_registry_writes_total", "mode", "deferred", "result", "dropped")
+			continue
+		}
+		o.deferred[a.ClientID] = a
+	}
+	if !o.retrying {
+		o.retrying = true
+		go o.retry()
+	}
+	return true
+}
+
+// retry flushes the deferred writes with exponential backoff until they all went through.
+func (o *registryOutage) retry() {
+	backoff := 500 * time.Millisecond
+	for {
+		clock.Sleep(backoff)
+		if o.flush() {
+			return
+		}
+		if backoff < 30*time.Second {
+			backoff = min(2*backoff, 30*time.Second)
+		}
+	}
+}
+
+// flush writes the deferred assignments in batches of up to 100. It reports true, having ended
+// the outage, once nothing is left.
+func (o *registryOutage) flush() bool {
+	for batch := o.nextBatch(); len(batch) > 0; batch = o.nextBatch() {
+		if err := putBatch(registry, batch); err != nil {
+			return false
+		}
+		metrics.add("routing_registry_writes_total", float64(len(batch)), "mode", "deferred", "result", "ok")
+		o.mu.Lock()
+		for _, a := range batch {
+			// An assignment deferred again meanwhile stays for the next batch.
+			if cur, ok := o.deferred[a.ClientID]; ok && cur == a {
+				delete(o.deferred, a.ClientID)
+			}
+		}
+		o.mu.Unlock()
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.deferred) > 0 {
+		return false
+	}
+	took := time.Since(o.since)
+	metrics.add("routing_registry_outage_seconds_total", took.Seconds())
+	log.Printf("registry reachable again after %s; deferred assignment writes flushed", took.Round(time.Millisecond))
+	o.since, o.retrying = time.Time{}, false
+	o.down.Store(false)
+	return true
+}
+
+func (o *registryOutage) nextBatch() []Assignment {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	batch := make([]Assignment, 0, min(len(o.deferred), 100))
+	for _, a := range o.deferred {
+		if batch = append(batch, a); len(batch) == 100 {
+			break
+		}
+	}
+	return batch
+}
+
+// noteDegraded counts a response flagged degraded on endpoint, and reports whether it is.
+func (o *registryOutage) noteDegraded(endpoint string) bool {
+	if !o.degraded() {
+		return false
+	}
+	metrics.inc("routing_registry_degraded_responses_total", "endpoint", endpoint)
+	return true
+}
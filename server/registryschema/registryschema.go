@@ -0,0 +1,111 @@
+// Package registryschema defines how assignments are laid out in a shared registry, by schema
+// version, and how a stored record is converted from one version to the next. The server reads
+// and writes the version REGISTRY_SCHEMA names; cmd/migrate moves existing data between versions.
+//
+// Versions:
+//   - 1: poc-routing:assignment:<client_id> holds the assignment as a JSON object
+//   - 2: poc-routing:v2:assignment:<client_id> holds the same object with "schema": 2, so every
+//     record names its version and records of two versions can sit side by side during a migration
+//
+// VersionKey records the version the data was last migrated to; a registry without it is
+// version 1. A new version gets a prefix, an entry in steps, and a bump of Latest.
+package registryschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Latest is the newest schema version.
+const Latest = 2
+
+// VersionKey holds the schema version of the stored data, written by cmd/migrate.
+const VersionKey = "poc-routing:schema"
+
+// Valid reports whether v is a known schema version.
+func Valid(v int) bool {
+	return v >= 1 && v <= Latest
+}
+
+// Prefix returns the key prefix of assignment records under version v.
+func Prefix(v int) string {
+	if v == 1 {
+		return "poc-routing:assignment:"
+	}
+	return "poc-routing:v" + strconv.Itoa(v) + ":assignment:"
+}
+
+// step converts records between version n and n+1.
+type step struct {
+	up   func(rec map[string]json.RawMessage) error
+	down func(rec map[string]json.RawMessage) error
+}
+
+// steps[n-1] converts between versions n and n+1.
+var steps = []step{
+	{
+		up: func(rec map[string]json.RawMessage) error {
+			rec["schema"] = json.RawMessage("2")
+			return nil
+		},
+		down: func(rec map[string]json.RawMessage) error {
+			delete(rec, "schema")
+			return nil
+		},
+	},
+}
+
+// Encode renders the assignment JSON object plain (as the server marshals it) for version v.
+func Encode(v int, plain []byte) ([]byte, error) {
+	return Convert(plain, 1, v)
+}
+
+// Decode checks that raw is a record of version v and returns it as a plain assignment object.
+// Fields a version adds are left in place; the server's decoder ignores them.
+func Decode(v int, raw []byte) ([]byte, error) {
+	if v == 1 {
+		return raw, nil
+	}
+	var rec struct {
+		Schema int `json:"schema"`
+	}
+	if err := json.Unmarshal(raw, &rec); err != nil {
+		return nil, err
+	}
+	if rec.Schema != v {
+		return nil, fmt.Errorf("registry record is schema %d, want %d", rec.Schema, v)
+	}
+	return raw, nil
+}
+
+// Convert rewrites a record from version from to version to, one step at a time.
+func Convert(raw []byte, from, to int) ([]byte, error) {
+	if !Valid(from) || !Valid(to) {
+		return nil, fmt.Errorf("unknown schema version (from %d to %d, latest %d)", from, to, Latest)
+	}
+	if from == to {
+		return raw, nil
+	}
+	var rec map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &rec); err != nil {
+		return nil, err
+	}
+	if _, ok := rec["client_id"]; !ok {
+		return nil, fmt.Errorf("record has no client_id")
+	}
+	for v := from; v != to; {
+		var err error
+		if v < to {
+			err = steps[v-1].up(rec)
+			v++
+		} else {
+			err = steps[v-2].down(rec)
+			v--
+		}
+		if err != nil {
+			return nil, fmt.Errorf("schema %d: %w", v, err)
+		}
+	}
+	return json.Marshal(rec)
+}
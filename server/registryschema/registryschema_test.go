@@ -0,0 +1,61 @@
+package registryschema
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// cmd/migrate rewrites stored assignments with these conversions, so a round trip must give back
+// the record it started from, and a record of the wrong version must never pass as another.
+
+const plain = `{"client_id":"c-42","replica":"server-1:8081","updated_at":"2026-01-02T00:00:00Z"}`
+
+func sameJSON(t *testing.T, got []byte, want string) bool {
+	t.Helper()
+	var a, b map[string]any
+	if err := json.Unmarshal(got, &a); err != nil {
+		t.Fatalf("%s: %v", got, err)
+	}
+	_ = json.Unmarshal([]byte(want), &b)
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return string(ja) == string(jb)
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	up, err := Convert([]byte(plain), 1, Latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameJSON(t, up, strings.TrimSuffix(plain, "}")+`,"schema":2}`) {
+		t.Errorf("up: %s", up)
+	}
+	if _, err := Decode(Latest, up); err != nil {
+		t.Errorf("Decode(%d) of a converted record: %v", Latest, err)
+	}
+	down, err := Convert(up, Latest, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameJSON(t, down, plain) {
+		t.Errorf("down: %s, want %s", down, plain)
+	}
+	if enc, err := Encode(2, []byte(plain)); err != nil || !sameJSON(t, enc, string(up)) {
+		t.Errorf("Encode(2) = %s, %v; want %s", enc, err, up)
+	}
+}
+
+func TestDecodeRejectsOtherVersions(t *testing.T) {
+	for _, raw := range []string{plain, strings.TrimSuffix(plain, "}") + `,"schema":3}`, "not json"} {
+		if _, err := Decode(2, []byte(raw)); err == nil {
+			t.Errorf("Decode(2, %s) accepted it", raw)
+		}
+	}
+	if _, err := Convert([]byte(`{"replica":"server-1:8081"}`), 1, 2); err == nil {
+		t.Error("Convert accepted a record without client_id")
+	}
+	if _, err := Convert([]byte(plain), 1, Latest+1); err == nil {
+		t.Error("Convert accepted an unknown version")
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"personal/poc-routing/server/buildinfo"
+)
+
+// peerInfo is what a replica advertises about itself on /internal/info.
+type peerInfo struct {
+	Hostname          string `json:"hostname"`
+	Self              string `json:"self"`
+	Version           string `json:"version"`
+	Zone              string `json:"zone,omitempty"`
+	Weight            int    `json:"weight"`
+	Sessions          int    `json:"sessions"`
+	ConfigFingerprint string `json:"config_fingerprint"`
+}
+
+// replicaInfo is what this replica last observed about a peer via its /internal/info.
+type replicaInfo struct {
+	Target            string    `json:"target"`
+	Healthy           bool      `json:"healthy"`
+	Version           string    `json:"version,omitempty"`
+	Zone              string    `json:"zone,omitempty"`
+	Weight            int       `json:"weight,omitempty"`
+	Sessions          int       `json:"active_sessions"`
+	ConfigFingerprint string    `json:"config_fingerprint,omitempty"`
+	LastSeen          time.Time `json:"last_seen,omitzero"`
+	Error             string    `json:"error,omitempty"`
+}
+
+// replicaView is the locally observed state of every replica in allTargets(). Probe results are
+// published in the routing table; the view reads them from there, so requests never take a lock
+// to read replica state.
+type replicaView struct {
+	notify atomic.Pointer[chan struct{}] // closed and replaced after every refresh
+}
+
+var replicas = newReplicaView()
+
+func newReplicaView() *replicaView {
+	v := &replicaView{}
+	ch := make(chan struct{})
+	v.notify.Store(&ch)
+	return v
+}
+
+var replicaClient = newInternalClient(time.Second)
+
+// appVersion is the version this replica advertises (APP_VERSION, default the build's version).
+func appVersion() string {
+	if v := os.Getenv("APP_VERSION"); v != "" {
+		return v
+	}
+	return buildinfo.Get().Version
+}
+
+// replicaWeight is the relative weight this replica advertises (WEIGHT, default 1).
+func replicaWeight() int {
+	if w, err := strconv.Atoi(os.Getenv("WEIGHT")); err == nil && w > 0 {
+		return w
+	}
+	return 1
+}
+
+func localInfo() peerInfo {
+	hostname, _ := os.Hostname()
+	return peerInfo{
+		Hostname:          hostname,
+		Self:              getSelf(),
+		Version:           appVersion(),
+		Zone:              os.Getenv("ZONE"),
+		Weight:            replicaWeight(),
+		Sessions:          sessions.count(),
+		ConfigFingerprint: configFingerprint(),
+	}
+}
+
+// handleInfo serves this replica's identity for peers on the internal API.
+func handleInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(localInfo())
+}
+
+// refresh polls every target's /internal/info once and replaces the view.
+func (v *replicaView) refresh() {
+	targets := allTargets()
+	next := make(map[string]*replicaInfo, len(targets))
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for _, t := range targets {
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			info := probeReplica(target)
+			mu.Lock()
+			next[target] = info
+			mu.Unlock()
+		}(t)
+	}
+	wg.Wait()
+
+	publishTable("health", next)
+	v.changed()
+}
+
+// changed wakes everything waiting on updated().
+func (v *replicaView) changed() {
+	next := make(chan struct{})
+	close(*v.notify.Swap(&next))
+}
+
+// updated returns a channel that is closed after the next refresh.
+func (v *replicaView) updated() <-chan struct{} {
+	return *v.notify.Load()
+}
+
+// polled reports whether the view has been populated by a refresh.
+func (v *replicaView) polled() bool {
+	return currentTable().polled
+}
+
+func probeReplica(target string) *replicaInfo {
+	defer slowOps.discoveryOp("replica_probe", target, time.Now())
+	info := &replicaInfo{Target: target}
+	req, err := newInternalRequest(http.MethodGet, target, "/internal/info", nil)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	resp, err := replicaClient.Do(req)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	defer resp.Body.Close()
+	var body peerInfo
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&body) != nil {
+		info.Error = resp.Status
+		return info
+	}
+	info.Healthy = true
+	info.Version = body.Version
+	info.Zone = body.Zone
+	info.Weight = body.Weight
+	info.Sessions = body.Sessions
+	info.ConfigFingerprint = body.ConfigFingerprint
+	info.LastSeen = clock.Now()
+	return info
+}
+
+// snapshot returns a copy of the view ordered by target.
+func (v *replicaView) snapshot() []replicaInfo {
+	t := currentTable()
+	out := make([]replicaInfo, 0, len(t.replicas))
+	for _, info := range t.replicas {
+		out = append(out, *info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Target < out[j].Target })
+	return out
+}
+
+// get returns what we know about target.
+func (v *replicaView) get(target string) (replicaInfo, bool) {
+	return currentTable().info(target)
+}
+
+// runReplicaPoller refreshes the replica view every REPLICA_POLL_INTERVAL (default 5s).
+func runReplicaPoller() {
+	interval := 5 * time.Second
+	if d, err := time.ParseDuration(os.Getenv("REPLICA_POLL_INTERVAL")); err == nil && d > 0 {
+		interval = d
+	}
+	for {
+		replicas.refresh()
+		// Poll faster while nothing is healthy or requests wait on an owner, so recovery is noticed quickly.
+		if (len(healthyTargets()) == 0 || ownerWait.waiting()) && interval > 500*time.Millisecond {
+			clock.Sleep(500 * time.Millisecond)
+			continue
+		}
+		clock.Sleep(interval)
+	}
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Retries on the gateway's reverse proxy, with an Envoy-style retry budget. With PROXY_RETRIES
+// set (default 0, off), a forward that fails is sent to the owner again, up to that many times:
+//   - GET, HEAD and OPTIONS are retried on a transport error or a 502, 503 or 504
+//   - other methods only when the connection could not be opened, so the owner never saw them
+//   - requests with a body the proxy can't replay, upgrades and calls refused by a breaker are not
+//
+// Retries go to the same owner, the only replica that holds the client; outlier ejection (see
+// outlier.go) is what moves clients off a replica that keeps failing. Each retry waits a fully
+// jittered exponential backoff from PROXY_RETRY_BACKOFF (default 25ms, capped at 10x). The
+// budget keeps retries from multiplying load on a struggling ring: retries in flight may be at
+// most PROXY_RETRY_BUDGET_PERCENT (default 20) of the active forwards, but never fewer than
+// PROXY_RETRY_MIN_CONCURRENCY (default 3). A retry over budget is skipped and the failure
+// returned as is.
+//
+// Metrics use Envoy's names and its envoy_cluster_name label (XDS_CLUSTER):
+// envoy_cluster_upstream_rq_retry, _retry_success, _retry_overflow (over budget),
+// _retry_limit_exceeded (out of retries) and _retry_backoff_exponential.
+
+type retryTransport struct {
+	base           http.RoundTripper
+	retries        int
+	backoff        time.Duration
+	budgetPercent  float64
+	minConcurrency int
+
+	active   atomic.Int64
+	retrying atomic.Int64
+}
+
+func newRetryTransport(base http.RoundTripper) http.RoundTripper {
+	n, err := strconv.Atoi(os.Getenv("PROXY_RETRIES"))
+	if err != nil || n <= 0 {
+		return base
+	}
+	t := &retryTransport{base: base, retries: n, backoff: 25 * time.Millisecond, budgetPercent: 20, minConcurrency: 3}
+	if d, err := time.ParseDuration(os.Getenv("PROXY_RETRY_BACKOFF")); err == nil && d > 0 {
+		t.backoff = d
+	}
+	if p, err := strconv.ParseFloat(os.Getenv("PROXY_RETRY_BUDGET_PERCENT"), 64); err == nil && p >= 0 {
+		t.budgetPercent = p
+	}
+	if c, err := strconv.Atoi(os.Getenv("PROXY_RETRY_MIN_CONCURRENCY")); err == nil && c >= 0 {
+		t.minConcurrency = c
+	}
+	metrics.counter("envoy_cluster_upstream_rq_retry", "Forwards retried.")
+	metrics.counter("envoy_cluster_upstream_rq_retry_success", "Retries that succeeded.")
+	metrics.counter("envoy_cluster_upstream_rq_retry_overflow", "Retries skipped because the retry budget was used up.")
+	metrics.counter("envoy_cluster_upstream_rq_retry_limit_exceeded", "Forwards that still failed after PROXY_RETRIES retries.")
+	metrics.counter("envoy_cluster_upstream_rq_retry_backoff_exponential", "Retries that waited an exponential backoff.")
+	return t
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.active.Add(1)
+	defer t.active.Add(-1)
+	cluster := xds.cluster
+	resp, err := t.base.RoundTrip(req)
+	for attempt := 1; retriable(req, resp, err); attempt++ {
+		if attempt > t.retries {
+			metrics.inc("envoy_cluster_upstream_rq_retry_limit_exceeded", "envoy_cluster_name", cluster)
+			break
+		}
+		if !t.admit() {
+			metrics.inc("envoy_cluster_upstream_rq_retry_overflow", "envoy_cluster_name", cluster)
+			break
+		}
+		retry, rerr := rewindRequest(req)
+		if rerr != nil {
+			t.retrying.Add(-1)
+			break
+		}
+		timer := time.NewTimer(t.backoffFor(attempt))
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			t.retrying.Add(-1)
+			return resp, err
+		case <-timer.C:
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+			resp.Body.Close()
+		}
+		metrics.inc("envoy_cluster_upstream_rq_retry", "envoy_cluster_name", cluster)
+		metrics.inc("envoy_cluster_upstream_rq_retry_backoff_exponential", "envoy_cluster_name", cluster)
+		resp, err = t.base.RoundTrip(retry)
+		t.retrying.Add(-1)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			metrics.inc("envoy_cluster_upstream_rq_retry_success", "envoy_cluster_name", cluster)
+		}
+	}
+	return resp, err
+}
+
+// admit takes a slot in the retry budget.
+func (t *retryTransport) admit() bool {
+	allowed := max(int64(t.minConcurrency), int64(t.budgetPercent*float64(t.active.Load())/100))
+	if t.retrying.Add(1) > allowed {
+		t.retrying.Add(-1)
+		return false
+	}
+	return true
+}
+
+// backoffFor is Envoy's fully jittered exponential backoff for the given retry.
+func (t *retryTransport) backoffFor(attempt int) time.Duration {
+	ceiling := min(t.backoff<<min(attempt, 16), 10*t.backoff)
+	return time.Duration(rand.Int64N(int64(ceiling)) + 1)
+}
+
+// retriable reports whether a forward that ended in resp or err may be sent again.
+func retriable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil || isWebSocketUpgrade(req) {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	idempotent := req.Method == http.MethodGet || req.Method == http.MethodHead || req.Method == http.MethodOptions
+	if err != nil {
+		if errors.Is(err, errBreakerOpen) {
+			return false
+		}
+		var op *net.OpError
+		return idempotent || errors.As(err, &op) && op.Op == "dial"
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
+
+// rewindRequest returns a copy of req that can be sent again.
+func rewindRequest(req *http.Request) (*http.Request, error) {
+	out := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		out.Body = body
+	}
+	return out, nil
+}
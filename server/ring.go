@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// GET /ring describes how the hash space is divided between replicas, for external tools to
+// render and check balance. Routing is HASH_ALGORITHM(routing key) mod N over the ordered targets
+// rather than a ring of virtual nodes, so there are no contiguous arcs or virtual-node positions
+// to list: each replica owns the residue class of its index. Its arc is reported as that class,
+// every stride-th hash value from start to end, with the exact share of the 2^32 or 2^64 hash
+// space, plus an empirical distribution of ?sample=N generated IDs (?prefix=, default "client-")
+// so uneven real-world spread shows up.
+
+const ringSampleMax = 1_000_000
+
+type ringReplica struct {
+	Index   int      `json:"index"`
+	Target  string   `json:"target"`
+	Healthy bool     `json:"healthy"`
+	Weight  int      `json:"weight"`
+	Hashes  uint64   `json:"hashes"` // hash values owned out of hash_space
+	Share   float64  `json:"share"`
+	Sampled int      `json:"sampled"`
+	Arc     *ringArc `json:"arc,omitempty"` // nil when the replica owns no hash value
+}
+
+// ringArc is the hash values a replica owns: start, start+stride, ... up to end. Positions are
+// strings, since 64-bit values don't survive a JSON number in most tools.
+type ringArc struct {
+	Start  uint64 `json:"start,string"`
+	End    uint64 `json:"end,string"`
+	Stride int    `json:"stride"`
+}
+
+func handleRing(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	sample := 10000
+	if v := q.Get("sample"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > ringSampleMax {
+			http.Error(w, "invalid sample", http.StatusBadRequest)
+			return
+		}
+		sample = n
+	}
+	prefix := q.Get("prefix")
+	if prefix == "" {
+		prefix = "client-"
+	}
+
+	out, maxOverMean := ringReplicas(sample, prefix)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"algorithm":     keyHasher.Name() + "-mod-n",
+		"index_mode":    ringIndexMode(),
+		"hash_space":    hashSpace(keyHasher),
+		"ring_version":  ringVersion(),
+		"table_version": currentTable().Version,
+		"membership":    members.source(),
+		"replicas":      out,
+		"sample":        sample,
+		"sample_prefix": prefix,
+		"max_over_mean": maxOverMean,
+	})
+}
+
+// ringReplicas describes each target's share of the hash space and, with sample > 0, how many of
+// sample generated IDs it owns, plus the largest of those counts over the mean.
+func ringReplicas(sample int, prefix string) ([]ringReplica, float64) {
+	targets := allTargets()
+	n := max(len(targets), 1)
+	out := make([]ringReplica, len(targets))
+	pos := make(map[string]int, len(targets))
+	for i, t := range targets {
+		info, _ := replicas.get(t)
+		// Values h in the hash space with h mod n == i.
+		hashes := hashesInBucket(keyHasher, i, n)
+		out[i] = ringReplica{
+			Index:   indexBase() + i,
+			Target:  t,
+			Healthy: ownerHealthy(t),
+			Weight:  max(info.Weight, 1),
+			Hashes:  hashes,
+			Share:   float64(hashes) / hashSpace(keyHasher),
+		}
+		if hashes > 0 {
+			out[i].Arc = &ringArc{Start: uint64(i), End: lastInBucket(keyHasher, i, n), Stride: n}
+		}
+		pos[t] = i
+	}
+	for i := 0; i < sample && len(targets) > 0; i++ {
+		if j, ok := pos[pickByHashScaled(prefix+strconv.Itoa(i))]; ok {
+			out[j].Sampled++
+		}
+	}
+	maxOverMean := 0.0
+	if sample > 0 && len(targets) > 0 {
+		mean := float64(sample) / float64(len(targets))
+		for _, rr := range out {
+			maxOverMean = max(maxOverMean, float64(rr.Sampled)/mean)
+		}
+	}
+
+	return out, maxOverMean
+}
+
+func ringIndexMode() string {
+	if mode := strings.ToLower(strings.TrimSpace(os.Getenv("INDEX_MODE"))); mode == "numeric" {
+		return mode
+	}
+	return "hash"
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Shared ring view. /where is routed round-robin, so any replica may answer for any client, and
+// a replica still hashing over an old ring (membership held back, a poll behind, stale config)
+// would answer differently from its peers. With RING_VIEW=registry the replicas agree on a
+// cluster-wide ring epoch in the shared registry: the record holds the epoch and the ring
+// version (target list hash) it stands for.
+//
+// Every RING_VIEW_INTERVAL (default 1s) each replica compares its ring with the record:
+//   - same ring: the replica is in sync at that epoch and renews the record
+//   - different ring, record still at the epoch this replica last synced: this replica's ring
+//     moved first, so it publishes the next epoch (compare-and-set; one replica wins a race)
+//   - different ring, newer epoch: the cluster moved on without this replica, whose view is stale
+//
+// A stale replica answers /where and /where/batch with 503 STALE_VIEW and the header
+// X-Routing-Stale-View, which envoy.yaml retries on another replica, until its ring catches up.
+// The record expires after 10 intervals without a replica in sync renewing it, so a ring nobody
+// holds any more (e.g. after a rolling restart that changed REPLICAS) gives way to the new one.
+// A registry error leaves the replica answering: it can't tell it is behind. Needs a registry
+// backend that supports it (redis).
+
+// ringViewRecord is the shared ring view. Epoch is assigned by the registry on publish.
+type ringViewRecord struct {
+	Epoch       int64     `json:"epoch"`
+	Ring        string    `json:"ring"`
+	Targets     []string  `json:"targets"`
+	PublishedBy string    `json:"published_by"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// ringViewStore is implemented by registry backends that can hold the shared ring view.
+type ringViewStore interface {
+	// RingView returns the shared view, with Epoch 0 when none is published.
+	RingView() (ringViewRecord, error)
+	// PublishRingView replaces the view if it is still at epoch prev (0: none) and returns the
+	// new epoch, or 0 if another replica published first.
+	PublishRingView(prev int64, rec ringViewRecord, ttl time.Duration) (int64, error)
+	// RenewRingView extends the view while it is at epoch.
+	RenewRingView(epoch int64, ttl time.Duration) error
+}
+
+const staleViewHeader = "X-Routing-Stale-View"
+
+type ringViewState struct {
+	mu      sync.Mutex
+	enabled bool
+	synced  int64 // shared epoch at which the local ring last matched
+	shared  ringViewRecord
+	stale   bool
+	err     string
+}
+
+var ringView = &ringViewState{}
+
+func ringViewInterval() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("RING_VIEW_INTERVAL")); err == nil && d > 0 {
+		return d
+	}
+	return time.Second
+}
+
+// runRingView keeps this replica's ring in step with the shared view.
+func runRingView() {
+	if os.Getenv("RING_VIEW") != "registry" {
+		return
+	}
+	store, ok := registryAs[ringViewStore]()
+	if !ok {
+		log.Printf("RING_VIEW=registry needs a shared registry backend (redis); /where is not checked against peers")
+		return
+	}
+	metrics.counter("routing_stale_view_rejections_total", "Lookups refused while this replica's ring was behind the cluster's, by endpoint.")
+	metrics.counter("routing_ring_view_errors_total", "Shared ring view reads and writes that failed.")
+	metrics.gaugeFunc("routing_ring_view_stale", "1 while this replica's ring is behind the shared ring view.", func() float64 {
+		if ringView.isStale() {
+			return 1
+		}
+		return 0
+	})
+	metrics.gaugeFunc("routing_ring_view_epoch", "Shared ring view epoch this replica last matched.", func() float64 {
+		ringView.mu.Lock()
+		defer ringView.mu.Unlock()
+		return float64(ringView.synced)
+	})
+	ringView.mu.Lock()
+	ringView.enabled = true
+	ringView.mu.Unlock()
+	bootstrap.wait()
+	interval := ringViewInterval()
+	ringView.check(store, 10*interval)
+	for range clock.Tick(interval) {
+		ringView.check(store, 10*interval)
+	}
+}
+
+// check compares the local ring with the shared view, publishing or renewing it as needed.
+func (v *ringViewState) check(store ringViewStore, ttl time.Duration) {
+	t := currentTable()
+	rec, err := store.RingView()
+	if err == nil {
+		switch {
+		case rec.Epoch != 0 && rec.Ring == t.ring:
+			err = store.RenewRingView(rec.Epoch, ttl)
+		case rec.Epoch == 0 || rec.Epoch == v.syncedEpoch():
+			next := ringViewRecord{Ring: t.ring, Targets: t.Targets, PublishedBy: selfTarget(), PublishedAt: clock.Now()}
+			var epoch int64
+			if epoch, err = store.PublishRingView(rec.Epoch, next, ttl); err == nil && epoch != 0 {
+				next.Epoch = epoch
+				rec = next
+				log.Printf("ring view: published epoch %d (ring %s)", epoch, t.ring)
+			}
+		}
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err != nil {
+		metrics.inc("routing_ring_view_errors_total")
+		if v.err == "" {
+			log.Printf("ring view: %v; answering without it", err)
+		}
+		v.err, v.stale = err.Error(), false
+		return
+	}
+	v.err, v.shared = "", rec
+	stale := rec.Epoch != 0 && rec.Ring != t.ring
+	if !stale {
+		v.synced = rec.Epoch
+	}
+	if stale != v.stale {
+		if stale {
+			log.Printf("ring view: STALE, cluster is at epoch %d (ring %s, published by %s), local ring %s",
+				rec.Epoch, rec.Ring, rec.PublishedBy, t.ring)
+		} else {
+			log.Printf("ring view: in sync at epoch %d", rec.Epoch)
+		}
+	}
+	v.stale = stale
+}
+
+func (v *ringViewState) syncedEpoch() int64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.synced
+}
+
+func (v *ringViewState) isStale() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.stale
+}
+
+// checkRingView answers 503 STALE_VIEW and returns false while this replica's ring is behind.
+func checkRingView(w http.ResponseWriter, endpoint string) bool {
+	v := ringView
+	v.mu.Lock()
+	stale, shared := v.stale, v.shared
+	v.mu.Unlock()
+	if !stale {
+		return true
+	}
+	metrics.inc("routing_stale_view_rejections_total", "endpoint", endpoint)
+	w.Header().Set(staleViewHeader, strconv.FormatInt(shared.Epoch, 10))
+	w.Header().Set("Retry-After", "1")
+	writeError(w, http.StatusServiceUnavailable, "STALE_VIEW",
+		fmt.Sprintf("ring %s is behind the cluster's ring %s (epoch %d)", ringVersion(), shared.Ring, shared.Epoch))
+	return false
+}
+
+func ringViewStatus() map[string]any {
+	v := ringView
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !v.enabled {
+		return nil
+	}
+	st := map[string]any{
+		"synced_epoch": v.synced,
+		"stale":        v.stale,
+		"shared":       v.shared,
+	}
+	if v.err != "" {
+		st["error"] = v.err
+	}
+	return st
+}
+
+const (
+	ringViewKey   = "poc-routing:ring-view"
+	ringViewEpoch = "poc-routing:ring-view:epoch"
+)
+
+// redisPublishRingViewScript swaps the view in if it is still at ARGV[1]. The epoch counter is a
+// separate key, so epochs keep increasing after an expired view.
+const redisPublishRingViewScript = `local cur = tonumber(redis.call('HGET', KEYS[1], 'epoch') or '0')
+if cur ~= tonumber(ARGV[1]) then return 0 end
+local n = redis.call('INCR', KEYS[2])
+redis.call('HSET', KEYS[1], 'epoch', n, 'record', ARGV[2])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return n`
+
+const redisRenewRingViewScript = `if redis.call('HGET', KEYS[1], 'epoch') == ARGV[1] then return redis.call('PEXPIRE', KEYS[1], ARGV[2]) else return 0 end`
+
+func (r *redisRegistry) RingView() (ringViewRecord, error) {
+	v, err := r.client.Do("HMGET", ringViewKey, "epoch", "record")
+	if err != nil {
+		return ringViewRecord{}, err
+	}
+	fields, _ := v.([]any)
+	if len(fields) != 2 {
+		return ringViewRecord{}, nil
+	}
+	epoch, _ := fields[0].(string)
+	raw, _ := fields[1].(string)
+	if epoch == "" || raw == "" {
+		return ringViewRecord{}, nil
+	}
+	var rec ringViewRecord
+	if err := json.Unmarshal([]byte(raw), &rec); err != nil {
+		return ringViewRecord{}, fmt.Errorf("ring view: %w", err)
+	}
+	rec.Epoch, err = strconv.ParseInt(epoch, 10, 64)
+	return rec, err
+}
+
+func (r *redisRegistry) PublishRingView(prev int64, rec ringViewRecord, ttl time.Duration) (int64, error) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return 0, err
+	}
+	v, err := r.client.Do("EVAL", redisPublishRingViewScript, "2", ringViewKey, ringViewEpoch,
+		strconv.FormatInt(prev, 10), string(b), strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		return 0, err
+	}
+	n, _ := v.(int64)
+	return n, nil
+}
+
+func (r *redisRegistry) RenewRingView(epoch int64, ttl time.Duration) error {
+	_, err := r.client.Do("EVAL", redisRenewRingViewScript, "1", ringViewKey,
+		strconv.FormatInt(epoch, 10), strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
@@ -0,0 +1,1192 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: admin.proto
+
+package routingpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type GetRingRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Generated IDs to place for the sampled distribution; 0 skips sampling, like ?sample=0.
+	Sample int32 `protobuf:"varint,1,opt,name=sample,proto3" json:"sample,omitempty"`
+	// Prefix of the generated IDs, default "client-".
+	Prefix        string `protobuf:"bytes,2,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetRingRequest) Reset() {
+	*x = GetRingRequest{}
+	mi := &file_admin_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetRingRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetRingRequest) ProtoMessage() {}
+
+func (x *GetRingRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetRingRequest.ProtoReflect.Descriptor instead.
+func (*GetRingRequest) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *GetRingRequest) GetSample() int32 {
+	if x != nil {
+		return x.Sample
+	}
+	return 0
+}
+
+func (x *GetRingRequest) GetPrefix() string {
+	if x != nil {
+		return x.Prefix
+	}
+	return ""
+}
+
+type Ring struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Algorithm     string                 `protobuf:"bytes,1,opt,name=algorithm,proto3" json:"algorithm,omitempty"`
+	IndexMode     string                 `protobuf:"bytes,2,opt,name=index_mode,json=indexMode,proto3" json:"index_mode,omitempty"`
+	HashSpace     float64                `protobuf:"fixed64,3,opt,name=hash_space,json=hashSpace,proto3" json:"hash_space,omitempty"`
+	RingVersion   string                 `protobuf:"bytes,4,opt,name=ring_version,json=ringVersion,proto3" json:"ring_version,omitempty"`
+	TableVersion  uint64                 `protobuf:"varint,5,opt,name=table_version,json=tableVersion,proto3" json:"table_version,omitempty"`
+	Membership    string                 `protobuf:"bytes,6,opt,name=membership,proto3" json:"membership,omitempty"`
+	Replicas      []*RingReplica         `protobuf:"bytes,7,rep,name=replicas,proto3" json:"replicas,omitempty"`
+	Sample        int32                  `protobuf:"varint,8,opt,name=sample,proto3" json:"sample,omitempty"`
+	SamplePrefix  string                 `protobuf:"bytes,9,opt,name=sample_prefix,json=samplePrefix,proto3" json:"sample_prefix,omitempty"`
+	MaxOverMean   float64                `protobuf:"fixed64,10,opt,name=max_over_mean,json=maxOverMean,proto3" json:"max_over_mean,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Ring) Reset() {
+	*x = Ring{}
+	mi := &file_admin_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Ring) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Ring) ProtoMessage() {}
+
+func (x *Ring) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Ring.ProtoReflect.Descriptor instead.
+func (*Ring) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Ring) GetAlgorithm() string {
+	if x != nil {
+		return x.Algorithm
+	}
+	return ""
+}
+
+func (x *Ring) GetIndexMode() string {
+	if x != nil {
+		return x.IndexMode
+	}
+	return ""
+}
+
+func (x *Ring) GetHashSpace() float64 {
+	if x != nil {
+		return x.HashSpace
+	}
+	return 0
+}
+
+func (x *Ring) GetRingVersion() string {
+	if x != nil {
+		return x.RingVersion
+	}
+	return ""
+}
+
+func (x *Ring) GetTableVersion() uint64 {
+	if x != nil {
+		return x.TableVersion
+	}
+	return 0
+}
+
+func (x *Ring) GetMembership() string {
+	if x != nil {
+		return x.Membership
+	}
+	return ""
+}
+
+func (x *Ring) GetReplicas() []*RingReplica {
+	if x != nil {
+		return x.Replicas
+	}
+	return nil
+}
+
+func (x *Ring) GetSample() int32 {
+	if x != nil {
+		return x.Sample
+	}
+	return 0
+}
+
+func (x *Ring) GetSamplePrefix() string {
+	if x != nil {
+		return x.SamplePrefix
+	}
+	return ""
+}
+
+func (x *Ring) GetMaxOverMean() float64 {
+	if x != nil {
+		return x.MaxOverMean
+	}
+	return 0
+}
+
+type RingReplica struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Index   int32                  `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	Target  string                 `protobuf:"bytes,2,opt,name=target,proto3" json:"target,omitempty"`
+	Healthy bool                   `protobuf:"varint,3,opt,name=healthy,proto3" json:"healthy,omitempty"`
+	Weight  int32                  `protobuf:"varint,4,opt,name=weight,proto3" json:"weight,omitempty"`
+	// Hash values owned out of hash_space.
+	Hashes  uint64  `protobuf:"varint,5,opt,name=hashes,proto3" json:"hashes,omitempty"`
+	Share   float64 `protobuf:"fixed64,6,opt,name=share,proto3" json:"share,omitempty"`
+	Sampled int32   `protobuf:"varint,7,opt,name=sampled,proto3" json:"sampled,omitempty"`
+	// The hash values owned: arc_start, arc_start + arc_stride, ... up to arc_end. Routing is hash
+	// mod N, so a replica owns this residue class rather than a contiguous arc. All zero when it
+	// owns none.
+	ArcStart      uint64 `protobuf:"varint,8,opt,name=arc_start,json=arcStart,proto3" json:"arc_start,omitempty"`
+	ArcEnd        uint64 `protobuf:"varint,9,opt,name=arc_end,json=arcEnd,proto3" json:"arc_end,omitempty"`
+	ArcStride     int32  `protobuf:"varint,10,opt,name=arc_stride,json=arcStride,proto3" json:"arc_stride,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RingReplica) Reset() {
+	*x = RingReplica{}
+	mi := &file_admin_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RingReplica) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RingReplica) ProtoMessage() {}
+
+func (x *RingReplica) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RingReplica.ProtoReflect.Descriptor instead.
+func (*RingReplica) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *RingReplica) GetIndex() int32 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *RingReplica) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *RingReplica) GetHealthy() bool {
+	if x != nil {
+		return x.Healthy
+	}
+	return false
+}
+
+func (x *RingReplica) GetWeight() int32 {
+	if x != nil {
+		return x.Weight
+	}
+	return 0
+}
+
+func (x *RingReplica) GetHashes() uint64 {
+	if x != nil {
+		return x.Hashes
+	}
+	return 0
+}
+
+func (x *RingReplica) GetShare() float64 {
+	if x != nil {
+		return x.Share
+	}
+	return 0
+}
+
+func (x *RingReplica) GetSampled() int32 {
+	if x != nil {
+		return x.Sampled
+	}
+	return 0
+}
+
+func (x *RingReplica) GetArcStart() uint64 {
+	if x != nil {
+		return x.ArcStart
+	}
+	return 0
+}
+
+func (x *RingReplica) GetArcEnd() uint64 {
+	if x != nil {
+		return x.ArcEnd
+	}
+	return 0
+}
+
+func (x *RingReplica) GetArcStride() int32 {
+	if x != nil {
+		return x.ArcStride
+	}
+	return 0
+}
+
+type GetSlotMapRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetSlotMapRequest) Reset() {
+	*x = GetSlotMapRequest{}
+	mi := &file_admin_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetSlotMapRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetSlotMapRequest) ProtoMessage() {}
+
+func (x *GetSlotMapRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetSlotMapRequest.ProtoReflect.Descriptor instead.
+func (*GetSlotMapRequest) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{3}
+}
+
+type SlotMap struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	TableVersion uint64                 `protobuf:"varint,1,opt,name=table_version,json=tableVersion,proto3" json:"table_version,omitempty"`
+	// What triggered the last version change: config, membership, health, gossip or outlier.
+	Reason        string                 `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	BuiltAt       *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=built_at,json=builtAt,proto3" json:"built_at,omitempty"`
+	RingVersion   string                 `protobuf:"bytes,4,opt,name=ring_version,json=ringVersion,proto3" json:"ring_version,omitempty"`
+	Slots         []*Slot                `protobuf:"bytes,5,rep,name=slots,proto3" json:"slots,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SlotMap) Reset() {
+	*x = SlotMap{}
+	mi := &file_admin_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SlotMap) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SlotMap) ProtoMessage() {}
+
+func (x *SlotMap) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SlotMap.ProtoReflect.Descriptor instead.
+func (*SlotMap) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *SlotMap) GetTableVersion() uint64 {
+	if x != nil {
+		return x.TableVersion
+	}
+	return 0
+}
+
+func (x *SlotMap) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *SlotMap) GetBuiltAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.BuiltAt
+	}
+	return nil
+}
+
+func (x *SlotMap) GetRingVersion() string {
+	if x != nil {
+		return x.RingVersion
+	}
+	return ""
+}
+
+func (x *SlotMap) GetSlots() []*Slot {
+	if x != nil {
+		return x.Slots
+	}
+	return nil
+}
+
+type Slot struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Index   int32                  `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	Target  string                 `protobuf:"bytes,2,opt,name=target,proto3" json:"target,omitempty"`
+	Healthy bool                   `protobuf:"varint,3,opt,name=healthy,proto3" json:"healthy,omitempty"`
+	// The STANDBY_PAIRS partner that takes the slot's clients while target is down, if any.
+	Standby       string `protobuf:"bytes,4,opt,name=standby,proto3" json:"standby,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Slot) Reset() {
+	*x = Slot{}
+	mi := &file_admin_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Slot) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Slot) ProtoMessage() {}
+
+func (x *Slot) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Slot.ProtoReflect.Descriptor instead.
+func (*Slot) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Slot) GetIndex() int32 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *Slot) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *Slot) GetHealthy() bool {
+	if x != nil {
+		return x.Healthy
+	}
+	return false
+}
+
+func (x *Slot) GetStandby() string {
+	if x != nil {
+		return x.Standby
+	}
+	return ""
+}
+
+type ListPinsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListPinsRequest) Reset() {
+	*x = ListPinsRequest{}
+	mi := &file_admin_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListPinsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPinsRequest) ProtoMessage() {}
+
+func (x *ListPinsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPinsRequest.ProtoReflect.Descriptor instead.
+func (*ListPinsRequest) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{6}
+}
+
+type PinList struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Pins  []*Pin                 `protobuf:"bytes,1,rep,name=pins,proto3" json:"pins,omitempty"`
+	// Oldest first, at most 100.
+	History       []*Move `protobuf:"bytes,2,rep,name=history,proto3" json:"history,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PinList) Reset() {
+	*x = PinList{}
+	mi := &file_admin_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PinList) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PinList) ProtoMessage() {}
+
+func (x *PinList) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PinList.ProtoReflect.Descriptor instead.
+func (*PinList) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *PinList) GetPins() []*Pin {
+	if x != nil {
+		return x.Pins
+	}
+	return nil
+}
+
+func (x *PinList) GetHistory() []*Move {
+	if x != nil {
+		return x.History
+	}
+	return nil
+}
+
+type Pin struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ClientId      string                 `protobuf:"bytes,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	Replica       string                 `protobuf:"bytes,2,opt,name=replica,proto3" json:"replica,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Pin) Reset() {
+	*x = Pin{}
+	mi := &file_admin_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Pin) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Pin) ProtoMessage() {}
+
+func (x *Pin) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Pin.ProtoReflect.Descriptor instead.
+func (*Pin) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Pin) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+func (x *Pin) GetReplica() string {
+	if x != nil {
+		return x.Replica
+	}
+	return ""
+}
+
+type Move struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ClientId      string                 `protobuf:"bytes,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	From          string                 `protobuf:"bytes,2,opt,name=from,proto3" json:"from,omitempty"`
+	To            string                 `protobuf:"bytes,3,opt,name=to,proto3" json:"to,omitempty"`
+	Reason        string                 `protobuf:"bytes,4,opt,name=reason,proto3" json:"reason,omitempty"`
+	Ts            *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=ts,proto3" json:"ts,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Move) Reset() {
+	*x = Move{}
+	mi := &file_admin_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Move) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Move) ProtoMessage() {}
+
+func (x *Move) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Move.ProtoReflect.Descriptor instead.
+func (*Move) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Move) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+func (x *Move) GetFrom() string {
+	if x != nil {
+		return x.From
+	}
+	return ""
+}
+
+func (x *Move) GetTo() string {
+	if x != nil {
+		return x.To
+	}
+	return ""
+}
+
+func (x *Move) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *Move) GetTs() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Ts
+	}
+	return nil
+}
+
+type GetHealthRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetHealthRequest) Reset() {
+	*x = GetHealthRequest{}
+	mi := &file_admin_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetHealthRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetHealthRequest) ProtoMessage() {}
+
+func (x *GetHealthRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetHealthRequest.ProtoReflect.Descriptor instead.
+func (*GetHealthRequest) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{10}
+}
+
+type HealthView struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Replicas        []*ReplicaHealth       `protobuf:"bytes,1,rep,name=replicas,proto3" json:"replicas,omitempty"`
+	ReplicasHealthy int32                  `protobuf:"varint,2,opt,name=replicas_healthy,json=replicasHealthy,proto3" json:"replicas_healthy,omitempty"`
+	BreakersEnabled bool                   `protobuf:"varint,3,opt,name=breakers_enabled,json=breakersEnabled,proto3" json:"breakers_enabled,omitempty"`
+	Breakers        []*Breaker             `protobuf:"bytes,4,rep,name=breakers,proto3" json:"breakers,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *HealthView) Reset() {
+	*x = HealthView{}
+	mi := &file_admin_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HealthView) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HealthView) ProtoMessage() {}
+
+func (x *HealthView) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HealthView.ProtoReflect.Descriptor instead.
+func (*HealthView) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *HealthView) GetReplicas() []*ReplicaHealth {
+	if x != nil {
+		return x.Replicas
+	}
+	return nil
+}
+
+func (x *HealthView) GetReplicasHealthy() int32 {
+	if x != nil {
+		return x.ReplicasHealthy
+	}
+	return 0
+}
+
+func (x *HealthView) GetBreakersEnabled() bool {
+	if x != nil {
+		return x.BreakersEnabled
+	}
+	return false
+}
+
+func (x *HealthView) GetBreakers() []*Breaker {
+	if x != nil {
+		return x.Breakers
+	}
+	return nil
+}
+
+type ReplicaHealth struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	Target            string                 `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
+	Healthy           bool                   `protobuf:"varint,2,opt,name=healthy,proto3" json:"healthy,omitempty"`
+	Version           string                 `protobuf:"bytes,3,opt,name=version,proto3" json:"version,omitempty"`
+	Zone              string                 `protobuf:"bytes,4,opt,name=zone,proto3" json:"zone,omitempty"`
+	Weight            int32                  `protobuf:"varint,5,opt,name=weight,proto3" json:"weight,omitempty"`
+	ActiveSessions    int32                  `protobuf:"varint,6,opt,name=active_sessions,json=activeSessions,proto3" json:"active_sessions,omitempty"`
+	ConfigFingerprint string                 `protobuf:"bytes,7,opt,name=config_fingerprint,json=configFingerprint,proto3" json:"config_fingerprint,omitempty"`
+	LastSeen          *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=last_seen,json=lastSeen,proto3" json:"last_seen,omitempty"`
+	Error             string                 `protobuf:"bytes,9,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *ReplicaHealth) Reset() {
+	*x = ReplicaHealth{}
+	mi := &file_admin_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReplicaHealth) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReplicaHealth) ProtoMessage() {}
+
+func (x *ReplicaHealth) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReplicaHealth.ProtoReflect.Descriptor instead.
+func (*ReplicaHealth) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *ReplicaHealth) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *ReplicaHealth) GetHealthy() bool {
+	if x != nil {
+		return x.Healthy
+	}
+	return false
+}
+
+func (x *ReplicaHealth) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *ReplicaHealth) GetZone() string {
+	if x != nil {
+		return x.Zone
+	}
+	return ""
+}
+
+func (x *ReplicaHealth) GetWeight() int32 {
+	if x != nil {
+		return x.Weight
+	}
+	return 0
+}
+
+func (x *ReplicaHealth) GetActiveSessions() int32 {
+	if x != nil {
+		return x.ActiveSessions
+	}
+	return 0
+}
+
+func (x *ReplicaHealth) GetConfigFingerprint() string {
+	if x != nil {
+		return x.ConfigFingerprint
+	}
+	return ""
+}
+
+func (x *ReplicaHealth) GetLastSeen() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastSeen
+	}
+	return nil
+}
+
+func (x *ReplicaHealth) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type Breaker struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Target string                 `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
+	// closed, half-open or open.
+	State               string                 `protobuf:"bytes,2,opt,name=state,proto3" json:"state,omitempty"`
+	ConsecutiveFailures int32                  `protobuf:"varint,3,opt,name=consecutive_failures,json=consecutiveFailures,proto3" json:"consecutive_failures,omitempty"`
+	Requests            int32                  `protobuf:"varint,4,opt,name=requests,proto3" json:"requests,omitempty"`
+	ErrorRate           float64                `protobuf:"fixed64,5,opt,name=error_rate,json=errorRate,proto3" json:"error_rate,omitempty"`
+	Opens               int32                  `protobuf:"varint,6,opt,name=opens,proto3" json:"opens,omitempty"`
+	OpenedAt            *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=opened_at,json=openedAt,proto3" json:"opened_at,omitempty"`
+	unknownFields       protoimpl.UnknownFields
+	sizeCache           protoimpl.SizeCache
+}
+
+func (x *Breaker) Reset() {
+	*x = Breaker{}
+	mi := &file_admin_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Breaker) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Breaker) ProtoMessage() {}
+
+func (x *Breaker) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Breaker.ProtoReflect.Descriptor instead.
+func (*Breaker) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *Breaker) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *Breaker) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *Breaker) GetConsecutiveFailures() int32 {
+	if x != nil {
+		return x.ConsecutiveFailures
+	}
+	return 0
+}
+
+func (x *Breaker) GetRequests() int32 {
+	if x != nil {
+		return x.Requests
+	}
+	return 0
+}
+
+func (x *Breaker) GetErrorRate() float64 {
+	if x != nil {
+		return x.ErrorRate
+	}
+	return 0
+}
+
+func (x *Breaker) GetOpens() int32 {
+	if x != nil {
+		return x.Opens
+	}
+	return 0
+}
+
+func (x *Breaker) GetOpenedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.OpenedAt
+	}
+	return nil
+}
+
+var File_admin_proto protoreflect.FileDescriptor
+
+const file_admin_proto_rawDesc = "" +
+	"\n" +
+	"\vadmin.proto\x12\x0epoc.routing.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"@\n" +
+	"\x0eGetRingRequest\x12\x16\n" +
+	"\x06sample\x18\x01 \x01(\x05R\x06sample\x12\x16\n" +
+	"\x06prefix\x18\x02 \x01(\tR\x06prefix\"\xe4\x02\n" +
+	"\x04Ring\x12\x1c\n" +
+	"\talgorithm\x18\x01 \x01(\tR\talgorithm\x12\x1d\n" +
+	"\n" +
+	"index_mode\x18\x02 \x01(\tR\tindexMode\x12\x1d\n" +
+	"\n" +
+	"hash_space\x18\x03 \x01(\x01R\thashSpace\x12!\n" +
+	"\fring_version\x18\x04 \x01(\tR\vringVersion\x12#\n" +
+	"\rtable_version\x18\x05 \x01(\x04R\ftableVersion\x12\x1e\n" +
+	"\n" +
+	"membership\x18\x06 \x01(\tR\n" +
+	"membership\x127\n" +
+	"\breplicas\x18\a \x03(\v2\x1b.poc.routing.v1.RingReplicaR\breplicas\x12\x16\n" +
+	"\x06sample\x18\b \x01(\x05R\x06sample\x12#\n" +
+	"\rsample_prefix\x18\t \x01(\tR\fsamplePrefix\x12\"\n" +
+	"\rmax_over_mean\x18\n" +
+	" \x01(\x01R\vmaxOverMean\"\x8a\x02\n" +
+	"\vRingReplica\x12\x14\n" +
+	"\x05index\x18\x01 \x01(\x05R\x05index\x12\x16\n" +
+	"\x06target\x18\x02 \x01(\tR\x06target\x12\x18\n" +
+	"\ahealthy\x18\x03 \x01(\bR\ahealthy\x12\x16\n" +
+	"\x06weight\x18\x04 \x01(\x05R\x06weight\x12\x16\n" +
+	"\x06hashes\x18\x05 \x01(\x04R\x06hashes\x12\x14\n" +
+	"\x05share\x18\x06 \x01(\x01R\x05share\x12\x18\n" +
+	"\asampled\x18\a \x01(\x05R\asampled\x12\x1b\n" +
+	"\tarc_start\x18\b \x01(\x04R\barcStart\x12\x17\n" +
+	"\aarc_end\x18\t \x01(\x04R\x06arcEnd\x12\x1d\n" +
+	"\n" +
+	"arc_stride\x18\n" +
+	" \x01(\x05R\tarcStride\"\x13\n" +
+	"\x11GetSlotMapRequest\"\xcc\x01\n" +
+	"\aSlotMap\x12#\n" +
+	"\rtable_version\x18\x01 \x01(\x04R\ftableVersion\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reason\x125\n" +
+	"\bbuilt_at\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\abuiltAt\x12!\n" +
+	"\fring_version\x18\x04 \x01(\tR\vringVersion\x12*\n" +
+	"\x05slots\x18\x05 \x03(\v2\x14.poc.routing.v1.SlotR\x05slots\"h\n" +
+	"\x04Slot\x12\x14\n" +
+	"\x05index\x18\x01 \x01(\x05R\x05index\x12\x16\n" +
+	"\x06target\x18\x02 \x01(\tR\x06target\x12\x18\n" +
+	"\ahealthy\x18\x03 \x01(\bR\ahealthy\x12\x18\n" +
+	"\astandby\x18\x04 \x01(\tR\astandby\"\x11\n" +
+	"\x0fListPinsRequest\"b\n" +
+	"\aPinList\x12'\n" +
+	"\x04pins\x18\x01 \x03(\v2\x13.poc.routing.v1.PinR\x04pins\x12.\n" +
+	"\ahistory\x18\x02 \x03(\v2\x14.poc.routing.v1.MoveR\ahistory\"<\n" +
+	"\x03Pin\x12\x1b\n" +
+	"\tclient_id\x18\x01 \x01(\tR\bclientId\x12\x18\n" +
+	"\areplica\x18\x02 \x01(\tR\areplica\"\x8b\x01\n" +
+	"\x04Move\x12\x1b\n" +
+	"\tclient_id\x18\x01 \x01(\tR\bclientId\x12\x12\n" +
+	"\x04from\x18\x02 \x01(\tR\x04from\x12\x0e\n" +
+	"\x02to\x18\x03 \x01(\tR\x02to\x12\x16\n" +
+	"\x06reason\x18\x04 \x01(\tR\x06reason\x12*\n" +
+	"\x02ts\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\x02ts\"\x12\n" +
+	"\x10GetHealthRequest\"\xd2\x01\n" +
+	"\n" +
+	"HealthView\x129\n" +
+	"\breplicas\x18\x01 \x03(\v2\x1d.poc.routing.v1.ReplicaHealthR\breplicas\x12)\n" +
+	"\x10replicas_healthy\x18\x02 \x01(\x05R\x0freplicasHealthy\x12)\n" +
+	"\x10breakers_enabled\x18\x03 \x01(\bR\x0fbreakersEnabled\x123\n" +
+	"\bbreakers\x18\x04 \x03(\v2\x17.poc.routing.v1.BreakerR\bbreakers\"\xae\x02\n" +
+	"\rReplicaHealth\x12\x16\n" +
+	"\x06target\x18\x01 \x01(\tR\x06target\x12\x18\n" +
+	"\ahealthy\x18\x02 \x01(\bR\ahealthy\x12\x18\n" +
+	"\aversion\x18\x03 \x01(\tR\aversion\x12\x12\n" +
+	"\x04zone\x18\x04 \x01(\tR\x04zone\x12\x16\n" +
+	"\x06weight\x18\x05 \x01(\x05R\x06weight\x12'\n" +
+	"\x0factive_sessions\x18\x06 \x01(\x05R\x0eactiveSessions\x12-\n" +
+	"\x12config_fingerprint\x18\a \x01(\tR\x11configFingerprint\x127\n" +
+	"\tlast_seen\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\blastSeen\x12\x14\n" +
+	"\x05error\x18\t \x01(\tR\x05error\"\xf4\x01\n" +
+	"\aBreaker\x12\x16\n" +
+	"\x06target\x18\x01 \x01(\tR\x06target\x12\x14\n" +
+	"\x05state\x18\x02 \x01(\tR\x05state\x121\n" +
+	"\x14consecutive_failures\x18\x03 \x01(\x05R\x13consecutiveFailures\x12\x1a\n" +
+	"\brequests\x18\x04 \x01(\x05R\brequests\x12\x1d\n" +
+	"\n" +
+	"error_rate\x18\x05 \x01(\x01R\terrorRate\x12\x14\n" +
+	"\x05opens\x18\x06 \x01(\x05R\x05opens\x127\n" +
+	"\topened_at\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\bopenedAt2\xaa\x02\n" +
+	"\fRoutingAdmin\x12?\n" +
+	"\aGetRing\x12\x1e.poc.routing.v1.GetRingRequest\x1a\x14.poc.routing.v1.Ring\x12H\n" +
+	"\n" +
+	"GetSlotMap\x12!.poc.routing.v1.GetSlotMapRequest\x1a\x17.poc.routing.v1.SlotMap\x12D\n" +
+	"\bListPins\x12\x1f.poc.routing.v1.ListPinsRequest\x1a\x17.poc.routing.v1.PinList\x12I\n" +
+	"\tGetHealth\x12 .poc.routing.v1.GetHealthRequest\x1a\x1a.poc.routing.v1.HealthViewB'Z%personal/poc-routing/server/routingpbb\x06proto3"
+
+var (
+	file_admin_proto_rawDescOnce sync.Once
+	file_admin_proto_rawDescData []byte
+)
+
+func file_admin_proto_rawDescGZIP() []byte {
+	file_admin_proto_rawDescOnce.Do(func() {
+		file_admin_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_admin_proto_rawDesc), len(file_admin_proto_rawDesc)))
+	})
+	return file_admin_proto_rawDescData
+}
+
+var file_admin_proto_msgTypes = make([]protoimpl.MessageInfo, 14)
+var file_admin_proto_goTypes = []any{
+	(*GetRingRequest)(nil),        // 0: poc.routing.v1.GetRingRequest
+	(*Ring)(nil),                  // 1: poc.routing.v1.Ring
+	(*RingReplica)(nil),           // 2: poc.routing.v1.RingReplica
+	(*GetSlotMapRequest)(nil),     // 3: poc.routing.v1.GetSlotMapRequest
+	(*SlotMap)(nil),               // 4: poc.routing.v1.SlotMap
+	(*Slot)(nil),                  // 5: poc.routing.v1.Slot
+	(*ListPinsRequest)(nil),       // 6: poc.routing.v1.ListPinsRequest
+	(*PinList)(nil),               // 7: poc.routing.v1.PinList
+	(*Pin)(nil),                   // 8: poc.routing.v1.Pin
+	(*Move)(nil),                  // 9: poc.routing.v1.Move
+	(*GetHealthRequest)(nil),      // 10: poc.routing.v1.GetHealthRequest
+	(*HealthView)(nil),            // 11: poc.routing.v1.HealthView
+	(*ReplicaHealth)(nil),         // 12: poc.routing.v1.ReplicaHealth
+	(*Breaker)(nil),               // 13: poc.routing.v1.Breaker
+	(*timestamppb.Timestamp)(nil), // 14: google.protobuf.Timestamp
+}
+var file_admin_proto_depIdxs = []int32{
+	2,  // 0: poc.routing.v1.Ring.replicas:type_name -> poc.routing.v1.RingReplica
+	14, // 1: poc.routing.v1.SlotMap.built_at:type_name -> google.protobuf.Timestamp
+	5,  // 2: poc.routing.v1.SlotMap.slots:type_name -> poc.routing.v1.Slot
+	8,  // 3: poc.routing.v1.PinList.pins:type_name -> poc.routing.v1.Pin
+	9,  // 4: poc.routing.v1.PinList.history:type_name -> poc.routing.v1.Move
+	14, // 5: poc.routing.v1.Move.ts:type_name -> google.protobuf.Timestamp
+	12, // 6: poc.routing.v1.HealthView.replicas:type_name -> poc.routing.v1.ReplicaHealth
+	13, // 7: poc.routing.v1.HealthView.breakers:type_name -> poc.routing.v1.Breaker
+	14, // 8: poc.routing.v1.ReplicaHealth.last_seen:type_name -> google.protobuf.Timestamp
+	14, // 9: poc.routing.v1.Breaker.opened_at:type_name -> google.protobuf.Timestamp
+	0,  // 10: poc.routing.v1.RoutingAdmin.GetRing:input_type -> poc.routing.v1.GetRingRequest
+	3,  // 11: poc.routing.v1.RoutingAdmin.GetSlotMap:input_type -> poc.routing.v1.GetSlotMapRequest
+	6,  // 12: poc.routing.v1.RoutingAdmin.ListPins:input_type -> poc.routing.v1.ListPinsRequest
+	10, // 13: poc.routing.v1.RoutingAdmin.GetHealth:input_type -> poc.routing.v1.GetHealthRequest
+	1,  // 14: poc.routing.v1.RoutingAdmin.GetRing:output_type -> poc.routing.v1.Ring
+	4,  // 15: poc.routing.v1.RoutingAdmin.GetSlotMap:output_type -> poc.routing.v1.SlotMap
+	7,  // 16: poc.routing.v1.RoutingAdmin.ListPins:output_type -> poc.routing.v1.PinList
+	11, // 17: poc.routing.v1.RoutingAdmin.GetHealth:output_type -> poc.routing.v1.HealthView
+	14, // [14:18] is the sub-list for method output_type
+	10, // [10:14] is the sub-list for method input_type
+	10, // [10:10] is the sub-list for extension type_name
+	10, // [10:10] is the sub-list for extension extendee
+	0,  // [0:10] is the sub-list for field type_name
+}
+
+func init() { file_admin_proto_init() }
+func file_admin_proto_init() {
+	if File_admin_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_admin_proto_rawDesc), len(file_admin_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   14,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_admin_proto_goTypes,
+		DependencyIndexes: file_admin_proto_depIdxs,
+		MessageInfos:      file_admin_proto_msgTypes,
+	}.Build()
+	File_admin_proto = out.File
+	file_admin_proto_goTypes = nil
+	file_admin_proto_depIdxs = nil
+}
@@ -0,0 +1,251 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: admin.proto
+
+package routingpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	RoutingAdmin_GetRing_FullMethodName    = "/poc.routing.v1.RoutingAdmin/GetRing"
+	RoutingAdmin_GetSlotMap_FullMethodName = "/poc.routing.v1.RoutingAdmin/GetSlotMap"
+	RoutingAdmin_ListPins_FullMethodName   = "/poc.routing.v1.RoutingAdmin/ListPins"
+	RoutingAdmin_GetHealth_FullMethodName  = "/poc.routing.v1.RoutingAdmin/GetHealth"
+)
+
+// RoutingAdminClient is the client API for RoutingAdmin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// RoutingAdmin serves a replica's (or gateway's) routing state as typed messages for tooling, on
+// the public port (see admingrpc.go). Each RPC answers from the same state as its JSON endpoint.
+type RoutingAdminClient interface {
+	// GetRing is GET /ring: how the hash space is split between the targets.
+	GetRing(ctx context.Context, in *GetRingRequest, opts ...grpc.CallOption) (*Ring, error)
+	// GetSlotMap is the routing table: which target holds each slot, and its standby.
+	GetSlotMap(ctx context.Context, in *GetSlotMapRequest, opts ...grpc.CallOption) (*SlotMap, error)
+	// ListPins is GET /admin/move: the current pins and the last moves. Needs a read or admin
+	// token when ADMIN_TOKENS is set.
+	ListPins(ctx context.Context, in *ListPinsRequest, opts ...grpc.CallOption) (*PinList, error)
+	// GetHealth is the replica view of GET /cluster/status plus GET /breakers.
+	GetHealth(ctx context.Context, in *GetHealthRequest, opts ...grpc.CallOption) (*HealthView, error)
+}
+
+type routingAdminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRoutingAdminClient(cc grpc.ClientConnInterface) RoutingAdminClient {
+	return &routingAdminClient{cc}
+}
+
+func (c *routingAdminClient) GetRing(ctx context.Context, in *GetRingRequest, opts ...grpc.CallOption) (*Ring, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Ring)
+	err := c.cc.Invoke(ctx, RoutingAdmin_GetRing_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routingAdminClient) GetSlotMap(ctx context.Context, in *GetSlotMapRequest, opts ...grpc.CallOption) (*SlotMap, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SlotMap)
+	err := c.cc.Invoke(ctx, RoutingAdmin_GetSlotMap_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routingAdminClient) ListPins(ctx context.Context, in *ListPinsRequest, opts ...grpc.CallOption) (*PinList, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PinList)
+	err := c.cc.Invoke(ctx, RoutingAdmin_ListPins_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routingAdminClient) GetHealth(ctx context.Context, in *GetHealthRequest, opts ...grpc.CallOption) (*HealthView, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(HealthView)
+	err := c.cc.Invoke(ctx, RoutingAdmin_GetHealth_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RoutingAdminServer is the server API for RoutingAdmin service.
+// All implementations must embed UnimplementedRoutingAdminServer
+// for forward compatibility.
+//
+// RoutingAdmin serves a replica's (or gateway's) routing state as typed messages for tooling, on
+// the public port (see admingrpc.go). Each RPC answers from the same state as its JSON endpoint.
+type RoutingAdminServer interface {
+	// GetRing is GET /ring: how the hash space is split between the targets.
+	GetRing(context.Context, *GetRingRequest) (*Ring, error)
+	// GetSlotMap is the routing table: which target holds each slot, and its standby.
+	GetSlotMap(context.Context, *GetSlotMapRequest) (*SlotMap, error)
+	// ListPins is GET /admin/move: the current pins and the last moves. Needs a read or admin
+	// token when ADMIN_TOKENS is set.
+	ListPins(context.Context, *ListPinsRequest) (*PinList, error)
+	// GetHealth is the replica view of GET /cluster/status plus GET /breakers.
+	GetHealth(context.Context, *GetHealthRequest) (*HealthView, error)
+	mustEmbedUnimplementedRoutingAdminServer()
+}
+
+// UnimplementedRoutingAdminServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedRoutingAdminServer struct{}
+
+func (UnimplementedRoutingAdminServer) GetRing(context.Context, *GetRingRequest) (*Ring, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetRing not implemented")
+}
+func (UnimplementedRoutingAdminServer) GetSlotMap(context.Context, *GetSlotMapRequest) (*SlotMap, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetSlotMap not implemented")
+}
+func (UnimplementedRoutingAdminServer) ListPins(context.Context, *ListPinsRequest) (*PinList, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListPins not implemented")
+}
+func (UnimplementedRoutingAdminServer) GetHealth(context.Context, *GetHealthRequest) (*HealthView, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetHealth not implemented")
+}
+func (UnimplementedRoutingAdminServer) mustEmbedUnimplementedRoutingAdminServer() {}
+func (UnimplementedRoutingAdminServer) testEmbeddedByValue()                      {}
+
+// UnsafeRoutingAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RoutingAdminServer will
+// result in compilation errors.
+type UnsafeRoutingAdminServer interface {
+	mustEmbedUnimplementedRoutingAdminServer()
+}
+
+func RegisterRoutingAdminServer(s grpc.ServiceRegistrar, srv RoutingAdminServer) {
+	// If the following call panics, it indicates UnimplementedRoutingAdminServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&RoutingAdmin_ServiceDesc, srv)
+}
+
+func _RoutingAdmin_GetRing_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RoutingAdminServer).GetRing(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RoutingAdmin_GetRing_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RoutingAdminServer).GetRing(ctx, req.(*GetRingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RoutingAdmin_GetSlotMap_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSlotMapRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RoutingAdminServer).GetSlotMap(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RoutingAdmin_GetSlotMap_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RoutingAdminServer).GetSlotMap(ctx, req.(*GetSlotMapRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RoutingAdmin_ListPins_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPinsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RoutingAdminServer).ListPins(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RoutingAdmin_ListPins_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RoutingAdminServer).ListPins(ctx, req.(*ListPinsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RoutingAdmin_GetHealth_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetHealthRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RoutingAdminServer).GetHealth(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RoutingAdmin_GetHealth_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RoutingAdminServer).GetHealth(ctx, req.(*GetHealthRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// RoutingAdmin_ServiceDesc is the grpc.ServiceDesc for RoutingAdmin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var RoutingAdmin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "poc.routing.v1.RoutingAdmin",
+	HandlerType: (*RoutingAdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetRing",
+			Handler:    _RoutingAdmin_GetRing_Handler,
+		},
+		{
+			MethodName: "GetSlotMap",
+			Handler:    _RoutingAdmin_GetSlotMap_Handler,
+		},
+		{
+			MethodName: "ListPins",
+			Handler:    _RoutingAdmin_ListPins_Handler,
+		},
+		{
+			MethodName: "GetHealth",
+			Handler:    _RoutingAdmin_GetHealth_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "admin.proto",
+}
@@ -0,0 +1,305 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Session is the per-client state a replica holds after a successful /join.
+type Session struct {
+	ClientID string            `json:"client_id"`
+	Owner    string            `json:"owner"`
+	JoinedAt time.Time         `json:"joined_at"`
+	LastSeen time.Time         `json:"last_seen"`
+	Joins    int               `json:"joins"`
+	Meta     map[string]string `json:"meta,omitempty"`
+}
+
+// clone returns sess with its own Meta, so callers can read it outside the store's lock while
+// later joins update the original.
+func (sess *Session) clone() Session {
+	c := *sess
+	c.Meta = maps.Clone(sess.Meta)
+	return c
+}
+
+// seenBucket is the granularity of the last-seen index.
+const seenBucket = 10 * time.Second
+
+// sessionStore is an in-memory map of client_id -> Session for this replica. Sessions are also
+// indexed by last-seen time in seenBucket steps; with a fixed SESSION_TTL that orders them by expiry.
+// At most max sessions are held (MAX_SESSIONS, see memlimits.go).
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+	bySeen   map[int64]map[string]struct{} // bucket start (unix seconds) -> client IDs
+	max      int
+}
+
+var sessions = newSessionStore()
+
+func newSessionStore() *sessionStore {
+	return &sessionStore{
+		sessions: make(map[string]*Session),
+		bySeen:   make(map[int64]map[string]struct{}),
+		max:      memoryLimitFromEnv("MAX_SESSIONS", 100000),
+	}
+}
+
+func seenKey(t time.Time) int64 {
+	return t.Truncate(seenBucket).Unix()
+}
+
+// index and unindex maintain bySeen; callers hold s.mu.
+func (s *sessionStore) index(sess *Session) {
+	k := seenKey(sess.LastSeen)
+	ids, ok := s.bySeen[k]
+	if !ok {
+		ids = make(map[string]struct{})
+		s.bySeen[k] = ids
+	}
+	ids[sess.ClientID] = struct{}{}
+}
+
+func (s *sessionStore) unindex(sess *Session) {
+	k := seenKey(sess.LastSeen)
+	delete(s.bySeen[k], sess.ClientID)
+	if len(s.bySeen[k]) == 0 {
+		delete(s.bySeen, k)
+	}
+}
+
+// touch records a join for clientID, creating the session if needed, and returns a copy
+// and whether the session was created.
+func (s *sessionStore) touch(clientID, owner string, meta map[string]string) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := clock.Now()
+	sess, ok := s.sessions[clientID]
+	if !ok {
+		s.makeRoomLocked()
+		sess = &Session{ClientID: clientID, JoinedAt: now}
+		s.sessions[clientID] = sess
+	} else {
+		s.unindex(sess)
+	}
+	sess.Owner = owner
+	sess.LastSeen = now
+	s.index(sess)
+	sess.Joins++
+	for k, v := range meta {
+		if sess.Meta == nil {
+			sess.Meta = make(map[string]string)
+		}
+		sess.Meta[k] = v
+	}
+	return sess.clone(), !ok
+}
+
+// get returns a copy of the session for clientID.
+func (s *sessionStore) get(clientID string) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[clientID]
+	if !ok {
+		return Session{}, false
+	}
+	return sess.clone(), true
+}
+
+// take removes and returns the session for clientID.
+func (s *sessionStore) take(clientID string) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[clientID]
+	if !ok {
+		return Session{}, false
+	}
+	delete(s.sessions, clientID)
+	s.unindex(sess)
+	return sess.clone(), true
+}
+
+// put stores sess unless a session for the same client that was seen more recently already exists.
+func (s *sessionStore) put(sess Session) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.sessions[sess.ClientID]
+	if ok && cur.LastSeen.After(sess.LastSeen) {
+		return false
+	}
+	if ok {
+		s.unindex(cur)
+	} else {
+		s.makeRoomLocked()
+	}
+	s.sessions[sess.ClientID] = &sess
+	s.index(&sess)
+	return true
+}
+
+// takeAll removes and returns every session.
+func (s *sessionStore) takeAll() []Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Session, 0, len(s.sessions))
+	for id, sess := range s.sessions {
+		out = append(out, *sess)
+		delete(s.sessions, id)
+	}
+	s.bySeen = make(map[int64]map[string]struct{})
+	return out
+}
+
+// list returns a copy of every session.
+func (s *sessionStore) list() []Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		out = append(out, sess.clone())
+	}
+	return out
+}
+
+// count returns the number of sessions held.
+func (s *sessionStore) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+// limit returns the most sessions held.
+func (s *sessionStore) limit() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.max
+}
+
+// resize sets the most sessions held, evicting the least recently seen beyond it.
+func (s *sessionStore) resize(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.max = n
+	s.evictLocked(len(s.sessions) - n)
+}
+
+// makeRoomLocked evicts a batch of the least recently seen sessions when the store is full, ahead of
+// adding one; callers hold s.mu.
+func (s *sessionStore) makeRoomLocked() {
+	if len(s.sessions) >= s.max {
+		s.evictLocked(len(s.sessions) - s.max + evictionBatch(s.max))
+	}
+}
+
+// evictLocked removes the n least recently seen sessions, oldest index bucket first; callers hold s.mu.
+func (s *sessionStore) evictLocked(n int) {
+	if n <= 0 {
+		return
+	}
+	evicted := 0
+	for _, k := range slices.Sorted(maps.Keys(s.bySeen)) {
+		if evicted == n {
+			break
+		}
+		for id := range s.bySeen[k] {
+			if evicted == n {
+				break
+			}
+			sess := s.sessions[id]
+			delete(s.sessions, id)
+			s.unindex(sess)
+			evicted++
+		}
+	}
+	log.Printf("memory: evicted %d least recently seen sessions (MAX_SESSIONS=%d)", evicted, s.max)
+	noteEvictions("sessions", evicted)
+}
+
+// expire removes and returns sessions not seen since before cutoff. Only index buckets that
+// start before cutoff are visited.
+func (s *sessionStore) expire(cutoff time.Time) []Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Session
+	for k, ids := range s.bySeen {
+		if k >= cutoff.Unix() {
+			continue
+		}
+		for id := range ids {
+			sess := s.sessions[id]
+			if sess.LastSeen.Before(cutoff) {
+				out = append(out, *sess)
+				delete(s.sessions, id)
+				s.unindex(sess)
+			}
+		}
+	}
+	return out
+}
+
+// expiryCounts returns how many sessions expire in each step-wide bucket from now until horizon,
+// given ttl, plus the number due later. Already-due sessions fall in the first bucket.
+func (s *sessionStore) expiryCounts(ttl, step, horizon time.Duration, now time.Time) ([]int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make([]int, int((horizon+step-1)/step))
+	later := 0
+	for k, ids := range s.bySeen {
+		at := time.Unix(k, 0).Add(ttl)
+		i := int(at.Sub(now) / step)
+		switch {
+		case i < 0:
+			i = 0
+		case i >= len(counts):
+			later += len(ids)
+			continue
+		}
+		counts[i] += len(ids)
+	}
+	return counts, later
+}
+
+// sessionTTL returns SESSION_TTL, or 0 when sessions never expire.
+func sessionTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("SESSION_TTL"))
+	if err != nil || ttl <= 0 {
+		return 0
+	}
+	return ttl
+}
+
+// runSessionExpiry drops sessions idle for longer than SESSION_TTL (e.g. 30m). Disabled when unset.
+func runSessionExpiry() {
+	ttl := sessionTTL()
+	if ttl == 0 {
+		return
+	}
+	interval := ttl / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	for range clock.Tick(interval) {
+		for _, sess := range sessions.expire(clock.Now().Add(-ttl)) {
+			log.Printf("session client_id=%s expired on %s", sess.ClientID, sess.Owner)
+			events.emit(eventExpired, sess.ClientID, sess.Owner, "", "")
+		}
+	}
+}
+
+// handleSessionLookup answers whether this replica holds a session for client_id (internal API).
+func handleSessionLookup(w http.ResponseWriter, r *http.Request) {
+	sess, ok := sessions.get(r.URL.Query().Get("client_id"))
+	if !ok {
+		http.Error(w, "no session", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(sess)
+}
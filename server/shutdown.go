@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	shutdownMu    sync.Mutex
+	shutdownHooks []func()
+)
+
+// onShutdown registers fn to run when the process receives SIGTERM or SIGINT, before the public
+// listener stops accepting requests.
+func onShutdown(fn func()) {
+	shutdownMu.Lock()
+	shutdownHooks = append(shutdownHooks, fn)
+	shutdownMu.Unlock()
+}
+
+// shutdownOnSignal waits for SIGTERM/SIGINT, runs the shutdown hooks in order and then shuts srv
+// down, giving in-flight requests up to 5s.
+func shutdownOnSignal(srv *http.Server) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	log.Printf("received %v, shutting down", <-sig)
+	shutdownMu.Lock()
+	hooks := shutdownHooks
+	shutdownMu.Unlock()
+	for _, fn := range hooks {
+		fn()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+}
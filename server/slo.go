@@ -0,0 +1,226 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Latency SLO tracking for /where, /join and replica-to-replica hops (per target).
+// Every observation feeds the routing_latency_seconds histogram and a sliding window of the
+// last sloWindow samples used for the p50/p95/p99 shown on /slo.
+// SLO_BUDGETS declares budgets as kind:quantile=limit pairs, e.g.
+// "where:p99=50ms,join:p99=100ms,hop:p95=200ms"; they are checked every SLO_CHECK_INTERVAL
+// (default 30s) and violations are logged.
+
+const sloWindow = 2048
+
+type sloKey struct {
+	Kind   string
+	Target string
+}
+
+type latencyWindow struct {
+	samples []time.Duration
+	next    int
+	total   uint64
+}
+
+func (w *latencyWindow) add(d time.Duration) {
+	if len(w.samples) < sloWindow {
+		w.samples = append(w.samples, d)
+	} else {
+		w.samples[w.next] = d
+		w.next = (w.next + 1) % sloWindow
+	}
+	w.total++
+}
+
+// quantiles returns the requested quantiles of the current window.
+func (w *latencyWindow) quantiles(qs ...float64) []time.Duration {
+	sorted := append([]time.Duration(nil), w.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	out := make([]time.Duration, len(qs))
+	if len(sorted) == 0 {
+		return out
+	}
+	for i, q := range qs {
+		idx := int(q*float64(len(sorted))+0.5) - 1
+		if idx < 0 {
+			idx = 0
+		}
+		if idx >= len(sorted) {
+			idx = len(sorted) - 1
+		}
+		out[i] = sorted[idx]
+	}
+	return out
+}
+
+type sloBudget struct {
+	Quantile string        `json:"quantile"`
+	Limit    time.Duration `json:"-"`
+	LimitMs  float64       `json:"limit_ms"`
+}
+
+type sloTracker struct {
+	mu      sync.Mutex
+	windows map[sloKey]*latencyWindow
+	budgets map[string]sloBudget // by kind
+}
+
+var slo = newSLOTrackerFromEnv()
+
+func newSLOTrackerFromEnv() *sloTracker {
+	metrics.histogram("routing_latency_seconds", "Latency of /where, /join and replica-to-replica hops.")
+	t := &sloTracker{windows: make(map[sloKey]*latencyWindow), budgets: make(map[string]sloBudget)}
+	for _, entry := range strings.Split(os.Getenv("SLO_BUDGETS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kind, rest, ok1 := strings.Cut(entry, ":")
+		q, limit, ok2 := strings.Cut(rest, "=")
+		d, err := time.ParseDuration(limit)
+		if !ok1 || !ok2 || err != nil || quantileValue(q) == 0 {
+			log.Printf("ignoring invalid SLO budget %q", entry)
+			continue
+		}
+		t.budgets[kind] = sloBudget{Quantile: q, Limit: d, LimitMs: ms(d)}
+	}
+	return t
+}
+
+func quantileValue(q string) float64 {
+	switch q {
+	case "p50":
+		return 0.50
+	case "p95":
+		return 0.95
+	case "p99":
+		return 0.99
+	}
+	return 0
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// observe records one latency sample.
+func (t *sloTracker) observe(kind, target string, d time.Duration) {
+	if target == "" {
+		metrics.observe("routing_latency_seconds", d.Seconds(), "kind", kind)
+	} else {
+		metrics.observe("routing_latency_seconds", d.Seconds(), "kind", kind, "target", target)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	k := sloKey{kind, target}
+	w, ok := t.windows[k]
+	if !ok {
+		w = &latencyWindow{}
+		t.windows[k] = w
+	}
+	w.add(d)
+}
+
+// quantile returns quantile q of the window for kind and target, 0 when it has no samples.
+func (t *sloTracker) quantile(kind, target string, q float64) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	w, ok := t.windows[sloKey{kind, target}]
+	if !ok {
+		return 0
+	}
+	return w.quantiles(q)[0]
+}
+
+type sloEntry struct {
+	Kind     string     `json:"kind"`
+	Target   string     `json:"target,omitempty"`
+	Count    uint64     `json:"count"`
+	Window   int        `json:"window"`
+	P50Ms    float64    `json:"p50_ms"`
+	P95Ms    float64    `json:"p95_ms"`
+	P99Ms    float64    `json:"p99_ms"`
+	Budget   *sloBudget `json:"budget,omitempty"`
+	Violated bool       `json:"violated"`
+}
+
+func (t *sloTracker) summary() []sloEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]sloEntry, 0, len(t.windows))
+	for k, w := range t.windows {
+		q := w.quantiles(0.50, 0.95, 0.99)
+		e := sloEntry{Kind: k.Kind, Target: k.Target, Count: w.total, Window: len(w.samples), P50Ms: ms(q[0]), P95Ms: ms(q[1]), P99Ms: ms(q[2])}
+		if b, ok := t.budgets[k.Kind]; ok {
+			e.Budget = &b
+			e.Violated = w.quantiles(quantileValue(b.Quantile))[0] > b.Limit
+		}
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Kind != out[j].Kind {
+			return out[i].Kind < out[j].Kind
+		}
+		return out[i].Target < out[j].Target
+	})
+	return out
+}
+
+// runSLOChecker periodically logs budget violations.
+func runSLOChecker() {
+	if len(slo.budgets) == 0 {
+		return
+	}
+	interval := 30 * time.Second
+	if d, err := time.ParseDuration(os.Getenv("SLO_CHECK_INTERVAL")); err == nil && d > 0 {
+		interval = d
+	}
+	for range time.Tick(interval) {
+		for _, e := range slo.summary() {
+			if e.Violated {
+				log.Printf("warning: slo budget exceeded kind=%s target=%s %s>%gms (p50=%gms p95=%gms p99=%gms n=%d)",
+					e.Kind, e.Target, e.Budget.Quantile, e.Budget.LimitMs, e.P50Ms, e.P95Ms, e.P99Ms, e.Window)
+			}
+		}
+	}
+}
+
+// timed records the handler's latency under kind.
+func timed(kind string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		h(w, r)
+		slo.observe(kind, "", time.Since(start))
+	}
+}
+
+// timedTransport records replica-to-replica hop latency per target host.
+type timedTransport struct {
+	base http.RoundTripper
+}
+
+func (t timedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	slo.observe("hop", req.URL.Host, time.Since(start))
+	weights.observe(req.URL.Host, err != nil || resp.StatusCode >= 500)
+	return resp, err
+}
+
+func handleSLO(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"window":  fmt.Sprintf("last %d samples", sloWindow),
+		"entries": slo.summary(),
+	})
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// Slow-operation logging for the registry and discovery, to line tail-latency spikes seen through
+// Envoy up with their cause. Redis registry reads and writes and discovery refreshes (replica probes,
+// membership register/list) are timed into routing_backend_op_seconds{op}. Calls over
+// SLOW_REGISTRY_THRESHOLD (default 20ms) or SLOW_DISCOVERY_THRESHOLD (default 200ms) are logged with
+// the affected client_id or target and counted in routing_slow_operations_total{op}. A threshold of 0
+// turns that logging off.
+
+type slowOpLog struct {
+	registry  time.Duration
+	discovery time.Duration
+}
+
+var slowOps = newSlowOpLog()
+
+func newSlowOpLog() *slowOpLog {
+	metrics.histogram("routing_backend_op_seconds", "Latency of registry and discovery operations, by op.")
+	metrics.counter("routing_slow_operations_total", "Registry and discovery operations over their slow threshold, by op.")
+	return &slowOpLog{
+		registry:  slowThreshold("SLOW_REGISTRY_THRESHOLD", 20*time.Millisecond),
+		discovery: slowThreshold("SLOW_DISCOVERY_THRESHOLD", 200*time.Millisecond),
+	}
+}
+
+func slowThreshold(name string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(name)); err == nil && d >= 0 {
+		return d
+	}
+	return def
+}
+
+// registryOp records a registry call started at start; use as defer slowOps.registryOp(op, id, time.Now()).
+func (s *slowOpLog) registryOp(op, clientID string, start time.Time) {
+	s.record("registry_"+op, "client_id", clientID, s.registry, time.Since(start))
+}
+
+// discoveryOp records a discovery call started at start against target.
+func (s *slowOpLog) discoveryOp(op, target string, start time.Time) {
+	s.record(op, "target", target, s.discovery, time.Since(start))
+}
+
+func (s *slowOpLog) record(op, field, value string, threshold, d time.Duration) {
+	metrics.observe("routing_backend_op_seconds", d.Seconds(), "op", op)
+	if threshold <= 0 || d < threshold {
+		return
+	}
+	metrics.inc("routing_slow_operations_total", "op", op)
+	log.Printf("slow %s %s=%s took %s (threshold %s)", op, field, value, d.Round(time.Microsecond), threshold)
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// Warm standby pairs. STANDBY_PAIRS pairs replicas, e.g. "server-0=server-5,server-1=server-6";
+// each side is the standby of the other. A side names a target exactly, by host, or by the first
+// DNS label of its host (the StatefulSet pod name). /where answers with the standby next to the
+// owner, so clients can fall back to it without resolving again, and when the owner is unhealthy
+// the resolution goes to its standby if that is healthy, before any FAILOVER_POLICY rehash.
+// This models an active/passive controller pair; both sides still take their own hash share.
+
+type standbyPair struct{ a, b string }
+
+var standbyPairs = parseStandbyPairs(os.Getenv("STANDBY_PAIRS"))
+
+func init() {
+	metrics.counter("routing_standby_failover_total", "Resolutions sent to the owner's designated standby, by standby.")
+}
+
+func parseStandbyPairs(spec string) []standbyPair {
+	var out []standbyPair
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		a, b, ok := strings.Cut(part, "=")
+		a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+		if !ok || a == "" || b == "" || a == b {
+			log.Printf("ignoring invalid STANDBY_PAIRS entry %q (want primary=standby)", part)
+			continue
+		}
+		out = append(out, standbyPair{a: a, b: b})
+	}
+	return out
+}
+
+// standbyFor returns the routing target paired with target, if any.
+func standbyFor(target string) (string, bool) {
+	for _, p := range standbyPairs {
+		other := ""
+		switch {
+		case targetNamed(p.a, target):
+			other = p.b
+		case targetNamed(p.b, target):
+			other = p.a
+		default:
+			continue
+		}
+		for _, t := range allTargets() {
+			if t != target && targetNamed(other, t) {
+				return t, true
+			}
+		}
+	}
+	return "", false
+}
+
+// standbyFailover returns owner's standby when it is healthy.
+func standbyFailover(clientID, owner string) (string, bool) {
+	to, ok := standbyFor(owner)
+	if !ok || !ownerHealthy(to) {
+		return "", false
+	}
+	metrics.inc("routing_standby_failover_total", "standby", to)
+	log.Printf("client_id=%s owner %s unhealthy, failing over to standby %s", clientID, owner, to)
+	return to, true
+}
+
+// standbyStatus lists the configured pairs as resolved against the current targets.
+func standbyStatus() []map[string]string {
+	var out []map[string]string
+	for _, t := range allTargets() {
+		if to, ok := standbyFor(t); ok {
+			out = append(out, map[string]string{"target": t, "standby": to})
+		}
+	}
+	return out
+}
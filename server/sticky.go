@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Sticky-on-success routing. By default (ROUTING_STICKINESS=strict-hash) every resolution follows
+// the ring, so a ring change moves a client on its next /where even while the replica it is
+// connected to is healthy. ROUTING_STICKINESS=sticky-on-success keeps the client where its last
+// successful /join landed: that join's registry assignment wins over the hash owner as long as
+//   - it was written within STICKY_TTL (default 30m; each successful join renews it)
+//   - its replica is still in the ring, healthy and not in a MAINTENANCE_WINDOWS window
+//   - the request names no affinity group (see affinity.go), which limits the client's replicas
+//
+// Operator pins (/admin/move) still win over stickiness. Once the sticky replica fails, the client
+// is resolved by hash and its next join there becomes the new sticky replica. The assignment is
+// read from the registry on every resolution, so stickiness holds across replicas only with a
+// shared registry (REGISTRY_BACKEND=redis); with the in-memory one a replica only knows the joins
+// it served itself. Joins record the replica's target name (selfTarget), and older assignments
+// recorded as hostname:port are matched to their target (see ringTarget). Resolutions answered
+// this way are counted in routing_sticky_total{result}.
+
+type stickyPolicy struct {
+	enabled bool
+	ttl     time.Duration
+}
+
+var sticky = newStickyPolicyFromEnv()
+
+func newStickyPolicyFromEnv() stickyPolicy {
+	p := stickyPolicy{ttl: 30 * time.Minute}
+	switch m := strings.ToLower(strings.TrimSpace(os.Getenv("ROUTING_STICKINESS"))); m {
+	case "", "strict-hash":
+	case "sticky-on-success":
+		p.enabled = true
+	default:
+		log.Printf("unknown ROUTING_STICKINESS=%q, using strict-hash", m)
+	}
+	if d, err := time.ParseDuration(os.Getenv("STICKY_TTL")); err == nil && d > 0 {
+		p.ttl = d
+	}
+	if p.enabled {
+		if _, shared := registryAs[*redisRegistry](); !shared {
+			log.Printf("ROUTING_STICKINESS=sticky-on-success with a per-replica registry: replicas only stick to joins they served")
+		}
+		metrics.counter("routing_sticky_total", "Resolutions under sticky-on-success, by result (sticky, expired, unhealthy, none).")
+	}
+	return p
+}
+
+// mode names the policy as ROUTING_STICKINESS does.
+func (p stickyPolicy) mode() string {
+	if p.enabled {
+		return "sticky-on-success"
+	}
+	return "strict-hash"
+}
+
+// owner returns the replica of clientID's last successful join, when stickiness applies to it.
+func (p stickyPolicy) owner(ctx context.Context, clientID string) (string, bool) {
+	if !p.enabled || outage.degraded() {
+		return "", false
+	}
+	if _, grouped := affinityFor(requestHeaders(ctx)); grouped {
+		return "", false
+	}
+	a, ok, err := registry.Get(clientID)
+	if err != nil || !ok || a.Replica == "" {
+		metrics.inc("routing_sticky_total", "result", "none")
+		return "", false
+	}
+	target, inRing := ringTarget(a.Replica, allTargets())
+	switch _, _, busy := inMaintenance(target, clock.Now()); {
+	case clock.Now().Sub(a.UpdatedAt) > p.ttl:
+		metrics.inc("routing_sticky_total", "result", "expired")
+		explainf(ctx, "sticky", "", "last joined %s at %s, longer ago than STICKY_TTL=%s", a.Replica, a.UpdatedAt.Format(time.RFC3339), p.ttl)
+		return "", false
+	case !inRing || !ownerHealthy(target) || busy:
+		metrics.inc("routing_sticky_total", "result", "unhealthy")
+		explainf(ctx, "sticky", "", "last joined %s, ignored since it is unhealthy, out of the ring or in maintenance", a.Replica)
+		return "", false
+	}
+	metrics.inc("routing_sticky_total", "result", "sticky")
+	explainf(ctx, "sticky", target, "last joined %s at %s; ROUTING_STICKINESS=sticky-on-success keeps it there until STICKY_TTL=%s", a.Replica, a.UpdatedAt.Format(time.RFC3339), p.ttl)
+	return target, true
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Routing table snapshots. Everything a placement reads from the cluster view, i.e. the ordered
+// targets and the last probe of each replica (health, weight, version, zone), lives in one
+// immutable routingTable that is rebuilt and swapped atomically when membership or health changes.
+// Version increments only when something placement depends on changed. It is returned with
+// routing answers (X-Routing-Table-Version, "table_version") so client behaviour can be lined up
+// with table changes. resolveOwner starts over if the table was swapped mid-decision, so an
+// answer never mixes two views. Readers take no lock; publishTable serialises the writers.
+
+type routingTable struct {
+	Version  uint64
+	Reason   string // what triggered the last version change: config, membership, health, gossip or outlier
+	BuiltAt  time.Time
+	Targets  []string
+	ring     string                  // ringVersion of Targets
+	legacy   bool                    // SERVER_PEERS hashing (the peers TargetResolver, no members)
+	probes   map[string]*replicaInfo // last probe results
+	replicas map[string]*replicaInfo // probes with gossip overrides applied
+	polled   bool                    // probes holds at least one completed refresh
+}
+
+var routingState atomic.Pointer[routingTable]
+
+var tableBuilds = newTableBuilder()
+
+// tableBuilder serialises publishTable.
+type tableBuilder struct{ mu sync.Mutex }
+
+func newTableBuilder() *tableBuilder {
+	metrics.counter("routing_table_swaps_total", "Routing table versions published, by reason.")
+	metrics.counter("routing_table_reresolves_total", "Resolutions restarted because the routing table changed underneath them.")
+	metrics.gaugeFunc("routing_table_version", "Version of the routing table in use.", func() float64 {
+		if t := routingState.Load(); t != nil {
+			return float64(t.Version)
+		}
+		return 0
+	})
+	return &tableBuilder{}
+}
+
+// currentTable returns the routing table in use, building the first one from config.
+func currentTable() *routingTable {
+	if t := routingState.Load(); t != nil {
+		return t
+	}
+	return publishTable("config", nil)
+}
+
+// publishTable builds a table from the current membership and config plus probes (nil keeps the
+// previous probe results) and swaps it in.
+func publishTable(reason string, probes map[string]*replicaInfo) *routingTable {
+	tableBuilds.mu.Lock()
+	defer tableBuilds.mu.Unlock()
+	cur := routingState.Load()
+	next := &routingTable{
+		Reason:  reason,
+		BuiltAt: time.Now(),
+		Targets: configuredTargets(),
+		legacy:  len(members.targets()) == 0 && targetResolverFromEnv().Mode() == "peers",
+		probes:  probes,
+		polled:  probes != nil,
+	}
+	next.ring = ringHash(next.Targets)
+	if probes == nil && cur != nil {
+		next.probes, next.polled = cur.probes, cur.polled
+	}
+	if next.probes == nil {
+		next.probes = make(map[string]*replicaInfo)
+	}
+	next.replicas = next.probes
+	// Gossip overrides the last probe until the peer is heard from again, outlier ejection until
+	// the ejection time is up.
+	down := make(map[string]string)
+	for target := range outliers.ejected() {
+		down[target] = "outlier: ejected after consecutive 5xx"
+	}
+	for target := range gossip.deadPeers() {
+		down[target] = "gossip: no heartbeat"
+	}
+	if len(down) > 0 {
+		next.replicas = make(map[string]*replicaInfo, len(next.probes))
+		for target, info := range next.probes {
+			if reason, ok := down[target]; ok {
+				marked := *info
+				marked.Healthy, marked.Error = false, reason
+				info = &marked
+			}
+			next.replicas[target] = info
+		}
+	}
+	switch {
+	case cur == nil:
+		next.Version = 1
+	case cur.sameRouting(next):
+		// Only observations placement ignores (sessions, last seen) changed.
+		next.Version, next.Reason, next.BuiltAt = cur.Version, cur.Reason, cur.BuiltAt
+	default:
+		next.Version = cur.Version + 1
+		log.Printf("routing table v%d (%s): %d targets", next.Version, reason, len(next.Targets))
+	}
+	if cur == nil || next.Version != cur.Version {
+		metrics.inc("routing_table_swaps_total", "reason", reason)
+	}
+	routingState.Store(next)
+	return next
+}
+
+func (t *routingTable) sameRouting(o *routingTable) bool {
+	if t.legacy != o.legacy || t.polled != o.polled || !slices.Equal(t.Targets, o.Targets) {
+		return false
+	}
+	for _, target := range t.Targets {
+		a, aok := t.replicas[target]
+		b, bok := o.replicas[target]
+		if aok != bok {
+			return false
+		}
+		if aok && (a.Healthy != b.Healthy || a.Weight != b.Weight || a.Version != b.Version || a.Zone != b.Zone) {
+			return false
+		}
+	}
+	return true
+}
+
+// info returns the last probe of target.
+func (t *routingTable) info(target string) (replicaInfo, bool) {
+	info, ok := t.replicas[target]
+	if !ok {
+		return replicaInfo{}, false
+	}
+	return *info, true
+}
+
+// pick returns the hash target for clientID.
+func (t *routingTable) pick(clientID string) string {
+	if t.legacy {
+		return pickByHashLegacy(clientID)
+	}
+	return t.Targets[computeIndex(clientID, len(t.Targets))-indexBase()]
+}
+
+// resolveOwnerAt is resolveOwner that also returns the version of the table the answer came from.
+func resolveOwnerAt(ctx context.Context, clientID string) (string, uint64, error) {
+	if explaining(ctx) == nil && debugTraces.matches(clientID) {
+		return resolveDebug(ctx, clientID)
+	}
+	for attempt := 1; ; attempt++ {
+		version := currentTable().Version
+		owner, err := resolveOwnerOnce(ctx, clientID)
+		if currentTable().Version == version || attempt == 3 {
+			if err == nil {
+				noteUpstream(ctx, owner)
+			}
+			return owner, version, err
+		}
+		metrics.inc("routing_table_reresolves_total")
+	}
+}
+
+// setTableVersion reports the routing table version on a response.
+func setTableVersion(w http.ResponseWriter, version uint64) {
+	w.Header()["X-Routing-Table-Version"] = versionHeaderValue(version)
+}
+
+// tableStatus summarises the table in use for /cluster/status.
+func tableStatus() map[string]any {
+	t := currentTable()
+	return map[string]any{
+		"version":  t.Version,
+		"reason":   t.Reason,
+		"built_at": t.BuiltAt,
+		"targets":  len(t.Targets),
+	}
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestResolveDuringTableSwaps resolves clients from many goroutines while the ring grows and
+// shrinks and pins change underneath them. Every answer must be the placement of the table whose
+// version came back with it, never a mix of two tables. Run it with -race to check the request
+// path reads shared state only through snapshots.
+func TestResolveDuringTableSwaps(t *testing.T) {
+	setupBenchRing(t)
+	var tables sync.Map // version -> *routingTable
+	publish := func(n int) {
+		t.Setenv("REPLICAS", strconv.Itoa(n))
+		next := publishTable("membership", nil)
+		tables.Store(next.Version, next)
+	}
+	publish(5)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	var wg sync.WaitGroup
+	var resolved, swaps atomic.Int64
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ctx.Err() == nil; i++ {
+			publish(3 + i%3)
+			pins.set(moveRecord{ClientID: "pinned-" + strconv.Itoa(i%8), To: allTargets()[0], Time: time.Now()})
+			swaps.Add(1)
+			time.Sleep(200 * time.Microsecond)
+		}
+	}()
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ctx.Err() == nil; i++ {
+				id := benchIDs[(g*131+i)%len(benchIDs)]
+				owner, version, err := resolveOwnerAt(context.Background(), id)
+				if err != nil {
+					t.Errorf("%s: %v", id, err)
+					return
+				}
+				v, ok := tables.Load(version)
+				if !ok {
+					t.Errorf("%s resolved at unpublished version %d", id, version)
+					return
+				}
+				if want := v.(*routingTable).pick(id); owner != want {
+					t.Errorf("%s at v%d: got %s, want %s", id, version, owner, want)
+					return
+				}
+				resolved.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if resolved.Load() == 0 || swaps.Load() < 2 {
+		t.Fatalf("resolved %d clients over %d swaps", resolved.Load(), swaps.Load())
+	}
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Target resolution. The configured targets, which the ring is built over until live membership
+// replaces them, come from a TargetResolver, one per kind of deployment. TARGET_MODE picks it:
+//   - compose: <SERVICE_PREFIX>-<N>:PORT, the replicas of a Compose service (INDEX_BASE 1)
+//   - statefulset: <SERVICE_PREFIX>-<N><SERVICE_SUFFIX>:PORT, the pods of a StatefulSet behind
+//     a headless service (INDEX_BASE 0, SERVICE_SUFFIX like .server-headless.ns.svc.cluster.local)
+//   - template: TARGET_TEMPLATE over the SERVICE_PREFIX fields (see naming.go)
+//   - addresses: REPLICA_ADDRESSES, hosts or IPs in index order (VMs, plain containers)
+//   - lookup: TARGET_LOOKUP, an external source listing the replicas; srv:<name> reads the DNS SRV
+//     records of name (e.g. _http._tcp.server-headless.ns.svc.cluster.local), ordered by the
+//     trailing -N of each host and re-read every TARGET_LOOKUP_INTERVAL (default 30s)
+//   - peers: SERVER_PEERS as given, the legacy path
+//
+// Without TARGET_MODE it is detected from env as before: REPLICA_ADDRESSES, then TARGET_LOOKUP,
+// then SERVICE_PREFIX (template with TARGET_TEMPLATE, statefulset with SERVICE_SUFFIX, compose
+// otherwise), then peers. Routing only sees the ordered list, so a new environment is a new
+// TargetResolver and an entry in targetResolverFromEnv.
+
+// TargetResolver lists the replicas of one kind of deployment.
+type TargetResolver interface {
+	// Mode names the resolver, as TARGET_MODE does.
+	Mode() string
+	// Targets lists the replicas in index order: entry i is replica INDEX_BASE+i.
+	Targets() []string
+}
+
+// targetResolverFromEnv returns the resolver for the current config. Env is read on every call,
+// as the rest of the routing config is.
+func targetResolverFromEnv() TargetResolver {
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv("TARGET_MODE")))
+	if mode == "" {
+		switch {
+		case os.Getenv("REPLICA_ADDRESSES") != "":
+			mode = "addresses"
+		case os.Getenv("TARGET_LOOKUP") != "":
+			mode = "lookup"
+		case os.Getenv("SERVICE_PREFIX") == "":
+			mode = "peers"
+		case strings.TrimSpace(os.Getenv("TARGET_TEMPLATE")) != "":
+			mode = "template"
+		case os.Getenv("SERVICE_SUFFIX") != "":
+			mode = "statefulset"
+		default:
+			mode = "compose"
+		}
+	}
+	switch mode {
+	case "compose":
+		return composeTargets{}
+	case "statefulset":
+		return statefulSetTargets{}
+	case "template":
+		return templateTargets{}
+	case "addresses":
+		return addressTargets{}
+	case "lookup":
+		return lookupTargets{}
+	case "peers":
+		return peerTargets{}
+	}
+	unknownTargetMode.Do(func() { log.Printf("unknown TARGET_MODE=%q, using SERVER_PEERS", mode) })
+	return peerTargets{}
+}
+
+var unknownTargetMode sync.Once
+
+// targetPort returns PORT, defaulting to 8081.
+func targetPort() string {
+	if port := os.Getenv("PORT"); port != "" {
+		return port
+	}
+	return "8081"
+}
+
+type composeTargets struct{}
+
+func (composeTargets) Mode() string { return "compose" }
+
+func (composeTargets) Targets() []string {
+	prefix, port := os.Getenv("SERVICE_PREFIX"), targetPort()
+	n, base := replicaCount(), indexBase()
+	out := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		out = append(out, prefix+"-"+strconv.Itoa(base+i)+":"+port)
+	}
+	return out
+}
+
+type statefulSetTargets struct{}
+
+func (statefulSetTargets) Mode() string { return "statefulset" }
+
+func (statefulSetTargets) Targets() []string {
+	prefix, suffix, port := os.Getenv("SERVICE_PREFIX"), os.Getenv("SERVICE_SUFFIX"), targetPort()
+	n, base := replicaCount(), indexBase()
+	out := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		out = append(out, prefix+"-"+strconv.Itoa(base+i)+suffix+":"+port)
+	}
+	return out
+}
+
+type templateTargets struct{}
+
+func (templateTargets) Mode() string { return "template" }
+
+func (templateTargets) Targets() []string {
+	port := targetPort()
+	n, base := replicaCount(), indexBase()
+	out := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		t, err := renderTarget(base+i, port)
+		if err != nil {
+			log.Printf("TARGET_TEMPLATE failed for index %d, using default naming: %v", base+i, err)
+			t = os.Getenv("SERVICE_PREFIX") + "-" + strconv.Itoa(base+i) + os.Getenv("SERVICE_SUFFIX") + ":" + port
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+type addressTargets struct{}
+
+func (addressTargets) Mode() string { return "addresses" }
+
+func (addressTargets) Targets() []string { return replicaAddresses() }
+
+type peerTargets struct{}
+
+func (peerTargets) Mode() string { return "peers" }
+
+func (peerTargets) Targets() []string { return legacyPeers() }
+
+type lookupTargets struct{}
+
+func (lookupTargets) Mode() string { return "lookup" }
+
+func (lookupTargets) Targets() []string {
+	return targetLookups.get(strings.TrimSpace(os.Getenv("TARGET_LOOKUP")))
+}
+
+// targetLookupCache keeps the last answer of each TARGET_LOOKUP source and refreshes it in the
+// background, so building a routing table never waits on DNS once the first answer is in.
+type targetLookupCache struct {
+	mu      sync.Mutex
+	entries map[string]*targetLookup
+	lookup  func(ctx context.Context, spec string) ([]string, error)
+}
+
+type targetLookup struct {
+	targets    []string
+	at         time.Time
+	refreshing bool
+}
+
+var targetLookups = &targetLookupCache{entries: make(map[string]*targetLookup), lookup: lookupTargetSpec}
+
+func targetLookupInterval() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("TARGET_LOOKUP_INTERVAL")); err == nil && d > 0 {
+		return d
+	}
+	return 30 * time.Second
+}
+
+func (c *targetLookupCache) get(spec string) []string {
+	c.mu.Lock()
+	e, ok := c.entries[spec]
+	if !ok {
+		e = &targetLookup{refreshing: true}
+		c.entries[spec] = e
+		c.mu.Unlock()
+		c.refresh(spec, e)
+		c.mu.Lock()
+	} else if !e.refreshing && clock.Now().Sub(e.at) >= targetLookupInterval() {
+		e.refreshing = true
+		go c.refresh(spec, e)
+	}
+	defer c.mu.Unlock()
+	return e.targets
+}
+
+// refresh looks spec up again. A failed lookup keeps the last answer; a changed one rebuilds the
+// routing table.
+func (c *targetLookupCache) refresh(spec string, e *targetLookup) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	targets, err := c.lookup(ctx, spec)
+	c.mu.Lock()
+	first, changed := e.at.IsZero(), err == nil && !slices.Equal(targets, e.targets)
+	e.at, e.refreshing = clock.Now(), false
+	if changed {
+		e.targets = targets
+	}
+	kept := len(e.targets)
+	c.mu.Unlock()
+	switch {
+	case err != nil:
+		log.Printf("TARGET_LOOKUP %s: %v; keeping %d targets", spec, err, kept)
+	case changed && !first:
+		log.Printf("TARGET_LOOKUP %s: targets changed to %v", spec, targets)
+		publishTable("config", nil)
+	}
+}
+
+// lookupTargetSpec resolves a TARGET_LOOKUP source.
+func lookupTargetSpec(ctx context.Context, spec string) ([]string, error) {
+	scheme, name, _ := strings.Cut(spec, ":")
+	if scheme != "srv" || name == "" {
+		return nil, fmt.Errorf("unsupported TARGET_LOOKUP %q (want srv:<name>)", spec)
+	}
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, len(records))
+	for _, r := range records {
+		out = append(out, net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port))))
+	}
+	slices.SortFunc(out, func(a, b string) int {
+		return cmp.Or(cmp.Compare(targetOrdinal(a), targetOrdinal(b)), cmp.Compare(a, b))
+	})
+	return slices.Compact(out), nil
+}
+
+// targetOrdinal is the trailing -N of a target's first DNS label (server-3.svc:8081 -> 3), or -1.
+func targetOrdinal(target string) int {
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		host = target
+	}
+	label, _, _ := strings.Cut(host, ".")
+	if i := strings.LastIndexByte(label, '-'); i >= 0 {
+		if n, err := strconv.Atoi(label[i+1:]); err == nil {
+			return n
+		}
+	}
+	return -1
+}
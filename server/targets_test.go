@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+// Target resolvers must name replicas exactly as the TARGET_TEMPLATE default does, since a
+// changed name moves every client; and the detected resolver must follow the env as before.
+
+func TestResolversMatchDefaultTemplate(t *testing.T) {
+	for _, tc := range []struct{ suffix, base string }{
+		{"", "1"},
+		{".server-headless.poc-routing.svc.cluster.local", "0"},
+	} {
+		t.Setenv("SERVICE_PREFIX", "server")
+		t.Setenv("SERVICE_SUFFIX", tc.suffix)
+		t.Setenv("INDEX_BASE", tc.base)
+		t.Setenv("REPLICAS", "4")
+		t.Setenv("PORT", "8081")
+		want := templateTargets{}.Targets()
+		got := targetResolverFromEnv()
+		if !slices.Equal(got.Targets(), want) {
+			t.Errorf("%s: %v, template default gives %v", got.Mode(), got.Targets(), want)
+		}
+	}
+}
+
+func TestTargetResolverDetection(t *testing.T) {
+	for _, tc := range []struct {
+		env  map[string]string
+		mode string
+	}{
+		{map[string]string{}, "peers"},
+		{map[string]string{"SERVICE_PREFIX": "server"}, "compose"},
+		{map[string]string{"SERVICE_PREFIX": "server", "SERVICE_SUFFIX": ".svc"}, "statefulset"},
+		{map[string]string{"SERVICE_PREFIX": "server", "TARGET_TEMPLATE": "{{.Prefix}}-{{.Index}}:{{.Port}}"}, "template"},
+		{map[string]string{"SERVICE_PREFIX": "server", "TARGET_LOOKUP": "srv:_http._tcp.server"}, "lookup"},
+		{map[string]string{"SERVICE_PREFIX": "server", "REPLICA_ADDRESSES": "10.0.0.1"}, "addresses"},
+		{map[string]string{"SERVICE_PREFIX": "server", "TARGET_MODE": "compose", "SERVICE_SUFFIX": ".svc"}, "compose"},
+	} {
+		for _, k := range []string{"SERVICE_PREFIX", "SERVICE_SUFFIX", "TARGET_TEMPLATE", "TARGET_LOOKUP", "REPLICA_ADDRESSES", "TARGET_MODE"} {
+			t.Setenv(k, tc.env[k])
+		}
+		if got := targetResolverFromEnv().Mode(); got != tc.mode {
+			t.Errorf("%v: mode %s, want %s", tc.env, got, tc.mode)
+		}
+	}
+}
+
+func TestTargetLookupKeepsLastAnswer(t *testing.T) {
+	answers := [][]string{{"server-0.svc:8081", "server-1.svc:8081"}, nil}
+	c := &targetLookupCache{entries: make(map[string]*targetLookup), lookup: func(context.Context, string) ([]string, error) {
+		if answers[0] == nil {
+			return nil, context.DeadlineExceeded
+		}
+		return answers[0], nil
+	}}
+	first := c.get("srv:x")
+	answers = answers[1:]
+	c.refresh("srv:x", c.entries["srv:x"])
+	if got := c.get("srv:x"); !slices.Equal(got, first) || len(got) != 2 {
+		t.Fatalf("after a failed refresh: %v, want %v", got, first)
+	}
+}
+
+func TestRingTarget(t *testing.T) {
+	ring := []string{"server-0.server-headless.ns.svc.cluster.local:8081", "server-1.server-headless.ns.svc.cluster.local:8081"}
+	for _, tc := range []struct{ name, want string }{
+		{ring[1], ring[1]},
+		{"server-1:8081", ring[1]}, // hostname:port, as joins recorded it
+		{"server-0", ring[0]},
+		{"server-1.server-headless.ns.svc.cluster.local", ring[1]},
+		{"server-1:9090", ""},
+		{"server-2:8081", ""},
+		{"", ""},
+	} {
+		got, ok := ringTarget(tc.name, ring)
+		if got != tc.want || ok != (tc.want != "") {
+			t.Errorf("ringTarget(%q) = %q, %v; want %q", tc.name, got, ok, tc.want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Raw TCP proxy. TCP_PROXY_LISTEN (e.g. :9000) opens a listener for non-HTTP protocols. Each
+// connection names its client before any payload, in one of two ways:
+//   - a preamble: a 2-byte big-endian length followed by that many bytes of client_id, which is
+//     consumed and not forwarded
+//   - TLS: the client_id is taken from the ClientHello's SNI, with TCP_PROXY_SNI_SUFFIX trimmed
+//     (e.g. .robots.example), or else its first DNS label; the handshake is passed through untouched
+//
+// The client is resolved like /where and the connection is spliced to the owner's host on
+// TCP_PROXY_UPSTREAM_PORT (default: the TCP_PROXY_LISTEN port), e.g. the raw service next to each
+// replica. The preamble or ClientHello must arrive within 5s.
+
+const tcpPreambleTimeout = 5 * time.Second
+
+func init() {
+	metrics.counter("routing_tcp_proxy_total", "Raw TCP connections handled by the TCP proxy, by routing key source and result.")
+}
+
+// runTCPProxy serves TCP_PROXY_LISTEN until the process exits; it does nothing when unset.
+func runTCPProxy() {
+	addr := strings.TrimSpace(os.Getenv("TCP_PROXY_LISTEN"))
+	if addr == "" {
+		return
+	}
+	upstreamPort := os.Getenv("TCP_PROXY_UPSTREAM_PORT")
+	if upstreamPort == "" {
+		_, p, err := net.SplitHostPort(addr)
+		if err != nil {
+			log.Fatalf("invalid TCP_PROXY_LISTEN %q: %v", addr, err)
+		}
+		upstreamPort = p
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("tcp proxy listen: %v", err)
+	}
+	log.Printf("tcp proxy listening on %s, upstream port %s", addr, upstreamPort)
+	onShutdown(func() { _ = ln.Close() })
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("tcp proxy accept: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go proxyTCP(c, upstreamPort)
+	}
+}
+
+func proxyTCP(c net.Conn, upstreamPort string) {
+	defer c.Close()
+	_ = c.SetReadDeadline(time.Now().Add(tcpPreambleTimeout))
+	br := bufio.NewReader(c)
+	clientID, replay, source, err := readRoutingKey(br)
+	if err != nil {
+		metrics.inc("routing_tcp_proxy_total", "source", source, "result", "bad_preamble")
+		log.Printf("tcp proxy %s: %v", c.RemoteAddr(), err)
+		return
+	}
+	_ = c.SetReadDeadline(time.Time{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), tcpPreambleTimeout)
+	owner, err := resolveOwner(ctx, clientID)
+	cancel()
+	if err != nil {
+		metrics.inc("routing_tcp_proxy_total", "source", source, "result", "unresolved")
+		log.Printf("tcp proxy client_id=%s: %v", clientID, err)
+		return
+	}
+	host, _, err := net.SplitHostPort(owner)
+	if err != nil {
+		host = owner
+	}
+	upstream, err := dialTimeout("tcp", net.JoinHostPort(host, upstreamPort), 2*time.Second)
+	if err != nil {
+		metrics.inc("routing_tcp_proxy_total", "source", source, "result", "dial_error")
+		log.Printf("tcp proxy client_id=%s: dial %s: %v", clientID, owner, err)
+		return
+	}
+	defer upstream.Close()
+	if _, err := upstream.Write(replay); err != nil {
+		metrics.inc("routing_tcp_proxy_total", "source", source, "result", "dial_error")
+		return
+	}
+	metrics.inc("routing_tcp_proxy_total", "source", source, "result", "proxied")
+	log.Printf("tcp proxy client_id=%s (%s) -> %s:%s", clientID, source, host, upstreamPort)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(upstream, br)
+		if tc, ok := upstream.(*net.TCPConn); ok {
+			_ = tc.CloseWrite()
+		}
+	}()
+	_, _ = io.Copy(c, upstream)
+	if tc, ok := c.(*net.TCPConn); ok {
+		_ = tc.CloseWrite()
+	}
+	wg.Wait()
+}
+
+// readRoutingKey reads the client_id from a preamble or a TLS ClientHello. replay holds the bytes
+// that still have to be sent upstream (the ClientHello; nothing for a preamble).
+func readRoutingKey(br *bufio.Reader) (clientID string, replay []byte, source string, err error) {
+	first, err := br.Peek(1)
+	if err != nil {
+		return "", nil, "preamble", err
+	}
+	if first[0] == 0x16 { // TLS handshake record
+		clientID, replay, err = readSNI(br)
+		return clientID, replay, "sni", err
+	}
+	var n uint16
+	if err := binary.Read(br, binary.BigEndian, &n); err != nil {
+		return "", nil, "preamble", err
+	}
+	if n == 0 || n > 1024 {
+		return "", nil, "preamble", fmt.Errorf("preamble length %d out of range 1..1024", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(br, buf); err != nil {
+		return "", nil, "preamble", err
+	}
+	return string(buf), nil, "preamble", nil
+}
+
+var errHelloRead = errors.New("client hello read")
+
+// readSNI parses the ClientHello with crypto/tls over a connection that only records what it
+// reads, and stops the handshake once the hello is seen.
+func readSNI(br *bufio.Reader) (string, []byte, error) {
+	rec := &recordingConn{r: br}
+	var sni string
+	err := tls.Server(rec, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			sni = hello.ServerName
+			return nil, errHelloRead
+		},
+	}).Handshake()
+	if !errors.Is(err, errHelloRead) {
+		return "", nil, fmt.Errorf("reading TLS ClientHello: %v", err)
+	}
+	if sni == "" {
+		return "", nil, errors.New("TLS ClientHello has no SNI")
+	}
+	if suffix := os.Getenv("TCP_PROXY_SNI_SUFFIX"); suffix != "" {
+		if id, ok := strings.CutSuffix(sni, suffix); ok && id != "" {
+			return id, rec.buf.Bytes(), nil
+		}
+	}
+	id, _, _ := strings.Cut(sni, ".")
+	return id, rec.buf.Bytes(), nil
+}
+
+// recordingConn is a read-only net.Conn for tls.Server: reads are recorded, writes dropped.
+type recordingConn struct {
+	r   io.Reader
+	buf bytes.Buffer
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.buf.Write(p[:n])
+	return n, err
+}
+
+func (c *recordingConn) Write(p []byte) (int, error)        { return len(p), nil }
+func (c *recordingConn) Close() error                       { return nil }
+func (c *recordingConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (c *recordingConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (c *recordingConn) SetDeadline(t time.Time) error      { return nil }
+func (c *recordingConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *recordingConn) SetWriteDeadline(t time.Time) error { return nil }
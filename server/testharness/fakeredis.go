@@ -0,0 +1,337 @@
+package testharness
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeRedis is an in-memory RESP2 server with the commands the routing server uses (PING, GET,
+// SET with PX/EX/NX/XX, DEL, EXISTS, INCR, PEXPIRE, SCAN, KEYS, FLUSHALL). It backs the shared
+// assignment registry and registry membership of a Cluster, and can be taken down to test
+// registry outages.
+type FakeRedis struct {
+	ln   net.Listener
+	mu   sync.Mutex
+	data map[string]fakeEntry
+	down bool
+}
+
+type fakeEntry struct {
+	val     string
+	expires time.Time // zero: no TTL
+}
+
+// StartFakeRedis listens on addr (e.g. 127.0.0.1:0) and serves until Close.
+func StartFakeRedis(addr string) (*FakeRedis, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	f := &FakeRedis{ln: ln, data: make(map[string]fakeEntry)}
+	go f.serve()
+	return f, nil
+}
+
+// Addr is the host:port clients connect to.
+func (f *FakeRedis) Addr() string { return f.ln.Addr().String() }
+
+// Close stops the server.
+func (f *FakeRedis) Close() error { return f.ln.Close() }
+
+// SetDown makes every command fail with an error until called with false.
+func (f *FakeRedis) SetDown(down bool) {
+	f.mu.Lock()
+	f.down = down
+	f.mu.Unlock()
+}
+
+// Get returns the live value of key.
+func (f *FakeRedis) Get(key string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, ok := f.live(key, time.Now())
+	return e.val, ok
+}
+
+// Keys lists the live keys matching pattern (* and ? globs), sorted.
+func (f *FakeRedis) Keys(pattern string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.keys(pattern, time.Now())
+}
+
+func (f *FakeRedis) serve() {
+	for {
+		c, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(c)
+	}
+}
+
+func (f *FakeRedis) handle(c net.Conn) {
+	defer c.Close()
+	r, w := bufio.NewReader(c), bufio.NewWriter(c)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		writeReply(w, f.exec(args))
+		// Flush once the pipelined commands already received are answered.
+		if r.Buffered() == 0 {
+			if w.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+type redisErr string
+
+func (f *FakeRedis) exec(args []string) any {
+	if len(args) == 0 {
+		return redisErr("ERR empty command")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return redisErr("LOADING fake redis is down")
+	}
+	now := time.Now()
+	switch cmd := strings.ToUpper(args[0]); cmd {
+	case "PING":
+		return "PONG"
+	case "FLUSHALL":
+		f.data = make(map[string]fakeEntry)
+		return "OK"
+	case "GET":
+		if len(args) != 2 {
+			return arity(cmd)
+		}
+		if e, ok := f.live(args[1], now); ok {
+			return bulk(e.val)
+		}
+		return nil
+	case "SET":
+		return f.set(args, now)
+	case "DEL", "EXISTS":
+		n := int64(0)
+		for _, k := range args[1:] {
+			if _, ok := f.live(k, now); ok {
+				n++
+				if cmd == "DEL" {
+					delete(f.data, k)
+				}
+			}
+		}
+		return n
+	case "INCR":
+		if len(args) != 2 {
+			return arity(cmd)
+		}
+		e, _ := f.live(args[1], now)
+		n := int64(0)
+		if e.val != "" {
+			v, err := strconv.ParseInt(e.val, 10, 64)
+			if err != nil {
+				return redisErr("ERR value is not an integer or out of range")
+			}
+			n = v
+		}
+		n++
+		e.val = strconv.FormatInt(n, 10)
+		f.data[args[1]] = e
+		return n
+	case "PEXPIRE":
+		if len(args) != 3 {
+			return arity(cmd)
+		}
+		ms, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return redisErr("ERR value is not an integer or out of range")
+		}
+		e, ok := f.live(args[1], now)
+		if !ok {
+			return int64(0)
+		}
+		e.expires = now.Add(time.Duration(ms) * time.Millisecond)
+		f.data[args[1]] = e
+		return int64(1)
+	case "KEYS":
+		if len(args) != 2 {
+			return arity(cmd)
+		}
+		return bulks(f.keys(args[1], now))
+	case "SCAN":
+		// The whole keyspace in one page, which is a valid (if unusual) SCAN answer.
+		pattern := "*"
+		for i := 2; i+1 < len(args); i += 2 {
+			if strings.EqualFold(args[i], "MATCH") {
+				pattern = args[i+1]
+			}
+		}
+		return []any{bulk("0"), bulks(f.keys(pattern, now))}
+	default:
+		return redisErr(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	}
+}
+
+func (f *FakeRedis) set(args []string, now time.Time) any {
+	if len(args) < 3 {
+		return arity("SET")
+	}
+	key, e := args[1], fakeEntry{val: args[2]}
+	nx, xx := false, false
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "PX", "EX":
+			if i+1 >= len(args) {
+				return redisErr("ERR syntax error")
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n <= 0 {
+				return redisErr("ERR invalid expire time in 'set' command")
+			}
+			unit := time.Millisecond
+			if opt == "EX" {
+				unit = time.Second
+			}
+			e.expires = now.Add(time.Duration(n) * unit)
+			i++
+		default:
+			return redisErr("ERR syntax error")
+		}
+	}
+	_, exists := f.live(key, now)
+	if (nx && exists) || (xx && !exists) {
+		return nil
+	}
+	f.data[key] = e
+	return "OK"
+}
+
+// live returns key's entry, dropping it when it has expired. Callers hold f.mu.
+func (f *FakeRedis) live(key string, now time.Time) (fakeEntry, bool) {
+	e, ok := f.data[key]
+	if ok && !e.expires.IsZero() && !now.Before(e.expires) {
+		delete(f.data, key)
+		return fakeEntry{}, false
+	}
+	return e, ok
+}
+
+func (f *FakeRedis) keys(pattern string, now time.Time) []string {
+	var out []string
+	for k := range f.data {
+		if _, ok := f.live(k, now); ok && globMatch(pattern, k) {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// globMatch matches Redis-style * and ? patterns; unlike path.Match, * also spans '/'.
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return s == ""
+}
+
+type bulk string
+
+func bulks(ss []string) []any {
+	out := make([]any, len(ss))
+	for i, s := range ss {
+		out[i] = bulk(s)
+	}
+	return out
+}
+
+func arity(cmd string) redisErr {
+	return redisErr(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil // inline command, e.g. from redis-cli or nc
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 {
+		return nil, errors.New("bad array header")
+	}
+	args := make([]string, n)
+	for i := range args {
+		hdr, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimRight(strings.TrimPrefix(hdr, "$"), "\r\n"))
+		if err != nil || size < 0 {
+			return nil, errors.New("bad bulk header")
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func writeReply(w *bufio.Writer, v any) {
+	switch v := v.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case string:
+		w.WriteString("+" + v + "\r\n")
+	case bulk:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case redisErr:
+		w.WriteString("-" + string(v) + "\r\n")
+	case []any:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	}
+}
@@ -0,0 +1,508 @@
+// Package testharness runs a small routing cluster for end-to-end tests without Docker Compose.
+//
+// Start builds the server once per test binary and runs N replicas of it as child processes on
+// 127.0.0.2, 127.0.0.3, ... (the server keeps its configuration in package-level state read from
+// the environment, so replicas cannot share one process). Each replica gets the same PORT and
+// INTERNAL_PORT on its own loopback IP, as pods would, and finds the others through a fixed
+// REPLICA_ADDRESSES list or, with Options.Membership, the registry. The shared assignment
+// registry is an in-process FakeRedis. Tests drive joins, kill and restart replicas, and check
+// placement invariants:
+//
+//	c := testharness.Start(t, testharness.Options{Replicas: 3})
+//	owner := c.Join(t, "c-42")
+//	c.Kill(t, c.NodeFor(owner))
+//	c.WaitHealthy(t, 2)
+//	c.AssertConsistent(t, testharness.IDs("c-", 500))
+//
+// The extra loopback addresses work out of the box on Linux; on macOS add them with
+// `sudo ifconfig lo0 alias 127.0.0.N up`.
+package testharness
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Options configures a Cluster.
+type Options struct {
+	Replicas   int               // default 3
+	Env        map[string]string // extra environment for every replica, e.g. FAILOVER_POLICY
+	Membership bool              // discover replicas through the registry (MEMBERSHIP=registry)
+	Binary     string            // prebuilt server binary; by default the server is built from this module
+}
+
+// Cluster is a running set of replicas sharing a FakeRedis registry.
+type Cluster struct {
+	Registry *FakeRedis
+	Nodes    []*Node
+
+	opts   Options
+	port   int
+	iport  int
+	binary string
+	client *http.Client
+}
+
+// Node is one replica.
+type Node struct {
+	Index  int    // position in Cluster.Nodes and the ring
+	Target string // host:port the ring and /where use for it
+
+	env  []string
+	mu   sync.Mutex
+	cmd  *exec.Cmd
+	done chan struct{}
+	logs bytes.Buffer
+}
+
+// Logs returns everything the replica has logged so far.
+func (n *Node) Logs() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.logs.String()
+}
+
+func (n *Node) Write(p []byte) (int, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.logs.Write(p)
+}
+
+// Running reports whether the replica process is up.
+func (n *Node) Running() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.cmd == nil {
+		return false
+	}
+	select {
+	case <-n.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// Start launches a cluster and registers its shutdown with t.Cleanup. It fails the test when
+// the cluster does not become healthy within 10s.
+func Start(t testing.TB, opts Options) *Cluster {
+	t.Helper()
+	if opts.Replicas <= 0 {
+		opts.Replicas = 3
+	}
+	bin := opts.Binary
+	if bin == "" {
+		bin = buildServer(t)
+	}
+	reg, err := StartFakeRedis("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("testharness: fake redis: %v", err)
+	}
+	c := &Cluster{
+		Registry: reg,
+		opts:     opts,
+		port:     freePort(t),
+		iport:    freePort(t),
+		binary:   bin,
+		client:   &http.Client{Timeout: 5 * time.Second, CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }},
+	}
+	targets := make([]string, opts.Replicas)
+	for i := range targets {
+		targets[i] = net.JoinHostPort(nodeIP(i), strconv.Itoa(c.port))
+	}
+	for i, target := range targets {
+		n := &Node{Index: i, Target: target}
+		n.env = c.nodeEnv(i, targets)
+		c.Nodes = append(c.Nodes, n)
+	}
+	t.Cleanup(func() {
+		for _, n := range c.Nodes {
+			c.stop(n, syscall.SIGKILL)
+		}
+		_ = reg.Close()
+		if t.Failed() {
+			for _, n := range c.Nodes {
+				t.Logf("--- %s logs ---\n%s", n.Target, n.Logs())
+			}
+		}
+	})
+	for _, n := range c.Nodes {
+		if err := c.launch(n); err != nil {
+			t.Fatalf("testharness: start %s: %v", n.Target, err)
+		}
+	}
+	c.WaitHealthy(t, opts.Replicas)
+	return c
+}
+
+func nodeIP(i int) string { return "127.0.0." + strconv.Itoa(i+2) }
+
+func (c *Cluster) nodeEnv(i int, targets []string) []string {
+	ip := nodeIP(i)
+	env := map[string]string{
+		"PORT":                  strconv.Itoa(c.port),
+		"INTERNAL_PORT":         strconv.Itoa(c.iport),
+		"LISTEN":                targets[i],
+		"INTERNAL_BIND":         net.JoinHostPort(ip, strconv.Itoa(c.iport)),
+		"SELF_HOSTPORT":         targets[i],
+		"INDEX_BASE":            "0",
+		"REGISTRY_BACKEND":      "redis",
+		"REDIS_ADDR":            c.Registry.Addr(),
+		"REPLICA_POLL_INTERVAL": "200ms",
+		"COMPRESSION":           "off",
+	}
+	if c.opts.Membership {
+		env["MEMBERSHIP"] = "registry"
+		env["MEMBER_TTL"] = "2s"
+		env["MEMBERSHIP_STABLE_WINDOW"] = "0s"
+	} else {
+		env["REPLICA_ADDRESSES"] = strings.Join(targets, ",")
+	}
+	for k, v := range c.opts.Env {
+		env[k] = v
+	}
+	out := make([]string, 0, len(env)+2)
+	for _, k := range []string{"PATH", "HOME"} {
+		out = append(out, k+"="+os.Getenv(k))
+	}
+	for k, v := range env {
+		out = append(out, k+"="+v)
+	}
+	return out
+}
+
+func (c *Cluster) launch(n *Node) error {
+	cmd := exec.Command(c.binary)
+	cmd.Env = n.env
+	cmd.Stdout, cmd.Stderr = n, n
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(done)
+	}()
+	n.mu.Lock()
+	n.cmd, n.done = cmd, done
+	n.mu.Unlock()
+	return nil
+}
+
+func (c *Cluster) stop(n *Node, sig syscall.Signal) {
+	n.mu.Lock()
+	cmd, done := n.cmd, n.done
+	n.mu.Unlock()
+	if cmd == nil {
+		return
+	}
+	_ = cmd.Process.Signal(sig)
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		_ = cmd.Process.Kill()
+		<-done
+	}
+}
+
+// Kill stops replica i abruptly, like a crashed pod.
+func (c *Cluster) Kill(t testing.TB, i int) {
+	t.Helper()
+	c.stop(c.Nodes[i], syscall.SIGKILL)
+}
+
+// Stop shuts replica i down gracefully (SIGTERM), running its drain and deregistration.
+func (c *Cluster) Stop(t testing.TB, i int) {
+	t.Helper()
+	c.stop(c.Nodes[i], syscall.SIGTERM)
+}
+
+// Restart starts a stopped replica again and waits until it answers /health.
+func (c *Cluster) Restart(t testing.TB, i int) {
+	t.Helper()
+	n := c.Nodes[i]
+	if n.Running() {
+		c.stop(n, syscall.SIGTERM)
+	}
+	if err := c.launch(n); err != nil {
+		t.Fatalf("testharness: restart %s: %v", n.Target, err)
+	}
+	c.waitFor(t, 10*time.Second, n.Target+" healthy", func() bool { return c.healthy(n) })
+}
+
+// Live returns the running replicas.
+func (c *Cluster) Live() []*Node {
+	var out []*Node
+	for _, n := range c.Nodes {
+		if n.Running() {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// NodeFor returns the index of the replica with target, or -1.
+func (c *Cluster) NodeFor(target string) int {
+	for _, n := range c.Nodes {
+		if n.Target == target {
+			return n.Index
+		}
+	}
+	return -1
+}
+
+func (c *Cluster) healthy(n *Node) bool {
+	resp, err := c.client.Get("http://" + n.Target + "/health")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// WaitHealthy waits until every running replica answers /health and reports exactly want healthy
+// targets in /cluster/status, i.e. the cluster has noticed replicas that were stopped or started.
+// With Options.Membership the ring must also have shrunk or grown to want members.
+func (c *Cluster) WaitHealthy(t testing.TB, want int) {
+	t.Helper()
+	c.waitFor(t, 10*time.Second, fmt.Sprintf("%d healthy replicas", want), func() bool {
+		live := c.Live()
+		if len(live) == 0 {
+			return want == 0
+		}
+		for _, n := range live {
+			if !c.healthy(n) || !c.hasHealthy(n, want) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func (c *Cluster) hasHealthy(n *Node, want int) bool {
+	var status struct {
+		Total   int `json:"replicas_total"`
+		Healthy int `json:"replicas_healthy"`
+	}
+	if c.getJSON(n, "/cluster/status", &status) != nil {
+		return false
+	}
+	return status.Healthy == want && (!c.opts.Membership || status.Total == want)
+}
+
+func (c *Cluster) waitFor(t testing.TB, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("testharness: timed out after %s waiting for %s", timeout, what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func (c *Cluster) getJSON(n *Node, path string, v any) error {
+	resp, err := c.client.Get("http://" + n.Target + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s%s: status %d: %s", n.Target, path, resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return json.Unmarshal(body, v)
+}
+
+// Where asks replica i for clientID's owner.
+func (c *Cluster) Where(node int, clientID string) (string, error) {
+	var body struct {
+		HostPort string `json:"hostport"`
+	}
+	if err := c.getJSON(c.Nodes[node], "/where?"+url.Values{"client_id": {clientID}}.Encode(), &body); err != nil {
+		return "", err
+	}
+	return body.HostPort, nil
+}
+
+// Join routes clientID the way Envoy does: it asks the first running replica for the owner, then
+// sends /join there, following one handoff redirect. It returns the replica that served the join.
+func (c *Cluster) Join(t testing.TB, clientID string) string {
+	t.Helper()
+	live := c.Live()
+	if len(live) == 0 {
+		t.Fatalf("testharness: join %s: no running replicas", clientID)
+	}
+	owner, err := c.Where(live[0].Index, clientID)
+	if err != nil {
+		t.Fatalf("testharness: join %s: %v", clientID, err)
+	}
+	for range 2 {
+		resp, err := c.client.Get("http://" + owner + "/join?" + url.Values{"client_id": {clientID}}.Encode())
+		if err != nil {
+			t.Fatalf("testharness: join %s on %s: %v", clientID, owner, err)
+		}
+		var body struct {
+			Assigned string `json:"assigned"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return owner
+		case http.StatusTemporaryRedirect:
+			owner = body.Assigned
+		default:
+			t.Fatalf("testharness: join %s on %s: status %d", clientID, owner, resp.StatusCode)
+		}
+	}
+	t.Fatalf("testharness: join %s: redirected more than once", clientID)
+	return ""
+}
+
+// IDs returns n client IDs prefix0 .. prefix(n-1).
+func IDs(prefix string, n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		out[i] = prefix + strconv.Itoa(i)
+	}
+	return out
+}
+
+// Owners resolves ids on every running replica; owners[id] holds one answer per replica.
+func (c *Cluster) Owners(t testing.TB, ids []string) map[string][]string {
+	t.Helper()
+	out := make(map[string][]string, len(ids))
+	for _, n := range c.Live() {
+		for _, id := range ids {
+			owner, err := c.Where(n.Index, id)
+			if err != nil {
+				t.Fatalf("testharness: where %s on %s: %v", id, n.Target, err)
+			}
+			out[id] = append(out[id], owner)
+		}
+	}
+	return out
+}
+
+// AssertConsistent checks that every running replica routes each ID to the same, running replica.
+func (c *Cluster) AssertConsistent(t testing.TB, ids []string) {
+	t.Helper()
+	running := make(map[string]bool)
+	for _, n := range c.Live() {
+		running[n.Target] = true
+	}
+	for id, owners := range c.Owners(t, ids) {
+		for _, o := range owners {
+			if o != owners[0] {
+				t.Errorf("client %s: replicas disagree on its owner: %v", id, owners)
+				break
+			}
+		}
+		if !running[owners[0]] {
+			t.Errorf("client %s: routed to %s, which is not running", id, owners[0])
+		}
+	}
+}
+
+// AssertBalanced checks that ids spread over the running replicas with no replica's count more
+// than maxDeviation (e.g. 0.2) away from an even share.
+func (c *Cluster) AssertBalanced(t testing.TB, ids []string, maxDeviation float64) {
+	t.Helper()
+	live := c.Live()
+	if len(live) == 0 {
+		t.Fatal("testharness: no running replicas")
+	}
+	counts := make(map[string]int)
+	for _, n := range live {
+		counts[n.Target] = 0
+	}
+	for _, id := range ids {
+		owner, err := c.Where(live[0].Index, id)
+		if err != nil {
+			t.Fatalf("testharness: where %s on %s: %v", id, live[0].Target, err)
+		}
+		counts[owner]++
+	}
+	want := float64(len(ids)) / float64(len(live))
+	for target, got := range counts {
+		if dev := math.Abs(float64(got)-want) / want; dev > maxDeviation {
+			t.Errorf("replica %s owns %d of %d clients, %.0f%% off an even share of %.0f", target, got, len(ids), dev*100, want)
+		}
+	}
+}
+
+// AssertMovedOnly checks that between two owner snapshots (from Owners) only clients of the
+// removed replicas changed owner, i.e. removing a replica did not reshuffle anyone else.
+func AssertMovedOnly(t testing.TB, before, after map[string][]string, removed ...string) {
+	t.Helper()
+	gone := make(map[string]bool)
+	for _, r := range removed {
+		gone[r] = true
+	}
+	for id, b := range before {
+		a, ok := after[id]
+		if !ok || len(a) == 0 || len(b) == 0 {
+			continue
+		}
+		if a[0] != b[0] && !gone[b[0]] {
+			t.Errorf("client %s moved from %s to %s although %s is still up", id, b[0], a[0], b[0])
+		}
+	}
+}
+
+var (
+	buildOnce sync.Once
+	buildPath string
+	buildErr  error
+)
+
+// buildServer compiles cmd/server next to this package into a temporary directory, once per test
+// binary.
+func buildServer(t testing.TB) string {
+	t.Helper()
+	buildOnce.Do(func() {
+		_, file, _, _ := runtime.Caller(0)
+		dir, err := os.MkdirTemp("", "testharness-")
+		if err != nil {
+			buildErr = err
+			return
+		}
+		buildPath = filepath.Join(dir, "server")
+		cmd := exec.Command("go", "build", "-o", buildPath, "./cmd/server")
+		cmd.Dir = filepath.Join(filepath.Dir(file), "..")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			buildErr = fmt.Errorf("go build: %v\n%s", err, out)
+		}
+	})
+	if buildErr != nil {
+		t.Fatalf("testharness: %v", buildErr)
+	}
+	return buildPath
+}
+
+func freePort(t testing.TB) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("testharness: %v", err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
@@ -0,0 +1,74 @@
+package testharness
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFailoverKeepsPlacementConsistent(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a cluster")
+	}
+	c := Start(t, Options{Replicas: 3, Env: map[string]string{"FAILOVER_POLICY": "successor"}})
+	ids := IDs("c-", 300)
+	c.AssertConsistent(t, ids)
+	c.AssertBalanced(t, ids, 0.3)
+
+	owner := c.Join(t, "c-42")
+	if _, ok := c.Registry.Get("poc-routing:assignment:c-42"); !ok {
+		t.Fatal("join did not reach the shared registry")
+	}
+	before := c.Owners(t, ids)
+	c.Kill(t, c.NodeFor(owner))
+	c.WaitHealthy(t, 2)
+	c.AssertConsistent(t, ids)
+	AssertMovedOnly(t, before, c.Owners(t, ids), owner)
+
+	c.Restart(t, c.NodeFor(owner))
+	c.WaitHealthy(t, 3)
+	c.AssertConsistent(t, ids)
+}
+
+func TestMembershipShrinksRing(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a cluster")
+	}
+	c := Start(t, Options{Replicas: 3, Membership: true})
+	ids := IDs("m-", 300)
+	c.AssertConsistent(t, ids)
+	c.Stop(t, 2)
+	c.WaitHealthy(t, 2)
+	c.AssertConsistent(t, ids)
+	if keys := c.Registry.Keys("poc-routing:member:*"); len(keys) != 2 {
+		t.Fatalf("members after a graceful stop: %v", keys)
+	}
+}
+
+func TestRegistryOutageDefersWrites(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a cluster")
+	}
+	c := Start(t, Options{Replicas: 2})
+	c.Registry.SetDown(true)
+	owner := c.Join(t, "r-1")
+	var where struct {
+		Degraded bool `json:"degraded"`
+	}
+	if err := c.getJSON(c.Nodes[c.NodeFor(owner)], "/where?client_id=r-1", &where); err != nil || !where.Degraded {
+		t.Fatalf("/where during the outage: degraded=%v, err=%v", where.Degraded, err)
+	}
+
+	c.Registry.SetDown(false)
+	c.waitFor(t, 10*time.Second, "the deferred write", func() bool {
+		_, ok := c.Registry.Get("poc-routing:assignment:r-1")
+		return ok
+	})
+	where.Degraded = false
+	if err := c.getJSON(c.Nodes[c.NodeFor(owner)], "/where?client_id=r-1", &where); err != nil || where.Degraded {
+		t.Fatalf("/where after recovery: degraded=%v, err=%v", where.Degraded, err)
+	}
+	if logs := c.Nodes[c.NodeFor(owner)].Logs(); !strings.Contains(logs, "registry reachable again") {
+		t.Fatalf("no recovery log line:\n%s", logs)
+	}
+}
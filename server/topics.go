@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Topic routing (experimental). With TOPIC_LEVELS set, client IDs are treated as MQTT-style
+// topics (site/42/device/7) and only their leading levels are hashed, so a whole topic subtree
+// lands on one replica. TOPIC_LEVELS is either a count ("2": site/42) or per-root rules keyed by
+// the first level with an optional * default ("site=2,fleet=3,*=1"). Topics with fewer levels
+// are hashed whole. TOPIC_SEPARATOR defaults to "/". GET /topics/where?topic= resolves a topic,
+// or a subscription filter with + and # wildcards: a filter whose hashed levels are all literal
+// lives on one replica, anything wider fans out to every target.
+
+type topicRules struct {
+	byRoot   map[string]int
+	fallback int
+}
+
+var topicLevels = parseTopicLevels(os.Getenv("TOPIC_LEVELS"))
+
+func parseTopicLevels(spec string) *topicRules {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil
+	}
+	if n, err := strconv.Atoi(spec); err == nil && n > 0 {
+		return &topicRules{fallback: n}
+	}
+	rules := &topicRules{byRoot: make(map[string]int)}
+	for _, part := range strings.Split(spec, ",") {
+		root, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if root = strings.TrimSpace(root); !ok || root == "" || err != nil || n <= 0 {
+			log.Fatalf("invalid TOPIC_LEVELS entry %q (want root=levels or *=levels)", part)
+		}
+		if root == "*" {
+			rules.fallback = n
+		} else {
+			rules.byRoot[root] = n
+		}
+	}
+	return rules
+}
+
+func topicSeparator() string {
+	if s := os.Getenv("TOPIC_SEPARATOR"); s != "" {
+		return s
+	}
+	return "/"
+}
+
+// levels returns how many leading levels of topic are hashed (0: the whole topic).
+func (t *topicRules) levels(topic string) int {
+	root, _, _ := strings.Cut(topic, topicSeparator())
+	if n, ok := t.byRoot[root]; ok {
+		return n
+	}
+	return t.fallback
+}
+
+// topicKey returns the routing key of topic and whether topic routing applies.
+func topicKey(topic string) (string, bool) {
+	if topicLevels == nil {
+		return "", false
+	}
+	n := topicLevels.levels(topic)
+	sep := topicSeparator()
+	parts := strings.Split(topic, sep)
+	if n == 0 || len(parts) <= n {
+		return topic, true
+	}
+	return strings.Join(parts[:n], sep), true
+}
+
+func handleTopicWhere(w http.ResponseWriter, r *http.Request) {
+	topic := r.URL.Query().Get("topic")
+	if topic == "" {
+		http.Error(w, "missing topic", http.StatusBadRequest)
+		return
+	}
+	if topicLevels == nil {
+		writeError(w, http.StatusConflict, "TOPIC_ROUTING_DISABLED", "set TOPIC_LEVELS to route by topic")
+		return
+	}
+	sep := topicSeparator()
+	parts := strings.Split(topic, sep)
+	n := topicLevels.levels(topic)
+	hashed := parts
+	if n > 0 && len(parts) > n {
+		hashed = parts[:n]
+	}
+	out := map[string]any{"topic": topic, "levels": n}
+	// Wildcards below the hashed levels only widen the subtree; within them it spans replicas.
+	if slices.Contains(hashed, "+") || slices.Contains(hashed, "#") {
+		out["fan_out"] = true
+		out["targets"] = allTargets()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+		return
+	}
+	key := strings.Join(hashed, sep)
+	owner, version, err := resolveOwnerAt(r.Context(), key)
+	if err != nil {
+		writeResolveError(w, err)
+		return
+	}
+	setTableVersion(w, version)
+	out["routing_key"] = key
+	out["hostport"] = owner
+	out["table_version"] = version
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
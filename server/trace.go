@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Routing trace headers. A hop that forwards a request to its owner stamps it with how it was
+// routed, so Envoy access logs and the owner can correlate placement without calling back into
+// the API:
+//   - X-Routed-By: the routing hops so far, comma-separated (gateway, resolving replica)
+//   - X-Routing-Mode: the step that decided the owner, as named by /explain (hash, pin,
+//     anti_affinity, routing_expr, target_version, maintenance, standby, failover, pool, ...)
+//   - X-Ring-Version: the routing table version the owner was resolved at
+//   - X-Hops: how many routing hops the request has taken
+//
+// The gateway sets them on the request it forwards and echoes them on the response (not for
+// upgrades, whose response is piped through untouched); the replica /ws pass-through sets them
+// on the forwarded upgrade. /where answers with the headers a forward should carry, and the Envoy
+// Lua filter copies them onto the request it sends upstream.
+
+const (
+	routedByHeader    = "X-Routed-By"
+	routingModeHeader = "X-Routing-Mode"
+	ringVersionHeader = "X-Ring-Version"
+	hopsHeader        = "X-Hops"
+)
+
+var traceHeaders = []string{routedByHeader, routingModeHeader, ringVersionHeader, hopsHeader}
+
+type traceKey struct{}
+
+// routeTrace records the deciding step of a resolution. It is also the context the resolution
+// runs in, so tracing a /where costs one allocation rather than two.
+type routeTrace struct {
+	context.Context
+	mu   sync.Mutex
+	mode string
+}
+
+func (t *routeTrace) Value(key any) any {
+	if key == (traceKey{}) {
+		return t
+	}
+	return t.Context.Value(key)
+}
+
+func tracing(ctx context.Context) *routeTrace {
+	t, _ := ctx.Value(traceKey{}).(*routeTrace)
+	return t
+}
+
+// noteRouteStep is fed every explainf step: one that names a target decides the owner (later
+// steps override earlier ones), one without is only kept when nothing has decided yet.
+func noteRouteStep(ctx context.Context, step, target string) {
+	t := tracing(ctx)
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if target != "" || t.mode == "" {
+		t.mode = step
+	}
+	t.mu.Unlock()
+}
+
+// resolveTraced resolves clientID like resolveOwnerAt and also returns the deciding step.
+func resolveTraced(ctx context.Context, clientID string) (owner string, version uint64, mode string, err error) {
+	t := &routeTrace{Context: ctx}
+	owner, version, err = resolveOwnerAt(t, clientID)
+	t.mu.Lock()
+	mode = t.mode
+	t.mu.Unlock()
+	if mode == "" {
+		mode = "hash"
+	}
+	return owner, version, mode, err
+}
+
+// setRouteTrace writes the trace headers for this hop to h, extending those on the incoming
+// request in (h may be in itself). version 0 leaves X-Ring-Version out.
+func setRouteTrace(h, in http.Header, mode string, version uint64) {
+	by := sharedHeaderValue(getSelf())
+	if prev := in.Get(routedByHeader); prev != "" {
+		by = []string{prev + ", " + by[0]}
+	}
+	hops := 0
+	if v := in.Get(hopsHeader); v != "" {
+		hops, _ = strconv.Atoi(v)
+	}
+	h[routedByHeader] = by
+	h[routingModeHeader] = sharedHeaderValue(mode)
+	if hops >= 0 && hops < 16 {
+		h[hopsHeader] = sharedHeaderValue(strconv.Itoa(hops + 1))
+	} else {
+		h.Set(hopsHeader, strconv.Itoa(hops+1))
+	}
+	if version > 0 {
+		h[ringVersionHeader] = versionHeaderValue(version)
+	} else {
+		h.Del(ringVersionHeader)
+	}
+}
+
+// sharedValues holds header value slices shared by every response that sets them, like
+// whereNoCache, so /where doesn't allocate one per header. Only values from small sets go in: step
+// names, replica names and low hop counts. They must not be modified in place.
+var sharedValues = struct {
+	sync.RWMutex
+	m map[string][]string
+}{m: make(map[string][]string)}
+
+func sharedHeaderValue(v string) []string {
+	sharedValues.RLock()
+	s, ok := sharedValues.m[v]
+	sharedValues.RUnlock()
+	if ok {
+		return s
+	}
+	sharedValues.Lock()
+	defer sharedValues.Unlock()
+	if s, ok = sharedValues.m[v]; !ok {
+		s = []string{v}
+		sharedValues.m[v] = s
+	}
+	return s
+}
+
+// versionValue is the rendered header value of the latest table version.
+var versionValue atomic.Pointer[struct {
+	version uint64
+	value   []string
+}]
+
+// versionHeaderValue returns version as a shared header value; it is rendered once per table.
+func versionHeaderValue(version uint64) []string {
+	if v := versionValue.Load(); v != nil && v.version == version {
+		return v.value
+	}
+	v := &struct {
+		version uint64
+		value   []string
+	}{version, []string{strconv.FormatUint(version, 10)}}
+	versionValue.Store(v)
+	return v.value
+}
+
+// copyRouteTrace copies the trace headers from src to dst.
+func copyRouteTrace(dst, src http.Header) {
+	for _, k := range traceHeaders {
+		if v := src.Get(k); v != "" {
+			dst.Set(k, v)
+		}
+	}
+}
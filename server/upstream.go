@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Per-upstream proxy transports. The gateway's reverse proxy keeps one http.Transport per
+// upstream replica, so each replica gets its own connection pool and one slow replica cannot
+// starve the idle connections of the others. The pools are tuned by:
+//   - PROXY_MAX_IDLE_PER_HOST (default 100): idle connections kept per replica
+//   - PROXY_MAX_CONNS_PER_HOST (default 0, unlimited): connections per replica; requests beyond
+//     it wait for one to free up
+//   - PROXY_IDLE_CONN_TIMEOUT (default 90s): how long an idle connection is kept
+//   - PROXY_TLS_HANDSHAKE_TIMEOUT (default 10s)
+//   - PROXY_KEEPALIVE (default 30s): TCP keep-alive period; "off" also disables HTTP keep-alive,
+//     so every request dials
+//   - PROXY_HTTP2=on: speak h2c (HTTP/2 with prior knowledge, which replicas serve by default, see
+//     HTTP_PROTOCOLS) and multiplex requests over one connection per replica instead of HTTP/1.1
+//
+// routing_proxy_conns_open{target}, routing_proxy_requests_in_flight{target} and
+// routing_proxy_conns_total{target,reused} show how each pool is used; a high reused=false rate
+// means connections churn.
+
+type upstreamConfig struct {
+	maxIdlePerHost   int
+	maxConnsPerHost  int
+	idleTimeout      time.Duration
+	handshakeTimeout time.Duration
+	keepAlive        time.Duration // < 0 disables keep-alives
+	http2            bool
+}
+
+func upstreamConfigFromEnv() upstreamConfig {
+	c := upstreamConfig{
+		maxIdlePerHost:   100,
+		idleTimeout:      90 * time.Second,
+		handshakeTimeout: 10 * time.Second,
+		keepAlive:        30 * time.Second,
+	}
+	if n, err := strconv.Atoi(os.Getenv("PROXY_MAX_IDLE_PER_HOST")); err == nil && n >= 0 {
+		c.maxIdlePerHost = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("PROXY_MAX_CONNS_PER_HOST")); err == nil && n >= 0 {
+		c.maxConnsPerHost = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("PROXY_IDLE_CONN_TIMEOUT")); err == nil && d > 0 {
+		c.idleTimeout = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("PROXY_TLS_HANDSHAKE_TIMEOUT")); err == nil && d > 0 {
+		c.handshakeTimeout = d
+	}
+	if v := strings.TrimSpace(os.Getenv("PROXY_KEEPALIVE")); strings.EqualFold(v, "off") {
+		c.keepAlive = -1
+	} else if d, err := time.ParseDuration(v); err == nil && d > 0 {
+		c.keepAlive = d
+	}
+	c.http2 = strings.EqualFold(strings.TrimSpace(os.Getenv("PROXY_HTTP2")), "on")
+	return c
+}
+
+// upstreamPool is one replica's transport and its usage.
+type upstreamPool struct {
+	target    string
+	transport *http.Transport
+	open      atomic.Int64
+	inFlight  atomic.Int64
+}
+
+// upstreamTransport is an http.RoundTripper that sends each request through the pool of the
+// replica it is addressed to.
+type upstreamTransport struct {
+	cfg upstreamConfig
+
+	mu    sync.Mutex
+	pools map[string]*upstreamPool
+}
+
+func newUpstreamTransport() *upstreamTransport {
+	cfg := upstreamConfigFromEnv()
+	metrics.gauge("routing_proxy_conns_open", "Open proxy connections per upstream replica.")
+	metrics.gauge("routing_proxy_requests_in_flight", "Proxied requests in flight per upstream replica.")
+	metrics.counter("routing_proxy_conns_total", "Connections used by proxied requests, by upstream and whether they were reused.")
+	return &upstreamTransport{cfg: cfg, pools: make(map[string]*upstreamPool)}
+}
+
+func (u *upstreamTransport) pool(target string) *upstreamPool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if p, ok := u.pools[target]; ok {
+		return p
+	}
+	p := &upstreamPool{target: target}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: u.cfg.keepAlive}
+	t := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         p.dial(dialer),
+		MaxIdleConns:        u.cfg.maxIdlePerHost,
+		MaxIdleConnsPerHost: u.cfg.maxIdlePerHost,
+		MaxConnsPerHost:     u.cfg.maxConnsPerHost,
+		IdleConnTimeout:     u.cfg.idleTimeout,
+		TLSHandshakeTimeout: u.cfg.handshakeTimeout,
+		DisableKeepAlives:   u.cfg.keepAlive < 0,
+		ForceAttemptHTTP2:   u.cfg.http2,
+	}
+	if u.cfg.http2 {
+		t.Protocols = new(http.Protocols)
+		t.Protocols.SetUnencryptedHTTP2(true)
+	}
+	p.transport = t
+	u.pools[target] = p
+	return p
+}
+
+func (u *upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	p := u.pool(req.URL.Host)
+	trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) {
+		metrics.inc("routing_proxy_conns_total", "target", p.target, "reused", strconv.FormatBool(info.Reused))
+	}}
+	metrics.set("routing_proxy_requests_in_flight", float64(p.inFlight.Add(1)), "target", p.target)
+	resp, err := p.transport.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err != nil {
+		p.finish()
+		return nil, err
+	}
+	// The request is in flight until its body has been read and closed.
+	resp.Body = &onClose{ReadCloser: resp.Body, fn: p.finish}
+	return resp, nil
+}
+
+func (p *upstreamPool) finish() {
+	metrics.set("routing_proxy_requests_in_flight", float64(p.inFlight.Add(-1)), "target", p.target)
+}
+
+// dial opens connections through d, counting them open until they close.
+func (p *upstreamPool) dial(d *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dnsCache.dial(ctx, d, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		metrics.set("routing_proxy_conns_open", float64(p.open.Add(1)), "target", p.target)
+		return &countedConn{Conn: conn, pool: p}, nil
+	}
+}
+
+type countedConn struct {
+	net.Conn
+	pool *upstreamPool
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() {
+		metrics.set("routing_proxy_conns_open", float64(c.pool.open.Add(-1)), "target", c.pool.target)
+	})
+	return c.Conn.Close()
+}
+
+type onClose struct {
+	io.ReadCloser
+	fn   func()
+	once sync.Once
+}
+
+func (c *onClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.once.Do(c.fn)
+	return err
+}
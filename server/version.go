@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// Rollout version preference. When TARGET_VERSION is set and a client's hash owner advertises a
+// different version, clients the owner doesn't already hold a session for are placed on a
+// replica running TARGET_VERSION instead (hashed over those replicas). Existing sessions stay put.
+
+func preferTargetVersion(clientID, owner string) string {
+	want := os.Getenv("TARGET_VERSION")
+	if want == "" {
+		return owner
+	}
+	if info, ok := replicas.get(owner); !ok || info.Version == want {
+		return owner
+	}
+	var candidates []string
+	for _, info := range replicas.snapshot() {
+		if info.Healthy && info.Version == want {
+			candidates = append(candidates, info.Target)
+		}
+	}
+	if len(candidates) == 0 || ownerHasSession(owner, clientID) {
+		return owner
+	}
+	return candidates[bucket(hashKey(routingKey(clientID)), len(candidates))]
+}
+
+// ownerHasSession asks owner whether it holds clientID's session. Errors count as "yes"
+// so an unverifiable client is never moved.
+func ownerHasSession(owner, clientID string) bool {
+	if isSelfTarget(owner) {
+		_, ok := sessions.get(clientID)
+		return ok
+	}
+	req, err := newInternalRequest(http.MethodGet, owner, "/internal/sessions?client_id="+url.QueryEscape(clientID), nil)
+	if err != nil {
+		return true
+	}
+	resp, err := replicaClient.Do(req)
+	if err != nil {
+		return true
+	}
+	resp.Body.Close()
+	return resp.StatusCode != http.StatusNotFound
+}
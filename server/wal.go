@@ -0,0 +1,170 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Assignment write-ahead log. ASSIGNMENT_WAL=/var/lib/poc-routing/assignments.wal appends every
+// assignment lifecycle event (assigned, moved, expired, shed) to a local JSON-lines file as it is
+// emitted, independently of the registry backend and the event bus, so how placements evolved
+// can be reconstructed after an incident even when the registry was what failed. Each record has
+// a sequence number and the replica that wrote it; an "open" record marks every process start.
+// The file rotates at ASSIGNMENT_WAL_MAX_BYTES (default 64MiB) to .1, .2, ..., keeping
+// ASSIGNMENT_WAL_KEEP (default 4) old files. ASSIGNMENT_WAL_SYNC=on fsyncs each record.
+// `client wal-replay` reads the files back.
+
+const walRecordOpen = "open"
+
+type walRecord struct {
+	Seq    uint64 `json:"seq"`
+	Writer string `json:"writer"`
+	assignmentEvent
+}
+
+type assignmentWAL struct {
+	mu       sync.Mutex
+	path     string
+	f        *os.File
+	size     int64
+	maxBytes int64
+	keep     int
+	sync     bool
+	seq      uint64
+}
+
+var wal *assignmentWAL
+
+func init() {
+	metrics.counter("routing_wal_records_total", "Records appended to the assignment WAL, by type.")
+	metrics.counter("routing_wal_errors_total", "Assignment WAL writes or rotations that failed.")
+	wal = openWALFromEnv()
+	onShutdown(wal.close)
+}
+
+func openWALFromEnv() *assignmentWAL {
+	path := strings.TrimSpace(os.Getenv("ASSIGNMENT_WAL"))
+	if path == "" {
+		return nil
+	}
+	w := &assignmentWAL{path: path, maxBytes: 64 << 20, keep: 4, sync: os.Getenv("ASSIGNMENT_WAL_SYNC") == "on"}
+	if v := os.Getenv("ASSIGNMENT_WAL_MAX_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			log.Fatalf("invalid ASSIGNMENT_WAL_MAX_BYTES %q", v)
+		}
+		w.maxBytes = n
+	}
+	if v := os.Getenv("ASSIGNMENT_WAL_KEEP"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid ASSIGNMENT_WAL_KEEP %q", v)
+		}
+		w.keep = n
+	}
+	if err := w.openFile(); err != nil {
+		log.Fatalf("assignment wal: %v", err)
+	}
+	log.Printf("writing assignment WAL to %s (rotating at %d bytes, keeping %d)", path, w.maxBytes, w.keep)
+	w.append(assignmentEvent{Type: walRecordOpen, Time: clock.Now()})
+	return w
+}
+
+func (w *assignmentWAL) openFile() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f, w.size = f, st.Size()
+	return nil
+}
+
+// append writes ev as one record. Failures are logged and counted; they never fail the caller.
+// Safe to call on a nil WAL.
+func (w *assignmentWAL) append(ev assignmentEvent) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return
+	}
+	w.seq++
+	line, err := json.Marshal(walRecord{Seq: w.seq, Writer: selfTarget(), assignmentEvent: ev})
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	if w.size > 0 && w.size+int64(len(line)) > w.maxBytes {
+		if err := w.rotate(); err != nil {
+			metrics.inc("routing_wal_errors_total")
+			log.Printf("assignment wal: rotate: %v", err)
+			if w.f == nil {
+				return
+			}
+		}
+	}
+	n, err := w.f.Write(line)
+	w.size += int64(n)
+	if err == nil && w.sync {
+		err = w.f.Sync()
+	}
+	if err != nil {
+		metrics.inc("routing_wal_errors_total")
+		log.Printf("assignment wal: write %s client_id=%s: %v", ev.Type, ev.ClientID, err)
+		return
+	}
+	metrics.inc("routing_wal_records_total", "type", ev.Type)
+}
+
+// rotate shifts path.N-1 to path.N (dropping what falls beyond keep), path to path.1, and
+// reopens path.
+func (w *assignmentWAL) rotate() error {
+	if err := w.f.Close(); err != nil {
+		log.Printf("assignment wal: close: %v", err)
+	}
+	w.f = nil
+	if w.keep == 0 {
+		if err := os.Remove(w.path); err != nil {
+			return err
+		}
+		return w.openFile()
+	}
+	_ = os.Remove(fmt.Sprintf("%s.%d", w.path, w.keep))
+	for i := w.keep - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", w.path, i), fmt.Sprintf("%s.%d", w.path, i+1))
+	}
+	if err := os.Rename(w.path, w.path+".1"); err != nil {
+		if oerr := w.openFile(); oerr != nil {
+			return oerr
+		}
+		return err
+	}
+	return w.openFile()
+}
+
+func (w *assignmentWAL) close() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f != nil {
+		if w.sync {
+			_ = w.f.Sync()
+		}
+		_ = w.f.Close()
+		w.f = nil
+	}
+}
@@ -0,0 +1,396 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WebSocket sessions. GET /ws?client_id= upgrades to a WebSocket on the client's owner, which sends
+// {"status":"connected",...} and then echoes every message back prefixed with its own name. A
+// replica that isn't the owner pipes the upgraded connection through to the owner (WS_PROXY=on,
+// default) or redirects to it (WS_PROXY=off), so clients that land on the wrong pod still reach
+// their owner. At most WS_PROXY_MAX_CONNS (default 1000) connections are proxied at once, and both
+// ends close a connection that has been idle for WS_IDLE_TIMEOUT (default 5m).
+
+const (
+	wsGUID       = "258EAFA5-E914-47DA-95CA-C5AB0DC11B85"
+	wsMaxPayload = 64 << 10
+
+	wsText   = 0x1
+	wsBinary = 0x2
+	wsClose  = 0x8
+	wsPing   = 0x9
+	wsPong   = 0xA
+)
+
+func wsIdleTimeout() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("WS_IDLE_TIMEOUT")); err == nil && d > 0 {
+		return d
+	}
+	return 5 * time.Minute
+}
+
+func isWebSocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+		strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
+}
+
+// wsConn is an accepted server-side WebSocket connection.
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	mu   sync.Mutex // serialises writes
+}
+
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hdr := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		hdr = append(hdr, byte(n))
+	case n <= 0xFFFF:
+		hdr = append(hdr, 126, byte(n>>8), byte(n))
+	default:
+		hdr = append(hdr, 127)
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+	if _, err := c.conn.Write(hdr); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+// closeWith sends a close frame with code and reason, then closes the connection.
+func (c *wsConn) closeWith(code uint16, reason string) {
+	_ = c.writeFrame(wsClose, append(binary.BigEndian.AppendUint16(nil, code), reason...))
+	_ = c.conn.Close()
+}
+
+// readFrame reads one (masked) client frame.
+func (c *wsConn) readFrame() (byte, []byte, error) {
+	var h [2]byte
+	if _, err := io.ReadFull(c.br, h[:]); err != nil {
+		return 0, nil, err
+	}
+	op := h[0] & 0x0F
+	n := uint64(h[1] & 0x7F)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(c.br, b[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(c.br, b[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	if n > wsMaxPayload {
+		return 0, nil, errors.New("frame too large")
+	}
+	var mask [4]byte
+	masked := h[1]&0x80 != 0
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return op, payload, nil
+}
+
+// wsRegistry tracks the WebSocket connections held locally, by client ID.
+type wsRegistry struct {
+	mu    sync.Mutex
+	conns map[string]map[*wsConn]struct{}
+}
+
+var wsClients = newWSRegistry()
+
+func newWSRegistry() *wsRegistry {
+	r := &wsRegistry{conns: make(map[string]map[*wsConn]struct{})}
+	metrics.gaugeFunc("routing_ws_connections", "WebSocket connections served by this replica.", func() float64 {
+		return float64(r.count())
+	})
+	return r
+}
+
+func (r *wsRegistry) add(clientID string, c *wsConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conns[clientID] == nil {
+		r.conns[clientID] = make(map[*wsConn]struct{})
+	}
+	r.conns[clientID][c] = struct{}{}
+}
+
+func (r *wsRegistry) remove(clientID string, c *wsConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.conns[clientID], c)
+	if len(r.conns[clientID]) == 0 {
+		delete(r.conns, clientID)
+	}
+}
+
+// closeClient closes clientID's local WebSocket connections with the given reason.
+func (r *wsRegistry) closeClient(clientID, reason string) int {
+	r.mu.Lock()
+	conns := r.conns[clientID]
+	delete(r.conns, clientID)
+	r.mu.Unlock()
+	for c := range conns {
+		c.closeWith(4000, reason)
+	}
+	return len(conns)
+}
+
+// takeAll removes and returns every local connection, by client ID.
+func (r *wsRegistry) takeAll() map[string]map[*wsConn]struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	conns := r.conns
+	r.conns = make(map[string]map[*wsConn]struct{})
+	return conns
+}
+
+func (r *wsRegistry) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, cs := range r.conns {
+		n += len(cs)
+	}
+	return n
+}
+
+// handleWebSocket serves /ws on the owner and forwards it from any other replica.
+func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	clientID := r.URL.Query().Get("client_id")
+	if clientID == "" {
+		http.Error(w, "missing client_id", http.StatusBadRequest)
+		return
+	}
+	if !isWebSocketUpgrade(r) || r.Header.Get("Sec-WebSocket-Key") == "" {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return
+	}
+	if pool, ok := requestPool(w, r); !ok {
+		return
+	} else if pool != nil {
+		forwardToPool(w, r, clientID, pool)
+		return
+	}
+	if !checkAffinity(w, r) {
+		return
+	}
+	owner, version, mode, err := resolveTraced(r.Context(), clientID)
+	if err != nil {
+		writeResolveError(w, err)
+		return
+	}
+	// A request already proxied once is served here even if the views disagree, to avoid loops.
+	if !isSelfTarget(owner) && r.Header.Get(proxiedHeader) == "" {
+		setRouteTrace(r.Header, r.Header, mode, version)
+		if os.Getenv("WS_PROXY") == "off" {
+			copyRouteTrace(w.Header(), r.Header)
+			http.Redirect(w, r, "ws://"+owner+"/ws?client_id="+url.QueryEscape(clientID), http.StatusTemporaryRedirect)
+			return
+		}
+		proxyUpgrade(w, r, owner)
+		return
+	}
+	serveWebSocket(w, r, clientID)
+}
+
+func serveWebSocket(w http.ResponseWriter, r *http.Request, clientID string) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported on this connection", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + wsGUID))
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := brw.Flush(); err != nil {
+		_ = conn.Close()
+		return
+	}
+	self := getSelf()
+	c := &wsConn{conn: conn, br: brw.Reader}
+	wsClients.add(clientID, c)
+	defer func() {
+		wsClients.remove(clientID, c)
+		_ = conn.Close()
+	}()
+	log.Printf("/ws client_id=%s connected to %s", clientID, self)
+	hello, _ := json.Marshal(map[string]string{"status": "connected", "client_id": clientID, "assigned": self})
+	if c.writeFrame(wsText, hello) != nil {
+		return
+	}
+	idle := wsIdleTimeout()
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(idle))
+		op, payload, err := c.readFrame()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				c.closeWith(1001, "idle timeout")
+			}
+			return
+		}
+		switch op {
+		case wsClose:
+			c.closeWith(1000, "")
+			return
+		case wsPing:
+			_ = c.writeFrame(wsPong, payload)
+		case wsText, wsBinary:
+			if c.writeFrame(op, append([]byte(self+": "), payload...)) != nil {
+				return
+			}
+		}
+	}
+}
+
+// Upgrade proxying, shared by /ws and anything else that needs to pipe a connection to its owner.
+
+const proxiedHeader = "X-Routing-Proxied-By"
+
+// wsProxySlots bounds the connections piped at once (WS_PROXY_MAX_CONNS).
+var wsProxySlots = newWSProxySlots()
+
+func newWSProxySlots() chan struct{} {
+	n := 1000
+	if v, err := strconv.Atoi(os.Getenv("WS_PROXY_MAX_CONNS")); err == nil && v > 0 {
+		n = v
+	}
+	slots := make(chan struct{}, n)
+	metrics.counter("routing_ws_proxy_total", "Upgraded connections forwarded to their owner, by result.")
+	metrics.gaugeFunc("routing_ws_proxy_connections", "Upgraded connections currently piped to another replica.", func() float64 {
+		return float64(len(slots))
+	})
+	return slots
+}
+
+// proxyUpgrade forwards the upgrade request to owner and pipes both directions until either side
+// closes or the connection idles out.
+func proxyUpgrade(w http.ResponseWriter, r *http.Request, owner string) {
+	select {
+	case wsProxySlots <- struct{}{}:
+	default:
+		metrics.inc("routing_ws_proxy_total", "result", "limit")
+		writeError(w, http.StatusServiceUnavailable, "PROXY_LIMIT", "too many proxied connections")
+		return
+	}
+	defer func() { <-wsProxySlots }()
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		metrics.inc("routing_ws_proxy_total", "result", "unsupported")
+		http.Error(w, "upgrade not supported on this connection", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	call, err := breakers.allow(owner)
+	if err != nil {
+		metrics.inc("routing_ws_proxy_total", "result", "breaker_open")
+		writeError(w, http.StatusServiceUnavailable, "BREAKER_OPEN", err.Error())
+		return
+	}
+	upstream, err := dialTimeout("tcp", owner, 2*time.Second)
+	if err != nil {
+		call.done(false)
+		metrics.inc("routing_ws_proxy_total", "result", "dial_error")
+		writeError(w, http.StatusBadGateway, "OWNER_UNREACHABLE", err.Error())
+		return
+	}
+	defer upstream.Close()
+	out := r.Clone(r.Context())
+	out.Host = owner
+	out.Header.Set(proxiedHeader, getSelf())
+	propagateDeadline(r.Context(), out.Header, r.Header)
+	err = out.Write(upstream)
+	call.done(err == nil)
+	if err != nil {
+		metrics.inc("routing_ws_proxy_total", "result", "dial_error")
+		writeError(w, http.StatusBadGateway, "OWNER_UNREACHABLE", err.Error())
+		return
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	metrics.inc("routing_ws_proxy_total", "result", "ok")
+	log.Printf("/ws proxying client_id=%s from %s to %s", r.URL.Query().Get("client_id"), getSelf(), owner)
+
+	p := &idlePipe{idle: wsIdleTimeout()}
+	p.touch()
+	done := make(chan struct{}, 2)
+	go func() { p.copy(upstream, brw.Reader, conn); done <- struct{}{} }()
+	go func() { p.copy(conn, upstream, upstream); done <- struct{}{} }()
+	<-done
+}
+
+// idlePipe copies both directions of a proxied connection, closing it once neither direction has
+// carried data for idle.
+type idlePipe struct {
+	idle time.Duration
+	last atomic.Int64 // unix nanos of the last transfer in either direction
+}
+
+func (p *idlePipe) touch() { p.last.Store(time.Now().UnixNano()) }
+
+// copy reads src (whose deadline is set on srcConn) into dst until an error or an idle timeout.
+func (p *idlePipe) copy(dst net.Conn, src io.Reader, srcConn net.Conn) {
+	buf := make([]byte, 32<<10)
+	for {
+		_ = srcConn.SetReadDeadline(time.Unix(0, p.last.Load()).Add(p.idle))
+		n, err := src.Read(buf)
+		if n > 0 {
+			p.touch()
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return
+			}
+		}
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() && time.Since(time.Unix(0, p.last.Load())) < p.idle {
+			continue // the other direction was active
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Adaptive replica weights. With WEIGHT_TUNING=adaptive, each process scales the WEIGHT every
+// replica advertises by a factor it tunes from its own calls to that replica (gateway forwards
+// and replica-to-replica requests): every WEIGHT_TUNING_INTERVAL (default 30s) a replica with at
+// least WEIGHT_TUNING_MIN_SAMPLES calls (default 20) in the interval is
+//   - slow when its hop p95 (over the /slo window) is over 1.5x the median p95 of all replicas, or
+//     over 5% of its calls failed (transport error or 5xx): the factor drops by
+//     WEIGHT_TUNING_STEP (default 0.1), down to WEIGHT_TUNING_MIN (default 0.1)
+//   - healthy when its p95 is under 1.2x the median and under 1% failed: the factor recovers by a
+//     step, up to 1
+//
+// and otherwise keeps its factor, so a replica hovering around a threshold doesn't flap. The
+// effective weights drive the weighted and random FAILOVER_POLICY picks; hash placement is
+// unweighted. GET /weights shows them with the figures of the last interval, and
+// routing_replica_weight_factor{target} tracks the factors.
+
+type callStats struct {
+	calls, failed int
+}
+
+type weightState struct {
+	Target    string    `json:"target"`
+	Base      int       `json:"base"`
+	Factor    float64   `json:"factor"`
+	Effective float64   `json:"effective"`
+	P95Ms     float64   `json:"p95_ms"`
+	Calls     int       `json:"calls"`
+	ErrorRate float64   `json:"error_rate"`
+	Verdict   string    `json:"verdict"` // slow, healthy, steady or idle (too few calls)
+	ChangedAt time.Time `json:"changed_at,omitzero"`
+}
+
+type weightTuner struct {
+	enabled    bool
+	interval   time.Duration
+	step       float64
+	min        float64
+	minSamples int
+
+	mu      sync.Mutex
+	stats   map[string]*callStats // since the last adjustment
+	factors map[string]float64
+	last    map[string]weightState
+}
+
+var weights = newWeightTunerFromEnv()
+
+func newWeightTunerFromEnv() *weightTuner {
+	t := &weightTuner{
+		enabled:    strings.EqualFold(strings.TrimSpace(os.Getenv("WEIGHT_TUNING")), "adaptive"),
+		interval:   30 * time.Second,
+		step:       0.1,
+		min:        0.1,
+		minSamples: 20,
+		stats:      make(map[string]*callStats),
+		factors:    make(map[string]float64),
+		last:       make(map[string]weightState),
+	}
+	if d, err := time.ParseDuration(os.Getenv("WEIGHT_TUNING_INTERVAL")); err == nil && d > 0 {
+		t.interval = d
+	}
+	if f, err := strconv.ParseFloat(os.Getenv("WEIGHT_TUNING_STEP"), 64); err == nil && f > 0 && f <= 1 {
+		t.step = f
+	}
+	if f, err := strconv.ParseFloat(os.Getenv("WEIGHT_TUNING_MIN"), 64); err == nil && f > 0 && f <= 1 {
+		t.min = f
+	}
+	if n, err := strconv.Atoi(os.Getenv("WEIGHT_TUNING_MIN_SAMPLES")); err == nil && n > 0 {
+		t.minSamples = n
+	}
+	if t.enabled {
+		metrics.gauge("routing_replica_weight_factor", "Adaptive weight factor per replica (1 is the advertised WEIGHT).")
+	}
+	return t
+}
+
+// observe counts one proxied call to target.
+func (t *weightTuner) observe(target string, failed bool) {
+	if !t.enabled {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.stats[target]
+	if !ok {
+		s = &callStats{}
+		t.stats[target] = s
+	}
+	s.calls++
+	if failed {
+		s.failed++
+	}
+}
+
+// factor is target's current weight factor, 1 when tuning is off or it was never adjusted.
+func (t *weightTuner) factor(target string) float64 {
+	if !t.enabled {
+		return 1
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if f, ok := t.factors[target]; ok {
+		return f
+	}
+	return 1
+}
+
+// adjust runs one tuning round over targets, with p95 giving each one's hop latency p95.
+func (t *weightTuner) adjust(targets []string, p95 func(target string) time.Duration) {
+	lat := make(map[string]time.Duration, len(targets))
+	var all []time.Duration
+	for _, target := range targets {
+		if d := p95(target); d > 0 {
+			lat[target] = d
+			all = append(all, d)
+		}
+	}
+	slices.Sort(all)
+	var median time.Duration
+	if len(all) > 0 {
+		median = all[len(all)/2]
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, target := range targets {
+		s := t.stats[target]
+		if s == nil {
+			s = &callStats{}
+		}
+		f, ok := t.factors[target]
+		if !ok {
+			f = 1
+		}
+		st := t.last[target]
+		st.Target, st.Calls, st.P95Ms, st.ErrorRate = target, s.calls, ms(lat[target]), 0
+		if s.calls > 0 {
+			st.ErrorRate = float64(s.failed) / float64(s.calls)
+		}
+		prev := f
+		switch {
+		case s.calls < t.minSamples:
+			st.Verdict = "idle"
+		case st.ErrorRate > 0.05 || (median > 0 && lat[target] > median*3/2):
+			st.Verdict = "slow"
+			f = max(f-t.step, t.min)
+		case st.ErrorRate < 0.01 && (median == 0 || lat[target] < median*6/5):
+			st.Verdict = "healthy"
+			f = min(f+t.step, 1)
+		default:
+			st.Verdict = "steady"
+		}
+		if f != prev {
+			st.ChangedAt = clock.Now()
+			log.Printf("weight tuning: %s factor %.2f -> %.2f (%s, p95=%.1fms, errors=%.1f%%, calls=%d)",
+				target, prev, f, st.Verdict, st.P95Ms, 100*st.ErrorRate, s.calls)
+		}
+		t.factors[target] = f
+		st.Factor = f
+		t.last[target] = st
+		metrics.set("routing_replica_weight_factor", f, "target", target)
+	}
+	t.stats = make(map[string]*callStats)
+}
+
+// runWeightTuner adjusts the weight factors every interval while WEIGHT_TUNING=adaptive.
+func runWeightTuner() {
+	if !weights.enabled {
+		return
+	}
+	log.Printf("weight tuning: adaptive every %s (step %.2f, min %.2f)", weights.interval, weights.step, weights.min)
+	for range clock.Tick(weights.interval) {
+		weights.adjust(allTargets(), func(target string) time.Duration {
+			return slo.quantile("hop", target, 0.95)
+		})
+	}
+}
+
+// handleWeights lists each replica's advertised and effective weight.
+func handleWeights(w http.ResponseWriter, r *http.Request) {
+	out := make([]weightState, 0)
+	weights.mu.Lock()
+	for _, target := range allTargets() {
+		st := weights.last[target]
+		st.Target = target
+		if f, ok := weights.factors[target]; ok {
+			st.Factor = f
+		} else {
+			st.Factor = 1
+		}
+		out = append(out, st)
+	}
+	weights.mu.Unlock()
+	for i := range out {
+		out[i].Base = advertisedWeight(out[i].Target)
+		out[i].Effective = float64(out[i].Base) * out[i].Factor
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Target < out[j].Target })
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"mode":     map[bool]string{true: "adaptive", false: "static"}[weights.enabled],
+		"interval": weights.interval.String(),
+		"weights":  out,
+	})
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Caching headers on /where, so Envoy's cache filter or any shared cache in front of the replicas
+// can answer repeated lookups for the same client. A /where answer holds until the ring changes or
+// the assignment expires, neither of which a cache can see, so the freshness lifetime is the
+// smallest of:
+//   - WHERE_CACHE_MAX_AGE (default 5s)
+//   - SESSION_TTL, when set: an idle assignment expires by then
+//   - the age of the current routing table: a table that just changed is the most likely to change
+//     again (failover, scale-up), so lookups right after a ring change are barely cached
+//
+// Cached answers keep the ETag, so a cache revalidates with a cheap 304 once they go stale.
+// Responses also vary on the pool and affinity headers, which select a different answer or gate
+// the request. Degraded answers (registry outage) are never cached. WHERE_CACHE=off sends
+// Cache-Control: no-cache instead, making every lookup revalidate at the replica, for tests that
+// need each /where to see the current ring.
+
+type whereCachePolicy struct {
+	enabled bool
+	maxAge  time.Duration
+	ttl     time.Duration // SESSION_TTL, 0 when sessions don't expire
+	vary    []string
+}
+
+var whereCache = newWhereCachePolicy()
+
+func newWhereCachePolicy() *whereCachePolicy {
+	p := &whereCachePolicy{
+		enabled: !strings.EqualFold(strings.TrimSpace(os.Getenv("WHERE_CACHE")), "off"),
+		maxAge:  5 * time.Second,
+		ttl:     sessionTTL(),
+	}
+	if d, err := time.ParseDuration(os.Getenv("WHERE_CACHE_MAX_AGE")); err == nil && d >= 0 {
+		p.maxAge = d
+	}
+	vary := []string{poolHeader}
+	if affinityHeader != "" {
+		vary = append(vary, affinityHeader)
+	}
+	p.vary = []string{strings.Join(vary, ", ")}
+	return p
+}
+
+// lifetime is how long a /where answer computed on t may be served from a cache.
+func (p *whereCachePolicy) lifetime(t *routingTable, now time.Time) time.Duration {
+	if !p.enabled || outage.degraded() {
+		return 0
+	}
+	d := p.maxAge
+	if p.ttl > 0 && p.ttl < d {
+		d = p.ttl
+	}
+	if age := now.Sub(t.BuiltAt); age < d {
+		d = age
+	}
+	return d.Truncate(time.Second)
+}
+
+// whereCacheHeaders are rendered header values, shared by every response in the same second with
+// the same lifetime. Shared slices have len == cap, so a later Header.Add copies instead of writing
+// into it.
+type whereCacheHeaders struct {
+	second  int64
+	d       time.Duration
+	control []string
+	expires []string
+}
+
+var (
+	whereNoCache       = []string{"no-cache"}
+	whereCacheRendered atomic.Pointer[whereCacheHeaders]
+)
+
+// set writes the caching headers for a /where answer, on both 200 and 304.
+func (p *whereCachePolicy) set(h http.Header, t *routingTable) {
+	now := time.Now()
+	d := p.lifetime(t, now)
+	if d <= 0 {
+		h["Cache-Control"] = whereNoCache
+		return
+	}
+	r := whereCacheRendered.Load()
+	if r == nil || r.second != now.Unix() || r.d != d {
+		r = &whereCacheHeaders{
+			second:  now.Unix(),
+			d:       d,
+			control: []string{"public, max-age=" + strconv.Itoa(int(d/time.Second))},
+			expires: []string{now.Truncate(time.Second).Add(d).UTC().Format(http.TimeFormat)},
+		}
+		whereCacheRendered.Store(r)
+	}
+	h["Cache-Control"] = r.control
+	h["Expires"] = r.expires
+	if len(h["Vary"]) == 0 {
+		h["Vary"] = p.vary
+	} else {
+		h.Add("Vary", p.vary[0])
+	}
+}
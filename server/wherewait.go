@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+)
+
+// handleWhereWait implements GET /where/wait?client_id=&current=&timeout=: it blocks until the
+// client's assignment differs from current, or the timeout (default 30s, capped by
+// WHERE_WAIT_MAX, default 60s) passes. The assignment is re-evaluated on every replica view refresh and pin change.
+func handleWhereWait(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	clientID := q.Get("client_id")
+	if clientID == "" {
+		http.Error(w, "missing client_id", http.StatusBadRequest)
+		return
+	}
+	if !checkAffinity(w, r) {
+		return
+	}
+	current := q.Get("current")
+
+	limit := 60 * time.Second
+	if d, err := time.ParseDuration(os.Getenv("WHERE_WAIT_MAX")); err == nil && d > 0 {
+		limit = d
+	}
+	timeout := 30 * time.Second
+	if v := q.Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			http.Error(w, "invalid timeout", http.StatusBadRequest)
+			return
+		}
+		timeout = d
+	}
+	if timeout > limit {
+		timeout = limit
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		// Subscribe before resolving so a refresh in between isn't missed.
+		updated, repinned := replicas.updated(), pins.updated()
+		hostPort, version, err := resolveOwnerAt(r.Context(), clientID)
+		if err != nil {
+			writeResolveError(w, err)
+			return
+		}
+		changed := current == "" || !sameReplica(current, hostPort)
+		if changed {
+			writeWaitResult(w, clientID, hostPort, version, true)
+			return
+		}
+		select {
+		case <-updated:
+		case <-repinned:
+		case <-deadline.C:
+			writeWaitResult(w, clientID, hostPort, version, false)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeWaitResult(w http.ResponseWriter, clientID, hostPort string, version uint64, changed bool) {
+	setTableVersion(w, version)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"client_id":     clientID,
+		"hostport":      hostPort,
+		"changed":       changed,
+		"table_version": version,
+	})
+}
+
+// sameReplica reports whether current (a host:port, host, short name like server-2, or a
+// replica's hostname:port such as server-2:8081) names the replica at hostPort.
+func sameReplica(current, hostPort string) bool {
+	_, ok := ringTarget(current, []string{hostPort})
+	return ok
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Persisting assignments from /join. REGISTRY_DURABILITY selects:
+//   - "sync" (default): /join waits for the registry write and fails with 503 if it fails
+//   - "async": /join acknowledges immediately; a write-behind queue (REGISTRY_QUEUE, default 10000)
+//     drained by REGISTRY_WRITERS workers (default 4) persists in batches, retrying each batch up to
+//     REGISTRY_RETRIES times (default 5) with exponential backoff. When the queue is full the write
+//     falls back to sync rather than being dropped.
+//
+// Either way, a write the registry refuses is deferred until it comes back (see registryoutage.go)
+// unless REGISTRY_OUTAGE=fail.
+
+var errRegistryWrite = errors.New("registry write failed")
+
+type registryWriter struct {
+	async   bool
+	queue   chan Assignment
+	retries int
+}
+
+var assignments = newRegistryWriterFromEnv()
+
+func newRegistryWriterFromEnv() *registryWriter {
+	w := &registryWriter{retries: 5}
+	if n, err := strconv.Atoi(os.Getenv("REGISTRY_RETRIES")); err == nil && n >= 0 {
+		w.retries = n
+	}
+	metrics.histogram("routing_registry_write_seconds", "Latency of registry writes by durability mode.")
+	metrics.counter("routing_registry_writes_total", "Assignments persisted, by mode and result.")
+	if strings.ToLower(strings.TrimSpace(os.Getenv("REGISTRY_DURABILITY"))) != "async" {
+		return w
+	}
+	size, writers := 10000, 4
+	if n, err := strconv.Atoi(os.Getenv("REGISTRY_QUEUE")); err == nil && n > 0 {
+		size = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("REGISTRY_WRITERS")); err == nil && n > 0 {
+		writers = n
+	}
+	w.async = true
+	w.queue = make(chan Assignment, size)
+	metrics.gaugeFunc("routing_registry_queue_depth", "Assignments waiting in the write-behind queue.", func() float64 {
+		return float64(len(w.queue))
+	})
+	for i := 0; i < writers; i++ {
+		go w.drain()
+	}
+	log.Printf("registry writes: async (queue=%d writers=%d)", size, writers)
+	return w
+}
+
+// persist records that clientID is served by replica.
+func (w *registryWriter) persist(clientID, replica string, assignedAt time.Time) error {
+	a := Assignment{ClientID: clientID, Replica: replica, AssignedAt: assignedAt, UpdatedAt: time.Now()}
+	if w.async {
+		select {
+		case w.queue <- a:
+			return nil
+		default:
+			metrics.inc("routing_registry_writes_total", "mode", "async", "result", "queue_full")
+		}
+	}
+	start := time.Now()
+	err := registry.Put(a)
+	metrics.observe("routing_registry_write_seconds", time.Since(start).Seconds(), "mode", "sync")
+	if err != nil {
+		metrics.inc("routing_registry_writes_total", "mode", "sync", "result", "error")
+		log.Printf("registry write client_id=%s failed: %v", clientID, err)
+		if outage.deferWrites([]Assignment{a}, err) {
+			return nil
+		}
+		return errRegistryWrite
+	}
+	metrics.inc("routing_registry_writes_total", "mode", "sync", "result", "ok")
+	return nil
+}
+
+// drain persists queued assignments in batches of up to 100.
+func (w *registryWriter) drain() {
+	for a := range w.queue {
+		batch := []Assignment{a}
+	fill:
+		for len(batch) < 100 {
+			select {
+			case next := <-w.queue:
+				batch = append(batch, next)
+			default:
+				break fill
+			}
+		}
+		w.writeBatch(batch)
+	}
+}
+
+func (w *registryWriter) writeBatch(batch []Assignment) {
+	backoff := 100 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		err := putBatch(registry, batch)
+		metrics.observe("routing_registry_write_seconds", time.Since(start).Seconds(), "mode", "async")
+		if err == nil {
+			metrics.add("routing_registry_writes_total", float64(len(batch)), "mode", "async", "result", "ok")
+			return
+		}
+		if attempt >= w.retries {
+			metrics.add("routing_registry_writes_total", float64(len(batch)), "mode", "async", "result", "error")
+			if outage.deferWrites(batch, err) {
+				return
+			}
+			log.Printf("registry write-behind dropped %d assignments after %d attempts: %v", len(batch), attempt+1, err)
+			return
+		}
+		time.Sleep(backoff)
+		if backoff < 5*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// putBatch writes batch to r in one call when the backend supports it, otherwise one by one.
+func putBatch(r assignmentRegistry, batch []Assignment) error {
+	if bp, ok := r.(batchPutter); ok {
+		return bp.PutBatch(batch)
+	}
+	for _, a := range batch {
+		if err := r.Put(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Endpoint discovery for Envoy. With XDS_MODE=serve the replica answers Envoy's REST-JSON EDS
+// polls (POST /v3/discovery:endpoints) with a ClusterLoadAssignment for XDS_CLUSTER (default
+// "replicas"): one endpoint per target, grouped into localities by ZONE, weighted by its effective
+// weight (WEIGHT times the adaptive factor, times 100 and rounded, at least 1) and marked HEALTHY
+// or UNHEALTHY. Every XDS_CHECK_INTERVAL (default 1s) the assignment is rebuilt from the routing
+// table and weights, and a change bumps the version and is logged, so an Envoy polling with a
+// short refresh_delay follows a rebalance within seconds. The version_info Envoy sees is a hash of
+// the assignment, so replicas polled in turn agree on it. XDS_MODE=dry-run rebuilds and logs the
+// same way ("would push") but refuses the polls with 503 XDS_DRY_RUN; GET on the same path shows
+// the current assignment in either mode. The same assignment is pushed over gRPC EDS and ADS
+// streams (see xdsstream.go).
+
+const edsTypeURL = "type.googleapis.com/envoy.config.endpoint.v3.ClusterLoadAssignment"
+
+type edsSocketAddress struct {
+	Address   string `json:"address"`
+	PortValue int    `json:"port_value"`
+}
+
+type edsLbEndpoint struct {
+	Endpoint struct {
+		Address struct {
+			SocketAddress edsSocketAddress `json:"socket_address"`
+		} `json:"address"`
+	} `json:"endpoint"`
+	HealthStatus        string `json:"health_status"`
+	LoadBalancingWeight int    `json:"load_balancing_weight"`
+}
+
+type edsLocality struct {
+	Locality            map[string]string `json:"locality,omitempty"`
+	LbEndpoints         []edsLbEndpoint   `json:"lb_endpoints"`
+	LoadBalancingWeight int               `json:"load_balancing_weight"`
+}
+
+type clusterLoadAssignment struct {
+	Type        string        `json:"@type"`
+	ClusterName string        `json:"cluster_name"`
+	Endpoints   []edsLocality `json:"endpoints"`
+}
+
+type xdsPublisher struct {
+	mode     string // off, serve or dry-run
+	cluster  string
+	interval time.Duration
+
+	mu      sync.Mutex
+	version uint64
+	hash    uint64
+	current clusterLoadAssignment
+	changed chan struct{} // closed and replaced when the assignment changes
+}
+
+var xds = newXDSPublisherFromEnv()
+
+func newXDSPublisherFromEnv() *xdsPublisher {
+	x := &xdsPublisher{mode: "off", cluster: "replicas", interval: time.Second, changed: make(chan struct{})}
+	switch m := strings.ToLower(strings.TrimSpace(os.Getenv("XDS_MODE"))); m {
+	case "", "off":
+	case "serve", "dry-run":
+		x.mode = m
+	default:
+		log.Printf("unknown XDS_MODE=%q, endpoint discovery disabled", m)
+	}
+	if c := strings.TrimSpace(os.Getenv("XDS_CLUSTER")); c != "" {
+		x.cluster = c
+	}
+	if d, err := time.ParseDuration(os.Getenv("XDS_CHECK_INTERVAL")); err == nil && d > 0 {
+		x.interval = d
+	}
+	if x.mode != "off" {
+		metrics.gaugeFunc("routing_xds_version", "Version of the EDS assignment offered to Envoy.", func() float64 {
+			x.mu.Lock()
+			defer x.mu.Unlock()
+			return float64(x.version)
+		})
+	}
+	return x
+}
+
+// build assembles the assignment for the current targets.
+func (x *xdsPublisher) build() clusterLoadAssignment {
+	cla := clusterLoadAssignment{Type: edsTypeURL, ClusterName: x.cluster, Endpoints: []edsLocality{}}
+	byZone := make(map[string]int)
+	for _, t := range allTargets() {
+		host, portStr, err := net.SplitHostPort(t)
+		port, perr := strconv.Atoi(portStr)
+		if err != nil || perr != nil {
+			continue
+		}
+		var ep edsLbEndpoint
+		ep.Endpoint.Address.SocketAddress = edsSocketAddress{Address: host, PortValue: port}
+		ep.HealthStatus = "UNHEALTHY"
+		if ownerHealthy(t) {
+			ep.HealthStatus = "HEALTHY"
+		}
+		ep.LoadBalancingWeight = max(int(math.Round(failoverWeight(t)*100)), 1)
+		info, _ := replicas.get(t)
+		i, ok := byZone[info.Zone]
+		if !ok {
+			i = len(cla.Endpoints)
+			byZone[info.Zone] = i
+			loc := edsLocality{LbEndpoints: []edsLbEndpoint{}}
+			if info.Zone != "" {
+				loc.Locality = map[string]string{"zone": info.Zone}
+			}
+			cla.Endpoints = append(cla.Endpoints, loc)
+		}
+		cla.Endpoints[i].LbEndpoints = append(cla.Endpoints[i].LbEndpoints, ep)
+		cla.Endpoints[i].LoadBalancingWeight += ep.LoadBalancingWeight
+	}
+	return cla
+}
+
+// refresh rebuilds the assignment and bumps the version when it changed.
+func (x *xdsPublisher) refresh() {
+	cla := x.build()
+	body, _ := json.Marshal(cla)
+	h := fnv.New64a()
+	_, _ = h.Write(body)
+	sum := h.Sum64()
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.version > 0 && sum == x.hash {
+		return
+	}
+	x.version++
+	x.hash, x.current = sum, cla
+	close(x.changed)
+	x.changed = make(chan struct{})
+	verb := "pushing"
+	if x.mode == "dry-run" {
+		verb = "dry-run: would push"
+	}
+	log.Printf("xds %s %s v%d: %s", verb, x.cluster, x.version, describeAssignment(cla))
+}
+
+// snapshot returns the current assignment, its version_info and a channel closed at the next change.
+func (x *xdsPublisher) snapshot() (version string, cla clusterLoadAssignment, changed <-chan struct{}) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return strconv.FormatUint(x.hash, 16), x.current, x.changed
+}
+
+func describeAssignment(cla clusterLoadAssignment) string {
+	var parts []string
+	for _, loc := range cla.Endpoints {
+		for _, ep := range loc.LbEndpoints {
+			a := ep.Endpoint.Address.SocketAddress
+			desc := fmt.Sprintf("%s:%d weight=%d %s", a.Address, a.PortValue, ep.LoadBalancingWeight, strings.ToLower(ep.HealthStatus))
+			if zone := loc.Locality["zone"]; zone != "" {
+				desc += " zone=" + zone
+			}
+			parts = append(parts, desc)
+		}
+	}
+	if len(parts) == 0 {
+		return "no endpoints"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// runXDSPublisher keeps the EDS assignment in step with the routing table and weights.
+func runXDSPublisher() {
+	if xds.mode == "off" {
+		return
+	}
+	xds.refresh()
+	for range clock.Tick(xds.interval) {
+		xds.refresh()
+	}
+}
+
+// handleEDS answers Envoy's REST EDS poll (POST) or shows the current assignment (GET).
+func handleEDS(w http.ResponseWriter, r *http.Request) {
+	if xds.mode == "off" {
+		writeError(w, http.StatusNotFound, "XDS_DISABLED", "endpoint discovery is off (XDS_MODE)")
+		return
+	}
+	version, cla, _ := xds.snapshot()
+	resources := []clusterLoadAssignment{cla}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if xds.mode == "dry-run" {
+			writeError(w, http.StatusServiceUnavailable, "XDS_DRY_RUN", "XDS_MODE=dry-run only logs assignments")
+			return
+		}
+		var req struct {
+			ResourceNames []string `json:"resource_names"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid discovery request", http.StatusBadRequest)
+			return
+		}
+		if len(req.ResourceNames) > 0 && !slices.Contains(req.ResourceNames, cla.ClusterName) {
+			resources = []clusterLoadAssignment{}
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"version_info": version,
+		"resources":    resources,
+		"type_url":     edsTypeURL,
+		"nonce":        version,
+	})
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strconv"
+	"sync/atomic"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	edsv3 "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// xDS over gRPC. With XDS_MODE=serve the gRPC server on the public port (see admingrpc.go) also
+// serves Envoy's EndpointDiscoveryService and AggregatedDiscoveryService, state-of-the-world
+// variant, so an Envoy cluster configured with api_type GRPC or ADS gets the assignment of
+// xds.go pushed instead of polling for it:
+//   - StreamEndpoints and StreamAggregatedResources send the assignment when a request asks for a
+//     version other than the current one, and again on every change, without waiting for a poll
+//   - FetchEndpoints answers one request with the current assignment
+//   - an ACK (the nonce and version just sent) waits for the next change; a NACK (error_detail
+//     set) is logged and counted, and the next change is sent as usual
+//   - a request naming resources gets the assignment only if XDS_CLUSTER is among them; no names
+//     means all of them
+//
+// Only ClusterLoadAssignment is served: an ADS request for another type gets no answer, so
+// clusters and listeners still come from Envoy's static config. The delta variants answer
+// UNIMPLEMENTED. XDS_MODE=dry-run refuses the calls with UNAVAILABLE, like the REST polls.
+
+func init() {
+	metrics.counter("routing_xds_pushes_total", "EDS assignments sent on xDS gRPC streams.")
+	metrics.counter("routing_xds_nacks_total", "EDS assignments rejected by Envoy.")
+}
+
+// xdsStreams counts open xDS streams, exported as routing_xds_streams.
+var xdsStreams atomic.Int64
+
+type xdsServer struct {
+	edsv3.UnimplementedEndpointDiscoveryServiceServer
+	discoveryv3.UnimplementedAggregatedDiscoveryServiceServer
+}
+
+// registerXDS adds the discovery services to srv unless XDS_MODE is off.
+func registerXDS(srv *grpc.Server) {
+	if xds.mode == "off" {
+		return
+	}
+	edsv3.RegisterEndpointDiscoveryServiceServer(srv, xdsServer{})
+	discoveryv3.RegisterAggregatedDiscoveryServiceServer(srv, xdsServer{})
+	metrics.gaugeFunc("routing_xds_streams", "Open xDS gRPC streams.", func() float64 {
+		return float64(xdsStreams.Load())
+	})
+}
+
+// xdsStream is the part of the EDS and ADS streams the server uses.
+type xdsStream interface {
+	Context() context.Context
+	Send(*discoveryv3.DiscoveryResponse) error
+	Recv() (*discoveryv3.DiscoveryRequest, error)
+}
+
+func (xdsServer) StreamEndpoints(s edsv3.EndpointDiscoveryService_StreamEndpointsServer) error {
+	return streamEDS(s)
+}
+
+func (xdsServer) StreamAggregatedResources(s discoveryv3.AggregatedDiscoveryService_StreamAggregatedResourcesServer) error {
+	return streamEDS(s)
+}
+
+func (xdsServer) FetchEndpoints(_ context.Context, req *discoveryv3.DiscoveryRequest) (*discoveryv3.DiscoveryResponse, error) {
+	if err := xdsServing(); err != nil {
+		return nil, err
+	}
+	version, cla, _ := xds.snapshot()
+	return edsResponse(version, cla, req.GetResourceNames(), "")
+}
+
+func xdsServing() error {
+	if xds.mode == "dry-run" {
+		return status.Error(codes.Unavailable, "XDS_MODE=dry-run only logs assignments")
+	}
+	return nil
+}
+
+// streamEDS runs one state-of-the-world EDS subscription until the stream ends.
+func streamEDS(s xdsStream) error {
+	if err := xdsServing(); err != nil {
+		return err
+	}
+	xdsStreams.Add(1)
+	defer xdsStreams.Add(-1)
+	ctx := s.Context()
+	remote := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		remote = p.Addr.String()
+	}
+
+	reqs := make(chan *discoveryv3.DiscoveryRequest)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			req, err := s.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case reqs <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		subscribed bool
+		names      []string
+		sent       string // version_info of the last response
+		nonce      string
+		n          int
+		changed    <-chan struct{}
+	)
+	send := func() error {
+		version, cla, next := xds.snapshot()
+		changed = next
+		if version == sent {
+			return nil
+		}
+		n++
+		nonce = strconv.Itoa(n)
+		res, err := edsResponse(version, cla, names, nonce)
+		if err != nil {
+			return err
+		}
+		if err := s.Send(res); err != nil {
+			return err
+		}
+		sent = version
+		metrics.inc("routing_xds_pushes_total")
+		return nil
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-recvErr:
+			if status.Code(err) == codes.Canceled {
+				return nil
+			}
+			return err
+		case <-changed:
+			if err := send(); err != nil {
+				return err
+			}
+		case req := <-reqs:
+			if t := req.GetTypeUrl(); t != "" && t != edsTypeURL {
+				continue
+			}
+			if req.GetResponseNonce() != "" && req.GetResponseNonce() != nonce {
+				continue // answers a response this stream has since replaced
+			}
+			if e := req.GetErrorDetail(); e != nil {
+				metrics.inc("routing_xds_nacks_total")
+				log.Printf("xds %s rejected version %s: %s", remote, sent, e.GetMessage())
+			}
+			resubscribed := !subscribed || !slices.Equal(req.GetResourceNames(), names)
+			subscribed, names = true, req.GetResourceNames()
+			if resubscribed || req.GetVersionInfo() != sent {
+				sent = "" // the subscription or Envoy's state differs from what was sent
+			}
+			if req.GetErrorDetail() != nil && !resubscribed {
+				// A rejected version is only sent again once the assignment changes.
+				_, _, changed = xds.snapshot()
+				continue
+			}
+			if err := send(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// edsResponse wraps cla for a discovery response, leaving it out when names don't include it.
+func edsResponse(version string, cla clusterLoadAssignment, names []string, nonce string) (*discoveryv3.DiscoveryResponse, error) {
+	res := &discoveryv3.DiscoveryResponse{VersionInfo: version, TypeUrl: edsTypeURL, Nonce: nonce}
+	if len(names) > 0 && !slices.Contains(names, cla.ClusterName) {
+		return res, nil
+	}
+	a, err := anypb.New(cla.proto())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "encoding the assignment: %v", err)
+	}
+	res.Resources = []*anypb.Any{a}
+	return res, nil
+}
+
+// proto converts the assignment to Envoy's message.
+func (cla clusterLoadAssignment) proto() *endpointv3.ClusterLoadAssignment {
+	out := &endpointv3.ClusterLoadAssignment{ClusterName: cla.ClusterName}
+	for _, loc := range cla.Endpoints {
+		l := &endpointv3.LocalityLbEndpoints{LoadBalancingWeight: wrapperspb.UInt32(uint32(loc.LoadBalancingWeight))}
+		if zone := loc.Locality["zone"]; zone != "" {
+			l.Locality = &corev3.Locality{Zone: zone}
+		}
+		for _, ep := range loc.LbEndpoints {
+			a := ep.Endpoint.Address.SocketAddress
+			health := corev3.HealthStatus_UNHEALTHY
+			if ep.HealthStatus == "HEALTHY" {
+				health = corev3.HealthStatus_HEALTHY
+			}
+			l.LbEndpoints = append(l.LbEndpoints, &endpointv3.LbEndpoint{
+				HostIdentifier: &endpointv3.LbEndpoint_Endpoint{Endpoint: &endpointv3.Endpoint{
+					Address: &corev3.Address{Address: &corev3.Address_SocketAddress{SocketAddress: &corev3.SocketAddress{
+						Address:       a.Address,
+						PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: uint32(a.PortValue)},
+					}}},
+				}},
+				HealthStatus:        health,
+				LoadBalancingWeight: wrapperspb.UInt32(uint32(ep.LoadBalancingWeight)),
+			})
+		}
+		out.Endpoints = append(out.Endpoints, l)
+	}
+	return out
+}